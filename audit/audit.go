@@ -0,0 +1,104 @@
+package audit
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/rumorshub/http/logsink"
+	"github.com/rumorshub/http/metrics"
+	"github.com/rumorshub/http/middleware"
+)
+
+// Log is the security audit log, the events are written to the own file and sinks apart from the access log.
+type Log struct {
+	cfg    *Config
+	log    *slog.Logger
+	file   *rotatingFile
+	sinks  *logsink.Sinks
+	events *metrics.Counter
+	// stopped drops the late events, e.g. of the hijacked connections closed after the stop
+	stopped atomic.Bool
+}
+
+// New opens the audit log, the config should have the defaults applied
+func New(cfg *Config, registry *metrics.Registry, log *slog.Logger) (*Log, error) {
+	l := &Log{
+		cfg:    cfg,
+		events: registry.Counter("http_audit_events_total", "Security events recorded by the audit log.", "event"),
+	}
+
+	handler := log.With("channel", "audit").Handler()
+	if cfg.File != "" {
+		file, err := openRotatingFile(cfg.File, int64(cfg.MaxSize)*1024*1024, cfg.MaxAge, cfg.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+
+		l.file = file
+		handler = slog.NewJSONHandler(file, nil)
+	}
+
+	if len(cfg.Sinks) > 0 {
+		dropped := registry.Counter("http_audit_log_dropped_total", "Audit log entries dropped by the sinks (full buffer, delivery error).", "sink", "reason")
+		l.sinks = logsink.New(cfg.Sinks, dropped, log)
+		handler = l.sinks.Handler(handler)
+	}
+
+	l.log = slog.New(handler)
+
+	return l, nil
+}
+
+// Audit records the event, the request is nil for the events of the connection
+func (l *Log) Audit(r *http.Request, event string, attrs ...slog.Attr) {
+	if l.stopped.Load() {
+		return
+	}
+
+	if len(l.cfg.Events) > 0 && !slices.Contains(l.cfg.Events, event) {
+		return
+	}
+
+	l.events.Inc(event)
+
+	all := make([]slog.Attr, 0, len(attrs)+6)
+	all = append(all, slog.String("event", event))
+
+	if r != nil {
+		ip, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+
+		all = append(all,
+			slog.String("ip", ip),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("request-id", middleware.GetRequestID(r)),
+		)
+
+		if user := middleware.GetUser(r); user != "" {
+			all = append(all, slog.String("user", user))
+		}
+	}
+
+	l.log.LogAttrs(context.Background(), slog.LevelWarn, "security event", append(all, attrs...)...)
+}
+
+// Stop ships the buffered events and closes the file
+func (l *Log) Stop() {
+	l.stopped.Store(true)
+
+	if l.sinks != nil {
+		l.sinks.Stop()
+	}
+
+	if l.file != nil {
+		_ = l.file.Close()
+	}
+}
@@ -0,0 +1,61 @@
+package audit
+
+import (
+	"time"
+
+	"github.com/roadrunner-server/errors"
+
+	"github.com/rumorshub/http/logsink"
+)
+
+type Config struct {
+	// File of the JSON lines, rotated by the size. Default: the plugin logger.
+	File string `mapstructure:"file" json:"file,omitempty" bson:"file,omitempty"`
+
+	// MaxSize of the file in megabytes before it is rotated. Default: 100.
+	MaxSize int `mapstructure:"max_size" json:"max_size,omitempty" bson:"max_size,omitempty"`
+
+	// MaxAge of the rotated files, the older ones are removed. Default: 720h (30 days).
+	MaxAge time.Duration `mapstructure:"max_age" json:"max_age,omitempty" bson:"max_age,omitempty"`
+
+	// MaxBackups of the rotated files to keep. Default: unlimited, limited by the max_age only.
+	MaxBackups int `mapstructure:"max_backups" json:"max_backups,omitempty" bson:"max_backups,omitempty"`
+
+	// Events to record, e.g. auth_failed, rate_limited, banned, geo_blocked, request_too_large,
+	// client_cert_failed, slow_client, uri_too_long. Default: all.
+	Events []string `mapstructure:"events" json:"events,omitempty" bson:"events,omitempty"`
+
+	// Sinks ship the events to syslog, the UDP/TCP collectors or the webhooks in addition to the file.
+	Sinks []logsink.Config `mapstructure:"sinks" json:"sinks,omitempty" bson:"sinks,omitempty"`
+}
+
+func (c *Config) InitDefaults() error {
+	const op = errors.Op("audit_log_config")
+
+	if c.MaxSize == 0 {
+		c.MaxSize = 100
+	}
+
+	if c.MaxAge == 0 {
+		c.MaxAge = 30 * 24 * time.Hour
+	}
+
+	if c.MaxSize < 0 || c.MaxAge < 0 || c.MaxBackups < 0 {
+		return errors.E(op, errors.Str("max_size, max_age and max_backups should not be negative"))
+	}
+
+	names := make(map[string]struct{}, len(c.Sinks))
+	for i := 0; i < len(c.Sinks); i++ {
+		err := c.Sinks[i].InitDefaults()
+		if err != nil {
+			return errors.E(op, err)
+		}
+
+		if _, ok := names[c.Sinks[i].Name]; ok {
+			return errors.E(op, errors.Errorf("sinks names should be unique, %q is repeated", c.Sinks[i].Name))
+		}
+		names[c.Sinks[i].Name] = struct{}{}
+	}
+
+	return nil
+}
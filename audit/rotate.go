@@ -0,0 +1,150 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// backupLayout of the rotated file suffix, sorted by the time
+const backupLayout = "20060102T150405.000000000"
+
+// rotatingFile renames the file once it grows above the max size, the backups older than the max age or beyond
+// the max count are removed on the rotation and on open.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.open()
+	if err != nil {
+		return nil, err
+	}
+
+	f.cleanup()
+
+	return f, nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	// the failed rotation is retried on the next write, the entry goes to the current file
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		_ = f.rotate()
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+
+	return n, err
+}
+
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+
+	err := f.file.Close()
+	f.file = nil
+
+	return err
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+
+	return nil
+}
+
+// rotate renames the file and opens the new one, the old file stays open and written on the failure, so the
+// events are not lost until the next rotation succeeds
+func (f *rotatingFile) rotate() error {
+	backup := f.path + "." + time.Now().UTC().Format(backupLayout)
+
+	err := os.Rename(f.path, backup)
+	if err != nil {
+		return err
+	}
+
+	old := f.file
+	err = f.open()
+	if err != nil {
+		// the old file is named as the backup now, put it back
+		_ = os.Rename(backup, f.path)
+		return err
+	}
+
+	_ = old.Close()
+	f.cleanup()
+
+	return nil
+}
+
+// cleanup removes the expired backups, the errors are ignored as the next rotation retries
+func (f *rotatingFile) cleanup() {
+	backups, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return
+	}
+
+	// the newest first
+	slices.Sort(backups)
+	slices.Reverse(backups)
+
+	kept := 0
+	for i := 0; i < len(backups); i++ {
+		stamp, err := time.Parse(backupLayout, backups[i][len(f.path)+1:])
+		if err != nil {
+			continue
+		}
+
+		if (f.maxBackups > 0 && kept >= f.maxBackups) || time.Since(stamp) > f.maxAge {
+			_ = os.Remove(backups[i])
+			continue
+		}
+
+		kept++
+	}
+}
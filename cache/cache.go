@@ -0,0 +1,307 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rumorshub/http/middleware"
+)
+
+const (
+	MiddlewareName = "cache"
+
+	// MethodPurge invalidates the cached responses by the path prefix or by the tags of the Surrogate-Key
+	// request header, accepted from the trusted clients only
+	MethodPurge = "PURGE"
+
+	// SurrogateKey header of the response lists the space separated tags of the cached entry
+	SurrogateKey = "Surrogate-Key"
+)
+
+// Cache is the shared (RFC 7234) response cache of the GET requests.
+type Cache struct {
+	cfg   *Config
+	log   *slog.Logger
+	store Store
+}
+
+// New creates the cache, the store could be nil, then the responses are kept in memory limited by the max_size
+func New(cfg *Config, store Store, log *slog.Logger) *Cache {
+	if store == nil {
+		store = newMemoryStore(cfg.MaxSize)
+	}
+
+	return &Cache{
+		cfg:   cfg,
+		log:   log,
+		store: store,
+	}
+}
+
+func (c *Cache) Name() string {
+	return MiddlewareName
+}
+
+func (c *Cache) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case MethodPurge:
+			c.purge(w, r)
+			return
+		case http.MethodHead, http.MethodOptions, http.MethodTrace:
+			next.ServeHTTP(w, r)
+			return
+		default:
+			// unsafe methods invalidate the cached response of the URI (RFC 7234 section 4.4)
+			rec := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			if rec.status < http.StatusBadRequest {
+				c.store.Delete(key(r))
+			}
+			return
+		}
+
+		cc := parseCacheControl(r.Header)
+		// authorized responses are private unless told otherwise, not worth the risk
+		if cc.has("no-store") || r.Header.Get("Authorization") != "" || middleware.IsUpgrade(r) || middleware.IsStreaming(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		base := key(r)
+		maxAge, limited := cc.seconds("max-age")
+		if !cc.has("no-cache") && !(limited && maxAge == 0) {
+			if entry, ok := c.lookup(r, base); ok && (!limited || time.Since(entry.Stored) <= maxAge) {
+				c.serve(w, entry)
+				return
+			}
+		}
+
+		rec := &recorder{ResponseWriter: w, limit: c.cfg.MaxObjectSize}
+		rec.Header().Set("X-Cache", "MISS")
+		next.ServeHTTP(rec, r)
+
+		// the streaming content type is known once the handler responded
+		if rec.skip || middleware.IsStreaming(r) {
+			return
+		}
+
+		c.save(r, base, rec)
+	})
+}
+
+// Purge invalidates the cached responses with the path prefix, the empty prefix purges everything
+func (c *Cache) Purge(prefix string) int {
+	return c.store.Purge(func(k string, _ *Entry) bool {
+		return strings.HasPrefix(keyPath(k), prefix)
+	})
+}
+
+// PurgeTags invalidates the cached responses tagged with any of the tags
+func (c *Cache) PurgeTags(tags ...string) int {
+	if len(tags) == 0 {
+		return 0
+	}
+
+	return c.store.Purge(func(_ string, entry *Entry) bool {
+		for i := 0; i < len(entry.Tags); i++ {
+			if slices.Contains(tags, entry.Tags[i]) {
+				return true
+			}
+		}
+
+		return false
+	})
+}
+
+// PurgeAll invalidates every cached response
+func (c *Cache) PurgeAll() int {
+	return c.store.Purge(func(string, *Entry) bool {
+		return true
+	})
+}
+
+func (c *Cache) purge(w http.ResponseWriter, r *http.Request) {
+	if !middleware.IsTrusted(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	var purged int
+	if tags := strings.Fields(r.Header.Get(SurrogateKey)); len(tags) > 0 {
+		purged = c.PurgeTags(tags...)
+		c.log.Debug("cache purged", "tags", tags, "entries", purged)
+	} else {
+		purged = c.Purge(r.URL.Path)
+		c.log.Debug("cache purged", "prefix", r.URL.Path, "entries", purged)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int{"purged": purged})
+}
+
+func (c *Cache) lookup(r *http.Request, base string) (*Entry, bool) {
+	entry, ok := c.store.Get(base)
+	if !ok {
+		return nil, false
+	}
+
+	if len(entry.Vary) == 0 {
+		return entry, true
+	}
+
+	return c.store.Get(variantKey(base, entry.Vary, r.Header))
+}
+
+func (c *Cache) serve(w http.ResponseWriter, entry *Entry) {
+	h := w.Header()
+	for name, values := range entry.Header {
+		h[name] = append([]string(nil), values...)
+	}
+
+	h.Set("Age", strconv.Itoa(int(time.Since(entry.Stored).Seconds())))
+	h.Set("X-Cache", "HIT")
+	w.WriteHeader(entry.Status)
+	_, _ = w.Write(entry.Body)
+}
+
+func (c *Cache) save(r *http.Request, base string, rec *recorder) {
+	status := rec.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	ttl := c.freshness(status, rec.Header())
+	if ttl <= 0 {
+		return
+	}
+
+	header := rec.Header().Clone()
+	for i := 0; i < len(hopHeaders); i++ {
+		header.Del(hopHeaders[i])
+	}
+	header.Del("X-Cache")
+
+	now := time.Now()
+	entry := &Entry{
+		Status:  status,
+		Header:  header,
+		Body:    rec.body,
+		Stored:  now,
+		Expires: now.Add(ttl),
+		Tags:    strings.Fields(header.Get(SurrogateKey)),
+	}
+
+	names := vary(header)
+	if len(names) == 0 {
+		c.store.Set(base, entry, ttl)
+		return
+	}
+
+	// the base entry points to the variants
+	c.store.Set(base, &Entry{Stored: now, Expires: now.Add(c.cfg.MaxTTL), Vary: names}, c.cfg.MaxTTL)
+	c.store.Set(variantKey(base, names, r.Header), entry, ttl)
+}
+
+// key is host and request URI, the path starts at the first slash
+func key(r *http.Request) string {
+	return strings.ToLower(r.Host) + r.URL.RequestURI()
+}
+
+func keyPath(k string) string {
+	if i := strings.IndexByte(k, '/'); i != -1 {
+		return k[i:]
+	}
+
+	return k
+}
+
+func variantKey(base string, names []string, header http.Header) string {
+	var sb strings.Builder
+	sb.WriteString(base)
+	for i := 0; i < len(names); i++ {
+		sb.WriteByte(0)
+		sb.WriteString(strings.Join(header.Values(names[i]), ","))
+	}
+
+	return sb.String()
+}
+
+// recorder passes the response through and keeps the body up to the limit
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   []byte
+	limit  int64
+	skip   bool
+}
+
+func (rec *recorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+
+	n, err := rec.ResponseWriter.Write(b)
+	if !rec.skip {
+		if int64(len(rec.body)+n) > rec.limit {
+			rec.skip = true
+			rec.body = nil
+		} else {
+			rec.body = append(rec.body, b[:n]...)
+		}
+	}
+
+	return n, err
+}
+
+// Flush streams the response, streamed responses are not cached
+func (rec *recorder) Flush() {
+	rec.skip = true
+	if fl, ok := rec.ResponseWriter.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+func (rec *recorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	rec.skip = true
+	if hj, ok := rec.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+
+	return nil, nil, middleware.ErrHijackerNotSupported
+}
+
+func (rec *recorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
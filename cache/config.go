@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/roadrunner-server/errors"
+
+	"github.com/rumorshub/http/redis"
+)
+
+type Config struct {
+	// MaxSize of the in-memory store in bytes, the least recently used responses are evicted. Default: 64Mb.
+	MaxSize int64 `mapstructure:"max_size" json:"max_size,omitempty" bson:"max_size,omitempty"`
+
+	// MaxObjectSize of the cached response body in bytes. Default: 1Mb.
+	MaxObjectSize int64 `mapstructure:"max_object_size" json:"max_object_size,omitempty" bson:"max_object_size,omitempty"`
+
+	// DefaultTTL of the responses without the explicit freshness (max-age, Expires), 0 does not cache them.
+	DefaultTTL time.Duration `mapstructure:"default_ttl" json:"default_ttl,omitempty" bson:"default_ttl,omitempty"`
+
+	// MaxTTL caps the freshness lifetime. Default: 1h.
+	MaxTTL time.Duration `mapstructure:"max_ttl" json:"max_ttl,omitempty" bson:"max_ttl,omitempty"`
+
+	// Redis shares the cached responses of the instances and keeps them over the restarts, the responses are
+	// kept in memory of every instance when not set.
+	Redis *redis.Config `mapstructure:"redis" json:"redis,omitempty" bson:"redis,omitempty"`
+}
+
+func (c *Config) InitDefaults() error {
+	if c.MaxSize == 0 {
+		c.MaxSize = 64 * 1024 * 1024
+	}
+
+	if c.MaxObjectSize == 0 {
+		c.MaxObjectSize = 1024 * 1024
+	}
+
+	if c.MaxTTL == 0 {
+		c.MaxTTL = time.Hour
+	}
+
+	if c.MaxObjectSize > c.MaxSize {
+		return errors.Str("cache max_object_size should not exceed max_size")
+	}
+
+	if c.Redis != nil {
+		return c.Redis.InitDefaults()
+	}
+
+	return nil
+}
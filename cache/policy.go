@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheControl directives, the names are lowercased
+type cacheControl map[string]string
+
+func parseCacheControl(header http.Header) cacheControl {
+	cc := make(cacheControl)
+	for _, value := range header.Values("Cache-Control") {
+		directives := strings.Split(value, ",")
+		for i := 0; i < len(directives); i++ {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directives[i]), "=")
+			if name == "" {
+				continue
+			}
+
+			cc[strings.ToLower(name)] = strings.Trim(arg, `"`)
+		}
+	}
+
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	arg, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+
+	sec, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || sec < 0 {
+		return 0, false
+	}
+
+	return time.Duration(sec) * time.Second, true
+}
+
+// cacheable statuses by default (RFC 7231 section 6.1)
+func cacheableStatus(status int) bool {
+	switch status {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent, http.StatusMultipleChoices,
+		http.StatusMovedPermanently, http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusGone,
+		http.StatusRequestURITooLong, http.StatusNotImplemented:
+		return true
+	default:
+		return false
+	}
+}
+
+// freshness lifetime of the response for the shared cache, 0 when the response should not be stored
+func (c *Cache) freshness(status int, header http.Header) time.Duration {
+	if !cacheableStatus(status) || header.Get("Set-Cookie") != "" || header.Get("Vary") == "*" {
+		return 0
+	}
+
+	cc := parseCacheControl(header)
+	// no-cache responses should be revalidated on every request
+	if cc.has("no-store") || cc.has("private") || cc.has("no-cache") {
+		return 0
+	}
+
+	ttl := c.cfg.DefaultTTL
+	if sMaxAge, ok := cc.seconds("s-maxage"); ok {
+		ttl = sMaxAge
+	} else if maxAge, ok := cc.seconds("max-age"); ok {
+		ttl = maxAge
+	} else if expires := header.Get("Expires"); expires != "" {
+		exp, err := http.ParseTime(expires)
+		if err != nil {
+			// invalid Expires means already expired
+			return 0
+		}
+
+		date, err := http.ParseTime(header.Get("Date"))
+		if err != nil {
+			date = time.Now()
+		}
+
+		ttl = exp.Sub(date)
+	}
+
+	if ttl > c.cfg.MaxTTL {
+		ttl = c.cfg.MaxTTL
+	}
+
+	if ttl < 0 {
+		return 0
+	}
+
+	return ttl
+}
+
+// vary lists the Vary header names in the canonical form
+func vary(header http.Header) []string {
+	var names []string
+	for _, value := range header.Values("Vary") {
+		fields := strings.Split(value, ",")
+		for i := 0; i < len(fields); i++ {
+			name := strings.TrimSpace(fields[i])
+			if name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+
+	return names
+}
+
+// hop-by-hop headers are not stored
+var hopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rumorshub/http/redis"
+)
+
+// RedisStore keeps the responses in Redis, so the instances share them and they survive the restarts. Redis
+// evicts the entries by its maxmemory policy, the max_size of the config does not apply. The store errors are
+// logged and served as the misses.
+type RedisStore struct {
+	client *redis.Client
+	log    *slog.Logger
+}
+
+func NewRedisStore(client *redis.Client, log *slog.Logger) *RedisStore {
+	return &RedisStore{
+		client: client,
+		log:    log,
+	}
+}
+
+func (s *RedisStore) Get(key string) (*Entry, bool) {
+	reply, err := s.client.Do(context.Background(), "GET", s.client.Key("cache", key))
+	if err != nil {
+		if err != redis.Nil { //nolint:errorlint
+			s.log.Warn("cache store get", "key", key, "error", err)
+		}
+
+		return nil, false
+	}
+
+	return s.decode(key, reply)
+}
+
+// decode returns the entry of the GET reply, the expired entries are the misses
+func (s *RedisStore) decode(key string, reply any) (*Entry, bool) {
+	data, _ := reply.(string)
+	entry := &Entry{}
+	if err := json.Unmarshal([]byte(data), entry); err != nil {
+		s.log.Warn("cache store entry", "key", key, "error", err)
+		return nil, false
+	}
+
+	if time.Now().After(entry.Expires) {
+		return nil, false
+	}
+
+	return entry, true
+}
+
+func (s *RedisStore) Set(key string, entry *Entry, ttl time.Duration) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		s.log.Warn("cache store entry", "key", key, "error", err)
+		return
+	}
+
+	_, err = s.client.Do(context.Background(), "SET", s.client.Key("cache", key), string(data), "PX", strconv.FormatInt(max(ttl.Milliseconds(), 1), 10))
+	if err != nil {
+		s.log.Warn("cache store set", "key", key, "error", err)
+	}
+}
+
+func (s *RedisStore) Delete(key string) {
+	_, err := s.client.Do(context.Background(), "DEL", s.client.Key("cache", key))
+	if err != nil {
+		s.log.Warn("cache store delete", "key", key, "error", err)
+	}
+}
+
+// Purge scans all the cached entries, it is meant for the rare invalidations, not the request path
+func (s *RedisStore) Purge(match func(key string, entry *Entry) bool) int {
+	ctx := context.Background()
+	prefix := s.client.Key("cache", "")
+	pattern := globEscape(prefix) + "*"
+
+	purged := 0
+	cursor := "0"
+	for {
+		reply, err := s.client.Do(ctx, "SCAN", cursor, "MATCH", pattern, "COUNT", "100")
+		if err != nil {
+			s.log.Warn("cache store scan", "error", err)
+			return purged
+		}
+
+		values, ok := reply.([]any)
+		if !ok || len(values) != 2 {
+			s.log.Warn("cache store scan", "reply", reply)
+			return purged
+		}
+
+		cursor, _ = values[0].(string)
+		keys, _ := values[1].([]any)
+
+		// the entries of the batch are fetched in a single round trip
+		gets := make([][]string, len(keys))
+		for i := 0; i < len(keys); i++ {
+			k, _ := keys[i].(string)
+			gets[i] = []string{"GET", k}
+		}
+
+		entries, err := s.client.Pipeline(ctx, gets...)
+		if err != nil {
+			s.log.Warn("cache store scan", "error", err)
+			return purged
+		}
+
+		var matched []string
+		for i := 0; i < len(entries); i++ {
+			// removed since the scan or not a string
+			if _, failed := entries[i].(error); failed {
+				continue
+			}
+
+			key := strings.TrimPrefix(gets[i][1], prefix)
+			entry, ok := s.decode(key, entries[i])
+			if ok && match(key, entry) {
+				matched = append(matched, gets[i][1])
+			}
+		}
+
+		if len(matched) > 0 {
+			reply, err = s.client.Do(ctx, append([]string{"DEL"}, matched...)...)
+			if err != nil {
+				s.log.Warn("cache store purge", "error", err)
+				return purged
+			}
+
+			n, _ := reply.(int64)
+			purged += int(n)
+		}
+
+		if cursor == "0" || cursor == "" {
+			return purged
+		}
+	}
+}
+
+// globEscape escapes the glob pattern characters of the SCAN MATCH
+func globEscape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', ']', '\\':
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(s[i])
+	}
+
+	return sb.String()
+}
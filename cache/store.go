@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Entry is the stored response
+type Entry struct {
+	Status  int         `json:"status"`
+	Header  http.Header `json:"header"`
+	Body    []byte      `json:"body"`
+	Stored  time.Time   `json:"stored"`
+	Expires time.Time   `json:"expires"`
+	// Vary request headers, the entry with Vary is a pointer to the variants keyed by the header values
+	Vary []string `json:"vary,omitempty"`
+	// Tags of the Surrogate-Key response header, the entries are purged by them
+	Tags []string `json:"tags,omitempty"`
+}
+
+func (e *Entry) size() int64 {
+	size := int64(len(e.Body))
+	for name, values := range e.Header {
+		size += int64(len(name))
+		for i := 0; i < len(values); i++ {
+			size += int64(len(values[i]))
+		}
+	}
+
+	return size
+}
+
+// Store keeps the cached responses, e.g. in memory of the instance or in the shared KV. The stores handle their
+// own errors, the failed lookup is a miss.
+type Store interface {
+	Get(key string) (*Entry, bool)
+	Set(key string, entry *Entry, ttl time.Duration)
+	Delete(key string)
+	// Purge deletes the matching entries and returns their number
+	Purge(match func(key string, entry *Entry) bool) int
+}
+
+type item struct {
+	key   string
+	entry *Entry
+	size  int64
+}
+
+// memoryStore is the LRU store limited by the total size
+type memoryStore struct {
+	mu      sync.Mutex
+	maxSize int64
+	size    int64
+	lru     *list.List
+	items   map[string]*list.Element
+}
+
+func newMemoryStore(maxSize int64) *memoryStore {
+	return &memoryStore{
+		maxSize: maxSize,
+		lru:     list.New(),
+		items:   make(map[string]*list.Element),
+	}
+}
+
+func (m *memoryStore) Get(key string) (*Entry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	it := el.Value.(*item)
+	if time.Now().After(it.entry.Expires) {
+		m.remove(el)
+		return nil, false
+	}
+
+	m.lru.MoveToFront(el)
+	return it.entry, true
+}
+
+func (m *memoryStore) Set(key string, entry *Entry, _ time.Duration) {
+	it := &item{key: key, entry: entry, size: entry.size() + int64(len(key))}
+	if it.size > m.maxSize {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.items[key]; ok {
+		m.remove(el)
+	}
+
+	m.items[key] = m.lru.PushFront(it)
+	m.size += it.size
+
+	for m.size > m.maxSize {
+		m.remove(m.lru.Back())
+	}
+}
+
+func (m *memoryStore) Delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.items[key]; ok {
+		m.remove(el)
+	}
+}
+
+func (m *memoryStore) Purge(match func(key string, entry *Entry) bool) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	purged := 0
+	for key, el := range m.items {
+		if match(key, el.Value.(*item).entry) {
+			m.remove(el)
+			purged++
+		}
+	}
+
+	return purged
+}
+
+func (m *memoryStore) remove(el *list.Element) {
+	it := m.lru.Remove(el).(*item)
+	delete(m.items, it.key)
+	m.size -= it.size
+}
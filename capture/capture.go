@@ -0,0 +1,329 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rumorshub/http/middleware"
+)
+
+const MiddlewareName = "capture"
+
+// Capture records the full requests and responses matching the filters for reproducing the production bugs.
+// The last captures are kept in the ring buffer, optionally every capture is written to the directory.
+type Capture struct {
+	cfg    *Config
+	log    *slog.Logger
+	redact *middleware.Redactor
+	seq    atomic.Uint64
+
+	mu   sync.Mutex
+	ring []*Entry
+	next int
+}
+
+func New(cfg *Config, log *slog.Logger) (*Capture, error) {
+	if cfg.Dir != "" {
+		err := os.MkdirAll(cfg.Dir, 0o700)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Capture{
+		cfg:    cfg,
+		log:    log,
+		redact: middleware.NewRedactor(cfg.Redact),
+		ring:   make([]*Entry, 0, cfg.Capacity),
+	}, nil
+}
+
+func (c *Capture) Name() string {
+	return MiddlewareName
+}
+
+func (c *Capture) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the upgraded connections are not HTTP after the handshake
+		if !c.match(r) || middleware.IsUpgrade(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+
+		body := &cappedBody{ReadCloser: r.Body, limit: c.cfg.MaxBodySize}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = body
+		}
+
+		rec := &recorder{ResponseWriter: w, limit: c.cfg.MaxBodySize}
+		next.ServeHTTP(rec, r)
+
+		c.add(c.entry(r, body, rec, start))
+	})
+}
+
+// HAR returns the captures of the ring buffer, the oldest first
+func (c *Capture) HAR() *HAR {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := make([]*Entry, 0, len(c.ring))
+	entries = append(entries, c.ring[c.next:]...)
+	entries = append(entries, c.ring[:c.next]...)
+
+	return newHAR(entries)
+}
+
+// Clear empties the ring buffer and returns the number of the removed captures
+func (c *Capture) Clear() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// the captures are released along with the backing array
+	n := len(c.ring)
+	c.ring = nil
+	c.next = 0
+
+	return n
+}
+
+func (c *Capture) match(r *http.Request) bool {
+	if len(c.cfg.Paths) > 0 {
+		matched := false
+		for i := 0; i < len(c.cfg.Paths); i++ {
+			if strings.HasPrefix(r.URL.Path, c.cfg.Paths[i]) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	for name, value := range c.cfg.Headers {
+		got := r.Header.Values(name)
+		if len(got) == 0 || (value != "" && !slices.Contains(got, value)) {
+			return false
+		}
+	}
+
+	return *c.cfg.SampleRate >= 1 || rand.Float64() < *c.cfg.SampleRate //nolint:gosec
+}
+
+func (c *Capture) entry(r *http.Request, body *cappedBody, rec *recorder, start time.Time) *Entry {
+	elapsed := float64(time.Since(start).Microseconds()) / 1000
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	status := rec.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	requestURI := r.URL.EscapedPath()
+	query := c.redact.RawQuery(r.URL.RawQuery)
+	if query != "" {
+		requestURI += "?" + query
+	}
+
+	queryValues, _ := url.ParseQuery(query)
+
+	e := &Entry{
+		StartedDateTime: start,
+		Time:            elapsed,
+		Request: HARRequest{
+			Method:      r.Method,
+			URL:         scheme + "://" + r.Host + requestURI,
+			HTTPVersion: r.Proto,
+			Cookies:     []HARNameValue{},
+			Headers:     nameValues(c.redactHeader(r.Header)),
+			QueryString: nameValues(queryValues),
+			HeadersSize: -1,
+			BodySize:    body.read,
+			RemoteAddr:  c.remoteAddr(r.RemoteAddr),
+			Truncated:   body.truncated,
+			Comment:     middleware.GetRequestID(r),
+		},
+		Response: HARResponse{
+			Status:      status,
+			StatusText:  http.StatusText(status),
+			HTTPVersion: r.Proto,
+			Cookies:     []HARNameValue{},
+			Headers:     nameValues(c.redactHeader(rec.Header())),
+			Content: HARContent{
+				Size:     rec.written,
+				MimeType: rec.Header().Get("Content-Type"),
+				Text:     c.redact.Body(rec.Header().Get("Content-Type"), rec.body),
+			},
+			RedirectURL: rec.Header().Get("Location"),
+			HeadersSize: -1,
+			BodySize:    rec.written,
+			Truncated:   rec.truncated,
+		},
+		Timings: HARTimings{Wait: elapsed},
+	}
+
+	if body.read > 0 {
+		e.Request.PostData = &HARPostData{MimeType: r.Header.Get("Content-Type"), Text: c.redact.Body(r.Header.Get("Content-Type"), body.data)}
+	}
+
+	return e
+}
+
+func (c *Capture) redactHeader(header http.Header) http.Header {
+	out := header.Clone()
+	for name, values := range out {
+		for i := 0; i < len(values); i++ {
+			values[i] = c.redact.Header(name, values[i])
+		}
+	}
+
+	return out
+}
+
+// remoteAddr anonymizes the IP of the host:port address
+func (c *Capture) remoteAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return c.redact.Addr(addr)
+	}
+
+	return net.JoinHostPort(c.redact.Addr(host), port)
+}
+
+func (c *Capture) add(e *Entry) {
+	id := c.seq.Add(1)
+
+	c.mu.Lock()
+	if c.cfg.Capacity > 0 {
+		if len(c.ring) < c.cfg.Capacity {
+			c.ring = append(c.ring, e)
+		} else {
+			c.ring[c.next] = e
+			c.next = (c.next + 1) % c.cfg.Capacity
+		}
+	}
+	c.mu.Unlock()
+
+	if c.cfg.Dir != "" {
+		c.write(id, e)
+	}
+}
+
+// write stores the capture as the file named by the time and the sequence number
+func (c *Capture) write(id uint64, e *Entry) {
+	name := e.StartedDateTime.UTC().Format("20060102T150405.000") + "-" + strconv.FormatUint(id, 10)
+
+	var data []byte
+	var err error
+	switch c.cfg.Format {
+	case FormatRaw:
+		name += ".txt"
+		data = e.raw()
+	default:
+		name += ".har"
+		data, err = json.Marshal(newHAR([]*Entry{e}))
+	}
+
+	if err == nil {
+		err = os.WriteFile(filepath.Join(c.cfg.Dir, name), data, 0o600)
+	}
+
+	if err != nil {
+		c.log.Warn("failed to write the capture", "file", name, "error", err)
+	}
+}
+
+// cappedBody keeps the beginning of the request body
+type cappedBody struct {
+	io.ReadCloser
+	limit     int
+	data      []byte
+	read      int
+	truncated bool
+}
+
+func (b *cappedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += n
+	b.data, b.truncated = keep(b.data, p[:n], b.limit, b.truncated)
+
+	return n, err
+}
+
+// recorder keeps the status and the beginning of the response body
+type recorder struct {
+	http.ResponseWriter
+	status    int
+	limit     int
+	body      []byte
+	written   int
+	truncated bool
+}
+
+func (r *recorder) WriteHeader(code int) {
+	if r.status == 0 || r.status < http.StatusOK {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	n, err := r.ResponseWriter.Write(b)
+	r.written += n
+	r.body, r.truncated = keep(r.body, b[:n], r.limit, r.truncated)
+
+	return n, err
+}
+
+func (r *recorder) Flush() {
+	if fl, ok := r.ResponseWriter.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+func (r *recorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := r.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+
+	return nil, nil, middleware.ErrHijackerNotSupported
+}
+
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// keep appends p up to the limit and reports whether the data has been truncated
+func keep(data, p []byte, limit int, truncated bool) ([]byte, bool) {
+	if len(data)+len(p) > limit {
+		return append(data, p[:max(limit-len(data), 0)]...), true
+	}
+
+	return append(data, p...), truncated
+}
@@ -0,0 +1,94 @@
+package capture
+
+import (
+	"strings"
+
+	"github.com/roadrunner-server/errors"
+	"github.com/rumorshub/http/middleware"
+)
+
+const (
+	FormatHAR = "har"
+	FormatRaw = "raw"
+)
+
+type Config struct {
+	// Paths prefixes of the captured requests, all paths when empty.
+	Paths []string `mapstructure:"paths" json:"paths,omitempty" bson:"paths,omitempty"`
+
+	// Headers the captured requests should have, the empty value matches any value, e.g. X-Debug-Capture.
+	Headers map[string]string `mapstructure:"headers" json:"headers,omitempty" bson:"headers,omitempty"`
+
+	// SampleRate of the matching requests to capture, from 0 to 1. Default: 1.
+	SampleRate *float64 `mapstructure:"sample_rate" json:"sample_rate,omitempty" bson:"sample_rate,omitempty"`
+
+	// MaxBodySize captured per request and response body in bytes, the rest is not kept. Default: 64Kb.
+	MaxBodySize int `mapstructure:"max_body_size" json:"max_body_size,omitempty" bson:"max_body_size,omitempty"`
+
+	// Capacity of the ring buffer of the last captures, retrieved over RPC. Default: 100.
+	Capacity int `mapstructure:"capacity" json:"capacity,omitempty" bson:"capacity,omitempty"`
+
+	// Dir to write every capture to as a file in addition to the ring buffer, optional.
+	Dir string `mapstructure:"dir" json:"dir,omitempty" bson:"dir,omitempty"`
+
+	// Format of the files, har or raw (HTTP/1.1 wire format). Default: har.
+	Format string `mapstructure:"format" json:"format,omitempty" bson:"format,omitempty"`
+
+	// Redact the captures the same way as the access log, the query parameters keys are hidden in the form and JSON
+	// bodies as well. Default headers: Authorization, Proxy-Authorization, Cookie, Set-Cookie.
+	Redact *middleware.RedactConfig `mapstructure:"redact" json:"redact,omitempty" bson:"redact,omitempty"`
+}
+
+func (c *Config) InitDefaults() error {
+	const op = errors.Op("capture_config")
+
+	if c.SampleRate == nil {
+		rate := 1.0
+		c.SampleRate = &rate
+	}
+
+	if *c.SampleRate < 0 || *c.SampleRate > 1 {
+		return errors.E(op, errors.Errorf("sample_rate should be in the [0, 1] range, got %v", *c.SampleRate))
+	}
+
+	if c.MaxBodySize == 0 {
+		c.MaxBodySize = 64 * 1024
+	}
+
+	if c.Capacity == 0 {
+		c.Capacity = 100
+	}
+
+	if c.MaxBodySize < 0 || c.Capacity < 0 {
+		return errors.E(op, errors.Str("max_body_size and capacity should not be negative"))
+	}
+
+	if c.Format == "" {
+		c.Format = FormatHAR
+	}
+
+	if c.Format != FormatHAR && c.Format != FormatRaw {
+		return errors.E(op, errors.Errorf("format should be har or raw, got %q", c.Format))
+	}
+
+	if c.Redact == nil {
+		c.Redact = &middleware.RedactConfig{}
+	}
+
+	if len(c.Redact.Headers) == 0 {
+		c.Redact.Headers = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+	}
+
+	err := c.Redact.InitDefaults()
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	for i := 0; i < len(c.Paths); i++ {
+		if !strings.HasPrefix(c.Paths[i], "/") {
+			return errors.E(op, errors.Errorf("path should start with /, got %q", c.Paths[i]))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package capture
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// HAR is the HTTP Archive 1.2 of the captured requests, opened by the browser developer tools
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []*Entry   `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Entry is the captured request and response
+type Entry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+	Comment     string         `json:"comment,omitempty"`
+	RemoteAddr  string         `json:"_remoteAddr,omitempty"`
+	Truncated   bool           `json:"_truncated,omitempty"`
+}
+
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+	Truncated   bool           `json:"_truncated,omitempty"`
+}
+
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type HARContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+}
+
+type HARTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+func newHAR(entries []*Entry) *HAR {
+	return &HAR{Log: HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "rumorshub/http", Version: "1"},
+		Entries: entries,
+	}}
+}
+
+// nameValues returns the sorted pairs of the header or query
+func nameValues(values map[string][]string) []HARNameValue {
+	out := make([]HARNameValue, 0, len(values))
+	for name, vv := range values {
+		for i := 0; i < len(vv); i++ {
+			out = append(out, HARNameValue{Name: name, Value: vv[i]})
+		}
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+
+	return out
+}
+
+// raw returns the entry in the HTTP/1.1 wire format
+func (e *Entry) raw() []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "%s %s %s\r\n", e.Request.Method, e.Request.URL, e.Request.HTTPVersion)
+	for i := 0; i < len(e.Request.Headers); i++ {
+		fmt.Fprintf(&buf, "%s: %s\r\n", e.Request.Headers[i].Name, e.Request.Headers[i].Value)
+	}
+	buf.WriteString("\r\n")
+	if e.Request.PostData != nil {
+		buf.WriteString(e.Request.PostData.Text)
+	}
+
+	fmt.Fprintf(&buf, "\r\n\r\n%s %d %s\r\n", e.Response.HTTPVersion, e.Response.Status, e.Response.StatusText)
+	for i := 0; i < len(e.Response.Headers); i++ {
+		fmt.Fprintf(&buf, "%s: %s\r\n", e.Response.Headers[i].Name, e.Response.Headers[i].Value)
+	}
+	buf.WriteString("\r\n")
+	buf.WriteString(e.Response.Content.Text)
+
+	return buf.Bytes()
+}
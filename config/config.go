@@ -23,13 +23,61 @@
 package config
 
 import (
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/roadrunner-server/errors"
 
+	"github.com/rumorshub/http/audit"
+	"github.com/rumorshub/http/cache"
+	"github.com/rumorshub/http/capture"
+	"github.com/rumorshub/http/inspector"
+	"github.com/rumorshub/http/metrics"
+	"github.com/rumorshub/http/middleware"
+	"github.com/rumorshub/http/proxy"
 	"github.com/rumorshub/http/servers/https"
+	"github.com/rumorshub/http/servers/listener"
+	"github.com/rumorshub/http/servers/mux"
+	"github.com/rumorshub/http/supervisor"
 )
 
+// BundledConfig of the access_log and max_request_size middleware. The max_request_size wraps the handler under the
+// user-defined middleware, the access_log wraps every middleware of the server, so the rejections are logged and
+// counted. Both could be positioned by listing them in the middleware order explicitly.
+type BundledConfig struct {
+	// DisableAccessLog turns the request logging off, the server stats are not collected then.
+	DisableAccessLog bool `mapstructure:"disable_access_log" json:"disable_access_log,omitempty" bson:"disable_access_log,omitempty"`
+
+	// DisableMaxRequestSize turns the request body limits off.
+	DisableMaxRequestSize bool `mapstructure:"disable_max_request_size" json:"disable_max_request_size,omitempty" bson:"disable_max_request_size,omitempty"`
+}
+
+// KeepAliveConfig of the persistent connections of every server, helps the load balancers to re-distribute
+// the clients.
+type KeepAliveConfig struct {
+	// Disable closes every connection after the response.
+	Disable bool `mapstructure:"disable" json:"disable,omitempty" bson:"disable,omitempty"`
+
+	// IdleTimeout of the connection between the requests. Default: the read timeout of the server.
+	IdleTimeout time.Duration `mapstructure:"idle_timeout" json:"idle_timeout,omitempty" bson:"idle_timeout,omitempty"`
+
+	// MaxRequests served on the HTTP/1.x connection, the last response has the Connection: close header.
+	// Default: unlimited.
+	MaxRequests uint64 `mapstructure:"max_requests" json:"max_requests,omitempty" bson:"max_requests,omitempty"`
+}
+
+func (c *KeepAliveConfig) Valid() error {
+	if c.IdleTimeout < 0 {
+		return errors.Errorf("idle_timeout should not be negative, got %s", c.IdleTimeout)
+	}
+
+	return nil
+}
+
 type Config struct {
 	// Host and port to handle as http server.
 	Address string `mapstructure:"address" json:"address,omitempty" bson:"address,omitempty"`
@@ -40,11 +88,128 @@ type Config struct {
 	// MaxRequestSize specified max size for payload body in megabytes, default: 100Mb.
 	MaxRequestSize uint64 `mapstructure:"max_request_size" json:"max_request_size,omitempty" bson:"max_request_size,omitempty"`
 
+	// MaxRequestSizeOverrides by the path prefix and methods, the longest matching path is used.
+	MaxRequestSizeOverrides []middleware.RequestSizeLimit `mapstructure:"max_request_size_overrides" json:"max_request_size_overrides,omitempty" bson:"max_request_size_overrides,omitempty"`
+
+	// URILimit rejects the requests with the long URIs before the middleware, off by default.
+	URILimit *middleware.URILimitConfig `mapstructure:"uri_limit" json:"uri_limit,omitempty" bson:"uri_limit,omitempty"`
+
+	// Streaming endpoints (SSE, long-poll) are flushed eagerly and not buffered by the middleware.
+	Streaming *middleware.StreamingConfig `mapstructure:"streaming" json:"streaming,omitempty" bson:"streaming,omitempty"`
+
+	// BodySpool buffers the large request bodies to the temporary files instead of the memory.
+	BodySpool *middleware.BodySpoolConfig `mapstructure:"body_spool" json:"body_spool,omitempty" bson:"body_spool,omitempty"`
+
 	// SSL defines https server options.
 	SSL *https.SSLConfig `mapstructure:"ssl" json:"ssl,omitempty" bson:"ssl,omitempty"`
 
 	// HTTP2 configuration
 	HTTP2 *https.HTTP2Config `mapstructure:"http2" json:"http2,omitempty" bson:"http2,omitempty"`
+
+	// Multiplex sniffs the connections of the http listener, the protocols of other plugins (SSH, TLS)
+	// are served on the same port next to HTTP/1.1, h2c and gRPC.
+	Multiplex *mux.Config `mapstructure:"multiplex" json:"multiplex,omitempty" bson:"multiplex,omitempty"`
+
+	// TrustedSubnets of the proxies (load balancers) allowed to pass the client address in the RealIPHeader.
+	TrustedSubnets []string `mapstructure:"trusted_subnets" json:"trusted_subnets,omitempty" bson:"trusted_subnets,omitempty"`
+
+	// RealIPHeader the trusted proxies pass the client address in: Forwarded, X-Forwarded-For or X-Real-IP.
+	// Only this header is read, the proxies should overwrite or append to it. Default: X-Forwarded-For.
+	RealIPHeader string `mapstructure:"real_ip_header" json:"real_ip_header,omitempty" bson:"real_ip_header,omitempty"`
+
+	// GeoIP enables the country resolution and filtering.
+	GeoIP *middleware.GeoIPConfig `mapstructure:"geoip" json:"geoip,omitempty" bson:"geoip,omitempty"`
+
+	// UserAgent filters the requests by the User-Agent rules and verifies the known bots.
+	UserAgent *middleware.UserAgentConfig `mapstructure:"user_agent" json:"user_agent,omitempty" bson:"user_agent,omitempty"`
+
+	// BasicAuth protects the endpoints with the basic authentication.
+	BasicAuth *middleware.BasicAuthConfig `mapstructure:"basic_auth" json:"basic_auth,omitempty" bson:"basic_auth,omitempty"`
+
+	// JWT validates the Bearer tokens.
+	JWT *middleware.JWTConfig `mapstructure:"jwt" json:"jwt,omitempty" bson:"jwt,omitempty"`
+
+	// HMAC verifies the signed requests (webhooks).
+	HMAC *middleware.HMACConfig `mapstructure:"hmac" json:"hmac,omitempty" bson:"hmac,omitempty"`
+
+	// SignedURL verifies the expiring signed links of the protected downloads.
+	SignedURL *middleware.SignedURLConfig `mapstructure:"signed_url" json:"signed_url,omitempty" bson:"signed_url,omitempty"`
+
+	// SecurityHeaders sets the hardening response headers.
+	SecurityHeaders *middleware.SecurityHeadersConfig `mapstructure:"security_headers" json:"security_headers,omitempty" bson:"security_headers,omitempty"`
+
+	// ErrorPages served instead of the plain text error responses.
+	ErrorPages *middleware.ErrorPagesConfig `mapstructure:"error_pages" json:"error_pages,omitempty" bson:"error_pages,omitempty"`
+
+	// Maintenance answers 503 to the untrusted clients while enabled, toggled over RPC at runtime.
+	Maintenance *middleware.MaintenanceConfig `mapstructure:"maintenance" json:"maintenance,omitempty" bson:"maintenance,omitempty"`
+
+	// DefaultStatus of the responses when no handler has been collected, 404 or 503. Default: 404.
+	DefaultStatus int `mapstructure:"default_status" json:"default_status,omitempty" bson:"default_status,omitempty"`
+
+	// SlowClients drops the clients sending the request headers and body too slowly (slowloris, slow POST).
+	SlowClients *middleware.SlowClientsConfig `mapstructure:"slow_clients" json:"slow_clients,omitempty" bson:"slow_clients,omitempty"`
+
+	// InFlight limits the number of the simultaneously served requests.
+	InFlight *middleware.InFlightConfig `mapstructure:"in_flight" json:"in_flight,omitempty" bson:"in_flight,omitempty"`
+
+	// Tarpit holds the abusive clients with the slow responses, used by the ban and the user_agent rules.
+	Tarpit *middleware.TarpitConfig `mapstructure:"tarpit" json:"tarpit,omitempty" bson:"tarpit,omitempty"`
+
+	// RateLimit limits the requests per client IP, host or tenant header.
+	RateLimit *middleware.RateLimitConfig `mapstructure:"rate_limit" json:"rate_limit,omitempty" bson:"rate_limit,omitempty"`
+
+	// Ban temporarily bans the IPs with the repeated authentication failures or error responses.
+	Ban *middleware.BanConfig `mapstructure:"ban" json:"ban,omitempty" bson:"ban,omitempty"`
+
+	// Honeypot answers the trap paths and bans the scanners hitting them.
+	Honeypot *middleware.HoneypotConfig `mapstructure:"honeypot" json:"honeypot,omitempty" bson:"honeypot,omitempty"`
+
+	// Proxy forwards the requests by the path prefix to the upstream pools.
+	Proxy *proxy.Config `mapstructure:"proxy" json:"proxy,omitempty" bson:"proxy,omitempty"`
+
+	// Mirror sends the copies of the requests to the secondary upstream.
+	Mirror *middleware.MirrorConfig `mapstructure:"mirror" json:"mirror,omitempty" bson:"mirror,omitempty"`
+
+	// Cache enables the shared response cache of the GET requests.
+	Cache *cache.Config `mapstructure:"cache" json:"cache,omitempty" bson:"cache,omitempty"`
+
+	// Metrics exposes the plugin metrics in the Prometheus text format on the dedicated address.
+	Metrics *metrics.Config `mapstructure:"metrics" json:"metrics,omitempty" bson:"metrics,omitempty"`
+
+	// Capture records the full requests and responses matching the filters, opt-in for debugging.
+	Capture *capture.Config `mapstructure:"capture" json:"capture,omitempty" bson:"capture,omitempty"`
+
+	// AuditLog records the security events (auth failures, rejections) apart from the access log.
+	AuditLog *audit.Config `mapstructure:"audit_log" json:"audit_log,omitempty" bson:"audit_log,omitempty"`
+
+	// AccessLog controls the bundled request logging.
+	AccessLog *middleware.AccessLogConfig `mapstructure:"access_log" json:"access_log,omitempty" bson:"access_log,omitempty"`
+
+	// Servers are the additional named servers with their own address, TLS, middleware and handler.
+	Servers map[string]*ServerConfig `mapstructure:"servers" json:"servers,omitempty" bson:"servers,omitempty"`
+
+	// ReloadSignal re-creates the servers with the re-read config on SIGHUP, the listeners of the kept addresses stay open.
+	// The config with the changed middleware sections (everything but the server settings) is rejected.
+	ReloadSignal bool `mapstructure:"reload_signal" json:"reload_signal,omitempty" bson:"reload_signal,omitempty"`
+
+	// Listener options of the TCP listeners bound by the servers.
+	Listener *listener.Config `mapstructure:"listener" json:"listener,omitempty" bson:"listener,omitempty"`
+
+	// KeepAlive controls the persistent connections of every server.
+	KeepAlive *KeepAliveConfig `mapstructure:"keep_alive" json:"keep_alive,omitempty" bson:"keep_alive,omitempty"`
+
+	// Bundled controls the request logging and size limit middleware applied to every server.
+	Bundled *BundledConfig `mapstructure:"bundled" json:"bundled,omitempty" bson:"bundled,omitempty"`
+
+	// TrustedClients exempted from the protective middleware (rate limits, maintenance mode, WAF).
+	TrustedClients *middleware.TrustedClientsConfig `mapstructure:"trusted_clients" json:"trusted_clients,omitempty" bson:"trusted_clients,omitempty"`
+
+	// Inspector enables the developer-mode request inspector UI.
+	Inspector *inspector.Config `mapstructure:"inspector" json:"inspector,omitempty" bson:"inspector,omitempty"`
+
+	// Workers enables the multi-process mode, the traffic is served by the worker processes.
+	Workers *supervisor.Config `mapstructure:"workers" json:"workers,omitempty" bson:"workers,omitempty"`
 }
 
 func (c *Config) EnableHTTP() bool {
@@ -55,51 +220,275 @@ func (c *Config) EnableTLS() bool {
 	if c.SSL == nil {
 		return false
 	}
-	if c.SSL.Acme != nil {
+	if c.SSL.Acme != nil || c.SSL.Spiffe != nil {
 		return true
 	}
 	return c.SSL.Key != "" || c.SSL.Cert != ""
 }
 
+// InitDefaults applies the defaults and validates the config, all the problems found are reported.
 func (c *Config) InitDefaults() error {
+	var errs []error
+
 	if c.MaxRequestSize == 0 {
 		c.MaxRequestSize = 100 // 100Mb
 	}
 
-	if c.HTTP2 != nil {
-		err := c.HTTP2.InitDefaults()
-		if err != nil {
-			return err
+	for i := 0; i < len(c.MaxRequestSizeOverrides); i++ {
+		limit := &c.MaxRequestSizeOverrides[i]
+		if !strings.HasPrefix(limit.Path, "/") || limit.Size == 0 {
+			errs = append(errs, errors.Errorf("max_request_size_overrides: path should start with / and size should be set, got path %q, size %d", limit.Path, limit.Size))
+		}
+
+		for j := 0; j < len(limit.Methods); j++ {
+			limit.Methods[j] = strings.ToUpper(limit.Methods[j])
 		}
 	}
 
+	if c.BodySpool != nil {
+		c.BodySpool.InitDefaults()
+	}
+
+	if c.RealIPHeader == "" {
+		c.RealIPHeader = middleware.HeaderXForwardedFor
+	}
+
+	c.RealIPHeader = http.CanonicalHeaderKey(c.RealIPHeader)
+	switch c.RealIPHeader {
+	case middleware.HeaderForwarded, middleware.HeaderXForwardedFor, middleware.HeaderXRealIP:
+	default:
+		errs = append(errs, errors.Errorf("real_ip_header should be Forwarded, X-Forwarded-For or X-Real-IP, got %q", c.RealIPHeader))
+	}
+
+	if c.Multiplex != nil {
+		c.Multiplex.InitDefaults()
+	}
+
+	if c.HTTP2 != nil {
+		errs = appendErr(errs, "http2", c.HTTP2.InitDefaults())
+	}
+
 	if c.SSL != nil {
-		err := c.SSL.InitDefaults()
-		if err != nil {
-			return err
+		errs = appendErr(errs, "ssl", c.SSL.InitDefaults())
+	}
+
+	for _, name := range c.serverNames() {
+		// the empty server configs are reported by Valid
+		if c.Servers[name] != nil {
+			errs = appendErr(errs, "servers."+name, c.Servers[name].InitDefaults())
 		}
 	}
 
-	return c.Valid()
+	if c.GeoIP != nil {
+		errs = appendErr(errs, "geoip", c.GeoIP.InitDefaults())
+	}
+
+	if c.UserAgent != nil {
+		errs = appendErr(errs, "user_agent", c.UserAgent.InitDefaults())
+	}
+
+	if c.BasicAuth != nil {
+		errs = appendErr(errs, "basic_auth", c.BasicAuth.InitDefaults())
+	}
+
+	if c.JWT != nil {
+		errs = appendErr(errs, "jwt", c.JWT.InitDefaults())
+	}
+
+	if c.HMAC != nil {
+		errs = appendErr(errs, "hmac", c.HMAC.InitDefaults())
+	}
+
+	if c.SignedURL != nil {
+		errs = appendErr(errs, "signed_url", c.SignedURL.InitDefaults())
+	}
+
+	if c.SecurityHeaders != nil {
+		c.SecurityHeaders.InitDefaults()
+	}
+
+	if c.Listener != nil {
+		errs = appendErr(errs, "listener", c.Listener.InitDefaults())
+	}
+
+	if c.KeepAlive != nil {
+		errs = appendErr(errs, "keep_alive", c.KeepAlive.Valid())
+	}
+
+	// maintenance middleware is always registered, so the mode could be toggled at runtime
+	if c.Maintenance == nil {
+		c.Maintenance = &middleware.MaintenanceConfig{}
+	}
+	c.Maintenance.InitDefaults()
+
+	switch c.DefaultStatus {
+	case 0:
+		c.DefaultStatus = http.StatusNotFound
+	case http.StatusNotFound, http.StatusServiceUnavailable:
+	default:
+		errs = append(errs, errors.Errorf("default_status should be 404 or 503, got %d", c.DefaultStatus))
+	}
+
+	if c.ErrorPages != nil {
+		errs = appendErr(errs, "error_pages", c.ErrorPages.InitDefaults())
+	}
+
+	if c.InFlight != nil {
+		errs = appendErr(errs, "in_flight", c.InFlight.InitDefaults())
+	}
+
+	if c.RateLimit != nil {
+		errs = appendErr(errs, "rate_limit", c.RateLimit.InitDefaults())
+	}
+
+	if c.Tarpit != nil {
+		errs = appendErr(errs, "tarpit", c.Tarpit.InitDefaults())
+	}
+
+	if c.Ban != nil {
+		errs = appendErr(errs, "ban", c.Ban.InitDefaults())
+	}
+
+	if c.Honeypot != nil {
+		errs = appendErr(errs, "honeypot", c.Honeypot.InitDefaults())
+	}
+
+	if c.URILimit != nil {
+		errs = appendErr(errs, "uri_limit", c.URILimit.InitDefaults())
+	}
+
+	if c.Streaming != nil {
+		errs = appendErr(errs, "streaming", c.Streaming.InitDefaults())
+	}
+
+	if c.SlowClients != nil {
+		errs = appendErr(errs, "slow_clients", c.SlowClients.InitDefaults())
+	}
+
+	if c.Proxy != nil {
+		errs = appendErr(errs, "proxy", c.Proxy.InitDefaults())
+	}
+
+	if c.Mirror != nil {
+		errs = appendErr(errs, "mirror", c.Mirror.InitDefaults())
+	}
+
+	if c.Cache != nil {
+		errs = appendErr(errs, "cache", c.Cache.InitDefaults())
+	}
+
+	if c.Metrics != nil {
+		errs = appendErr(errs, "metrics", c.Metrics.InitDefaults())
+	}
+
+	if c.Capture != nil {
+		errs = appendErr(errs, "capture", c.Capture.InitDefaults())
+	}
+
+	if c.AuditLog != nil {
+		errs = appendErr(errs, "audit_log", c.AuditLog.InitDefaults())
+	}
+
+	if c.AccessLog != nil {
+		errs = appendErr(errs, "access_log", c.AccessLog.InitDefaults())
+	}
+
+	if c.TrustedClients != nil {
+		errs = appendErr(errs, "trusted_clients", c.TrustedClients.InitDefaults())
+	}
+
+	if c.Inspector != nil {
+		errs = appendErr(errs, "inspector", c.Inspector.InitDefaults())
+	}
+
+	if c.Workers != nil {
+		errs = appendErr(errs, "workers", c.Workers.InitDefaults())
+	}
+
+	return stderrors.Join(append(errs, c.Valid())...)
 }
 
+// Valid reports all the problems of the config, not only the first one.
 func (c *Config) Valid() error {
 	const op = errors.Op("validation")
 
+	var errs []error
+
 	if !c.EnableHTTP() && !c.EnableTLS() {
-		return errors.E(op, errors.Str("unable to run http service, no method has been specified (http, https, http/2)"))
+		errs = append(errs, errors.Str("unable to run http service, no method has been specified (http, https, http/2)"))
 	}
 
 	if c.Address != "" && !strings.Contains(c.Address, ":") {
-		return errors.E(op, errors.Str("malformed http server address"))
+		errs = append(errs, errors.Str("malformed http server address"))
 	}
 
 	if c.EnableTLS() {
-		err := c.SSL.Valid()
-		if err != nil {
-			return errors.E(op, err)
+		errs = appendErr(errs, "ssl", c.SSL.Valid())
+	}
+
+	for _, name := range c.serverNames() {
+		if c.Servers[name] == nil {
+			errs = append(errs, errors.Errorf("server '%s' config is empty", name))
+			continue
+		}
+
+		errs = appendErr(errs, "servers."+name, c.Servers[name].Valid(name))
+	}
+
+	if c.Honeypot != nil && c.Honeypot.Ban && c.Ban == nil {
+		errs = append(errs, errors.Str("honeypot.ban requires the ban config"))
+	}
+
+	if c.Tarpit == nil {
+		if c.Ban != nil && c.Ban.Tarpit {
+			errs = append(errs, errors.Str("ban.tarpit requires the tarpit config"))
+		}
+
+		if c.UserAgent != nil && slices.ContainsFunc(c.UserAgent.Rules, func(rule middleware.UserAgentRule) bool {
+			return rule.Action == middleware.UserAgentTarpit
+		}) {
+			errs = append(errs, errors.Str("user_agent tarpit rules require the tarpit config"))
+		}
+	}
+
+	if c.Workers != nil {
+		// every worker binds the same addresses, only SO_REUSEPORT TCP sockets could be shared
+		if strings.HasPrefix(c.Address, "unix://") || (c.EnableTLS() && strings.HasPrefix(c.SSL.Address, "unix://")) {
+			errs = append(errs, errors.Str("workers mode does not support unix sockets"))
+		}
+
+		if c.Listener != nil && c.Listener.ReusePort != nil && !*c.Listener.ReusePort {
+			errs = append(errs, errors.Str("workers mode requires listener.reuse_port"))
+		}
+
+		if c.Inspector != nil {
+			errs = append(errs, errors.Str("inspector is not supported in the workers mode"))
 		}
 	}
 
+	if len(errs) > 0 {
+		return errors.E(op, stderrors.Join(errs...))
+	}
+
 	return nil
 }
+
+// serverNames of the named servers in the stable order of the reported problems
+func (c *Config) serverNames() []string {
+	names := make([]string, 0, len(c.Servers))
+	for name := range c.Servers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// appendErr prefixes the problem of the section with its key
+func appendErr(errs []error, key string, err error) []error {
+	if err == nil {
+		return errs
+	}
+
+	return append(errs, fmt.Errorf("%s: %w", key, err))
+}
@@ -0,0 +1,410 @@
+package config
+
+import (
+	stderrors "errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+)
+
+// section of the plugin in the config file, the decode errors are prefixed with it
+const section = "http"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Decode decodes the raw plugin section into the config following the mapstructure tags, the same way the config
+// plugin does with the weakly typed input. The durations accept Go duration strings ("30s", "5m") as well as the
+// integer nanoseconds, the errors name the offending key.
+func Decode(raw map[string]any, cfg *Config) error {
+	const op = errors.Op("http_config_decode")
+
+	if cfg == nil {
+		return errors.E(op, errors.Str("config should be provided"))
+	}
+
+	err := decode(section, raw, reflect.ValueOf(cfg).Elem())
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	return nil
+}
+
+// Validate decodes the raw http section and applies the defaults the same way the plugin does, nothing is started.
+// All the problems found are reported, so the deployment tooling could lint the section before the rollout.
+func Validate(raw map[string]any) error {
+	const op = errors.Op("http_config_validate")
+
+	cfg := &Config{}
+	err := decode(section, raw, reflect.ValueOf(cfg).Elem())
+	if err != nil {
+		// the defaults of the partially decoded config would report the fields failed to decode once more
+		return errors.E(op, err)
+	}
+
+	err = cfg.InitDefaults()
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	return nil
+}
+
+func decode(key string, in any, out reflect.Value) error {
+	if in == nil {
+		return nil
+	}
+
+	value := reflect.ValueOf(in)
+	if value.Kind() == reflect.Pointer && value.IsNil() {
+		return nil
+	}
+
+	// typed values (the config provided by the code) are assigned as is
+	if value.Type().AssignableTo(out.Type()) {
+		out.Set(value)
+		return nil
+	}
+
+	if out.Type() == durationType {
+		return decodeDuration(key, value, out)
+	}
+
+	switch out.Kind() {
+	case reflect.Pointer:
+		if out.IsNil() {
+			out.Set(reflect.New(out.Type().Elem()))
+		}
+
+		return decode(key, in, out.Elem())
+	case reflect.Interface:
+		if !value.Type().Implements(out.Type()) {
+			return fmt.Errorf("%s: expected %s, got %T", key, out.Type(), in)
+		}
+
+		out.Set(value)
+		return nil
+	case reflect.Struct:
+		return decodeStruct(key, value, out)
+	case reflect.Map:
+		return decodeMap(key, value, out)
+	case reflect.Slice:
+		return decodeSlice(key, value, out)
+	case reflect.String:
+		return decodeString(key, value, out)
+	case reflect.Bool:
+		return decodeBool(key, value, out)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return decodeInt(key, value, out)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return decodeUint(key, value, out)
+	case reflect.Float32, reflect.Float64:
+		return decodeFloat(key, value, out)
+	default:
+		return fmt.Errorf("%s: unsupported type %s", key, out.Type())
+	}
+}
+
+func decodeDuration(key string, in, out reflect.Value) error {
+	switch in.Kind() {
+	case reflect.String:
+		d, err := time.ParseDuration(strings.TrimSpace(in.String()))
+		if err != nil {
+			return fmt.Errorf("%s: should be a duration like 30s or 5m, got %q", key, in.String())
+		}
+
+		out.SetInt(int64(d))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		out.SetInt(in.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		out.SetInt(int64(in.Uint()))
+	case reflect.Float32, reflect.Float64:
+		out.SetInt(int64(in.Float()))
+	default:
+		return fmt.Errorf("%s: should be a duration like 30s or 5m, got %T", key, in.Interface())
+	}
+
+	return nil
+}
+
+func decodeStruct(key string, in, out reflect.Value) error {
+	if in.Kind() != reflect.Map {
+		return fmt.Errorf("%s: expected a section, got %s", key, in.Type())
+	}
+
+	fields := make(map[string]int, out.NumField())
+	for i := 0; i < out.NumField(); i++ {
+		field := out.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		fields[strings.ToLower(name)] = i
+	}
+
+	var errs []error
+	names, values := sortedEntries(in)
+	for j := 0; j < len(names); j++ {
+		// the unknown keys are ignored the same way the config plugin does
+		i, ok := fields[strings.ToLower(names[j])]
+		if !ok {
+			continue
+		}
+
+		errs = append(errs, decode(key+"."+names[j], values[j], out.Field(i)))
+	}
+
+	return stderrors.Join(errs...)
+}
+
+func decodeMap(key string, in, out reflect.Value) error {
+	if in.Kind() != reflect.Map {
+		return fmt.Errorf("%s: expected a section, got %s", key, in.Type())
+	}
+
+	if out.IsNil() {
+		out.Set(reflect.MakeMapWithSize(out.Type(), in.Len()))
+	}
+
+	var errs []error
+	names, values := sortedEntries(in)
+	for i := 0; i < len(names); i++ {
+		k := reflect.New(out.Type().Key()).Elem()
+		err := decode(key, names[i], k)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		v := reflect.New(out.Type().Elem()).Elem()
+		err = decode(key+"."+names[i], values[i], v)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		out.SetMapIndex(k, v)
+	}
+
+	return stderrors.Join(errs...)
+}
+
+// sortedEntries of the raw section, the problems are reported in the stable order
+func sortedEntries(in reflect.Value) ([]string, []any) {
+	entries := make(map[string]any, in.Len())
+	names := make([]string, 0, in.Len())
+
+	iter := in.MapRange()
+	for iter.Next() {
+		name := fmt.Sprint(iter.Key().Interface())
+		entries[name] = iter.Value().Interface()
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	values := make([]any, len(names))
+	for i := 0; i < len(names); i++ {
+		values[i] = entries[names[i]]
+	}
+
+	return names, values
+}
+
+func decodeSlice(key string, in, out reflect.Value) error {
+	switch in.Kind() {
+	case reflect.Slice, reflect.Array:
+	case reflect.String:
+		// comma separated lists come from the environment variables
+		if out.Type().Elem().Kind() == reflect.String {
+			if in.String() == "" {
+				out.Set(reflect.MakeSlice(out.Type(), 0, 0))
+				return nil
+			}
+
+			in = reflect.ValueOf(strings.Split(in.String(), ","))
+			break
+		}
+
+		fallthrough
+	default:
+		in = reflect.ValueOf([]any{in.Interface()})
+	}
+
+	var errs []error
+	slice := reflect.MakeSlice(out.Type(), in.Len(), in.Len())
+	for i := 0; i < in.Len(); i++ {
+		errs = append(errs, decode(key+"["+strconv.Itoa(i)+"]", in.Index(i).Interface(), slice.Index(i)))
+	}
+
+	out.Set(slice)
+
+	return stderrors.Join(errs...)
+}
+
+func decodeString(key string, in, out reflect.Value) error {
+	switch in.Kind() {
+	case reflect.String:
+		out.SetString(in.String())
+	case reflect.Bool:
+		out.SetString(strconv.FormatBool(in.Bool()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		out.SetString(strconv.FormatInt(in.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		out.SetString(strconv.FormatUint(in.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		out.SetString(strconv.FormatFloat(in.Float(), 'f', -1, 64))
+	default:
+		return fmt.Errorf("%s: expected a string, got %s", key, in.Type())
+	}
+
+	return nil
+}
+
+func decodeBool(key string, in, out reflect.Value) error {
+	switch in.Kind() {
+	case reflect.Bool:
+		out.SetBool(in.Bool())
+	case reflect.String:
+		if in.String() == "" {
+			out.SetBool(false)
+			return nil
+		}
+
+		b, err := strconv.ParseBool(in.String())
+		if err != nil {
+			return fmt.Errorf("%s: expected a boolean, got %q", key, in.String())
+		}
+
+		out.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		out.SetBool(in.Int() != 0)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		out.SetBool(in.Uint() != 0)
+	default:
+		return fmt.Errorf("%s: expected a boolean, got %s", key, in.Type())
+	}
+
+	return nil
+}
+
+func decodeInt(key string, in, out reflect.Value) error {
+	var n int64
+
+	switch in.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = in.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = int64(in.Uint())
+	case reflect.Float32, reflect.Float64:
+		n = int64(in.Float())
+	case reflect.Bool:
+		if in.Bool() {
+			n = 1
+		}
+	case reflect.String:
+		if in.String() == "" {
+			break
+		}
+
+		var err error
+		n, err = strconv.ParseInt(strings.TrimSpace(in.String()), 0, out.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%s: expected an integer, got %q", key, in.String())
+		}
+	default:
+		return fmt.Errorf("%s: expected an integer, got %s", key, in.Type())
+	}
+
+	if out.OverflowInt(n) {
+		return fmt.Errorf("%s: %d overflows %s", key, n, out.Type())
+	}
+
+	out.SetInt(n)
+
+	return nil
+}
+
+func decodeUint(key string, in, out reflect.Value) error {
+	var n uint64
+
+	switch in.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if in.Int() < 0 {
+			return fmt.Errorf("%s: should not be negative, got %d", key, in.Int())
+		}
+
+		n = uint64(in.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = in.Uint()
+	case reflect.Float32, reflect.Float64:
+		if in.Float() < 0 {
+			return fmt.Errorf("%s: should not be negative, got %v", key, in.Float())
+		}
+
+		n = uint64(in.Float())
+	case reflect.Bool:
+		if in.Bool() {
+			n = 1
+		}
+	case reflect.String:
+		if in.String() == "" {
+			break
+		}
+
+		var err error
+		n, err = strconv.ParseUint(strings.TrimSpace(in.String()), 0, out.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%s: expected a positive integer, got %q", key, in.String())
+		}
+	default:
+		return fmt.Errorf("%s: expected a positive integer, got %s", key, in.Type())
+	}
+
+	if out.OverflowUint(n) {
+		return fmt.Errorf("%s: %d overflows %s", key, n, out.Type())
+	}
+
+	out.SetUint(n)
+
+	return nil
+}
+
+func decodeFloat(key string, in, out reflect.Value) error {
+	switch in.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		out.SetFloat(float64(in.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		out.SetFloat(float64(in.Uint()))
+	case reflect.Float32, reflect.Float64:
+		out.SetFloat(in.Float())
+	case reflect.String:
+		if in.String() == "" {
+			out.SetFloat(0)
+			return nil
+		}
+
+		f, err := strconv.ParseFloat(strings.TrimSpace(in.String()), out.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%s: expected a number, got %q", key, in.String())
+		}
+
+		out.SetFloat(f)
+	default:
+		return fmt.Errorf("%s: expected a number, got %s", key, in.Type())
+	}
+
+	return nil
+}
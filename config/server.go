@@ -0,0 +1,67 @@
+package config
+
+import (
+	"strings"
+
+	"github.com/roadrunner-server/errors"
+
+	"github.com/rumorshub/http/servers/https"
+)
+
+// ServerConfig of the additional named server, e.g. the internal port next to the public API one.
+type ServerConfig struct {
+	// Address of the plain HTTP server, the ssl address is used for the HTTPS one.
+	Address string `mapstructure:"address" json:"address,omitempty" bson:"address,omitempty"`
+
+	// Middleware of the server (order will be preserved).
+	Middleware []string `mapstructure:"middleware" json:"middleware,omitempty" bson:"middleware,omitempty"`
+
+	// Handler name of the collected named handler, the default handler is used when empty.
+	Handler string `mapstructure:"handler" json:"handler,omitempty" bson:"handler,omitempty"`
+
+	// SSL makes the server HTTPS, ACME and the redirects are supported by the default servers only.
+	SSL *https.SSLConfig `mapstructure:"ssl" json:"ssl,omitempty" bson:"ssl,omitempty"`
+}
+
+func (c *ServerConfig) InitDefaults() error {
+	if c.SSL != nil {
+		err := c.SSL.InitDefaults()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *ServerConfig) Valid(name string) error {
+	const op = errors.Op("server_config_valid")
+
+	// the names of the default servers
+	if name == "http" || name == "https" {
+		return errors.E(op, errors.Errorf("server name '%s' is reserved", name))
+	}
+
+	if c.SSL == nil {
+		if !strings.Contains(c.Address, ":") {
+			return errors.E(op, errors.Errorf("server '%s': malformed address '%s'", name, c.Address))
+		}
+
+		return nil
+	}
+
+	if c.Address != "" {
+		return errors.E(op, errors.Errorf("server '%s': address is not used by the HTTPS server, ssl.address should be set", name))
+	}
+
+	if c.SSL.EnableACME() || c.SSL.Redirect {
+		return errors.E(op, errors.Errorf("server '%s': acme and redirect are supported by the default servers only", name))
+	}
+
+	err := c.SSL.Valid()
+	if err != nil {
+		return errors.E(op, errors.Errorf("server '%s': %v", name, err))
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package http
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rumorshub/http/metrics"
+	"github.com/rumorshub/http/servers/listener"
+	"github.com/rumorshub/http/stats"
+)
+
+const (
+	// churnWindow the connections closed before serving a request are counted in
+	churnWindow = time.Minute
+	// churnThreshold of such connections per window, the warning is logged when they are the majority of closed
+	churnThreshold = 100
+)
+
+// connMetrics publishes the connection state transitions of the servers and warns about the abnormal churn,
+// the connections closed before serving a single request (port scanners, failed handshakes, clients giving up).
+type connMetrics struct {
+	current *metrics.Gauge
+	total   *metrics.Counter
+	rejects *metrics.Counter
+	log     *slog.Logger
+}
+
+func newConnMetrics(registry *metrics.Registry, log *slog.Logger) *connMetrics {
+	return &connMetrics{
+		current: registry.Gauge("http_connections", "Open connections by the state (new, active, idle).", "server", "state"),
+		total:   registry.Counter("http_connections_total", "Connections by the terminal state (new, hijacked, closed).", "server", "state"),
+		rejects: registry.Counter("http_connections_rejected_total", "Connections closed by the listener limits.", "address", "reason"),
+		log:     log,
+	}
+}
+
+// observe should be called before the server starts
+func (c *connMetrics) observe(name string, st *stats.Stats) {
+	churn := &connChurn{started: time.Now()}
+
+	st.Observe(func(from, to http.ConnState) {
+		// a new connection has no previous state
+		if to != http.StateNew && isOpenState(from) {
+			c.current.Dec(name, from.String())
+		}
+
+		if isOpenState(to) {
+			c.current.Inc(name, to.String())
+		}
+
+		if to == http.StateNew || to == http.StateHijacked || to == http.StateClosed {
+			c.total.Inc(name, to.String())
+		}
+
+		if to == http.StateClosed {
+			churn.closed(name, from == http.StateNew, c.log)
+		}
+	})
+}
+
+// rejected returns the callback of the listener limits of the address
+func (c *connMetrics) rejected(address string) func(reason listener.RejectReason, addr net.Addr) {
+	return func(reason listener.RejectReason, addr net.Addr) {
+		c.rejects.Inc(address, string(reason))
+		c.log.Debug("connection rejected by the listener limit", "address", address, "reason", reason, "remote", addr.String())
+	}
+}
+
+func isOpenState(state http.ConnState) bool {
+	return state == http.StateNew || state == http.StateActive || state == http.StateIdle
+}
+
+// connChurn counts the closed connections within the window
+type connChurn struct {
+	mu      sync.Mutex
+	started time.Time
+	total   int
+	unused  int
+}
+
+func (c *connChurn) closed(name string, unused bool, log *slog.Logger) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.total++
+	if unused {
+		c.unused++
+	}
+
+	if time.Since(c.started) < churnWindow {
+		return
+	}
+
+	if c.unused >= churnThreshold && c.unused*2 >= c.total {
+		log.Warn("abnormal connection churn, most connections were closed before serving a request",
+			"server", name,
+			"unused", c.unused,
+			"closed", c.total,
+			"window", time.Since(c.started).Round(time.Second),
+		)
+	}
+
+	c.started = time.Now()
+	c.total = 0
+	c.unused = 0
+}
@@ -2,6 +2,7 @@ package http
 
 import (
 	"log/slog"
+	"net/http"
 
 	"go.uber.org/zap"
 )
@@ -15,3 +16,15 @@ type Logger interface {
 	NamedLogger(name string) *slog.Logger
 	NamedZapLogger(name string) *zap.Logger
 }
+
+// Mountable handler is served under the path prefix returned by Pattern, the rest goes to the default handler.
+type Mountable interface {
+	http.Handler
+	Pattern() string
+}
+
+// NamedHandler is bound to the named servers by the handler config option, it does not replace the default handler.
+type NamedHandler interface {
+	http.Handler
+	HandlerName() string
+}
@@ -0,0 +1,38 @@
+package events
+
+import "time"
+
+type Type string
+
+const (
+	ListenerBound     Type = "listener_bound"
+	ServerStarted     Type = "server_started"
+	MiddlewareMissing Type = "middleware_missing"
+	ServerStopped     Type = "server_stopped"
+	ServeError        Type = "serve_error"
+)
+
+// Event is a server lifecycle change
+type Event struct {
+	Type Type
+	// Server is http or https
+	Server  string
+	Address string
+	// Middleware requested in the configuration but not registered
+	Middleware string
+	Time       time.Time
+	Error      error
+}
+
+// Listener is implemented by the plugins reacting to the server lifecycle (service discovery, readiness).
+// OnServerEvent is called synchronously from the server goroutines, so it should not block.
+type Listener interface {
+	OnServerEvent(event Event)
+}
+
+// ListenerFunc is an adapter to use ordinary functions as Listener
+type ListenerFunc func(event Event)
+
+func (f ListenerFunc) OnServerEvent(event Event) {
+	f(event)
+}
@@ -0,0 +1,372 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/big"
+	"net"
+	"os"
+
+	"github.com/roadrunner-server/errors"
+)
+
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// Reader looks up the MaxMind DB (GeoIP2/GeoLite2 Country or City) records.
+type Reader struct {
+	buf        []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint
+}
+
+// Open reads the whole database file into memory.
+func Open(path string) (*Reader, error) {
+	const op = errors.Op("geoip_open")
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	r, err := newReader(buf)
+	if err != nil {
+		return nil, errors.E(op, errors.Errorf("%s: %v", path, err))
+	}
+
+	return r, nil
+}
+
+func newReader(buf []byte) (*Reader, error) {
+	start := bytes.LastIndex(buf, metadataMarker)
+	if start == -1 {
+		return nil, errors.Str("invalid MaxMind DB, metadata not found")
+	}
+
+	meta := buf[start+len(metadataMarker):]
+	value, _, err := (&decoder{buf: meta}).decode(0)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, ok := value.(map[string]any)
+	if !ok {
+		return nil, errors.Str("invalid MaxMind DB metadata")
+	}
+
+	r := &Reader{
+		buf:        buf,
+		nodeCount:  uint(toUint(metadata["node_count"])),
+		recordSize: uint(toUint(metadata["record_size"])),
+		ipVersion:  uint(toUint(metadata["ip_version"])),
+	}
+
+	switch r.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, errors.Errorf("unsupported record size: %d", r.recordSize)
+	}
+
+	treeSize := r.recordSize * 2 / 8 * r.nodeCount
+	// 16 zero bytes separate the search tree and the data section
+	if treeSize+16 > uint(start) {
+		return nil, errors.Str("invalid MaxMind DB, search tree is out of bounds")
+	}
+
+	r.data = buf[treeSize+16 : start]
+
+	// IPv4 addresses are in the ::/96 subtree of the IPv6 databases
+	if r.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.nodeCount; i++ {
+			node = r.record(node, 0)
+		}
+		r.ipv4Start = node
+	}
+
+	return r, nil
+}
+
+// Lookup returns the record of the ip, nil when the address is not in the database.
+func (r *Reader) Lookup(ip net.IP) (map[string]any, error) {
+	node := uint(0)
+	bits := 128
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		bits = 32
+		if r.ipVersion == 6 {
+			node = r.ipv4Start
+		}
+	} else if r.ipVersion == 4 || len(ip) != net.IPv6len {
+		return nil, nil
+	}
+
+	for i := 0; i < bits && node < r.nodeCount; i++ {
+		bit := (ip[i>>3] >> (7 - uint(i&7))) & 1
+		node = r.record(node, uint(bit))
+	}
+
+	if node == r.nodeCount {
+		return nil, nil
+	}
+
+	if node < r.nodeCount {
+		return nil, errors.Str("invalid MaxMind DB, search tree is too deep")
+	}
+
+	offset := node - r.nodeCount - 16
+	value, _, err := (&decoder{buf: r.data}).decode(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	record, _ := value.(map[string]any)
+	return record, nil
+}
+
+// Country returns the ISO 3166-1 alpha-2 code of the country, registered country is used as a fallback.
+func (r *Reader) Country(ip net.IP) (string, error) {
+	record, err := r.Lookup(ip)
+	if err != nil || record == nil {
+		return "", err
+	}
+
+	for _, key := range []string{"country", "registered_country"} {
+		if country, ok := record[key].(map[string]any); ok {
+			if code, ok := country["iso_code"].(string); ok {
+				return code, nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+func (r *Reader) record(node, bit uint) uint {
+	switch r.recordSize {
+	case 24:
+		off := node*6 + bit*3
+		b := r.buf[off : off+3]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		off := node * 7
+		b := r.buf[off : off+7]
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		off := node*8 + bit*4
+		return uint(binary.BigEndian.Uint32(r.buf[off : off+4]))
+	}
+}
+
+const (
+	typeExtended uint = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// maxDepth of the nested maps, arrays and pointers, the real databases nest a few levels only. The cycles of the
+// corrupt database stop on it instead of overflowing the stack.
+const maxDepth = 32
+
+// decoder of the MaxMind DB data section
+type decoder struct {
+	buf []byte
+}
+
+// decode returns the value at the offset and the offset of the next value
+func (d *decoder) decode(offset uint) (any, uint, error) {
+	return d.decodeDepth(offset, 0)
+}
+
+func (d *decoder) decodeDepth(offset uint, depth int) (any, uint, error) {
+	if depth > maxDepth {
+		return nil, 0, errors.Str("invalid MaxMind DB, data is nested too deep")
+	}
+
+	if offset >= uint(len(d.buf)) {
+		return nil, 0, errors.Str("invalid MaxMind DB, data offset is out of bounds")
+	}
+
+	ctrl := d.buf[offset]
+	offset++
+
+	typ := uint(ctrl >> 5)
+	if typ == typeExtended {
+		if offset >= uint(len(d.buf)) {
+			return nil, 0, errors.Str("invalid MaxMind DB, unexpected end of data")
+		}
+		typ = 7 + uint(d.buf[offset])
+		offset++
+	}
+
+	if typ == typePointer {
+		pointer, next, err := d.pointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		// the pointer to a pointer is not valid (the spec), the self pointer included
+		if pointer < uint(len(d.buf)) && uint(d.buf[pointer]>>5) == typePointer {
+			return nil, 0, errors.Str("invalid MaxMind DB, pointer to a pointer")
+		}
+
+		value, _, err := d.decodeDepth(pointer, depth+1)
+		return value, next, err
+	}
+
+	size, offset, err := d.size(ctrl, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]any, size)
+		for i := uint(0); i < size; i++ {
+			var key, value any
+			key, offset, err = d.decodeDepth(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			value, offset, err = d.decodeDepth(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.Str("invalid MaxMind DB, map key is not a string")
+			}
+			m[k] = value
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]any, 0, size)
+		for i := uint(0); i < size; i++ {
+			var value any
+			value, offset, err = d.decodeDepth(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, errors.Str("invalid MaxMind DB, value is out of bounds")
+	}
+
+	b := d.buf[offset : offset+size]
+	next := offset + size
+
+	switch typ {
+	case typeString:
+		return string(b), next, nil
+	case typeBytes:
+		return bytes.Clone(b), next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errors.Str("invalid MaxMind DB, double size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errors.Str("invalid MaxMind DB, float size")
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), next, nil
+	case typeUint16, typeUint32, typeUint64:
+		var v uint64
+		for i := 0; i < len(b); i++ {
+			v = v<<8 | uint64(b[i])
+		}
+		return v, next, nil
+	case typeInt32:
+		var v uint32
+		for i := 0; i < len(b); i++ {
+			v = v<<8 | uint32(b[i])
+		}
+		return int32(v), next, nil //nolint:gosec
+	case typeUint128:
+		return new(big.Int).SetBytes(b), next, nil
+	default:
+		return nil, 0, errors.Errorf("invalid MaxMind DB, unknown data type: %d", typ)
+	}
+}
+
+func (d *decoder) size(ctrl byte, offset uint) (uint, uint, error) {
+	size := uint(ctrl & 0x1f)
+	if size < 29 {
+		return size, offset, nil
+	}
+
+	n := size - 28
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, errors.Str("invalid MaxMind DB, unexpected end of data")
+	}
+
+	var v uint
+	for i := uint(0); i < n; i++ {
+		v = v<<8 | uint(d.buf[offset+i])
+	}
+
+	switch size {
+	case 29:
+		return 29 + v, offset + n, nil
+	case 30:
+		return 285 + v, offset + n, nil
+	default:
+		return 65821 + v, offset + n, nil
+	}
+}
+
+func (d *decoder) pointer(ctrl byte, offset uint) (uint, uint, error) {
+	n := uint((ctrl>>3)&0x3) + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, errors.Str("invalid MaxMind DB, unexpected end of data")
+	}
+
+	var v uint
+	if n < 4 {
+		v = uint(ctrl & 0x7)
+	}
+	for i := uint(0); i < n; i++ {
+		v = v<<8 | uint(d.buf[offset+i])
+	}
+
+	switch n {
+	case 2:
+		v += 2048
+	case 3:
+		v += 526336
+	}
+
+	return v, offset + n, nil
+}
+
+func toUint(v any) uint64 {
+	u, _ := v.(uint64)
+	return u
+}
@@ -0,0 +1,110 @@
+package geoip
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+// mmdb builds the IPv4 database of the single node, 0.0.0.0/1 points to the data, 128.0.0.0/1 is not found
+func mmdb(data []byte) []byte {
+	var buf bytes.Buffer
+
+	// record size 24: the left record is the data offset 0, the right one is the node count (not found)
+	buf.Write([]byte{0, 0, 1 + 16, 0, 0, 1})
+	buf.Write(make([]byte, 16))
+	buf.Write(data)
+	buf.Write(metadataMarker)
+	buf.Write(mapOf(3))
+	buf.Write(str("node_count"))
+	buf.Write([]byte{6<<5 | 1, 1})
+	buf.Write(str("record_size"))
+	buf.Write([]byte{5<<5 | 1, 24})
+	buf.Write(str("ip_version"))
+	buf.Write([]byte{5<<5 | 1, 4})
+
+	return buf.Bytes()
+}
+
+func str(s string) []byte {
+	return append([]byte{2<<5 | byte(len(s))}, s...)
+}
+
+func mapOf(size int) []byte {
+	return []byte{7<<5 | byte(size)}
+}
+
+// pointer to the offset below 2048
+func pointer(offset int) []byte {
+	return []byte{1<<5 | byte(offset>>8), byte(offset)}
+}
+
+func TestReaderCountry(t *testing.T) {
+	data := append(mapOf(1), str("country")...)
+	data = append(data, mapOf(1)...)
+	data = append(data, str("iso_code")...)
+	data = append(data, str("DE")...)
+
+	r, err := newReader(mmdb(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	country, err := r.Country(net.ParseIP("10.0.0.1"))
+	if err != nil || country != "DE" {
+		t.Fatalf("country of 10.0.0.1: %q, %v", country, err)
+	}
+
+	country, err = r.Country(net.ParseIP("192.168.0.1"))
+	if err != nil || country != "" {
+		t.Fatalf("country of 192.168.0.1: %q, %v", country, err)
+	}
+
+	country, err = r.Country(net.ParseIP("2001:db8::1"))
+	if err != nil || country != "" {
+		t.Fatalf("country of 2001:db8::1 in the IPv4 database: %q, %v", country, err)
+	}
+}
+
+func TestReaderPointerCycle(t *testing.T) {
+	// {"a": pointer to the map itself}
+	data := append(mapOf(1), str("a")...)
+	data = append(data, pointer(0)...)
+
+	r, err := newReader(mmdb(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = r.Lookup(net.ParseIP("10.0.0.1")); err == nil {
+		t.Fatal("the pointer cycle should fail the lookup")
+	}
+}
+
+func TestReaderSelfPointer(t *testing.T) {
+	r, err := newReader(mmdb(pointer(0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = r.Lookup(net.ParseIP("10.0.0.1")); err == nil {
+		t.Fatal("the pointer to itself should fail the lookup")
+	}
+}
+
+func TestReaderTruncated(t *testing.T) {
+	data := append(mapOf(1), str("country")...)
+
+	r, err := newReader(mmdb(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = r.Lookup(net.ParseIP("10.0.0.1")); err == nil {
+		t.Fatal("the truncated data should fail the lookup")
+	}
+
+	if _, err = newReader([]byte("not a database")); err == nil {
+		t.Fatal("the data without the metadata should not be opened")
+	}
+}
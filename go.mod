@@ -6,10 +6,12 @@ require (
 	github.com/caddyserver/certmagic v0.19.2
 	github.com/google/uuid v1.3.1
 	github.com/mholt/acmez v1.2.0
+	github.com/roadrunner-server/api/v4 v4.8.0
 	github.com/roadrunner-server/endure/v2 v2.4.2
 	github.com/roadrunner-server/errors v1.3.0
 	github.com/roadrunner-server/tcplisten v1.4.0
 	go.uber.org/zap v1.25.0
+	golang.org/x/crypto v0.13.0
 	golang.org/x/net v0.15.0
 	golang.org/x/sys v0.12.0
 )
@@ -20,7 +22,6 @@ require (
 	github.com/miekg/dns v1.1.55 // indirect
 	github.com/zeebo/blake3 v0.2.3 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
-	golang.org/x/crypto v0.13.0 // indirect
 	golang.org/x/mod v0.12.0 // indirect
 	golang.org/x/text v0.13.0 // indirect
 	golang.org/x/tools v0.13.0 // indirect
@@ -0,0 +1,57 @@
+package inspector
+
+import (
+	"net"
+
+	"github.com/roadrunner-server/errors"
+)
+
+type Config struct {
+	// Address of the inspector UI, only loopback addresses are allowed. Default: 127.0.0.1:8099.
+	Address string `mapstructure:"address" json:"address,omitempty" bson:"address,omitempty"`
+
+	// Capacity is the number of the last requests to keep. Default: 100.
+	Capacity int `mapstructure:"capacity" json:"capacity,omitempty" bson:"capacity,omitempty"`
+
+	// MaxBodySize captured per request body in bytes, the rest is not kept. Default: 64Kb.
+	MaxBodySize int `mapstructure:"max_body_size" json:"max_body_size,omitempty" bson:"max_body_size,omitempty"`
+}
+
+func (c *Config) InitDefaults() error {
+	if c.Address == "" {
+		c.Address = "127.0.0.1:8099"
+	}
+
+	if c.Capacity == 0 {
+		c.Capacity = 100
+	}
+
+	if c.MaxBodySize == 0 {
+		c.MaxBodySize = 64 * 1024
+	}
+
+	return c.Valid()
+}
+
+func (c *Config) Valid() error {
+	const op = errors.Op("inspector_config_valid")
+
+	host, _, err := net.SplitHostPort(c.Address)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	// captured requests contain credentials, the UI should never be exposed
+	if host != "localhost" {
+		ip := net.ParseIP(host)
+		if ip == nil || !ip.IsLoopback() {
+			return errors.E(op, errors.Errorf("inspector should listen on the loopback address, provided: %s", c.Address))
+		}
+	}
+
+	if c.Capacity < 0 {
+		return errors.E(op, errors.Str("capacity should be positive"))
+	}
+
+	return nil
+}
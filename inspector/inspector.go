@@ -0,0 +1,483 @@
+package inspector
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	rrErrors "github.com/roadrunner-server/errors"
+
+	"github.com/rumorshub/http/middleware"
+)
+
+const MiddlewareName = "inspector"
+
+var ErrHijackerNotSupported = errors.New("http.Hijacker interface is not supported")
+
+// Entry is a captured request
+type Entry struct {
+	ID                   uint64        `json:"id"`
+	ReplayOf             uint64        `json:"replay_of,omitempty"`
+	Time                 time.Time     `json:"time"`
+	Method               string        `json:"method"`
+	URL                  string        `json:"url"`
+	Proto                string        `json:"proto"`
+	Host                 string        `json:"host"`
+	RemoteAddr           string        `json:"remote_addr"`
+	TLS                  bool          `json:"tls"`
+	RequestHeader        http.Header   `json:"request_header,omitempty"`
+	RequestBody          string        `json:"request_body,omitempty"`
+	RequestBodyTruncated bool          `json:"request_body_truncated,omitempty"`
+	Status               int           `json:"status"`
+	ResponseHeader       http.Header   `json:"response_header,omitempty"`
+	BytesOut             int           `json:"bytes_out"`
+	Duration             time.Duration `json:"duration"`
+	Decisions            []string      `json:"decisions,omitempty"`
+
+	mu       sync.Mutex
+	finished bool
+	body     []byte
+	// handler the request was captured in front of, used to replay it
+	next http.Handler
+}
+
+// Annotate records the middleware decision
+func (e *Entry) Annotate(decision string) {
+	e.mu.Lock()
+	if !e.finished {
+		e.Decisions = append(e.Decisions, decision)
+	}
+	e.mu.Unlock()
+}
+
+// Inspector is a developer tool: it keeps the last requests passed through the middleware and serves a local-only UI
+// with a live tail of them and the ability to replay a captured request.
+type Inspector struct {
+	cfg *Config
+	log *slog.Logger
+	srv *http.Server
+
+	seq  atomic.Uint64
+	done chan struct{}
+	once sync.Once
+
+	mu      sync.RWMutex
+	entries []*Entry
+	pos     int
+	subs    map[chan *Entry]struct{}
+}
+
+func New(cfg *Config, log *slog.Logger) *Inspector {
+	i := &Inspector{
+		cfg:     cfg,
+		log:     log,
+		done:    make(chan struct{}),
+		entries: make([]*Entry, 0, cfg.Capacity),
+		subs:    make(map[chan *Entry]struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", i.index)
+	mux.HandleFunc("/api/requests", i.list)
+	mux.HandleFunc("/api/requests/", i.entry)
+	mux.HandleFunc("/api/tail", i.tail)
+
+	i.srv = &http.Server{
+		Handler:           localOnly(mux),
+		ReadHeaderTimeout: time.Minute,
+	}
+
+	return i
+}
+
+func (i *Inspector) Name() string {
+	return MiddlewareName
+}
+
+func (i *Inspector) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i.capture(next, w, r, 0)
+	})
+}
+
+func (i *Inspector) Start() error {
+	const op = rrErrors.Op("inspector_start")
+
+	l, err := net.Listen("tcp", i.cfg.Address)
+	if err != nil {
+		return rrErrors.E(op, err)
+	}
+
+	i.log.Debug("request inspector was started", "address", i.cfg.Address)
+	err = i.srv.Serve(l)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return rrErrors.E(op, err)
+	}
+
+	return nil
+}
+
+func (i *Inspector) Stop() {
+	// release the live tails, otherwise shutdown waits for them
+	i.once.Do(func() {
+		close(i.done)
+	})
+
+	err := i.srv.Shutdown(context.Background())
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		i.log.Error("inspector shutdown", "error", err)
+	}
+}
+
+// capture records the request, the decisions are the initial annotations of the entry
+func (i *Inspector) capture(next http.Handler, w http.ResponseWriter, r *http.Request, replayOf uint64, decisions ...string) *Entry {
+	e := &Entry{
+		ID:            i.seq.Add(1),
+		ReplayOf:      replayOf,
+		Time:          time.Now(),
+		Method:        r.Method,
+		URL:           r.URL.RequestURI(),
+		Proto:         r.Proto,
+		Host:          r.Host,
+		RemoteAddr:    r.RemoteAddr,
+		TLS:           r.TLS != nil,
+		RequestHeader: r.Header.Clone(),
+		Decisions:     decisions,
+		next:          next,
+	}
+
+	r = r.WithContext(middleware.WithAnnotator(r.Context(), e))
+
+	body := &capped{max: i.cfg.MaxBodySize}
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = &teeBody{ReadCloser: r.Body, buf: body}
+	}
+
+	rec := &recorder{w: w}
+	next.ServeHTTP(rec, r)
+
+	e.mu.Lock()
+	e.finished = true
+	e.Duration = time.Since(e.Time)
+	e.Status = rec.code
+	if e.Status == 0 {
+		e.Status = http.StatusOK
+	}
+	e.BytesOut = rec.written
+	e.ResponseHeader = w.Header().Clone()
+	e.body = body.buf.Bytes()
+	e.RequestBody = string(e.body)
+	e.RequestBodyTruncated = body.truncated
+	e.mu.Unlock()
+
+	i.add(e)
+
+	return e
+}
+
+func (i *Inspector) add(e *Entry) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.cfg.Capacity == 0 {
+		return
+	}
+
+	if len(i.entries) < i.cfg.Capacity {
+		i.entries = append(i.entries, e)
+	} else {
+		i.entries[i.pos] = e
+		i.pos = (i.pos + 1) % i.cfg.Capacity
+	}
+
+	for ch := range i.subs {
+		select {
+		case ch <- e:
+		default:
+			// slow UI, skip the entry, it is still available in the list
+		}
+	}
+}
+
+// snapshot returns the captured entries, newest first
+func (i *Inspector) snapshot() []*Entry {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	out := make([]*Entry, 0, len(i.entries))
+	for j := len(i.entries) - 1; j >= 0; j-- {
+		out = append(out, i.entries[(i.pos+j)%len(i.entries)])
+	}
+
+	return out
+}
+
+func (i *Inspector) get(id uint64) *Entry {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	for j := 0; j < len(i.entries); j++ {
+		if i.entries[j].ID == id {
+			return i.entries[j]
+		}
+	}
+
+	return nil
+}
+
+func (i *Inspector) index(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = io.WriteString(w, indexHTML)
+}
+
+func (i *Inspector) list(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, i.snapshot())
+}
+
+// entry handles /api/requests/{id} and /api/requests/{id}/replay
+func (i *Inspector) entry(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/requests/")
+	path, replay := strings.CutSuffix(path, "/replay")
+
+	id, err := strconv.ParseUint(path, 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	e := i.get(id)
+	if e == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !replay {
+		writeJSON(w, http.StatusOK, e)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	// the replay re-sends the captured credentials, the cross-site pages should not trigger it
+	if !sameOrigin(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, i.replay(e))
+}
+
+// replay re-sends the captured request through the same handler chain, the response is discarded (but captured)
+func (i *Inspector) replay(e *Entry) *Entry {
+	req, err := http.NewRequestWithContext(context.Background(), e.Method, e.URL, bytes.NewReader(e.body))
+	if err != nil {
+		return nil
+	}
+
+	req.Host = e.Host
+	req.RemoteAddr = e.RemoteAddr
+	req.Header = e.RequestHeader.Clone()
+	req.ContentLength = int64(len(e.body))
+	req.Header.Del("Content-Length")
+
+	var decisions []string
+	if e.RequestBodyTruncated {
+		decisions = append(decisions, "inspector: replayed with truncated body")
+	}
+
+	return i.capture(e.next, &discard{header: make(http.Header)}, req, e.ID, decisions...)
+}
+
+// localOnly rejects the requests with the Host other than the loopback one, so the pages of the DNS rebinding
+// domains could not read the captured requests
+func localOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+
+		if ip := net.ParseIP(strings.Trim(host, "[]")); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sameOrigin reports whether the request came from the inspector UI itself or from a non-browser client
+func sameOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		return false
+	}
+
+	origin := r.Header.Get("Origin")
+	return origin == "" || origin == "http://"+r.Host
+}
+
+// tail streams finished requests as server-sent events
+func (i *Inspector) tail(w http.ResponseWriter, r *http.Request) {
+	fl, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ch := make(chan *Entry, 64)
+	i.mu.Lock()
+	i.subs[ch] = struct{}{}
+	i.mu.Unlock()
+
+	defer func() {
+		i.mu.Lock()
+		delete(i.subs, ch)
+		i.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fl.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-i.done:
+			return
+		case e := <-ch:
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+
+			_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+			if err != nil {
+				return
+			}
+			fl.Flush()
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+type recorder struct {
+	w       http.ResponseWriter
+	code    int
+	written int
+}
+
+func (r *recorder) Header() http.Header {
+	return r.w.Header()
+}
+
+func (r *recorder) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+	r.w.WriteHeader(code)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.code == 0 {
+		r.code = http.StatusOK
+	}
+	n, err := r.w.Write(b)
+	r.written += n
+	return n, err
+}
+
+func (r *recorder) Flush() {
+	if fl, ok := r.w.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+func (r *recorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := r.w.(http.Hijacker); ok {
+		r.code = http.StatusSwitchingProtocols
+		return hj.Hijack()
+	}
+
+	return nil, nil, ErrHijackerNotSupported
+}
+
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.w
+}
+
+// discard is the response writer for the replayed requests
+type discard struct {
+	header http.Header
+}
+
+func (d *discard) Header() http.Header {
+	return d.header
+}
+
+func (d *discard) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (d *discard) WriteHeader(int) {}
+
+// capped keeps at most max bytes
+type capped struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (c *capped) Write(b []byte) (int, error) {
+	if left := c.max - c.buf.Len(); left < len(b) {
+		c.truncated = true
+		if left > 0 {
+			c.buf.Write(b[:left])
+		}
+		return len(b), nil
+	}
+
+	return c.buf.Write(b)
+}
+
+type teeBody struct {
+	io.ReadCloser
+	buf *capped
+}
+
+func (t *teeBody) Read(b []byte) (int, error) {
+	n, err := t.ReadCloser.Read(b)
+	if n > 0 {
+		_, _ = t.buf.Write(b[:n])
+	}
+	return n, err
+}
@@ -0,0 +1,63 @@
+package inspector
+
+const indexHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>http request inspector</title>
+<style>
+body { font: 13px/1.4 monospace; margin: 0; display: flex; height: 100vh; }
+#list { width: 55%; overflow: auto; border-right: 1px solid #ccc; }
+#details { flex: 1; overflow: auto; padding: 8px; white-space: pre-wrap; }
+table { border-collapse: collapse; width: 100%; }
+td, th { padding: 2px 6px; text-align: left; border-bottom: 1px solid #eee; }
+tr:hover { background: #f4f4f4; cursor: pointer; }
+.s4 { color: #b36b00; } .s5 { color: #c00; }
+button { margin-bottom: 8px; }
+</style>
+</head>
+<body>
+<div id="list">
+<table>
+<thead><tr><th>#</th><th>time</th><th>method</th><th>url</th><th>status</th><th>duration</th></tr></thead>
+<tbody id="rows"></tbody>
+</table>
+</div>
+<div id="details">select a request</div>
+<script>
+const rows = document.getElementById('rows');
+const details = document.getElementById('details');
+
+function row(e) {
+  const tr = document.createElement('tr');
+  tr.className = 's' + String(e.status)[0];
+  const cells = [e.id + (e.replay_of ? ' (replay of ' + e.replay_of + ')' : ''),
+    new Date(e.time).toLocaleTimeString(), e.method, e.url, e.status, (e.duration / 1e6).toFixed(2) + 'ms'];
+  for (const c of cells) {
+    const td = document.createElement('td');
+    td.textContent = c;
+    tr.appendChild(td);
+  }
+  tr.onclick = () => show(e.id);
+  rows.insertBefore(tr, rows.firstChild);
+}
+
+async function show(id) {
+  const e = await (await fetch('/api/requests/' + id)).json();
+  details.textContent = '';
+  const btn = document.createElement('button');
+  btn.textContent = 'replay';
+  btn.onclick = async () => {
+    const r = await (await fetch('/api/requests/' + id + '/replay', {method: 'POST'})).json();
+    if (r) show(r.id);
+  };
+  details.appendChild(btn);
+  details.appendChild(document.createTextNode(JSON.stringify(e, null, 2)));
+}
+
+fetch('/api/requests').then(r => r.json()).then(list => list.reverse().forEach(row));
+new EventSource('/api/tail').onmessage = m => row(JSON.parse(m.data));
+</script>
+</body>
+</html>
+`
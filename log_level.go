@@ -0,0 +1,50 @@
+package http
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+)
+
+// logLevel overrides the level of the logger plugin at runtime, nil level keeps the configured one
+type logLevel struct {
+	level atomic.Pointer[slog.Level]
+}
+
+func (l *logLevel) Set(level slog.Level) {
+	l.level.Store(&level)
+}
+
+// Reset restores the level configured in the logger plugin
+func (l *logLevel) Reset() {
+	l.level.Store(nil)
+}
+
+type levelHandler struct {
+	handler slog.Handler
+	level   *logLevel
+}
+
+func newLevelHandler(handler slog.Handler, level *logLevel) slog.Handler {
+	return &levelHandler{handler: handler, level: level}
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if l := h.level.level.Load(); l != nil {
+		return level >= *l
+	}
+
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.handler.Handle(ctx, r)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return newLevelHandler(h.handler.WithAttrs(attrs), h.level)
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return newLevelHandler(h.handler.WithGroup(name), h.level)
+}
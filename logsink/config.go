@@ -0,0 +1,144 @@
+package logsink
+
+import (
+	"log/slog"
+	"net/url"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+)
+
+const (
+	// TypeSyslog ships the RFC 5424 messages over UDP or TCP (octet counting framing)
+	TypeSyslog = "syslog"
+	// TypeUDP ships the JSON entries to the collector, one datagram per entry
+	TypeUDP = "udp"
+	// TypeTCP ships the newline delimited JSON entries to the collector
+	TypeTCP = "tcp"
+	// TypeWebhook posts the batches of the JSON entries as the JSON array
+	TypeWebhook = "webhook"
+)
+
+type Config struct {
+	// Name of the sink in the metrics. Default: the type.
+	Name string `mapstructure:"name" json:"name,omitempty" bson:"name,omitempty"`
+
+	// Type is syslog, udp, tcp or webhook.
+	Type string `mapstructure:"type" json:"type,omitempty" bson:"type,omitempty"`
+
+	// Address (host:port) of the syslog server or the collector.
+	Address string `mapstructure:"address" json:"address,omitempty" bson:"address,omitempty"`
+
+	// Network of the syslog server, udp or tcp. Default: udp.
+	Network string `mapstructure:"network" json:"network,omitempty" bson:"network,omitempty"`
+
+	// Facility of the syslog messages, from 0 to 23. Default: 16 (local0).
+	Facility *int `mapstructure:"facility" json:"facility,omitempty" bson:"facility,omitempty"`
+
+	// AppName of the syslog messages. Default: http.
+	AppName string `mapstructure:"app_name" json:"app_name,omitempty" bson:"app_name,omitempty"`
+
+	// URL of the webhook.
+	URL string `mapstructure:"url" json:"url,omitempty" bson:"url,omitempty"`
+
+	// Headers of the webhook requests, e.g. Authorization.
+	Headers map[string]string `mapstructure:"headers" json:"headers,omitempty" bson:"headers,omitempty"`
+
+	// BatchSize of the webhook requests. Default: 100.
+	BatchSize int `mapstructure:"batch_size" json:"batch_size,omitempty" bson:"batch_size,omitempty"`
+
+	// FlushInterval of the incomplete webhook batch. Default: 1s.
+	FlushInterval time.Duration `mapstructure:"flush_interval" json:"flush_interval,omitempty" bson:"flush_interval,omitempty"`
+
+	// Buffer of the entries waiting to be shipped, the new entries are dropped when it is full. Default: 1024.
+	Buffer int `mapstructure:"buffer" json:"buffer,omitempty" bson:"buffer,omitempty"`
+
+	// Timeout of the connection, write and webhook request. Default: 5s.
+	Timeout time.Duration `mapstructure:"timeout" json:"timeout,omitempty" bson:"timeout,omitempty"`
+
+	// MinLevel of the shipped entries: debug, info, warn or error. Default: info.
+	MinLevel string `mapstructure:"level" json:"level,omitempty" bson:"level,omitempty"`
+
+	level slog.Level
+}
+
+// Level is the minimal level of the shipped entries
+func (c *Config) Level() slog.Level {
+	return c.level
+}
+
+func (c *Config) InitDefaults() error {
+	const op = errors.Op("log_sink_config")
+
+	if c.Name == "" {
+		c.Name = c.Type
+	}
+
+	if c.Buffer == 0 {
+		c.Buffer = 1024
+	}
+
+	if c.Timeout == 0 {
+		c.Timeout = 5 * time.Second
+	}
+
+	if c.MinLevel == "" {
+		c.MinLevel = "info"
+	}
+
+	err := c.level.UnmarshalText([]byte(c.MinLevel))
+	if err != nil {
+		return errors.E(op, errors.Errorf("level should be debug, info, warn or error, got %q", c.MinLevel))
+	}
+
+	if c.Buffer < 0 || c.Timeout < 0 || c.BatchSize < 0 || c.FlushInterval < 0 {
+		return errors.E(op, errors.Str("buffer, timeout, batch_size and flush_interval should not be negative"))
+	}
+
+	switch c.Type {
+	case TypeSyslog:
+		if c.Network == "" {
+			c.Network = "udp"
+		}
+
+		if c.Network != "udp" && c.Network != "tcp" {
+			return errors.E(op, errors.Errorf("syslog network should be udp or tcp, got %q", c.Network))
+		}
+
+		if c.Facility == nil {
+			local0 := 16
+			c.Facility = &local0
+		}
+
+		if *c.Facility < 0 || *c.Facility > 23 {
+			return errors.E(op, errors.Errorf("syslog facility should be in the [0, 23] range, got %d", *c.Facility))
+		}
+
+		if c.AppName == "" {
+			c.AppName = "http"
+		}
+
+		fallthrough
+	case TypeUDP, TypeTCP:
+		if c.Address == "" {
+			return errors.E(op, errors.Errorf("address of the %s sink should be set", c.Type))
+		}
+	case TypeWebhook:
+		u, err := url.Parse(c.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.E(op, errors.Errorf("url of the webhook sink should be the http(s) URL, got %q", c.URL))
+		}
+
+		if c.BatchSize == 0 {
+			c.BatchSize = 100
+		}
+
+		if c.FlushInterval == 0 {
+			c.FlushInterval = time.Second
+		}
+	default:
+		return errors.E(op, errors.Errorf("type should be syslog, udp, tcp or webhook, got %q", c.Type))
+	}
+
+	return nil
+}
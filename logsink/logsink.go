@@ -0,0 +1,179 @@
+package logsink
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/rumorshub/http/metrics"
+)
+
+// entry is the JSON encoded log record
+type entry struct {
+	level slog.Level
+	time  time.Time
+	data  []byte
+}
+
+// Sinks ship the log records to the remote destinations asynchronously, the records are dropped and counted
+// instead of blocking the requests when the destination is slow or down.
+type Sinks struct {
+	sinks   []*sink
+	dropped *metrics.Counter
+	wg      sync.WaitGroup
+	stop    sync.Once
+	// done stops the sinks, the queues are never closed as the late records (e.g. of the hijacked connections
+	// closed after the stop) could still be pushed
+	done chan struct{}
+}
+
+// New starts the sinks, the configs should have the defaults applied. The dropped entries are counted by the sink
+// name and the reason (full, error).
+func New(cfgs []Config, dropped *metrics.Counter, log *slog.Logger) *Sinks {
+	s := &Sinks{
+		dropped: dropped,
+		done:    make(chan struct{}),
+	}
+
+	for i := 0; i < len(cfgs); i++ {
+		sk := newSink(&cfgs[i], s.done, s.dropped, log)
+		s.sinks = append(s.sinks, sk)
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			sk.run()
+		}()
+	}
+
+	return s
+}
+
+// Handler returns the handler writing the records to the primary handler and the sinks
+func (s *Sinks) Handler(primary slog.Handler) slog.Handler {
+	return &fanout{primary: primary, sinks: &handler{s: s}}
+}
+
+// Stop ships the buffered entries and closes the connections
+func (s *Sinks) Stop() {
+	s.stop.Do(func() {
+		close(s.done)
+	})
+
+	s.wg.Wait()
+}
+
+// enabled reports whether any sink ships the records of the level
+func (s *Sinks) enabled(level slog.Level) bool {
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+
+	for i := 0; i < len(s.sinks); i++ {
+		if level >= s.sinks[i].cfg.Level() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// push queues the entry to the sinks of its level, the entries pushed after the stop are dropped
+func (s *Sinks) push(e entry) {
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+
+	for i := 0; i < len(s.sinks); i++ {
+		if e.level < s.sinks[i].cfg.Level() {
+			continue
+		}
+
+		select {
+		case s.sinks[i].queue <- e:
+		default:
+			s.dropped.Inc(s.sinks[i].cfg.Name, "full")
+		}
+	}
+}
+
+// handler encodes the records as JSON for the sinks, the attrs and groups are applied by the JSON handler
+type handler struct {
+	s   *Sinks
+	ops []func(h slog.Handler) slog.Handler
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.s.enabled(level)
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	buf := &bytes.Buffer{}
+
+	var jh slog.Handler = slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+	for i := 0; i < len(h.ops); i++ {
+		jh = h.ops[i](jh)
+	}
+
+	err := jh.Handle(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	// the data is shared by the sinks, the capacity is limited so the appends copy it
+	data := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	h.s.push(entry{level: r.Level, time: r.Time, data: data[:len(data):len(data)]})
+
+	return nil
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(jh slog.Handler) slog.Handler { return jh.WithAttrs(attrs) })
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.with(func(jh slog.Handler) slog.Handler { return jh.WithGroup(name) })
+}
+
+func (h *handler) with(op func(h slog.Handler) slog.Handler) slog.Handler {
+	ops := make([]func(h slog.Handler) slog.Handler, 0, len(h.ops)+1)
+	ops = append(ops, h.ops...)
+
+	return &handler{s: h.s, ops: append(ops, op)}
+}
+
+// fanout passes the records to the primary handler by its level and to the sinks
+type fanout struct {
+	primary slog.Handler
+	sinks   slog.Handler
+}
+
+func (f *fanout) Enabled(ctx context.Context, level slog.Level) bool {
+	return f.primary.Enabled(ctx, level) || f.sinks.Enabled(ctx, level)
+}
+
+func (f *fanout) Handle(ctx context.Context, r slog.Record) error {
+	if f.primary.Enabled(ctx, r.Level) {
+		_ = f.primary.Handle(ctx, r.Clone())
+	}
+
+	if !f.sinks.Enabled(ctx, r.Level) {
+		return nil
+	}
+
+	return f.sinks.Handle(ctx, r)
+}
+
+func (f *fanout) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &fanout{primary: f.primary.WithAttrs(attrs), sinks: f.sinks.WithAttrs(attrs)}
+}
+
+func (f *fanout) WithGroup(name string) slog.Handler {
+	return &fanout{primary: f.primary.WithGroup(name), sinks: f.sinks.WithGroup(name)}
+}
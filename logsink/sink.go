@@ -0,0 +1,231 @@
+package logsink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rumorshub/http/metrics"
+)
+
+// sink ships the queued entries to a single destination
+type sink struct {
+	cfg     *Config
+	queue   chan entry
+	done    <-chan struct{}
+	dropped *metrics.Counter
+	log     *slog.Logger
+	failing bool
+
+	// conn of the syslog, udp and tcp sinks, re-dialed after the write error
+	conn   net.Conn
+	format func(e entry) []byte
+
+	client *http.Client
+}
+
+func newSink(cfg *Config, done <-chan struct{}, dropped *metrics.Counter, log *slog.Logger) *sink {
+	s := &sink{
+		cfg:     cfg,
+		queue:   make(chan entry, cfg.Buffer),
+		done:    done,
+		dropped: dropped,
+		log:     log,
+	}
+
+	switch cfg.Type {
+	case TypeSyslog:
+		s.format = syslogFormat(cfg)
+	case TypeUDP:
+		s.format = func(e entry) []byte { return e.data }
+	case TypeTCP:
+		s.format = func(e entry) []byte { return append(e.data, '\n') }
+	case TypeWebhook:
+		s.client = &http.Client{Timeout: cfg.Timeout}
+	}
+
+	return s
+}
+
+func (s *sink) run() {
+	if s.cfg.Type == TypeWebhook {
+		s.runBatches()
+		return
+	}
+
+	defer func() {
+		if s.conn != nil {
+			_ = s.conn.Close()
+		}
+	}()
+
+	for {
+		select {
+		case e := <-s.queue:
+			s.result(1, s.write(s.format(e)))
+		case <-s.done:
+			// ships the entries queued before the stop
+			for {
+				select {
+				case e := <-s.queue:
+					s.result(1, s.write(s.format(e)))
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// runBatches posts the full batches right away and the incomplete ones every flush interval
+func (s *sink) runBatches() {
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([][]byte, 0, s.cfg.BatchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			s.result(len(batch), s.post(batch))
+			batch = batch[:0]
+		}
+	}
+
+	for {
+		select {
+		case e := <-s.queue:
+			batch = append(batch, e.data)
+			if len(batch) >= s.cfg.BatchSize {
+				flush()
+			}
+		case <-s.done:
+			// ships the entries queued before the stop
+			for {
+				select {
+				case e := <-s.queue:
+					batch = append(batch, e.data)
+					if len(batch) >= s.cfg.BatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// result counts the entries lost by the failed delivery, the failure is logged once until the sink recovers
+func (s *sink) result(entries int, err error) {
+	if err == nil {
+		if s.failing {
+			s.failing = false
+			s.log.Info("access log sink recovered", "sink", s.cfg.Name)
+		}
+		return
+	}
+
+	s.dropped.Add(float64(entries), s.cfg.Name, "error")
+	if !s.failing {
+		s.failing = true
+		s.log.Warn("access log sink failed, the entries are dropped", "sink", s.cfg.Name, "error", err)
+	}
+}
+
+func (s *sink) write(data []byte) error {
+	if s.conn == nil {
+		network := s.cfg.Type
+		if s.cfg.Type == TypeSyslog {
+			network = s.cfg.Network
+		}
+
+		conn, err := net.DialTimeout(network, s.cfg.Address, s.cfg.Timeout)
+		if err != nil {
+			return err
+		}
+
+		s.conn = conn
+	}
+
+	_ = s.conn.SetWriteDeadline(time.Now().Add(s.cfg.Timeout))
+
+	_, err := s.conn.Write(data)
+	if err != nil {
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+
+	return err
+}
+
+func (s *sink) post(batch [][]byte) error {
+	body := make([]byte, 0, 64*len(batch))
+	body = append(body, '[')
+	body = append(body, bytes.Join(batch, []byte(","))...)
+	body = append(body, ']')
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range s.cfg.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+
+	return nil
+}
+
+// syslogFormat returns the RFC 5424 formatter, the TCP messages are framed by the octet counting (RFC 6587)
+func syslogFormat(cfg *Config) func(e entry) []byte {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	pid := strconv.Itoa(os.Getpid())
+
+	return func(e entry) []byte {
+		pri := *cfg.Facility*8 + severity(e.level)
+		msg := fmt.Appendf(nil, "<%d>1 %s %s %s %s access - ", pri, e.time.UTC().Format("2006-01-02T15:04:05.000000Z"), hostname, cfg.AppName, pid)
+		msg = append(msg, e.data...)
+
+		if cfg.Network == "tcp" {
+			return append(fmt.Appendf(nil, "%d ", len(msg)), msg...)
+		}
+
+		return msg
+	}
+}
+
+func severity(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	default:
+		return 7
+	}
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"net"
+
+	"github.com/roadrunner-server/errors"
+)
+
+type Config struct {
+	// Address of the metrics endpoint. Default: 127.0.0.1:2112.
+	Address string `mapstructure:"address" json:"address,omitempty" bson:"address,omitempty"`
+
+	// Path of the metrics endpoint. Default: /metrics.
+	Path string `mapstructure:"path" json:"path,omitempty" bson:"path,omitempty"`
+}
+
+func (c *Config) InitDefaults() error {
+	if c.Address == "" {
+		c.Address = "127.0.0.1:2112"
+	}
+
+	if c.Path == "" {
+		c.Path = "/metrics"
+	}
+
+	return c.Valid()
+}
+
+func (c *Config) Valid() error {
+	const op = errors.Op("metrics_config_valid")
+
+	_, _, err := net.SplitHostPort(c.Address)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	if c.Path[0] != '/' {
+		return errors.E(op, errors.Errorf("metrics path should start with /, provided: %s", c.Path))
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefBuckets of the latencies in seconds, the same as of the Prometheus clients
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram counts the observed values in the buckets. Every bucket keeps the exemplar of its latest
+// observation, so the dashboards could jump from the slow bucket to the request logs or the trace.
+type Histogram struct {
+	name    string
+	help    string
+	buckets []float64
+	labels  []string
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values    []string
+	counts    []uint64 // per bucket, the last one is +Inf
+	exemplars []exemplar
+	sum       float64
+	count     uint64
+}
+
+// maxExemplarLabels kept of the observation, the rest are dropped
+const maxExemplarLabels = 4
+
+// exemplar keeps the name and value pairs as observed, they are formatted by the scrape, not per request
+type exemplar struct {
+	pairs [2 * maxExemplarLabels]string
+	n     int
+	value float64
+	ts    time.Time
+}
+
+func newHistogram(name, help string, buckets []float64, labels []string) *Histogram {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	return &Histogram{
+		name:    name,
+		help:    help,
+		buckets: buckets,
+		labels:  labels,
+		series:  make(map[string]*histogramSeries),
+	}
+}
+
+// Observe records the value, label values are in the registration order
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.observe(v, nil, values)
+}
+
+// ObserveExemplar records the value with the exemplar labels, e.g. request_id and trace_id. The labels are the
+// name and value pairs, up to 4 of them, the ones with the empty values are skipped. The observation does not
+// allocate once the series exists.
+func (h *Histogram) ObserveExemplar(v float64, exemplarLabels []string, values ...string) {
+	h.observe(v, exemplarLabels, values)
+}
+
+func (h *Histogram) observe(v float64, exemplarLabels []string, values []string) {
+	var ex exemplar
+	for i := 0; i+1 < len(exemplarLabels) && ex.n < maxExemplarLabels; i += 2 {
+		if exemplarLabels[i+1] != "" {
+			ex.pairs[2*ex.n], ex.pairs[2*ex.n+1] = exemplarLabels[i], exemplarLabels[i+1]
+			ex.n++
+		}
+	}
+
+	if ex.n > 0 {
+		ex.value = v
+		ex.ts = time.Now()
+	}
+
+	bucket := sort.SearchFloat64s(h.buckets, v)
+
+	// the key of the lookup stays on the stack, it is allocated for the new series only
+	var buf [128]byte
+	key := buf[:0]
+	for i := 0; i < len(values); i++ {
+		if i > 0 {
+			key = append(key, '\xff')
+		}
+		key = append(key, values[i]...)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[string(key)]
+	if !ok {
+		// missing label values are empty
+		vals := make([]string, len(h.labels))
+		copy(vals, values)
+		s = &histogramSeries{
+			values:    vals,
+			counts:    make([]uint64, len(h.buckets)+1),
+			exemplars: make([]exemplar, len(h.buckets)+1),
+		}
+		h.series[string(key)] = s
+	}
+
+	s.counts[bucket]++
+	s.sum += v
+	s.count++
+	if ex.n > 0 {
+		s.exemplars[bucket] = ex
+	}
+}
+
+func (h *Histogram) write(w io.Writer, openMetrics bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, escape(h.help, openMetrics), h.name)
+
+	names := append(append([]string(nil), h.labels...), "le")
+	for i := 0; i < len(keys); i++ {
+		s := h.series[keys[i]]
+		values := append(append([]string(nil), s.values...), "")
+
+		var cumulative uint64
+		for j := 0; j < len(s.counts); j++ {
+			cumulative += s.counts[j]
+
+			values[len(values)-1] = "+Inf"
+			if j < len(h.buckets) {
+				values[len(values)-1] = formatFloat(h.buckets[j])
+			}
+
+			_, _ = fmt.Fprintf(w, "%s_bucket%s %d", h.name, labelPairs(names, values), cumulative)
+			if ex := &s.exemplars[j]; openMetrics && ex.n > 0 {
+				if labels := exemplarPairs(ex.pairs[:2*ex.n]); labels != "" {
+					_, _ = fmt.Fprintf(w, " # {%s} %s %s", labels, formatFloat(ex.value), strconv.FormatFloat(float64(ex.ts.UnixMilli())/1000, 'f', 3, 64))
+				}
+			}
+			_, _ = io.WriteString(w, "\n")
+		}
+
+		pairs := labelPairs(h.labels, s.values)
+		_, _ = fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n", h.name, pairs, formatFloat(s.sum), h.name, pairs, s.count)
+	}
+}
+
+// exemplarPairs formats the name and value pairs sorted by the name, without the braces. OpenMetrics limits the
+// label set of the exemplar to 128 characters, the longer one is dropped.
+func exemplarPairs(pairs []string) string {
+	size := 0
+	order := make([]int, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		order = append(order, i)
+		size += len(pairs[i]) + len(pairs[i+1])
+	}
+
+	if len(order) == 0 || size > 128 {
+		return ""
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return pairs[order[a]] < pairs[order[b]]
+	})
+
+	var sb strings.Builder
+	for i := 0; i < len(order); i++ {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(pairs[order[i]])
+		sb.WriteString(`="`)
+		sb.WriteString(escape(pairs[order[i]+1], true))
+		sb.WriteByte('"')
+	}
+
+	return sb.String()
+}
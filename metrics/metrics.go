@@ -0,0 +1,243 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry of the plugin metrics, exposed in the Prometheus text format.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	// write the metric family, the OpenMetrics one with the exemplars
+	write(w io.Writer, openMetrics bool)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Counter registers the monotonically increasing metric with the label names.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := &Counter{vec: newVec(name, help, "counter", labels)}
+	r.register(c)
+	return c
+}
+
+// Gauge registers the metric which can go up and down with the label names.
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{vec: newVec(name, help, "gauge", labels)}
+	r.register(g)
+	return g
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	r.metrics = append(r.metrics, m)
+	r.mu.Unlock()
+}
+
+// Histogram registers the distribution of the observed values in the buckets (upper bounds, +Inf is implicit)
+// with the label names.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := newHistogram(name, help, buckets, labels)
+	r.register(h)
+	return h
+}
+
+// Write writes all metrics in the Prometheus text exposition format.
+func (r *Registry) Write(w io.Writer) {
+	r.write(w, false)
+}
+
+// WriteOpenMetrics writes all metrics in the OpenMetrics text format, the only one carrying the exemplars.
+func (r *Registry) WriteOpenMetrics(w io.Writer) {
+	r.write(w, true)
+	_, _ = io.WriteString(w, "# EOF\n")
+}
+
+func (r *Registry) write(w io.Writer, openMetrics bool) {
+	r.mu.Lock()
+	metrics := r.metrics
+	r.mu.Unlock()
+
+	for i := 0; i < len(metrics); i++ {
+		metrics[i].write(w, openMetrics)
+	}
+}
+
+type Counter struct {
+	*vec
+}
+
+// Inc increments the counter, label values are in the registration order
+func (c *Counter) Inc(values ...string) {
+	c.add(1, values)
+}
+
+func (c *Counter) Add(v float64, values ...string) {
+	if v < 0 {
+		return
+	}
+	c.add(v, values)
+}
+
+type Gauge struct {
+	*vec
+}
+
+func (g *Gauge) Set(v float64, values ...string) {
+	g.set(v, values)
+}
+
+func (g *Gauge) Add(v float64, values ...string) {
+	g.add(v, values)
+}
+
+func (g *Gauge) Inc(values ...string) {
+	g.add(1, values)
+}
+
+func (g *Gauge) Dec(values ...string) {
+	g.add(-1, values)
+}
+
+// vec is the set of the metric series by the label values
+type vec struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	values []string
+	value  float64
+}
+
+func newVec(name, help, typ string, labels []string) *vec {
+	return &vec{
+		name:   name,
+		help:   help,
+		typ:    typ,
+		labels: labels,
+		series: make(map[string]*series),
+	}
+}
+
+func (v *vec) get(values []string) *series {
+	key := strings.Join(values, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		// missing label values are empty
+		vals := make([]string, len(v.labels))
+		copy(vals, values)
+		s = &series{values: vals}
+		v.series[key] = s
+	}
+
+	return s
+}
+
+func (v *vec) add(delta float64, values []string) {
+	v.mu.Lock()
+	v.get(values).value += delta
+	v.mu.Unlock()
+}
+
+func (v *vec) set(value float64, values []string) {
+	v.mu.Lock()
+	v.get(values).value = value
+	v.mu.Unlock()
+}
+
+// Value of the series, used by the informers
+func (v *vec) Value(values ...string) float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	s, ok := v.series[strings.Join(values, "\xff")]
+	if !ok {
+		return 0
+	}
+
+	return s.value
+}
+
+func (v *vec) write(w io.Writer, openMetrics bool) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// the OpenMetrics counter family is named without the _total suffix of its samples
+	family, name := v.name, v.name
+	if openMetrics && v.typ == "counter" {
+		family = strings.TrimSuffix(family, "_total")
+		name = family + "_total"
+	}
+
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", family, escape(v.help, openMetrics), family, v.typ)
+	for i := 0; i < len(keys); i++ {
+		s := v.series[keys[i]]
+		_, _ = fmt.Fprintf(w, "%s%s %s\n", name, labelPairs(v.labels, s.values), formatFloat(s.value))
+	}
+	v.mu.Unlock()
+}
+
+func labelPairs(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i := 0; i < len(names); i++ {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(names[i])
+		sb.WriteString(`="`)
+		sb.WriteString(escape(values[i], true))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+
+	return sb.String()
+}
+
+func escape(s string, quote bool) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	if quote {
+		s = strings.ReplaceAll(s, `"`, `\"`)
+	}
+
+	return s
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
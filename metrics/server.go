@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	rrErrors "github.com/roadrunner-server/errors"
+)
+
+// Server exposes the registry metrics on the dedicated address.
+type Server struct {
+	cfg *Config
+	log *slog.Logger
+	srv *http.Server
+}
+
+func NewServer(cfg *Config, registry *Registry, log *slog.Logger) *Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc(cfg.Path, func(w http.ResponseWriter, r *http.Request) {
+		// the exemplars are exposed to the scrapers asking for OpenMetrics only
+		if strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text") {
+			w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+			registry.WriteOpenMetrics(w)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		registry.Write(w)
+	})
+
+	return &Server{
+		cfg: cfg,
+		log: log,
+		srv: &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: time.Minute,
+		},
+	}
+}
+
+func (s *Server) Start() error {
+	const op = rrErrors.Op("metrics_start")
+
+	l, err := net.Listen("tcp", s.cfg.Address)
+	if err != nil {
+		return rrErrors.E(op, err)
+	}
+
+	s.log.Debug("metrics server was started", "address", s.cfg.Address, "path", s.cfg.Path)
+	err = s.srv.Serve(l)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return rrErrors.E(op, err)
+	}
+
+	return nil
+}
+
+func (s *Server) Stop() {
+	err := s.srv.Shutdown(context.Background())
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		s.log.Error("metrics server shutdown", "error", err)
+	}
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+)
+
+const annotatorCtx contextKey = "annotator"
+
+// Annotator collects the decisions made by middleware for a request (trusted client, rejected, redirected),
+// used by the developer request inspector.
+type Annotator interface {
+	Annotate(decision string)
+}
+
+// WithAnnotator returns a copy of the context which collects the middleware decisions into the annotator
+func WithAnnotator(ctx context.Context, a Annotator) context.Context {
+	return context.WithValue(ctx, annotatorCtx, a)
+}
+
+// Annotate records the middleware decision, it is a no-op when nobody collects them
+func Annotate(r *http.Request, decision string) {
+	if a, ok := r.Context().Value(annotatorCtx).(Annotator); ok {
+		a.Annotate(decision)
+	}
+}
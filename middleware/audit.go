@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+)
+
+const auditorCtx contextKey = "auditor"
+
+// Security events recorded by the audit log
+const (
+	AuditAuthFailed  = "auth_failed"
+	AuditRateLimited = "rate_limited"
+	AuditBanned      = "banned"
+	AuditGeoBlocked  = "geo_blocked"
+	AuditTooLarge    = "request_too_large"
+	AuditClientCert  = "client_cert_failed"
+	AuditSlowClient  = "slow_client"
+	AuditURITooLong  = "uri_too_long"
+	AuditHoneypot    = "honeypot"
+)
+
+// Auditor records the security relevant events, separately from the access log. The request is nil for the
+// events of the connection, e.g. the failed TLS handshake.
+type Auditor interface {
+	Audit(r *http.Request, event string, attrs ...slog.Attr)
+}
+
+// Auditing puts the auditor into the request context, so every middleware and handler could record the events
+func Auditing(next http.Handler, a Auditor) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), auditorCtx, a)))
+	})
+}
+
+// Audit records the security event of the request, it is a no-op when the audit log is disabled
+func Audit(r *http.Request, event string, attrs ...slog.Attr) {
+	if a, ok := r.Context().Value(auditorCtx).(Auditor); ok {
+		a.Audit(r, event, attrs...)
+	}
+}
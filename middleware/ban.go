@@ -0,0 +1,458 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+
+	"github.com/rumorshub/http/metrics"
+)
+
+const BanName = "ban"
+
+type BanConfig struct {
+	// MaxFailures of the IP within the FindTime before it is banned, defaults to 5.
+	MaxFailures int `mapstructure:"max_failures" json:"max_failures,omitempty" bson:"max_failures,omitempty"`
+
+	// FindTime window of the counted failures, defaults to 10m.
+	FindTime time.Duration `mapstructure:"find_time" json:"find_time,omitempty" bson:"find_time,omitempty"`
+
+	// BanTime of the first ban, doubled by every next ban of the IP, defaults to 10m.
+	BanTime time.Duration `mapstructure:"ban_time" json:"ban_time,omitempty" bson:"ban_time,omitempty"`
+
+	// MaxBanTime caps the escalated ban, the IP without failures for that long starts over, defaults to 24h.
+	MaxBanTime time.Duration `mapstructure:"max_ban_time" json:"max_ban_time,omitempty" bson:"max_ban_time,omitempty"`
+
+	// Events of the audit log counted as the failures, defaults to auth_failed.
+	Events []string `mapstructure:"events" json:"events,omitempty" bson:"events,omitempty"`
+
+	// Statuses of the responses counted as the failures, e.g. 404 for the scanners.
+	Statuses []int `mapstructure:"statuses" json:"statuses,omitempty" bson:"statuses,omitempty"`
+
+	// Tarpit holds the requests of the banned IPs in the tarpit instead of the 403, requires the tarpit config.
+	Tarpit bool `mapstructure:"tarpit" json:"tarpit,omitempty" bson:"tarpit,omitempty"`
+
+	// Listener closes the connections of the banned IPs right after the accept. The listener sees the peer
+	// address, so it is useful only for the clients connecting directly.
+	Listener bool `mapstructure:"listener" json:"listener,omitempty" bson:"listener,omitempty"`
+
+	// MaxOffenders tracked at once, the failures of the new IPs above it are not counted until the old ones
+	// expire. Defaults to 100000.
+	MaxOffenders int `mapstructure:"max_offenders" json:"max_offenders,omitempty" bson:"max_offenders,omitempty"`
+}
+
+func (c *BanConfig) InitDefaults() error {
+	if c.MaxFailures == 0 {
+		c.MaxFailures = 5
+	}
+
+	if c.FindTime == 0 {
+		c.FindTime = time.Minute * 10
+	}
+
+	if c.BanTime == 0 {
+		c.BanTime = time.Minute * 10
+	}
+
+	if c.MaxBanTime == 0 {
+		c.MaxBanTime = time.Hour * 24
+	}
+
+	if c.MaxOffenders == 0 {
+		c.MaxOffenders = 100000
+	}
+
+	if len(c.Events) == 0 && len(c.Statuses) == 0 {
+		c.Events = []string{AuditAuthFailed}
+	}
+
+	if c.MaxFailures < 0 || c.FindTime < 0 || c.BanTime < 0 || c.MaxOffenders < 0 {
+		return errors.Str("ban max_failures, find_time, ban_time and max_offenders should not be negative")
+	}
+
+	if c.MaxBanTime < c.BanTime {
+		return errors.Errorf("ban max_ban_time should not be less than the ban_time (%s), got %s", c.BanTime, c.MaxBanTime)
+	}
+
+	for i := 0; i < len(c.Statuses); i++ {
+		if c.Statuses[i] < 400 || c.Statuses[i] > 599 {
+			return errors.Errorf("ban statuses should be errors, got %d", c.Statuses[i])
+		}
+	}
+
+	return nil
+}
+
+// BanEntry of the banned IP, the IPv6 clients are banned by the /64 network, e.g. 2001:db8::/64
+type BanEntry struct {
+	IP    string    `json:"ip"`
+	Until time.Time `json:"until"`
+	Bans  int       `json:"bans"`
+}
+
+// Ban counts the failures of the client IPs and bans the IPs failing too often, the ban doubles with every
+// repeated offense. The IPv6 clients are counted by the /64 network, the single host usually owns all of it.
+// The trusted clients are never banned.
+type Ban struct {
+	cfg    *BanConfig
+	tarpit *Tarpit
+	bans   *metrics.Counter
+
+	mu        sync.Mutex
+	offenders map[string]*offender
+
+	stopCh chan struct{}
+	stop   sync.Once
+}
+
+// offender failures are kept up to the max failures, the oldest first
+type offender struct {
+	failures []time.Time
+	bans     int
+	until    time.Time
+	last     time.Time
+}
+
+// NewBan creates the ban, the tarpit is used when enabled by the config and could be nil otherwise
+func NewBan(cfg *BanConfig, tarpit *Tarpit, registry *metrics.Registry) *Ban {
+	if !cfg.Tarpit {
+		tarpit = nil
+	}
+
+	b := &Ban{
+		cfg:       cfg,
+		tarpit:    tarpit,
+		bans:      registry.Counter("http_bans_total", "IPs banned for the repeated failures."),
+		offenders: make(map[string]*offender),
+		stopCh:    make(chan struct{}),
+	}
+
+	go b.pruneLoop()
+
+	return b
+}
+
+// Stop stops the pruning of the expired offenders
+func (b *Ban) Stop() {
+	b.stop.Do(func() {
+		close(b.stopCh)
+	})
+}
+
+func (b *Ban) Name() string {
+	return BanName
+}
+
+func (b *Ban) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := remoteIP(r)
+		if ip == nil || IsTrusted(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := banKey(ip)
+		if until, ok := b.banned(key); ok {
+			Annotate(r, "ban: rejected, banned until "+until.UTC().Format(time.RFC3339))
+			if b.tarpit != nil && b.tarpit.Hold(w, r) {
+				return
+			}
+
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(until).Seconds())+1))
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		if len(b.cfg.Events) > 0 {
+			parent, _ := r.Context().Value(auditorCtx).(Auditor)
+			r = r.WithContext(context.WithValue(r.Context(), auditorCtx, &banAuditor{parent: parent, ban: b, ip: key}))
+		}
+
+		if len(b.cfg.Statuses) > 0 {
+			w = &banWriter{ResponseWriter: w, ban: b, r: r, ip: key}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// BannedIP reports whether the IP is banned, used by the listener
+func (b *Ban) BannedIP(ip net.IP) bool {
+	_, ok := b.banned(banKey(ip))
+	return ok
+}
+
+// List returns the active bans, the soonest to expire first
+func (b *Ban) List() []BanEntry {
+	now := time.Now()
+
+	b.mu.Lock()
+	out := make([]BanEntry, 0, len(b.offenders))
+	for ip, o := range b.offenders {
+		if now.Before(o.until) {
+			out = append(out, BanEntry{IP: ip, Until: o.until, Bans: o.bans})
+		}
+	}
+	b.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Until.Before(out[j].Until)
+	})
+
+	return out
+}
+
+// Unban lifts the ban of the IP (or the listed IPv6 /64) and forgets its failures, reports whether it was banned
+func (b *Ban) Unban(ip string) (bool, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		var err error
+		parsed, _, err = net.ParseCIDR(ip)
+		if err != nil {
+			return false, errors.Errorf("invalid ip: %q", ip)
+		}
+	}
+
+	key := banKey(parsed)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	o, ok := b.offenders[key]
+	if !ok {
+		return false, nil
+	}
+
+	delete(b.offenders, key)
+
+	return time.Now().Before(o.until), nil
+}
+
+func (b *Ban) banned(ip string) (time.Time, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	o, ok := b.offenders[ip]
+	if !ok || !time.Now().Before(o.until) {
+		return time.Time{}, false
+	}
+
+	return o.until, true
+}
+
+// fail counts the failure of the IP and returns the ban duration once the IP is banned
+func (b *Ban) fail(ip string) time.Duration {
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	o := b.offender(ip, now)
+	if o == nil {
+		return 0
+	}
+
+	// failures of the banned IP (requests in flight) do not extend the ban
+	if now.Before(o.until) {
+		return 0
+	}
+
+	o.failures = slices.DeleteFunc(o.failures, func(t time.Time) bool {
+		return now.Sub(t) > b.cfg.FindTime
+	})
+	o.failures = append(o.failures, now)
+
+	if len(o.failures) < b.cfg.MaxFailures {
+		return 0
+	}
+
+	return b.ban(o, now)
+}
+
+// BanIP bans the IP right away, e.g. for the honeypot hit, returns the ban duration or zero when already banned
+func (b *Ban) BanIP(ip net.IP) time.Duration {
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	o := b.offender(banKey(ip), now)
+	if o == nil || now.Before(o.until) {
+		return 0
+	}
+
+	return b.ban(o, now)
+}
+
+// offender returns the offender of the IP seen now or nil when the offenders are full, should be called with
+// the lock held
+func (b *Ban) offender(ip string, now time.Time) *offender {
+	o, ok := b.offenders[ip]
+	if !ok {
+		if len(b.offenders) >= b.cfg.MaxOffenders && !b.evict(now) {
+			return nil
+		}
+
+		o = &offender{}
+		b.offenders[ip] = o
+	}
+
+	// the escalation starts over after the quiet period
+	if o.bans > 0 && now.Sub(o.last) > b.cfg.MaxBanTime && !now.Before(o.until) {
+		o.bans = 0
+	}
+	o.last = now
+
+	return o
+}
+
+// ban escalates the ban of the offender, should be called with the lock held
+func (b *Ban) ban(o *offender, now time.Time) time.Duration {
+	duration := b.cfg.BanTime
+	for i := 0; i < o.bans && duration < b.cfg.MaxBanTime; i++ {
+		duration *= 2
+	}
+	duration = min(duration, b.cfg.MaxBanTime)
+
+	o.bans++
+	o.until = now.Add(duration)
+	o.failures = o.failures[:0]
+
+	b.bans.Inc()
+
+	return duration
+}
+
+// evict forgets one of the not banned offenders to make room for the new one, the map iteration order is random,
+// so a few probes are enough to find one unless nearly all are banned. Should be called with the lock held.
+func (b *Ban) evict(now time.Time) bool {
+	probes := 0
+	for ip, o := range b.offenders {
+		if !now.Before(o.until) {
+			delete(b.offenders, ip)
+			return true
+		}
+
+		probes++
+		if probes >= 16 {
+			return false
+		}
+	}
+
+	return false
+}
+
+// pruneLoop forgets the IPs which are neither banned nor failing, off the request path
+func (b *Ban) pruneLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.stopCh:
+			return
+		case now := <-ticker.C:
+			b.prune(now)
+		}
+	}
+}
+
+func (b *Ban) prune(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ip, o := range b.offenders {
+		if !now.Before(o.until) && now.Sub(o.last) > max(b.cfg.FindTime, b.cfg.MaxBanTime) {
+			delete(b.offenders, ip)
+		}
+	}
+}
+
+// banKey is the IPv4 address or the /64 network of the IPv6 one
+func banKey(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+
+	return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
+
+func (b *Ban) record(r *http.Request, ip string, reason string) {
+	duration := b.fail(ip)
+	if duration == 0 {
+		return
+	}
+
+	Annotate(r, "ban: banned for "+duration.String())
+	Audit(r, AuditBanned, slog.String("reason", reason), slog.Duration("duration", duration))
+}
+
+// banAuditor counts the audit events of the request as the failures and passes them to the audit log
+type banAuditor struct {
+	parent Auditor
+	ban    *Ban
+	ip     string
+}
+
+func (a *banAuditor) Audit(r *http.Request, event string, attrs ...slog.Attr) {
+	if a.parent != nil {
+		a.parent.Audit(r, event, attrs...)
+	}
+
+	// the ban itself is not a failure
+	if r != nil && event != AuditBanned && slices.Contains(a.ban.cfg.Events, event) {
+		a.ban.record(r, a.ip, event)
+	}
+}
+
+// banWriter counts the responses with the failure statuses
+type banWriter struct {
+	http.ResponseWriter
+	ban   *Ban
+	r     *http.Request
+	ip    string
+	wrote bool
+}
+
+func (w *banWriter) WriteHeader(code int) {
+	if !w.wrote && code >= http.StatusOK {
+		w.wrote = true
+		if slices.Contains(w.ban.cfg.Statuses, code) {
+			w.ban.record(w.r, w.ip, "status "+strconv.Itoa(code))
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *banWriter) Write(b []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *banWriter) Flush() {
+	if fl, ok := w.ResponseWriter.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+func (w *banWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+
+	return nil, nil, ErrHijackerNotSupported
+}
+
+func (w *banWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rumorshub/http/metrics"
+)
+
+func newTestBan(t *testing.T, cfg *BanConfig) (*Ban, http.Handler) {
+	t.Helper()
+
+	err := cfg.InitDefaults()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewBan(cfg, nil, metrics.NewRegistry())
+	t.Cleanup(b.Stop)
+
+	h := b.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case "/login":
+			Audit(r, AuditAuthFailed)
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+
+	return b, h
+}
+
+func banRequest(h http.Handler, addr, path string, trusted bool) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.RemoteAddr = addr
+	if trusted {
+		r = r.WithContext(context.WithValue(r.Context(), trustedCtx, true))
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestBanExpiry(t *testing.T) {
+	b, h := newTestBan(t, &BanConfig{MaxFailures: 3, Statuses: []int{http.StatusNotFound}, BanTime: time.Millisecond * 100, MaxBanTime: time.Second})
+
+	for i := 0; i < 2; i++ {
+		if w := banRequest(h, "192.0.2.1:1000", "/missing", false); w.Code != http.StatusNotFound {
+			t.Fatalf("failure %d: status %d, should be 404", i, w.Code)
+		}
+	}
+
+	if w := banRequest(h, "192.0.2.1:1000", "/", false); w.Code != http.StatusOK {
+		t.Fatalf("status %d below the max failures, should be 200", w.Code)
+	}
+
+	// the third failure bans the IP
+	banRequest(h, "192.0.2.1:1000", "/missing", false)
+
+	w := banRequest(h, "192.0.2.1:2000", "/", false)
+	if w.Code != http.StatusForbidden || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("status %d, retry after %q of the banned IP", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	if w = banRequest(h, "192.0.2.2:1000", "/", false); w.Code != http.StatusOK {
+		t.Fatalf("status %d of the other IP, should be 200", w.Code)
+	}
+
+	if w = banRequest(h, "192.0.2.1:1000", "/", true); w.Code != http.StatusOK {
+		t.Fatalf("status %d of the trusted client, should be 200", w.Code)
+	}
+
+	// the ban expires
+	time.Sleep(time.Millisecond * 150)
+	if w = banRequest(h, "192.0.2.1:1000", "/", false); w.Code != http.StatusOK {
+		t.Fatalf("status %d after the ban expiry, should be 200", w.Code)
+	}
+
+	// the repeated offense doubles the ban
+	for i := 0; i < 3; i++ {
+		banRequest(h, "192.0.2.1:1000", "/missing", false)
+	}
+
+	list := b.List()
+	if len(list) != 1 || list[0].IP != "192.0.2.1" || list[0].Bans != 2 || time.Until(list[0].Until) <= time.Millisecond*100 {
+		t.Fatalf("bans %+v, should be the second ban of 200ms", list)
+	}
+
+	if w = banRequest(h, "192.0.2.1:1000", "/", false); w.Code != http.StatusForbidden {
+		t.Fatalf("status %d during the escalated ban, should be 403", w.Code)
+	}
+
+	unbanned, err := b.Unban("192.0.2.1")
+	if err != nil || !unbanned {
+		t.Fatalf("unban %v, error %v", unbanned, err)
+	}
+
+	if w = banRequest(h, "192.0.2.1:1000", "/", false); w.Code != http.StatusOK {
+		t.Fatalf("status %d after the unban, should be 200", w.Code)
+	}
+}
+
+func TestBanFindTime(t *testing.T) {
+	_, h := newTestBan(t, &BanConfig{MaxFailures: 2, FindTime: time.Millisecond * 50})
+
+	banRequest(h, "192.0.2.1:1000", "/login", false)
+
+	// the failure out of the find time is not counted
+	time.Sleep(time.Millisecond * 100)
+	banRequest(h, "192.0.2.1:1000", "/login", false)
+
+	if w := banRequest(h, "192.0.2.1:1000", "/", false); w.Code != http.StatusOK {
+		t.Fatalf("status %d, the failures out of the find time should not ban", w.Code)
+	}
+
+	// the audited auth failures are counted
+	banRequest(h, "192.0.2.1:1000", "/login", false)
+
+	if w := banRequest(h, "192.0.2.1:1000", "/", false); w.Code != http.StatusForbidden {
+		t.Fatalf("status %d after the auth failures, should be 403", w.Code)
+	}
+}
+
+func TestBanIPv6Network(t *testing.T) {
+	b, h := newTestBan(t, &BanConfig{MaxFailures: 1, Statuses: []int{http.StatusNotFound}})
+
+	banRequest(h, "[2001:db8::1]:1000", "/missing", false)
+
+	tests := []struct {
+		addr   string
+		status int
+	}{
+		{addr: "[2001:db8::1]:1000", status: http.StatusForbidden},
+		// the same /64 network
+		{addr: "[2001:db8::ffff:1]:1000", status: http.StatusForbidden},
+		{addr: "[2001:db8:0:1::1]:1000", status: http.StatusOK},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		if w := banRequest(h, tests[i].addr, "/", false); w.Code != tests[i].status {
+			t.Fatalf("%s: status %d, should be %d", tests[i].addr, w.Code, tests[i].status)
+		}
+	}
+
+	if !b.BannedIP(net.ParseIP("2001:db8::2")) {
+		t.Fatal("the listener should reject the banned network")
+	}
+
+	// the network is unbanned by any of its addresses
+	unbanned, err := b.Unban("2001:db8::2")
+	if err != nil || !unbanned {
+		t.Fatalf("unban %v, error %v", unbanned, err)
+	}
+
+	if _, err = b.Unban("not an ip"); err == nil {
+		t.Fatal("the invalid ip should not be unbanned")
+	}
+}
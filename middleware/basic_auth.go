@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/roadrunner-server/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const BasicAuthName = "basic_auth"
+
+// UserKey is the request context key of the authenticated username
+const UserKey contextKey = "user"
+
+// dummyHash is compared for the unknown users, so the response time does not reveal the existing usernames
+const dummyHash = "$2a$10$7EqJtq98hPqEX7fNZaFWoOhi5BWX4Z2Z0z3Zi3d0nU0S2ZVvF1Gm."
+
+type BasicAuthConfig struct {
+	// Realm of the WWW-Authenticate challenge, defaults to Restricted.
+	Realm string `mapstructure:"realm" json:"realm,omitempty" bson:"realm,omitempty"`
+
+	// Users in the htpasswd format, user:bcrypt-hash.
+	Users []string `mapstructure:"users" json:"users,omitempty" bson:"users,omitempty"`
+
+	// HtpasswdFile with the bcrypt hashed passwords (htpasswd -B).
+	HtpasswdFile string `mapstructure:"htpasswd_file" json:"htpasswd_file,omitempty" bson:"htpasswd_file,omitempty"`
+
+	// ExcludePaths prefixes are not authenticated, matched on the whole segments of the cleaned path.
+	ExcludePaths []string `mapstructure:"exclude_paths" json:"exclude_paths,omitempty" bson:"exclude_paths,omitempty"`
+}
+
+func (c *BasicAuthConfig) InitDefaults() error {
+	if c.Realm == "" {
+		c.Realm = "Restricted"
+	}
+
+	if len(c.Users) == 0 && c.HtpasswdFile == "" {
+		return errors.Str("basic_auth requires users or htpasswd_file")
+	}
+
+	return nil
+}
+
+// BasicAuth authenticates the requests with the bcrypt hashed credentials, the username is put into the
+// request context.
+type BasicAuth struct {
+	users     map[string][]byte
+	challenge string
+	exclude   []string
+
+	// sha256(user:password) of the verified credentials, bcrypt is too slow to run per request
+	verified sync.Map
+}
+
+func NewBasicAuth(cfg *BasicAuthConfig) (*BasicAuth, error) {
+	const op = errors.Op("basic_auth_init")
+
+	ba := &BasicAuth{
+		users:     make(map[string][]byte, len(cfg.Users)),
+		challenge: "Basic realm=" + strconv.Quote(cfg.Realm) + ", charset=\"UTF-8\"",
+		exclude:   cfg.ExcludePaths,
+	}
+
+	lines := cfg.Users
+	if cfg.HtpasswdFile != "" {
+		file, err := readHtpasswd(cfg.HtpasswdFile)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+
+		lines = append(lines, file...)
+	}
+
+	for i := 0; i < len(lines); i++ {
+		user, hash, ok := strings.Cut(lines[i], ":")
+		if !ok || user == "" {
+			return nil, errors.E(op, errors.Errorf("malformed credentials, user:hash expected, line %d", i+1))
+		}
+
+		if !strings.HasPrefix(hash, "$2") {
+			return nil, errors.E(op, errors.Errorf("only bcrypt hashes are supported, user: %s", user))
+		}
+
+		ba.users[user] = []byte(hash)
+	}
+
+	return ba, nil
+}
+
+func (ba *BasicAuth) Name() string {
+	return BasicAuthName
+}
+
+func (ba *BasicAuth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if matchPath(r.URL.Path, ba.exclude) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, password, ok := r.BasicAuth()
+		if !ok || !ba.verify(user, password) {
+			Annotate(r, "basic_auth: rejected, invalid credentials")
+			Audit(r, AuditAuthFailed, slog.String("scheme", "basic"), slog.String("user", user))
+			w.Header().Set("WWW-Authenticate", ba.challenge)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), UserKey, user)))
+	})
+}
+
+func (ba *BasicAuth) verify(user, password string) bool {
+	sum := sha256.Sum256([]byte(user + ":" + password))
+	if _, ok := ba.verified.Load(sum); ok {
+		return true
+	}
+
+	hash, ok := ba.users[user]
+	if !ok {
+		_ = bcrypt.CompareHashAndPassword([]byte(dummyHash), []byte(password))
+		return false
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return false
+	}
+
+	ba.verified.Store(sum, struct{}{})
+	return true
+}
+
+// GetUser returns the username authenticated by the basic_auth middleware
+func GetUser(r *http.Request) string {
+	user, _ := r.Context().Value(UserKey).(string)
+	return user
+}
+
+func readHtpasswd(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines, scanner.Err()
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBasicAuthReject(t *testing.T) {
+	alice, err := bcrypt.GenerateFromPassword([]byte("alice-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, err := bcrypt.GenerateFromPassword([]byte("bob-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	htpasswd := filepath.Join(t.TempDir(), ".htpasswd")
+	err = os.WriteFile(htpasswd, []byte("# users\n\nbob:"+string(bob)+"\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &BasicAuthConfig{Users: []string{"alice:" + string(alice)}, HtpasswdFile: htpasswd, ExcludePaths: []string{"/public"}}
+	if err = cfg.InitDefaults(); err != nil {
+		t.Fatal(err)
+	}
+
+	ba, err := NewBasicAuth(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var user string
+	h := ba.Middleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		user = GetUser(r)
+	}))
+
+	tests := []struct {
+		name     string
+		path     string
+		user     string
+		password string
+		header   string
+		status   int
+	}{
+		{name: "valid", path: "/", user: "alice", password: "alice-password", status: http.StatusOK},
+		{name: "valid htpasswd", path: "/", user: "bob", password: "bob-password", status: http.StatusOK},
+		// the verified credentials are cached, the other password of the user is still checked
+		{name: "wrong password", path: "/", user: "alice", password: "bob-password", status: http.StatusUnauthorized},
+		{name: "other user password", path: "/", user: "bob", password: "alice-password", status: http.StatusUnauthorized},
+		{name: "unknown user", path: "/", user: "mallory", password: "alice-password", status: http.StatusUnauthorized},
+		{name: "empty password", path: "/", user: "alice", status: http.StatusUnauthorized},
+		{name: "missing credentials", path: "/", status: http.StatusUnauthorized},
+		{name: "malformed header", path: "/", header: "Basic !!!", status: http.StatusUnauthorized},
+		{name: "other scheme", path: "/", header: "Bearer token", status: http.StatusUnauthorized},
+		{name: "excluded path", path: "/public/file", status: http.StatusOK},
+		{name: "escaped excluded path", path: "/public/../admin", status: http.StatusUnauthorized},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		user = ""
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.URL.Path = tests[i].path
+		if tests[i].user != "" {
+			r.SetBasicAuth(tests[i].user, tests[i].password)
+		}
+		if tests[i].header != "" {
+			r.Header.Set("Authorization", tests[i].header)
+		}
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, r)
+
+		if w.Code != tests[i].status {
+			t.Fatalf("%s: status %d, should be %d", tests[i].name, w.Code, tests[i].status)
+		}
+
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != `Basic realm="Restricted", charset="UTF-8"` {
+			t.Fatalf("%s: challenge %q", tests[i].name, w.Header().Get("WWW-Authenticate"))
+		}
+
+		if w.Code == http.StatusOK && user != tests[i].user {
+			t.Fatalf("%s: user %q, should be %q", tests[i].name, user, tests[i].user)
+		}
+	}
+}
+
+func TestBasicAuthConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		users []string
+	}{
+		{name: "plain password", users: []string{"alice:password"}},
+		{name: "no hash", users: []string{"alice"}},
+		{name: "no user", users: []string{":$2a$10$hash"}},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		_, err := NewBasicAuth(&BasicAuthConfig{Users: tests[i].users})
+		if err == nil {
+			t.Fatalf("%s: the credentials should be rejected", tests[i].name)
+		}
+	}
+}
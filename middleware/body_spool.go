@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+)
+
+type BodySpoolConfig struct {
+	// Threshold in megabytes, larger bodies are buffered to the temporary files. Default: 10Mb.
+	Threshold uint64 `mapstructure:"threshold" json:"threshold,omitempty" bson:"threshold,omitempty"`
+
+	// Dir for the temporary files. Default: os.TempDir().
+	Dir string `mapstructure:"dir" json:"dir,omitempty" bson:"dir,omitempty"`
+}
+
+func (c *BodySpoolConfig) InitDefaults() {
+	if c.Threshold == 0 {
+		c.Threshold = 10
+	}
+
+	if c.Dir == "" {
+		c.Dir = os.TempDir()
+	}
+}
+
+// SpoolRequestBody reads the request body ahead, bodies above the threshold are kept in the temporary file
+// removed when the request is served. Size limit of the underlying body reader still applies.
+func SpoolRequestBody(next http.Handler, threshold int64, dir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// small bodies with the known length are streamed as is
+		if r.Body == nil || r.Body == http.NoBody || IsUpgrade(r) || IsGRPC(r) || (r.ContentLength >= 0 && r.ContentLength <= threshold) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		head, err := io.ReadAll(io.LimitReader(r.Body, threshold+1))
+		if err != nil {
+			bodyError(w, err)
+			return
+		}
+
+		r2 := r.Clone(r.Context())
+		if int64(len(head)) <= threshold {
+			r2.Body = io.NopCloser(bytes.NewReader(head))
+			r2.ContentLength = int64(len(head))
+			next.ServeHTTP(w, r2)
+			return
+		}
+
+		file, err := os.CreateTemp(dir, "http-body-*")
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		defer func() {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+		}()
+
+		size, err := io.Copy(file, io.MultiReader(bytes.NewReader(head), r.Body))
+		if err != nil {
+			bodyError(w, err)
+			return
+		}
+
+		_, err = file.Seek(0, io.SeekStart)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		r2.Body = io.NopCloser(file)
+		r2.ContentLength = size
+		next.ServeHTTP(w, r2)
+	})
+}
+
+func bodyError(w http.ResponseWriter, err error) {
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+}
@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+const clientCertCtx contextKey = "client_cert"
+
+// Client certificate identity headers, set by ClientCertIdentity when enabled. Incoming values are always removed,
+// every server applies ClientCertIdentity.
+const (
+	HeaderClientCertCN          = "X-Client-Cert-CN"
+	HeaderClientCertSubject     = "X-Client-Cert-Subject"
+	HeaderClientCertSAN         = "X-Client-Cert-SAN"
+	HeaderClientCertFingerprint = "X-Client-Cert-Fingerprint"
+)
+
+// ClientCertInfo is the identity of the verified client certificate
+type ClientCertInfo struct {
+	Subject        string    `json:"subject"`
+	CommonName     string    `json:"common_name"`
+	SerialNumber   string    `json:"serial_number"`
+	DNSNames       []string  `json:"dns_names,omitempty"`
+	EmailAddresses []string  `json:"email_addresses,omitempty"`
+	URIs           []string  `json:"uris,omitempty"`
+	IPAddresses    []string  `json:"ip_addresses,omitempty"`
+	Fingerprint    string    `json:"fingerprint"` // SHA-256 of the DER, hex
+	NotAfter       time.Time `json:"not_after"`
+}
+
+// SANs returns all subject alternative names
+func (c *ClientCertInfo) SANs() []string {
+	sans := make([]string, 0, len(c.DNSNames)+len(c.EmailAddresses)+len(c.URIs)+len(c.IPAddresses))
+	sans = append(sans, c.DNSNames...)
+	sans = append(sans, c.EmailAddresses...)
+	sans = append(sans, c.URIs...)
+	sans = append(sans, c.IPAddresses...)
+	return sans
+}
+
+// NewClientCertInfo extracts the identity from the certificate
+func NewClientCertInfo(cert *x509.Certificate) *ClientCertInfo {
+	sum := sha256.Sum256(cert.Raw)
+
+	info := &ClientCertInfo{
+		Subject:        cert.Subject.String(),
+		CommonName:     cert.Subject.CommonName,
+		SerialNumber:   cert.SerialNumber.String(),
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		Fingerprint:    hex.EncodeToString(sum[:]),
+		NotAfter:       cert.NotAfter,
+	}
+
+	for i := 0; i < len(cert.URIs); i++ {
+		info.URIs = append(info.URIs, cert.URIs[i].String())
+	}
+
+	for i := 0; i < len(cert.IPAddresses); i++ {
+		info.IPAddresses = append(info.IPAddresses, cert.IPAddresses[i].String())
+	}
+
+	return info
+}
+
+// ClientCert returns the verified client certificate identity or nil
+func ClientCert(r *http.Request) *ClientCertInfo {
+	info, _ := r.Context().Value(clientCertCtx).(*ClientCertInfo)
+	return info
+}
+
+// ClientCertIdentity puts the verified client certificate identity into the request context (see ClientCert)
+// and, when headers enabled, into the X-Client-Cert-* headers for the handlers not aware of the context.
+func ClientCertIdentity(next http.Handler, headers bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// never trust the identity headers sent by the client
+		r.Header.Del(HeaderClientCertCN)
+		r.Header.Del(HeaderClientCertSubject)
+		r.Header.Del(HeaderClientCertSAN)
+		r.Header.Del(HeaderClientCertFingerprint)
+
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		info := NewClientCertInfo(r.TLS.VerifiedChains[0][0])
+		if headers {
+			r.Header.Set(HeaderClientCertCN, info.CommonName)
+			r.Header.Set(HeaderClientCertSubject, info.Subject)
+			r.Header.Set(HeaderClientCertSAN, strings.Join(info.SANs(), ","))
+			r.Header.Set(HeaderClientCertFingerprint, info.Fingerprint)
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientCertCtx, info)))
+	})
+}
+
+// ClientCertRequired rejects requests to the path prefixes when the connection has no verified client certificate.
+// The TLS handshake should request the certificate (verify_client_cert_if_given), so the rest of the paths stay
+// reachable without it.
+func ClientCertRequired(next http.Handler, paths []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if matchPath(r.URL.Path, paths) && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+			Annotate(r, "client_cert: rejected, no verified client certificate")
+			Audit(r, AuditClientCert, slog.String("reason", "no verified client certificate"))
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// matchPath matches the cleaned path against the prefixes on the segment boundary, /admin matches /admin,
+// /admin/users, //admin and /x/../admin but not /administrator. The access rules should use it, so the path
+// the router resolves could not escape them.
+func matchPath(p string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return false
+	}
+
+	cleaned := CleanPath(p)
+	for i := 0; i < len(prefixes); i++ {
+		if SegmentPrefix(cleaned, prefixes[i]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SegmentPrefix reports whether the cleaned path starts with the prefix on the segment boundary
+func SegmentPrefix(cleaned, prefix string) bool {
+	if !strings.HasPrefix(cleaned, prefix) {
+		return false
+	}
+
+	return len(cleaned) == len(prefix) || strings.HasSuffix(prefix, "/") || cleaned[len(prefix)] == '/'
+}
+
+// CleanPath resolves the dot segments and the repeated slashes, the trailing slash is kept
+func CleanPath(p string) string {
+	if p == "" || p[0] != '/' {
+		p = "/" + p
+	}
+
+	cleaned := path.Clean(p)
+	if p[len(p)-1] == '/' && cleaned != "/" {
+		cleaned += "/"
+	}
+
+	return cleaned
+}
+
+func hasPrefix(path string, prefixes []string) bool {
+	for i := 0; i < len(prefixes); i++ {
+		if strings.HasPrefix(path, prefixes[i]) {
+			return true
+		}
+	}
+
+	return false
+}
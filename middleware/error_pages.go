@@ -0,0 +1,202 @@
+package middleware
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/roadrunner-server/errors"
+)
+
+const ErrorPagesName = "error_pages"
+
+type ErrorPagesConfig struct {
+	// Pages by the status code.
+	Pages []ErrorPageConfig `mapstructure:"pages" json:"pages,omitempty" bson:"pages,omitempty"`
+}
+
+type ErrorPageConfig struct {
+	Status int `mapstructure:"status" json:"status,omitempty" bson:"status,omitempty"`
+
+	// HTML file served to the browsers.
+	HTML string `mapstructure:"html" json:"html,omitempty" bson:"html,omitempty"`
+
+	// JSON file served to the clients preferring application/json.
+	JSON string `mapstructure:"json" json:"json,omitempty" bson:"json,omitempty"`
+}
+
+func (c *ErrorPagesConfig) InitDefaults() error {
+	const op = errors.Op("error_pages_config")
+
+	for i := 0; i < len(c.Pages); i++ {
+		if c.Pages[i].Status < 400 || c.Pages[i].Status > 599 {
+			return errors.E(op, errors.Errorf("error page status should be 4xx or 5xx, got %d", c.Pages[i].Status))
+		}
+
+		if c.Pages[i].HTML == "" && c.Pages[i].JSON == "" {
+			return errors.E(op, errors.Errorf("error page %d should have html or json file", c.Pages[i].Status))
+		}
+	}
+
+	return nil
+}
+
+type errorPage struct {
+	html []byte
+	json []byte
+}
+
+// ErrorPages replaces the plain text error responses of the middleware and built-ins with the configured pages,
+// the handler responses with the explicit content type are kept as is.
+type ErrorPages struct {
+	pages map[int]*errorPage
+}
+
+func NewErrorPages(cfg *ErrorPagesConfig) (*ErrorPages, error) {
+	const op = errors.Op("error_pages")
+
+	ep := &ErrorPages{pages: make(map[int]*errorPage, len(cfg.Pages))}
+	for i := 0; i < len(cfg.Pages); i++ {
+		page := &errorPage{}
+
+		var err error
+		if cfg.Pages[i].HTML != "" {
+			page.html, err = os.ReadFile(cfg.Pages[i].HTML)
+			if err != nil {
+				return nil, errors.E(op, err)
+			}
+		}
+
+		if cfg.Pages[i].JSON != "" {
+			page.json, err = os.ReadFile(cfg.Pages[i].JSON)
+			if err != nil {
+				return nil, errors.E(op, err)
+			}
+		}
+
+		ep.pages[cfg.Pages[i].Status] = page
+	}
+
+	return ep, nil
+}
+
+func (ep *ErrorPages) Name() string {
+	return ErrorPagesName
+}
+
+func (ep *ErrorPages) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&errorPageWriter{ResponseWriter: w, pages: ep.pages, accept: r.Header.Get("Accept")}, r)
+	})
+}
+
+// Write writes the page of the status, false when the page is not configured
+func (ep *ErrorPages) Write(w http.ResponseWriter, r *http.Request, status int) bool {
+	page, ok := ep.pages[status]
+	if !ok {
+		return false
+	}
+
+	writePage(w, page, r.Header.Get("Accept"), status)
+	return true
+}
+
+func writePage(w http.ResponseWriter, page *errorPage, accept string, status int) {
+	body, contentType := page.html, "text/html; charset=utf-8"
+	if page.json != nil && (page.html == nil || prefersJSON(accept)) {
+		body, contentType = page.json, "application/json"
+	}
+
+	h := w.Header()
+	h.Del("Content-Encoding")
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+// prefersJSON compares the application/json and text/html quality values of the Accept header
+func prefersJSON(accept string) bool {
+	jsonQ, htmlQ := -1.0, -1.0
+	ranges := strings.Split(accept, ",")
+	for i := 0; i < len(ranges); i++ {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(ranges[i]), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+
+		switch strings.ToLower(strings.TrimSpace(mediaType)) {
+		case "application/json", "application/problem+json":
+			jsonQ = max(jsonQ, q)
+		case "text/html":
+			htmlQ = max(htmlQ, q)
+		}
+	}
+
+	return jsonQ > htmlQ
+}
+
+type errorPageWriter struct {
+	http.ResponseWriter
+	pages    map[int]*errorPage
+	accept   string
+	wrote    bool
+	replaced bool
+}
+
+func (w *errorPageWriter) WriteHeader(code int) {
+	if w.wrote {
+		return
+	}
+	w.wrote = true
+
+	page, ok := w.pages[code]
+	contentType := w.Header().Get("Content-Type")
+	if !ok || (contentType != "" && !strings.HasPrefix(contentType, "text/plain")) {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	w.replaced = true
+	writePage(w.ResponseWriter, page, w.accept, code)
+}
+
+func (w *errorPageWriter) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.replaced {
+		return len(b), nil
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *errorPageWriter) Flush() {
+	if w.replaced {
+		return
+	}
+
+	if fl, ok := w.ResponseWriter.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+func (w *errorPageWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+
+	return nil, nil, ErrHijackerNotSupported
+}
+
+func (w *errorPageWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
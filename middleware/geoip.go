@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+
+	"github.com/rumorshub/http/geoip"
+)
+
+const GeoIPName = "geoip"
+
+// CountryKey is the request context key of the client country ISO code
+const CountryKey contextKey = "country"
+
+type GeoIPConfig struct {
+	// Database path of the MaxMind DB (GeoLite2/GeoIP2 Country or City).
+	Database string `mapstructure:"database" json:"database,omitempty" bson:"database,omitempty"`
+
+	// ReloadInterval of the database file, 0 disables the reload.
+	ReloadInterval time.Duration `mapstructure:"reload_interval" json:"reload_interval,omitempty" bson:"reload_interval,omitempty"`
+
+	// Allow only the requests from these countries (ISO codes), requests with the unknown country are rejected.
+	Allow []string `mapstructure:"allow" json:"allow,omitempty" bson:"allow,omitempty"`
+
+	// Block the requests from these countries (ISO codes).
+	Block []string `mapstructure:"block" json:"block,omitempty" bson:"block,omitempty"`
+}
+
+func (c *GeoIPConfig) InitDefaults() error {
+	if c.Database == "" {
+		return errors.Str("geoip database could not be empty")
+	}
+
+	for i := 0; i < len(c.Allow); i++ {
+		c.Allow[i] = strings.ToUpper(c.Allow[i])
+	}
+
+	for i := 0; i < len(c.Block); i++ {
+		c.Block[i] = strings.ToUpper(c.Block[i])
+	}
+
+	return nil
+}
+
+// GeoIP resolves the client country, puts it into the request context and filters the requests by the
+// allow and block lists. Trusted clients are not filtered.
+type GeoIP struct {
+	cfg    *GeoIPConfig
+	log    *slog.Logger
+	reader atomic.Pointer[geoip.Reader]
+	stopCh chan struct{}
+	stop   sync.Once
+}
+
+func NewGeoIP(cfg *GeoIPConfig, log *slog.Logger) (*GeoIP, error) {
+	reader, err := geoip.Open(cfg.Database)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &GeoIP{
+		cfg:    cfg,
+		log:    log,
+		stopCh: make(chan struct{}),
+	}
+	g.reader.Store(reader)
+
+	if cfg.ReloadInterval > 0 {
+		go g.reload()
+	}
+
+	return g, nil
+}
+
+func (g *GeoIP) Name() string {
+	return GeoIPName
+}
+
+func (g *GeoIP) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		country, err := g.reader.Load().Country(remoteIP(r))
+		if err != nil {
+			g.log.Warn("geoip lookup", "error", err)
+		}
+
+		if country != "" {
+			r = r.WithContext(context.WithValue(r.Context(), CountryKey, country))
+		}
+
+		if !IsTrusted(r) && !g.allowed(country) {
+			Annotate(r, "geoip: rejected, country "+country)
+			Audit(r, AuditGeoBlocked, slog.String("country", country))
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (g *GeoIP) Stop() {
+	g.stop.Do(func() {
+		close(g.stopCh)
+	})
+}
+
+func (g *GeoIP) allowed(country string) bool {
+	if len(g.cfg.Allow) > 0 && !slices.Contains(g.cfg.Allow, country) {
+		return false
+	}
+
+	return country == "" || !slices.Contains(g.cfg.Block, country)
+}
+
+func (g *GeoIP) reload() {
+	ticker := time.NewTicker(g.cfg.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-g.stopCh:
+			return
+		case <-ticker.C:
+			reader, err := geoip.Open(g.cfg.Database)
+			if err != nil {
+				// keep serving with the previous database
+				g.log.Error("geoip database reload", "error", err)
+				continue
+			}
+
+			g.reader.Store(reader)
+			g.log.Debug("geoip database reloaded", "database", g.cfg.Database)
+		}
+	}
+}
+
+// GetCountry returns the client country ISO code resolved by the geoip middleware
+func GetCountry(r *http.Request) string {
+	country, _ := r.Context().Value(CountryKey).(string)
+	return country
+}
@@ -0,0 +1,235 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+)
+
+const HMACName = "hmac"
+
+type HMACConfig struct {
+	// Algorithm of the signature: sha1, sha256 or sha512, defaults to sha256.
+	Algorithm string `mapstructure:"algorithm" json:"algorithm,omitempty" bson:"algorithm,omitempty"`
+
+	// Header with the signature, defaults to X-Signature.
+	Header string `mapstructure:"header" json:"header,omitempty" bson:"header,omitempty"`
+
+	// Prefix of the signature value, e.g. sha256= for the GitHub webhooks.
+	Prefix string `mapstructure:"prefix" json:"prefix,omitempty" bson:"prefix,omitempty"`
+
+	// Encoding of the signature: hex or base64, defaults to hex.
+	Encoding string `mapstructure:"encoding" json:"encoding,omitempty" bson:"encoding,omitempty"`
+
+	// Secrets shared with the senders, any of them is accepted, so the secrets could be rotated.
+	Secrets []string `mapstructure:"secrets" json:"secrets,omitempty" bson:"secrets,omitempty"`
+
+	// TimestampHeader with the unix time of the request, when set the signed payload is timestamp.body.
+	TimestampHeader string `mapstructure:"timestamp_header" json:"timestamp_header,omitempty" bson:"timestamp_header,omitempty"`
+
+	// Tolerance of the timestamp, defaults to 5m. Timestamped signatures seen before are rejected as replayed.
+	Tolerance time.Duration `mapstructure:"tolerance" json:"tolerance,omitempty" bson:"tolerance,omitempty"`
+
+	// Paths prefixes to verify, all requests when empty.
+	Paths []string `mapstructure:"paths" json:"paths,omitempty" bson:"paths,omitempty"`
+
+	// MaxBodySize of the signed request in bytes, defaults to 10MB.
+	MaxBodySize int64 `mapstructure:"max_body_size" json:"max_body_size,omitempty" bson:"max_body_size,omitempty"`
+}
+
+func (c *HMACConfig) InitDefaults() error {
+	if c.Algorithm == "" {
+		c.Algorithm = "sha256"
+	}
+
+	if c.Header == "" {
+		c.Header = "X-Signature"
+	}
+
+	if c.Encoding == "" {
+		c.Encoding = "hex"
+	}
+
+	if c.Tolerance == 0 {
+		c.Tolerance = time.Minute * 5
+	}
+
+	if c.MaxBodySize == 0 {
+		c.MaxBodySize = 10 * 1024 * 1024
+	}
+
+	if len(c.Secrets) == 0 {
+		return errors.Str("hmac secrets could not be empty")
+	}
+
+	if hmacHash(c.Algorithm) == nil {
+		return errors.Errorf("unsupported hmac algorithm: %s", c.Algorithm)
+	}
+
+	if c.Encoding != "hex" && c.Encoding != "base64" {
+		return errors.Errorf("unsupported hmac encoding: %s", c.Encoding)
+	}
+
+	return nil
+}
+
+// HMAC verifies the signature of the request body (webhooks), replayed and stale requests are rejected.
+type HMAC struct {
+	cfg     *HMACConfig
+	hash    func() hash.Hash
+	secrets [][]byte
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func NewHMAC(cfg *HMACConfig) *HMAC {
+	secrets := make([][]byte, 0, len(cfg.Secrets))
+	for i := 0; i < len(cfg.Secrets); i++ {
+		secrets = append(secrets, []byte(cfg.Secrets[i]))
+	}
+
+	return &HMAC{
+		cfg:     cfg,
+		hash:    hmacHash(cfg.Algorithm),
+		secrets: secrets,
+		seen:    make(map[string]time.Time),
+	}
+}
+
+func (h *HMAC) Name() string {
+	return HMACName
+}
+
+func (h *HMAC) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(h.cfg.Paths) > 0 && !matchPath(r.URL.Path, h.cfg.Paths) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, h.cfg.MaxBodySize+1))
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		if int64(len(body)) > h.cfg.MaxBodySize {
+			Audit(r, AuditTooLarge, slog.Int64("limit", h.cfg.MaxBodySize))
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		err = h.verify(r, body)
+		if err != nil {
+			Annotate(r, "hmac: rejected, "+err.Error())
+			Audit(r, AuditAuthFailed, slog.String("scheme", "hmac"), slog.String("reason", err.Error()))
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *HMAC) verify(r *http.Request, body []byte) error {
+	value, ok := strings.CutPrefix(r.Header.Get(h.cfg.Header), h.cfg.Prefix)
+	if !ok || value == "" {
+		return errors.Str("missing signature")
+	}
+
+	var signature []byte
+	var err error
+	if h.cfg.Encoding == "base64" {
+		signature, err = base64.StdEncoding.DecodeString(value)
+	} else {
+		signature, err = hex.DecodeString(value)
+	}
+	if err != nil {
+		return errors.Str("malformed signature")
+	}
+
+	var payload []byte
+	if h.cfg.TimestampHeader != "" {
+		timestamp := r.Header.Get(h.cfg.TimestampHeader)
+		unix, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return errors.Str("malformed timestamp")
+		}
+
+		age := time.Since(time.Unix(unix, 0))
+		if age > h.cfg.Tolerance || age < -h.cfg.Tolerance {
+			return errors.Str("timestamp is out of the tolerance")
+		}
+
+		payload = append([]byte(timestamp+"."), body...)
+	} else {
+		payload = body
+	}
+
+	for i := 0; i < len(h.secrets); i++ {
+		mac := hmac.New(h.hash, h.secrets[i])
+		_, _ = mac.Write(payload)
+		if hmac.Equal(mac.Sum(nil), signature) {
+			if h.cfg.TimestampHeader != "" && h.replayed(string(signature)) {
+				return errors.Str("replayed request")
+			}
+
+			return nil
+		}
+	}
+
+	return errors.Str("invalid signature")
+}
+
+// replayed remembers the signature for the tolerance period
+func (h *HMAC) replayed(signature string) bool {
+	now := time.Now()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if seen, ok := h.seen[signature]; ok && now.Sub(seen) <= h.cfg.Tolerance*2 {
+		return true
+	}
+
+	// expired signatures would fail the timestamp check anyway
+	if len(h.seen) >= 1024 {
+		for sig, seen := range h.seen {
+			if now.Sub(seen) > h.cfg.Tolerance*2 {
+				delete(h.seen, sig)
+			}
+		}
+	}
+
+	h.seen[signature] = now
+	return false
+}
+
+func hmacHash(algorithm string) func() hash.Hash {
+	switch algorithm {
+	case "sha1":
+		return sha1.New
+	case "sha256":
+		return sha256.New
+	case "sha512":
+		return sha512.New
+	default:
+		return nil
+	}
+}
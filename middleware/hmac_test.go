@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func hmacSign(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(payload))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestHMACReject(t *testing.T) {
+	cfg := &HMACConfig{
+		Prefix:          "sha256=",
+		Secrets:         []string{"new", "old"},
+		TimestampHeader: "X-Timestamp",
+		Tolerance:       time.Minute,
+		Paths:           []string{"/hooks"},
+		MaxBodySize:     16,
+	}
+	if err := cfg.InitDefaults(); err != nil {
+		t.Fatal(err)
+	}
+
+	var received string
+	h := NewHMAC(cfg).Middleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+	}))
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-time.Minute*2).Unix(), 10)
+	future := strconv.FormatInt(time.Now().Add(time.Minute*2).Unix(), 10)
+
+	tests := []struct {
+		name      string
+		path      string
+		body      string
+		timestamp string
+		signature string
+		status    int
+	}{
+		{name: "valid", path: "/hooks", body: "event", timestamp: now, signature: hmacSign("new", now+".event"), status: http.StatusOK},
+		{name: "rotated secret", path: "/hooks", body: "other", timestamp: now, signature: hmacSign("old", now+".other"), status: http.StatusOK},
+		{name: "replayed", path: "/hooks", body: "event", timestamp: now, signature: hmacSign("new", now+".event"), status: http.StatusUnauthorized},
+		{name: "unknown secret", path: "/hooks", body: "event", timestamp: now, signature: hmacSign("wrong", now+".event"), status: http.StatusUnauthorized},
+		{name: "tampered body", path: "/hooks", body: "evil", timestamp: now, signature: hmacSign("new", now+".third"), status: http.StatusUnauthorized},
+		{name: "tampered timestamp", path: "/hooks", body: "fourth", timestamp: now, signature: hmacSign("new", stale+".fourth"), status: http.StatusUnauthorized},
+		{name: "expired", path: "/hooks", body: "event", timestamp: stale, signature: hmacSign("new", stale+".event"), status: http.StatusUnauthorized},
+		{name: "future", path: "/hooks", body: "event", timestamp: future, signature: hmacSign("new", future+".event"), status: http.StatusUnauthorized},
+		{name: "missing timestamp", path: "/hooks", body: "event", signature: hmacSign("new", ".event"), status: http.StatusUnauthorized},
+		{name: "missing signature", path: "/hooks", body: "event", timestamp: now, status: http.StatusUnauthorized},
+		{name: "missing prefix", path: "/hooks", body: "fifth", timestamp: now, signature: strings.TrimPrefix(hmacSign("new", now+".fifth"), "sha256="), status: http.StatusUnauthorized},
+		{name: "malformed signature", path: "/hooks", body: "event", timestamp: now, signature: "sha256=zz", status: http.StatusUnauthorized},
+		{name: "too large", path: "/hooks", body: strings.Repeat("a", 17), timestamp: now, signature: hmacSign("new", now+"."+strings.Repeat("a", 17)), status: http.StatusRequestEntityTooLarge},
+		{name: "not verified path", path: "/api", body: "event", status: http.StatusOK},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		received = ""
+		r := httptest.NewRequest(http.MethodPost, tests[i].path, strings.NewReader(tests[i].body))
+		if tests[i].timestamp != "" {
+			r.Header.Set("X-Timestamp", tests[i].timestamp)
+		}
+		if tests[i].signature != "" {
+			r.Header.Set("X-Signature", tests[i].signature)
+		}
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, r)
+
+		if w.Code != tests[i].status {
+			t.Fatalf("%s: status %d, should be %d", tests[i].name, w.Code, tests[i].status)
+		}
+
+		// the verified body is passed on
+		if w.Code == http.StatusOK && received != tests[i].body {
+			t.Fatalf("%s: body %q, should be %q", tests[i].name, received, tests[i].body)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/roadrunner-server/errors"
+)
+
+const HoneypotName = "honeypot"
+
+type HoneypotConfig struct {
+	// Paths no legitimate client requests, e.g. /wp-login.php or /.env. The paths ending with / match the prefix.
+	Paths []string `mapstructure:"paths" json:"paths,omitempty" bson:"paths,omitempty"`
+
+	// Status of the trap response, defaults to 404.
+	Status int `mapstructure:"status" json:"status,omitempty" bson:"status,omitempty"`
+
+	// Ban the client right away, requires the ban config. Otherwise the hit is only recorded. The cross-site hits
+	// (e.g. the trap path embedded as the image by the third-party page) never ban, the visitors of that page did
+	// not request it on their own. Behind the shared address (CGNAT, the proxy without the real_ip) the ban hits
+	// everyone behind it.
+	Ban bool `mapstructure:"ban" json:"ban,omitempty" bson:"ban,omitempty"`
+}
+
+func (c *HoneypotConfig) InitDefaults() error {
+	if c.Status == 0 {
+		c.Status = http.StatusNotFound
+	}
+
+	if len(c.Paths) == 0 {
+		return errors.Str("honeypot paths could not be empty")
+	}
+
+	for i := 0; i < len(c.Paths); i++ {
+		if !strings.HasPrefix(c.Paths[i], "/") {
+			return errors.Errorf("honeypot path should start with /, got %q", c.Paths[i])
+		}
+	}
+
+	if c.Status < 400 || c.Status > 599 {
+		return errors.Errorf("honeypot status should be an error, got %d", c.Status)
+	}
+
+	return nil
+}
+
+// Honeypot answers the trap paths itself, records the hit in the audit log and bans the client, so the scanners
+// never reach the app. Trusted clients are answered without the ban.
+type Honeypot struct {
+	cfg *HoneypotConfig
+	ban *Ban
+}
+
+// NewHoneypot creates the honeypot, the ban could be nil, then the hits are only recorded
+func NewHoneypot(cfg *HoneypotConfig, ban *Ban) *Honeypot {
+	if !cfg.Ban {
+		ban = nil
+	}
+
+	return &Honeypot{
+		cfg: cfg,
+		ban: ban,
+	}
+}
+
+func (h *Honeypot) Name() string {
+	return HoneypotName
+}
+
+func (h *Honeypot) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.trap(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		Annotate(r, "honeypot: trap "+r.URL.Path)
+
+		ip := remoteIP(r)
+		if !IsTrusted(r) && ip != nil {
+			Audit(r, AuditHoneypot, slog.String("user_agent", r.UserAgent()))
+
+			if h.ban != nil {
+				if r.Header.Get("Sec-Fetch-Site") == "cross-site" {
+					Annotate(r, "honeypot: cross-site hit, not banned")
+				} else if duration := h.ban.BanIP(ip); duration > 0 {
+					Annotate(r, "ban: banned for "+duration.String())
+					Audit(r, AuditBanned, slog.String("reason", HoneypotName), slog.Duration("duration", duration))
+				}
+			}
+		}
+
+		http.Error(w, http.StatusText(h.cfg.Status), h.cfg.Status)
+	})
+}
+
+func (h *Honeypot) trap(path string) bool {
+	for i := 0; i < len(h.cfg.Paths); i++ {
+		trap := h.cfg.Paths[i]
+		if path == trap || (strings.HasSuffix(trap, "/") && strings.HasPrefix(path, trap)) {
+			return true
+		}
+	}
+
+	return false
+}
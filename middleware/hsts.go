@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/roadrunner-server/errors"
+)
+
+// hstsPreloadMaxAge is the minimal max-age of the preload list, 1 year
+const hstsPreloadMaxAge = 31536000
+
+type HSTSConfig struct {
+	// Enabled Strict-Transport-Security header, defaults to true.
+	Enabled *bool `mapstructure:"enabled" json:"enabled,omitempty" bson:"enabled,omitempty"`
+
+	// MaxAge in seconds, defaults to 31536000 (1 year). 0 tells the browsers to forget the policy.
+	MaxAge *int `mapstructure:"max_age" json:"max_age,omitempty" bson:"max_age,omitempty"`
+
+	// IncludeSubDomains directive, defaults to true.
+	IncludeSubDomains *bool `mapstructure:"include_subdomains" json:"include_subdomains,omitempty" bson:"include_subdomains,omitempty"`
+
+	// Preload directive, defaults to false. The preload list keeps the domain for months and applies the policy
+	// to every subdomain, it requires include_subdomains and max_age of at least 1 year.
+	Preload *bool `mapstructure:"preload" json:"preload,omitempty" bson:"preload,omitempty"`
+}
+
+func (c *HSTSConfig) InitDefaults() error {
+	const op = errors.Op("hsts_config")
+
+	enabled := true
+	if c.Enabled == nil {
+		c.Enabled = &enabled
+	}
+
+	if c.MaxAge == nil {
+		maxAge := hstsPreloadMaxAge
+		c.MaxAge = &maxAge
+	}
+
+	if c.IncludeSubDomains == nil {
+		c.IncludeSubDomains = &enabled
+	}
+
+	if c.Preload == nil {
+		preload := false
+		c.Preload = &preload
+	}
+
+	if *c.MaxAge < 0 {
+		return errors.E(op, errors.Str("max_age should not be negative"))
+	}
+
+	if *c.Preload && (!*c.IncludeSubDomains || *c.MaxAge < hstsPreloadMaxAge) {
+		return errors.E(op, errors.Str("preload requires include_subdomains and max_age of at least 31536000"))
+	}
+
+	return nil
+}
+
+// Value of the Strict-Transport-Security header, empty when disabled
+func (c *HSTSConfig) Value() string {
+	if c == nil || c.Enabled == nil || !*c.Enabled {
+		return ""
+	}
+
+	maxAge := hstsPreloadMaxAge
+	if c.MaxAge != nil {
+		maxAge = *c.MaxAge
+	}
+
+	value := "max-age=" + strconv.Itoa(maxAge)
+	if c.IncludeSubDomains != nil && *c.IncludeSubDomains {
+		value += "; includeSubDomains"
+	}
+
+	if c.Preload != nil && *c.Preload {
+		value += "; preload"
+	}
+
+	return value
+}
+
+// HSTS sets the Strict-Transport-Security header on the HTTPS responses
+func HSTS(next http.Handler, value string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,237 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+
+	"github.com/rumorshub/http/metrics"
+)
+
+const InFlightName = "in_flight"
+
+type InFlightConfig struct {
+	// Limit of the simultaneous requests, 0 means no global limit.
+	Limit int `mapstructure:"limit" json:"limit,omitempty" bson:"limit,omitempty"`
+
+	// Paths limits by the path prefix matched on the segment boundary of the cleaned path, the longest matching one
+	// is used in addition to the global limit.
+	Paths []InFlightPathConfig `mapstructure:"paths" json:"paths,omitempty" bson:"paths,omitempty"`
+
+	// Wait for the capacity while the client is connected instead of the immediate rejection.
+	Wait bool `mapstructure:"wait" json:"wait,omitempty" bson:"wait,omitempty"`
+
+	// Queue bounds the waiting requests, takes precedence over Wait.
+	Queue *InFlightQueueConfig `mapstructure:"queue" json:"queue,omitempty" bson:"queue,omitempty"`
+
+	// RetryAfter sent with the 503 responses. Default: 1s.
+	RetryAfter time.Duration `mapstructure:"retry_after" json:"retry_after,omitempty" bson:"retry_after,omitempty"`
+}
+
+type InFlightPathConfig struct {
+	Path  string `mapstructure:"path" json:"path,omitempty" bson:"path,omitempty"`
+	Limit int    `mapstructure:"limit" json:"limit,omitempty" bson:"limit,omitempty"`
+}
+
+type InFlightQueueConfig struct {
+	// Size is the max number of the waiting requests, the rest are rejected immediately.
+	Size int `mapstructure:"size" json:"size,omitempty" bson:"size,omitempty"`
+
+	// Timeout of the waiting for the capacity. Default: 5s.
+	Timeout time.Duration `mapstructure:"timeout" json:"timeout,omitempty" bson:"timeout,omitempty"`
+}
+
+func (c *InFlightConfig) InitDefaults() error {
+	const op = errors.Op("in_flight_config")
+
+	if c.Queue != nil {
+		if c.Queue.Timeout == 0 {
+			c.Queue.Timeout = 5 * time.Second
+		}
+
+		if c.Queue.Size <= 0 {
+			return errors.E(op, errors.Str("queue size should be positive"))
+		}
+	}
+
+	if c.RetryAfter == 0 {
+		c.RetryAfter = time.Second
+	}
+
+	if c.Limit < 0 {
+		return errors.E(op, errors.Str("limit should not be negative"))
+	}
+
+	for i := 0; i < len(c.Paths); i++ {
+		if !strings.HasPrefix(c.Paths[i].Path, "/") || c.Paths[i].Limit <= 0 {
+			return errors.E(op, errors.Errorf("path should start with / and limit should be positive, got path %q, limit %d", c.Paths[i].Path, c.Paths[i].Limit))
+		}
+	}
+
+	return nil
+}
+
+// InFlight limits the number of the simultaneously served requests.
+type InFlight struct {
+	cfg    *InFlightConfig
+	global chan struct{}
+	paths  []inFlightPath
+	retry  string
+
+	queued atomic.Int64
+	depth  *metrics.Gauge
+}
+
+type inFlightPath struct {
+	prefix string
+	slots  chan struct{}
+}
+
+func NewInFlight(cfg *InFlightConfig, registry *metrics.Registry) *InFlight {
+	f := &InFlight{
+		cfg:   cfg,
+		retry: strconv.Itoa(int((cfg.RetryAfter + time.Second - 1) / time.Second)),
+		depth: registry.Gauge("http_in_flight_queue_depth", "Requests waiting for the in-flight capacity."),
+	}
+
+	if cfg.Limit > 0 {
+		f.global = make(chan struct{}, cfg.Limit)
+	}
+
+	for i := 0; i < len(cfg.Paths); i++ {
+		f.paths = append(f.paths, inFlightPath{
+			prefix: cfg.Paths[i].Path,
+			slots:  make(chan struct{}, cfg.Paths[i].Limit),
+		})
+	}
+
+	return f
+}
+
+func (f *InFlight) Name() string {
+	return InFlightName
+}
+
+func (f *InFlight) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var path chan struct{}
+		if len(f.paths) > 0 {
+			matched := -1
+			cleaned := CleanPath(r.URL.Path)
+			for i := 0; i < len(f.paths); i++ {
+				if SegmentPrefix(cleaned, f.paths[i].prefix) && len(f.paths[i].prefix) > matched {
+					matched = len(f.paths[i].prefix)
+					path = f.paths[i].slots
+				}
+			}
+		}
+
+		if !f.acquire(r, path) {
+			Annotate(r, "in_flight: rejected, too many requests in flight")
+			Audit(r, AuditRateLimited, slog.String("limiter", InFlightName))
+			w.Header().Set("Retry-After", f.retry)
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+
+		defer f.release(path)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquire takes the path slot first, then the global one
+func (f *InFlight) acquire(r *http.Request, path chan struct{}) bool {
+	if f.free(path) {
+		if f.free(f.global) {
+			return true
+		}
+
+		if path != nil {
+			<-path
+		}
+	}
+
+	if !f.cfg.Wait && f.cfg.Queue == nil {
+		return false
+	}
+
+	return f.wait(r, path)
+}
+
+// free takes the slot without waiting
+func (f *InFlight) free(slots chan struct{}) bool {
+	if slots == nil {
+		return true
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (f *InFlight) wait(r *http.Request, path chan struct{}) bool {
+	var timeout <-chan time.Time
+	if f.cfg.Queue != nil {
+		if f.queued.Add(1) > int64(f.cfg.Queue.Size) {
+			f.queued.Add(-1)
+			return false
+		}
+
+		timer := time.NewTimer(f.cfg.Queue.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	} else {
+		f.queued.Add(1)
+	}
+
+	f.depth.Set(float64(f.queued.Load()))
+	defer func() {
+		f.depth.Set(float64(f.queued.Add(-1)))
+	}()
+
+	if !f.take(r, path, timeout) {
+		return false
+	}
+
+	if !f.take(r, f.global, timeout) {
+		if path != nil {
+			<-path
+		}
+		return false
+	}
+
+	return true
+}
+
+func (f *InFlight) take(r *http.Request, slots chan struct{}, timeout <-chan time.Time) bool {
+	if slots == nil {
+		return true
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-timeout:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func (f *InFlight) release(path chan struct{}) {
+	if f.global != nil {
+		<-f.global
+	}
+
+	if path != nil {
+		<-path
+	}
+}
@@ -0,0 +1,583 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+)
+
+const JWTName = "jwt"
+
+// ClaimsKey is the request context key of the validated JWT claims
+const ClaimsKey contextKey = "jwt_claims"
+
+type JWTConfig struct {
+	// Algorithms accepted, defaults to the HS* with the secret and to the RS*, PS*, ES* with the public keys.
+	Algorithms []string `mapstructure:"algorithms" json:"algorithms,omitempty" bson:"algorithms,omitempty"`
+
+	// Secret of the HMAC signed tokens.
+	Secret string `mapstructure:"secret" json:"secret,omitempty" bson:"secret,omitempty"`
+
+	// PublicKey PEM file of the RSA or ECDSA signed tokens.
+	PublicKey string `mapstructure:"public_key" json:"public_key,omitempty" bson:"public_key,omitempty"`
+
+	// JWKSURL of the identity provider key set.
+	JWKSURL string `mapstructure:"jwks_url" json:"jwks_url,omitempty" bson:"jwks_url,omitempty"`
+
+	// JWKSRefreshInterval of the cached key set, defaults to 1h. Unknown key IDs trigger the refresh as well, at
+	// most once per 30s.
+	JWKSRefreshInterval time.Duration `mapstructure:"jwks_refresh_interval" json:"jwks_refresh_interval,omitempty" bson:"jwks_refresh_interval,omitempty"`
+
+	// Issuer expected in the iss claim.
+	Issuer string `mapstructure:"issuer" json:"issuer,omitempty" bson:"issuer,omitempty"`
+
+	// Audience, one of them is expected in the aud claim.
+	Audience []string `mapstructure:"audience" json:"audience,omitempty" bson:"audience,omitempty"`
+
+	// Leeway of the exp and nbf checks for the clock skew.
+	Leeway time.Duration `mapstructure:"leeway" json:"leeway,omitempty" bson:"leeway,omitempty"`
+
+	// AllowMissingExp accepts the tokens without the exp claim, they never expire. The exp claim is required
+	// by default.
+	AllowMissingExp bool `mapstructure:"allow_missing_exp" json:"allow_missing_exp,omitempty" bson:"allow_missing_exp,omitempty"`
+
+	// ExcludePaths prefixes are not authenticated, matched on the whole segments of the cleaned path.
+	ExcludePaths []string `mapstructure:"exclude_paths" json:"exclude_paths,omitempty" bson:"exclude_paths,omitempty"`
+}
+
+func (c *JWTConfig) InitDefaults() error {
+	if c.Secret == "" && c.PublicKey == "" && c.JWKSURL == "" {
+		return errors.Str("jwt requires secret, public_key or jwks_url")
+	}
+
+	if c.JWKSRefreshInterval == 0 {
+		c.JWKSRefreshInterval = time.Hour
+	}
+
+	if len(c.Algorithms) == 0 {
+		if c.Secret != "" {
+			c.Algorithms = append(c.Algorithms, "HS256", "HS384", "HS512")
+		}
+
+		if c.PublicKey != "" || c.JWKSURL != "" {
+			c.Algorithms = append(c.Algorithms, "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512")
+		}
+	}
+
+	for i := 0; i < len(c.Algorithms); i++ {
+		if _, ok := jwtHashes[c.Algorithms[i]]; !ok {
+			return errors.Errorf("unsupported jwt algorithm: %s", c.Algorithms[i])
+		}
+	}
+
+	return nil
+}
+
+var jwtHashes = map[string]crypto.Hash{
+	"HS256": crypto.SHA256, "HS384": crypto.SHA384, "HS512": crypto.SHA512,
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// jwtCurves of the ECDSA algorithms, the key of another curve is not accepted (RFC 7518 3.4)
+var jwtCurves = map[string]string{
+	"ES256": "P-256", "ES384": "P-384", "ES512": "P-521",
+}
+
+// jwksMinInterval between the key set fetches triggered by the unknown key IDs
+const jwksMinInterval = time.Second * 30
+
+// JWT validates the Bearer tokens and puts the claims into the request context, the sub claim is used as the
+// request user.
+type JWT struct {
+	cfg    *JWTConfig
+	log    *slog.Logger
+	secret []byte
+	static crypto.PublicKey
+	client *http.Client
+
+	mu      sync.RWMutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+	// fetching is closed once the key set fetch in flight is done
+	fetching chan struct{}
+	stopCh   chan struct{}
+}
+
+func NewJWT(cfg *JWTConfig, log *slog.Logger) (*JWT, error) {
+	const op = errors.Op("jwt_init")
+
+	j := &JWT{
+		cfg:    cfg,
+		log:    log,
+		secret: []byte(cfg.Secret),
+		client: &http.Client{Timeout: time.Second * 10},
+		keys:   make(map[string]crypto.PublicKey),
+		stopCh: make(chan struct{}),
+	}
+
+	if cfg.PublicKey != "" {
+		key, err := readPublicKey(cfg.PublicKey)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+
+		j.static = key
+	}
+
+	if cfg.JWKSURL != "" {
+		// the identity provider could be unavailable for a moment, the keys are fetched again on demand
+		j.fetchKeys(context.Background(), false)
+
+		go j.refreshLoop()
+	}
+
+	return j, nil
+}
+
+func (j *JWT) Name() string {
+	return JWTName
+}
+
+func (j *JWT) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if matchPath(r.URL.Path, j.cfg.ExcludePaths) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			j.unauthorized(w, r, "missing bearer token")
+			return
+		}
+
+		claims, err := j.validate(r.Context(), token)
+		if err != nil {
+			j.unauthorized(w, r, err.Error())
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), ClaimsKey, claims)
+		if sub, ok := claims["sub"].(string); ok && sub != "" {
+			ctx = context.WithValue(ctx, UserKey, sub)
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (j *JWT) Stop() {
+	close(j.stopCh)
+}
+
+func (j *JWT) unauthorized(w http.ResponseWriter, r *http.Request, reason string) {
+	Annotate(r, "jwt: rejected, "+reason)
+	Audit(r, AuditAuthFailed, slog.String("scheme", "jwt"), slog.String("reason", reason))
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+func (j *JWT) validate(ctx context.Context, token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.Str("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return nil, errors.Str("malformed token header")
+	}
+
+	if !slices.Contains(j.cfg.Algorithms, header.Alg) {
+		return nil, errors.Errorf("algorithm %s is not allowed", header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.Str("malformed token signature")
+	}
+
+	err = j.verify(ctx, header.Alg, header.Kid, parts[0]+"."+parts[1], signature)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := make(map[string]any)
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, errors.Str("malformed token claims")
+	}
+
+	return claims, j.checkClaims(claims)
+}
+
+func (j *JWT) verify(ctx context.Context, alg, kid, signed string, signature []byte) error {
+	hash := jwtHashes[alg]
+	h := hash.New()
+	_, _ = h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	if alg[0] == 'H' {
+		if len(j.secret) == 0 {
+			return errors.Str("no secret for the hmac signed token")
+		}
+
+		mac := hmac.New(hash.New, j.secret)
+		_, _ = mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return errors.Str("invalid signature")
+		}
+
+		return nil
+	}
+
+	keys := j.publicKeys(ctx, kid)
+	for i := 0; i < len(keys); i++ {
+		if verifyAsymmetric(alg, hash, keys[i], digest, signature) {
+			return nil
+		}
+	}
+
+	return errors.Str("invalid signature")
+}
+
+func verifyAsymmetric(alg string, hash crypto.Hash, key crypto.PublicKey, digest, signature []byte) bool {
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[0] {
+		case 'R':
+			return rsa.VerifyPKCS1v15(pub, hash, digest, signature) == nil
+		case 'P':
+			return rsa.VerifyPSS(pub, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+	case *ecdsa.PublicKey:
+		// the signature size comes from the curve, it should be the one of the algorithm
+		if jwtCurves[alg] != pub.Curve.Params().Name {
+			return false
+		}
+
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return false
+		}
+
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(pub, digest, r, s)
+	}
+
+	return false
+}
+
+// publicKeys returns the key with the kid or every known key for the tokens without kid
+func (j *JWT) publicKeys(ctx context.Context, kid string) []crypto.PublicKey {
+	if kid != "" && j.cfg.JWKSURL != "" {
+		j.mu.RLock()
+		key, ok := j.keys[kid]
+		j.mu.RUnlock()
+
+		if ok {
+			return []crypto.PublicKey{key}
+		}
+
+		// rotated keys, the fetch is shared by the concurrent requests and limited to one per jwksMinInterval
+		j.fetchKeys(ctx, true)
+
+		j.mu.RLock()
+		key, ok = j.keys[kid]
+		j.mu.RUnlock()
+
+		if ok {
+			return []crypto.PublicKey{key}
+		}
+	}
+
+	keys := make([]crypto.PublicKey, 0, 1)
+	if j.static != nil {
+		keys = append(keys, j.static)
+	}
+
+	if kid == "" {
+		j.mu.RLock()
+		for _, key := range j.keys {
+			keys = append(keys, key)
+		}
+		j.mu.RUnlock()
+	}
+
+	return keys
+}
+
+func (j *JWT) checkClaims(claims map[string]any) error {
+	now := time.Now()
+
+	switch exp := claims["exp"].(type) {
+	case float64:
+		if now.After(time.Unix(int64(exp), 0).Add(j.cfg.Leeway)) {
+			return errors.Str("token is expired")
+		}
+	case nil:
+		if !j.cfg.AllowMissingExp {
+			return errors.Str("token has no exp claim")
+		}
+	default:
+		return errors.Str("malformed exp claim")
+	}
+
+	switch nbf := claims["nbf"].(type) {
+	case float64:
+		if now.Before(time.Unix(int64(nbf), 0).Add(-j.cfg.Leeway)) {
+			return errors.Str("token is not valid yet")
+		}
+	case nil:
+	default:
+		return errors.Str("malformed nbf claim")
+	}
+
+	if j.cfg.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != j.cfg.Issuer {
+			return errors.Str("invalid issuer")
+		}
+	}
+
+	if len(j.cfg.Audience) > 0 {
+		var aud []string
+		switch v := claims["aud"].(type) {
+		case string:
+			aud = []string{v}
+		case []any:
+			for i := 0; i < len(v); i++ {
+				if s, ok := v[i].(string); ok {
+					aud = append(aud, s)
+				}
+			}
+		}
+
+		for i := 0; i < len(aud); i++ {
+			if slices.Contains(j.cfg.Audience, aud[i]) {
+				return nil
+			}
+		}
+
+		return errors.Str("invalid audience")
+	}
+
+	return nil
+}
+
+func (j *JWT) refreshLoop() {
+	ticker := time.NewTicker(j.cfg.JWKSRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-j.stopCh:
+			return
+		case <-ticker.C:
+			j.fetchKeys(context.Background(), false)
+		}
+	}
+}
+
+// fetchKeys refreshes the key set once for the concurrent callers, they wait for the fetch in flight until ctx is
+// done. The on demand fetches of the unknown key IDs are limited to one per jwksMinInterval.
+func (j *JWT) fetchKeys(ctx context.Context, onDemand bool) {
+	j.mu.Lock()
+	if fetching := j.fetching; fetching != nil {
+		j.mu.Unlock()
+
+		select {
+		case <-fetching:
+		case <-ctx.Done():
+		}
+
+		return
+	}
+
+	if onDemand && time.Since(j.fetched) < jwksMinInterval {
+		j.mu.Unlock()
+		return
+	}
+
+	fetching := make(chan struct{})
+	j.fetching = fetching
+	j.fetched = time.Now()
+	j.mu.Unlock()
+
+	err := j.refresh()
+	if err != nil {
+		// keep the previous keys
+		j.log.Warn("jwks fetch", "url", j.cfg.JWKSURL, "error", err)
+	}
+
+	j.mu.Lock()
+	j.fetching = nil
+	j.mu.Unlock()
+	close(fetching)
+}
+
+func (j *JWT) refresh() error {
+	resp, err := j.client.Get(j.cfg.JWKSURL)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected jwks response status: %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&set)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for i := 0; i < len(set.Keys); i++ {
+		if set.Keys[i].Use != "" && set.Keys[i].Use != "sig" {
+			continue
+		}
+
+		key, err := set.Keys[i].publicKey()
+		if err != nil {
+			j.log.Warn("jwks key skipped", "kid", set.Keys[i].Kid, "error", err)
+			continue
+		}
+
+		keys[set.Keys[i].Kid] = key
+	}
+
+	j.mu.Lock()
+	j.keys = keys
+	j.mu.Unlock()
+
+	return nil
+}
+
+// GetClaims returns the claims of the token validated by the jwt middleware
+func GetClaims(r *http.Request) map[string]any {
+	claims, _ := r.Context().Value(ClaimsKey).(map[string]any)
+	return claims
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		var c elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			c = elliptic.P256()
+		case "P-384":
+			c = elliptic.P384()
+		case "P-521":
+			c = elliptic.P521()
+		default:
+			return nil, errors.Errorf("unsupported curve: %s", k.Crv)
+		}
+
+		pub := &ecdsa.PublicKey{Curve: c, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		// the point should be on the curve
+		if _, err := pub.ECDH(); err != nil {
+			return nil, err
+		}
+
+		return pub, nil
+	default:
+		return nil, errors.Errorf("unsupported key type: %s", k.Kty)
+	}
+}
+
+func readPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.Errorf("no PEM data found in %s", path)
+	}
+
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		return cert.PublicKey, nil
+	}
+
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,299 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// signJWT signs the token with the HMAC secret ([]byte), the RSA or the ECDSA private key
+func signJWT(t *testing.T, alg, kid string, claims map[string]any, key any) string {
+	t.Helper()
+
+	header := map[string]any{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+
+	hash, ok := jwtHashes[alg]
+	if !ok {
+		// e.g. none
+		return signed + "."
+	}
+
+	d := hash.New()
+	_, _ = d.Write([]byte(signed))
+	digest := d.Sum(nil)
+
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(hash.New, k)
+		_, _ = mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, hash, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		size := (k.Curve.Params().BitSize + 7) / 8
+		signature = make([]byte, 2*size)
+		r.FillBytes(signature[:size])
+		s.FillBytes(signature[size:])
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// writePublicKey writes the PEM file of the public key
+func writePublicKey(t *testing.T, key crypto.PublicKey) (string, []byte) {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	path := filepath.Join(t.TempDir(), "public.pem")
+
+	err = os.WriteFile(path, data, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path, data
+}
+
+func newTestJWT(t *testing.T, cfg *JWTConfig) *JWT {
+	t.Helper()
+
+	err := cfg.InitDefaults()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := NewJWT(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(j.Stop)
+
+	return j
+}
+
+func validClaims() map[string]any {
+	return map[string]any{"sub": "user", "exp": time.Now().Add(time.Minute).Unix()}
+}
+
+func TestJWTAlgorithmConfusion(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, pemData := writePublicKey(t, &key.PublicKey)
+
+	j := newTestJWT(t, &JWTConfig{PublicKey: path})
+	// the secret is configured, the HS tokens should still not be verified by the public key
+	both := newTestJWT(t, &JWTConfig{PublicKey: path, Secret: "secret", Algorithms: []string{"HS256", "RS256"}})
+
+	tests := []struct {
+		name  string
+		j     *JWT
+		token string
+		ok    bool
+	}{
+		{name: "rs256", j: j, token: signJWT(t, "RS256", "", validClaims(), key), ok: true},
+		{name: "none", j: j, token: signJWT(t, "none", "", validClaims(), nil)},
+		{name: "hs256 with the public key", j: j, token: signJWT(t, "HS256", "", validClaims(), pemData)},
+		{name: "hs256 with the public key and secret", j: both, token: signJWT(t, "HS256", "", validClaims(), pemData)},
+		{name: "hs256 with the secret", j: both, token: signJWT(t, "HS256", "", validClaims(), []byte("secret")), ok: true},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		_, err := tests[i].j.validate(context.Background(), tests[i].token)
+		if (err == nil) != tests[i].ok {
+			t.Fatalf("%s: error %v, valid should be %v", tests[i].name, err, tests[i].ok)
+		}
+	}
+}
+
+func TestJWTTimeClaims(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Now()
+
+	j := newTestJWT(t, &JWTConfig{Secret: string(secret), Leeway: time.Minute})
+	optional := newTestJWT(t, &JWTConfig{Secret: string(secret), Leeway: time.Minute, AllowMissingExp: true})
+
+	tests := []struct {
+		name   string
+		j      *JWT
+		claims map[string]any
+		ok     bool
+	}{
+		{name: "valid", j: j, claims: map[string]any{"exp": now.Add(time.Minute).Unix()}, ok: true},
+		{name: "expired within the leeway", j: j, claims: map[string]any{"exp": now.Add(-time.Second * 30).Unix()}, ok: true},
+		{name: "expired", j: j, claims: map[string]any{"exp": now.Add(-time.Minute * 2).Unix()}},
+		{name: "not valid yet within the leeway", j: j, claims: map[string]any{"exp": now.Add(time.Hour).Unix(), "nbf": now.Add(time.Second * 30).Unix()}, ok: true},
+		{name: "not valid yet", j: j, claims: map[string]any{"exp": now.Add(time.Hour).Unix(), "nbf": now.Add(time.Minute * 2).Unix()}},
+		{name: "missing exp", j: j, claims: map[string]any{"sub": "user"}},
+		{name: "malformed exp", j: j, claims: map[string]any{"exp": "tomorrow"}},
+		{name: "malformed nbf", j: j, claims: map[string]any{"exp": now.Add(time.Hour).Unix(), "nbf": "now"}},
+		{name: "missing exp allowed", j: optional, claims: map[string]any{"sub": "user"}, ok: true},
+		{name: "expired with the missing exp allowed", j: optional, claims: map[string]any{"exp": now.Add(-time.Minute * 2).Unix()}},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		_, err := tests[i].j.validate(context.Background(), signJWT(t, "HS256", "", tests[i].claims, secret))
+		if (err == nil) != tests[i].ok {
+			t.Fatalf("%s: error %v, valid should be %v", tests[i].name, err, tests[i].ok)
+		}
+	}
+}
+
+func TestJWTCurveMismatch(t *testing.T) {
+	p521, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, _ := writePublicKey(t, &p521.PublicKey)
+	j := newTestJWT(t, &JWTConfig{PublicKey: path, Algorithms: []string{"ES256", "ES512"}})
+
+	_, err = j.validate(context.Background(), signJWT(t, "ES512", "", validClaims(), p521))
+	if err != nil {
+		t.Fatalf("es512 with the p-521 key: %v", err)
+	}
+
+	// the signature of the p-521 size with the sha-256 digest is valid for the key, not for the algorithm
+	_, err = j.validate(context.Background(), signJWT(t, "ES256", "", validClaims(), p521))
+	if err == nil {
+		t.Fatal("es256 with the p-521 key should be rejected")
+	}
+}
+
+func TestJWTKeyRefresh(t *testing.T) {
+	first, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	keys := map[string]*rsa.PublicKey{"first": &first.PublicKey}
+	var fetches atomic.Int32
+
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fetches.Add(1)
+		// the concurrent requests should wait for the single fetch
+		time.Sleep(time.Millisecond * 50)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		set := make([]map[string]string, 0, len(keys))
+		for kid, key := range keys {
+			set = append(set, map[string]string{
+				"kty": "RSA",
+				"kid": kid,
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": set})
+	}))
+	defer jwks.Close()
+
+	j := newTestJWT(t, &JWTConfig{JWKSURL: jwks.URL})
+	if n := fetches.Load(); n != 1 {
+		t.Fatalf("%d fetches at the start, should be 1", n)
+	}
+
+	_, err = j.validate(context.Background(), signJWT(t, "RS256", "first", validClaims(), first))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the unknown key IDs do not trigger the fetch right after the previous one
+	for i := 0; i < 10; i++ {
+		_, err = j.validate(context.Background(), signJWT(t, "RS256", "unknown", validClaims(), second))
+		if err == nil {
+			t.Fatal("the token of the unknown key should be rejected")
+		}
+	}
+
+	if n := fetches.Load(); n != 1 {
+		t.Fatalf("%d fetches for the unknown key IDs, should be 1", n)
+	}
+
+	// the key is rotated, the concurrent requests share the single fetch
+	mu.Lock()
+	keys["second"] = &second.PublicKey
+	mu.Unlock()
+
+	j.mu.Lock()
+	j.fetched = time.Now().Add(-jwksMinInterval)
+	j.mu.Unlock()
+
+	token := signJWT(t, "RS256", "second", validClaims(), second)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, errV := j.validate(context.Background(), token)
+			errs <- errV
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for errV := range errs {
+		if errV != nil {
+			t.Fatalf("the token of the rotated key: %v", errV)
+		}
+	}
+
+	if n := fetches.Load(); n != 2 {
+		t.Fatalf("%d fetches after the rotation, should be 2", n)
+	}
+}
@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/rumorshub/http/servers/conninfo"
+)
+
+// MaxConnRequests closes the HTTP/1.x connection after the limit of requests by answering with the
+// Connection: close header, so the clients re-connect and the load balancers re-distribute them.
+// HTTP/2 connections are not limited.
+func MaxConnRequests(next http.Handler, limit uint64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info, ok := conninfo.FromContext(r.Context()); ok && r.ProtoMajor == 1 && info.Request() >= limit {
+			w.Header().Set("Connection", "close")
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -25,26 +25,42 @@ package middleware
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
 	"errors"
 	"io"
 	"log/slog"
+	"math/rand"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
+
+	"github.com/rumorshub/http/metrics"
+	"github.com/rumorshub/http/stats"
 )
 
 var (
 	_ io.ReadCloser       = (*wrapper)(nil)
 	_ http.ResponseWriter = (*wrapper)(nil)
+	_ http.Pusher         = (*wrapper)(nil)
+	_ io.ReaderFrom       = (*wrapper)(nil)
 )
 
 var ErrHijackerNotSupported = errors.New("http.Hijacker interface is not supported")
 
-const requestIDCtx = "request_id"
+const AccessLogName = "access_log"
+
+// RequestIDKey is the request context key of the request ID
+const RequestIDKey contextKey = "request_id"
+
+const logContextKey contextKey = "access_log"
+
+// maxRequestIDLength of the incoming request ID, longer ones are replaced
+const maxRequestIDLength = 128
 
 type wrapper struct {
 	io.ReadCloser
@@ -54,16 +70,33 @@ type wrapper struct {
 	w    http.ResponseWriter
 	code int
 	data []byte
+
+	// body snippets, data holds the response one
+	limit   int
+	types   []string
+	in      []byte
+	inOK    bool
+	outOK   bool
+	checked bool
+
+	// conn is the hijacked connection, the wrapper is not reused then
+	conn *hijackedConn
 }
 
 func (w *wrapper) Read(b []byte) (int, error) {
 	n, err := w.ReadCloser.Read(b)
 	w.read += n
+	if w.inOK {
+		w.in = capture(w.in, b[:n], w.limit)
+	}
 	return n, err
 }
 
 func (w *wrapper) WriteHeader(code int) {
-	w.code = code
+	// the informational responses precede the final one, the superfluous calls are ignored by the server
+	if informational(w.code) {
+		w.code = code
+	}
 	w.w.WriteHeader(code)
 }
 
@@ -72,14 +105,55 @@ func (w *wrapper) Header() http.Header {
 }
 
 func (w *wrapper) Write(b []byte) (int, error) {
+	w.implicitOK()
 	n, err := w.w.Write(b)
 	w.write += n
+	if w.capturesOut() {
+		w.data = capture(w.data, b[:n], w.limit)
+	}
 	return n, err
 }
 
+// implicitOK records the 200 sent by the server on the first write without the WriteHeader
+func (w *wrapper) implicitOK() {
+	if informational(w.code) {
+		w.code = http.StatusOK
+	}
+}
+
+// informational status or none is not final, 101 is final as the server switches the protocol after it
+func informational(code int) bool {
+	return code < http.StatusOK && code != http.StatusSwitchingProtocols
+}
+
+// capturesOut reports whether the response body snippet is captured, checked once by the content type
+func (w *wrapper) capturesOut() bool {
+	if w.limit == 0 {
+		return false
+	}
+
+	if !w.checked {
+		w.checked = true
+		w.outOK = matchContentType(w.w.Header().Get("Content-Type"), w.types)
+	}
+
+	return w.outOK
+}
+
 func (w *wrapper) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	if hj, ok := w.w.(http.Hijacker); ok {
-		return hj.Hijack()
+		conn, rw, err := hj.Hijack()
+		if err != nil {
+			return conn, rw, err
+		}
+
+		// upgrade handlers write 101 to the connection directly
+		if w.code == 0 {
+			w.code = http.StatusSwitchingProtocols
+		}
+
+		w.conn = &hijackedConn{Conn: conn}
+		return w.conn, rw, nil
 	}
 
 	return nil, nil, ErrHijackerNotSupported
@@ -91,6 +165,33 @@ func (w *wrapper) Flush() {
 	}
 }
 
+// Push initiates the HTTP/2 server push, http.ErrNotSupported when the connection does not support it
+func (w *wrapper) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.w.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+
+	return http.ErrNotSupported
+}
+
+// ReadFrom keeps the sendfile of the underlying writer, the body snippet is captured by the writes
+func (w *wrapper) ReadFrom(src io.Reader) (int64, error) {
+	if w.capturesOut() {
+		return io.Copy(writerOnly{w}, src)
+	}
+
+	w.implicitOK()
+	n, err := io.Copy(w.w, src)
+	w.write += int(n)
+
+	return n, err
+}
+
+// Unwrap returns the underlying writer for the http.ResponseController
+func (w *wrapper) Unwrap() http.ResponseWriter {
+	return w.w
+}
+
 func (w *wrapper) Close() error {
 	return w.ReadCloser.Close()
 }
@@ -102,21 +203,137 @@ func (w *wrapper) reset() {
 	w.w = nil
 	w.data = nil
 	w.ReadCloser = nil
+	w.limit = 0
+	w.types = nil
+	w.in = nil
+	w.inOK = false
+	w.outOK = false
+	w.checked = false
+	w.conn = nil
+}
+
+// hijackedConn calls the callback once the connection taken over by the handler is closed
+type hijackedConn struct {
+	net.Conn
+
+	mu     sync.Mutex
+	closed bool
+	done   func()
+}
+
+func (c *hijackedConn) Close() error {
+	err := c.Conn.Close()
+
+	c.mu.Lock()
+	done := c.done
+	first := !c.closed
+	c.closed = true
+	c.done = nil
+	c.mu.Unlock()
+
+	if first && done != nil {
+		done()
+	}
+
+	return err
+}
+
+// onClose sets the callback, it is called right away when the connection has been closed already
+func (c *hijackedConn) onClose(done func()) {
+	c.mu.Lock()
+	if !c.closed {
+		c.done = done
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+
+	done()
+}
+
+// writerOnly hides the ReadFrom of the writer from io.Copy
+type writerOnly struct {
+	io.Writer
+}
+
+// capture appends p to the snippet up to the limit
+func capture(snippet, p []byte, limit int) []byte {
+	if len(snippet) >= limit {
+		return snippet
+	}
+
+	if len(p) > limit-len(snippet) {
+		p = p[:limit-len(snippet)]
+	}
+
+	return append(snippet, p...)
+}
+
+func matchContentType(contentType string, types []string) bool {
+	if contentType == "" {
+		return false
+	}
+
+	return hasPrefix(strings.ToLower(strings.TrimSpace(contentType)), types)
 }
 
 type lm struct {
-	pool sync.Pool
-	log  *slog.Logger
+	pool  sync.Pool
+	attrs sync.Pool
+	log   *slog.Logger
+
+	exclude []string
+	rate    float64
+	headers []string
+	query   bool
+	redact  *Redactor
+	snippet *BodySnippetConfig
+
+	idHeader  string
+	idSubnets []*net.IPNet
+
+	stats   *stats.Stats
+	latency *metrics.Histogram
 }
 
-func NewLogMiddleware(next http.Handler, log *slog.Logger) http.Handler {
+// NewLogMiddleware logs every request, cfg is optional and controls the exclusions and sampling,
+// every request is recorded in the server stats and its latency in the histogram when they are provided
+func NewLogMiddleware(next http.Handler, log *slog.Logger, cfg *AccessLogConfig, st *stats.Stats, latency *metrics.Histogram) http.Handler {
 	l := &lm{
-		log: log,
+		log:      log,
+		stats:    st,
+		latency:  latency,
+		rate:     1,
+		idHeader: "X-Request-Id",
+		redact:   NewRedactor(nil),
 		pool: sync.Pool{
 			New: func() interface{} {
 				return &wrapper{}
 			},
 		},
+		attrs: sync.Pool{
+			New: func() interface{} {
+				attrs := make([]slog.Attr, 0, 24)
+				return &attrs
+			},
+		},
+	}
+
+	if cfg != nil {
+		l.exclude = cfg.ExcludePaths
+		l.headers = cfg.Headers
+		l.query = cfg.Query
+		l.redact = NewRedactor(cfg.Redact)
+		l.snippet = cfg.BodySnippet
+		if cfg.RequestID != nil {
+			// canonical, so setting and reading it does not allocate the canonical key
+			l.idHeader = http.CanonicalHeaderKey(cfg.RequestID.Header)
+			// validated by the config
+			l.idSubnets, _ = parseSubnets(cfg.RequestID.TrustedSubnets)
+		}
+		if cfg.SampleRate != nil {
+			l.rate = *cfg.SampleRate
+		}
 	}
 
 	return l.Log(next)
@@ -127,51 +344,243 @@ func (l *lm) Log(next http.Handler) http.Handler {
 		start := time.Now()
 		path := r.URL.Path
 
-		requestID := uuid.NewString()
-		w.Header().Set("X-Request-ID", requestID)
-		r = r.WithContext(context.WithValue(r.Context(), requestIDCtx, requestID))
+		requestID := l.requestID(r)
+		w.Header().Set(l.idHeader, requestID)
+
+		// the request ID, the trace context and the tracked request share the single context value
+		lc := &logContext{Context: r.Context(), requestID: requestID}
+		tc, traced := ParseTraceparent(r.Header.Get("Traceparent"))
+		if traced {
+			lc.tc = tc
+		}
+
+		r = r.WithContext(lc)
 
 		bw := l.getW(w)
-		defer l.putW(bw)
+		defer func() {
+			// the handler could keep using the writer of the hijacked connection after it returns
+			if bw.conn == nil {
+				l.putW(bw)
+			}
+		}()
+
+		if l.snippet != nil {
+			bw.limit = l.snippet.Size
+			bw.types = l.snippet.ContentTypes
+			bw.inOK = matchContentType(r.Header.Get("Content-Type"), bw.types)
+		}
+
+		// upgraded connections are read after the hijack, not through the body
+		upgrade := IsUpgrade(r)
+
+		// r is the copy made by WithContext already
+		if r.Body != nil && !upgrade {
+			bw.ReadCloser = r.Body
+			r.Body = bw
+		}
+
+		if l.stats != nil {
+			l.stats.Begin()
+		}
+
+		next.ServeHTTP(bw, r)
+
+		// the server answers 200 to the handler which has written nothing
+		if bw.code == 0 {
+			bw.code = http.StatusOK
+		}
+
+		if l.stats != nil {
+			l.stats.End(bw.code, bw.read, bw.write)
+		}
+
+		// the latencies of the streaming responses and the hijacked connections are meaningless
+		if l.latency != nil && bw.conn == nil && !IsStreaming(r) {
+			exemplar := [4]string{"request_id", requestID, "trace_id", tc.TraceID}
+			l.latency.ObserveExemplar(time.Since(start).Seconds(), exemplar[:], metricMethod(r.Method), metricCode(bw.code))
+		}
 
-		r2 := *r
-		if r2.Body != nil {
-			bw.ReadCloser = r2.Body
-			r2.Body = bw
+		if !l.shouldLog(path, bw.code) {
+			return
 		}
 
-		next.ServeHTTP(bw, &r2)
+		// the attributes are not built for the disabled level
+		level := logLevel(bw.code)
+		if bw.conn != nil {
+			level = slog.LevelInfo
+		}
+
+		if !l.log.Enabled(context.Background(), level) {
+			return
+		}
 
 		end := time.Now()
 		latency := end.Sub(start)
 
-		ip, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
+		// the outermost log reads the client address, the user, the country and the bot of the deepest request copy
+		inner := r
+		if lc.last != nil {
+			inner = lc.last
+		}
+
+		ip, _, err := net.SplitHostPort(strings.TrimSpace(inner.RemoteAddr))
 		if err != nil {
-			ip = r.RemoteAddr
+			ip = inner.RemoteAddr
 		}
 
-		attributes := []slog.Attr{
+		ap := l.attrs.Get().(*[]slog.Attr)
+		attributes := append((*ap)[:0],
 			slog.Int("status", bw.code),
 			slog.String("method", r.Method),
 			slog.String("path", path),
-			slog.String("ip", ip),
-			slog.Duration("latency", latency),
+			slog.String("ip", l.redact.Addr(ip)),
 			slog.String("user-agent", r.UserAgent()),
 			slog.Time("time", end),
 			slog.String("request-id", requestID),
+			slog.Int("bytes_in", bw.read),
+			slog.Int("bytes_out", bw.write),
+		)
+
+		// the streaming response lasts until the client is gone, its latency is meaningless, the duration
+		// of the hijacked connection is logged once it is closed
+		if bw.conn == nil {
+			if IsStreaming(r) {
+				attributes = append(attributes, slog.Duration("duration", latency), slog.Bool("streaming", true))
+			} else {
+				attributes = append(attributes, slog.Duration("latency", latency))
+			}
+		}
+
+		if upgrade {
+			attributes = append(attributes, slog.String("upgrade", r.Header.Get("Upgrade")))
+		}
+
+		if user := GetUser(inner); user != "" {
+			attributes = append(attributes, slog.String("user", user))
+		}
+
+		if country := GetCountry(inner); country != "" {
+			attributes = append(attributes, slog.String("country", country))
+		}
+
+		if bot := GetBot(inner); bot != "" {
+			attributes = append(attributes, slog.String("bot", bot))
+		}
+
+		if traced {
+			attributes = append(attributes, slog.String("trace_id", tc.TraceID), slog.String("span_id", tc.SpanID))
 		}
 
-		switch {
-		case bw.code >= http.StatusBadRequest && bw.code < http.StatusInternalServerError:
-			l.log.LogAttrs(context.Background(), slog.LevelWarn, "Incoming request", attributes...)
-		case bw.code >= http.StatusInternalServerError:
-			l.log.LogAttrs(context.Background(), slog.LevelError, "Incoming request", attributes...)
-		default:
-			l.log.LogAttrs(context.Background(), slog.LevelInfo, "Incoming request", attributes...)
+		if r.TLS != nil {
+			attributes = append(attributes,
+				slog.String("tls_version", tls.VersionName(r.TLS.Version)),
+				slog.String("tls_cipher", tls.CipherSuiteName(r.TLS.CipherSuite)),
+				slog.String("tls_sni", r.TLS.ServerName),
+				slog.String("tls_alpn", r.TLS.NegotiatedProtocol),
+			)
 		}
+
+		if len(bw.in) > 0 {
+			attributes = append(attributes, slog.String("request_body", string(bw.in)))
+		}
+
+		if len(bw.data) > 0 {
+			attributes = append(attributes, slog.String("response_body", string(bw.data)))
+		}
+
+		if l.query && r.URL.RawQuery != "" {
+			attributes = append(attributes, slog.String("query", l.redact.RawQuery(r.URL.RawQuery)))
+		}
+
+		if len(l.headers) > 0 {
+			attributes = append(attributes, l.headerAttrs(r.Header))
+		}
+
+		// the attributes are kept by the callback, not returned to the pool. The callback captures the copy of
+		// the slice header, the captured attributes would be moved to the heap for every request.
+		if bw.conn != nil {
+			kept := attributes
+			bw.conn.onClose(func() {
+				kept = append(kept, slog.Duration("duration", time.Since(start)))
+				l.log.LogAttrs(context.Background(), level, "Upgraded connection", kept...)
+			})
+			return
+		}
+
+		l.log.LogAttrs(context.Background(), level, "Incoming request", attributes...)
+
+		// the strings of the request should not be retained by the pool
+		clear(attributes)
+		*ap = attributes[:0]
+		l.attrs.Put(ap)
 	})
 }
 
+func logLevel(code int) slog.Level {
+	switch {
+	case code >= http.StatusBadRequest && code < http.StatusInternalServerError:
+		return slog.LevelWarn
+	case code >= http.StatusInternalServerError:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// requestID accepts the incoming ID from the trusted proxies only
+func (l *lm) requestID(r *http.Request) string {
+	if len(l.idSubnets) > 0 && containsIP(l.idSubnets, peerIP(r)) {
+		id := r.Header.Get(l.idHeader)
+		if validRequestID(id) {
+			return id
+		}
+	}
+
+	return uuid.NewString()
+}
+
+// validRequestID protects the logs from the injection, only printable ASCII is allowed
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (l *lm) headerAttrs(header http.Header) slog.Attr {
+	attrs := make([]any, 0, len(l.headers))
+	for i := 0; i < len(l.headers); i++ {
+		value := header.Get(l.headers[i])
+		if value == "" {
+			continue
+		}
+
+		attrs = append(attrs, slog.String(l.headers[i], l.redact.Header(l.headers[i], value)))
+	}
+
+	return slog.Group("headers", attrs...)
+}
+
+func (l *lm) shouldLog(path string, code int) bool {
+	if hasPrefix(path, l.exclude) {
+		return false
+	}
+
+	// errors are always logged
+	if code >= http.StatusBadRequest || l.rate >= 1 {
+		return true
+	}
+
+	return rand.Float64() < l.rate //nolint:gosec
+}
+
 func (l *lm) getW(w http.ResponseWriter) *wrapper {
 	wr := l.pool.Get().(*wrapper)
 	wr.w = w
@@ -184,10 +593,74 @@ func (l *lm) putW(w *wrapper) {
 }
 
 // GetRequestID returns the request identifier
+// logContext carries the values of the access log in the single context, the boxed values are allocated once
+type logContext struct {
+	context.Context
+	requestID any
+	tc        any
+	// last is the deepest request copy reached when the access log wraps the server level middleware
+	last *http.Request
+}
+
+func (c *logContext) Value(key any) any {
+	switch key {
+	case RequestIDKey:
+		return c.requestID
+	case TraceContextKey:
+		return c.tc
+	case logContextKey:
+		return c
+	default:
+		return c.Context.Value(key)
+	}
+}
+
+// TrackRequest records the request copy passed to next for the outermost access log, the middleware add the
+// client address, the user or the country to their copies
+func TrackRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if lc, ok := r.Context().Value(logContextKey).(*logContext); ok {
+			lc.last = r
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func GetRequestID(r *http.Request) string {
-	requestID, ok := r.Context().Value(requestIDCtx).(string)
+	requestID, ok := r.Context().Value(RequestIDKey).(string)
 	if !ok {
 		return ""
 	}
 	return requestID
 }
+
+// codes of the metric labels, the status codes are formatted once
+var codes = func() []string {
+	labels := make([]string, 600)
+	for i := 100; i < len(labels); i++ {
+		labels[i] = strconv.Itoa(i)
+	}
+
+	return labels
+}()
+
+// metricCode of the status, without the allocation for the valid ones
+func metricCode(code int) string {
+	if code >= 100 && code < len(codes) {
+		return codes[code]
+	}
+
+	return strconv.Itoa(code)
+}
+
+// metricMethod bounds the cardinality of the method label, the unknown methods are other
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return "other"
+	}
+}
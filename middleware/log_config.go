@@ -0,0 +1,138 @@
+// MIT License
+//
+// Copyright (c) 2023 Spiral Scout
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package middleware
+
+import (
+	"github.com/roadrunner-server/errors"
+
+	"github.com/rumorshub/http/logsink"
+)
+
+type AccessLogConfig struct {
+	// ExcludePaths prefixes are not logged (health checks, metrics).
+	ExcludePaths []string `mapstructure:"exclude_paths" json:"exclude_paths,omitempty" bson:"exclude_paths,omitempty"`
+
+	// SampleRate of the successful requests to log, from 0 to 1, defaults to 1. 4xx and 5xx are always logged.
+	SampleRate *float64 `mapstructure:"sample_rate" json:"sample_rate,omitempty" bson:"sample_rate,omitempty"`
+
+	// Headers of the request to log.
+	Headers []string `mapstructure:"headers" json:"headers,omitempty" bson:"headers,omitempty"`
+
+	// Query string is logged when true.
+	Query bool `mapstructure:"query" json:"query,omitempty" bson:"query,omitempty"`
+
+	// BodySnippet captures the beginning of the request and response bodies for debugging.
+	BodySnippet *BodySnippetConfig `mapstructure:"body_snippet" json:"body_snippet,omitempty" bson:"body_snippet,omitempty"`
+
+	// RequestID controls the X-Request-ID handling.
+	RequestID *RequestIDConfig `mapstructure:"request_id" json:"request_id,omitempty" bson:"request_id,omitempty"`
+
+	// Redact hides the sensitive headers, query parameters and client IP before they reach the log output. The
+	// default headers are hidden without it.
+	Redact *RedactConfig `mapstructure:"redact" json:"redact,omitempty" bson:"redact,omitempty"`
+
+	// Sinks ship the entries to syslog, the UDP/TCP collectors or the webhooks in addition to the logger.
+	Sinks []logsink.Config `mapstructure:"sinks" json:"sinks,omitempty" bson:"sinks,omitempty"`
+}
+
+type RequestIDConfig struct {
+	// Header carrying the request ID, defaults to X-Request-ID. Echoed on the response.
+	Header string `mapstructure:"header" json:"header,omitempty" bson:"header,omitempty"`
+
+	// TrustedSubnets of the proxies allowed to pass the incoming request ID, otherwise a new one is generated.
+	TrustedSubnets []string `mapstructure:"trusted_subnets" json:"trusted_subnets,omitempty" bson:"trusted_subnets,omitempty"`
+}
+
+func (c *RequestIDConfig) InitDefaults() error {
+	if c.Header == "" {
+		c.Header = "X-Request-ID"
+	}
+
+	_, err := parseSubnets(c.TrustedSubnets)
+	return err
+}
+
+type BodySnippetConfig struct {
+	// Size of the snippet in bytes, defaults to 1024.
+	Size int `mapstructure:"size" json:"size,omitempty" bson:"size,omitempty"`
+
+	// ContentTypes prefixes of the captured bodies, defaults to application/json, application/x-www-form-urlencoded and text/.
+	ContentTypes []string `mapstructure:"content_types" json:"content_types,omitempty" bson:"content_types,omitempty"`
+}
+
+func (c *BodySnippetConfig) InitDefaults() {
+	if c.Size <= 0 {
+		c.Size = 1024
+	}
+
+	if len(c.ContentTypes) == 0 {
+		c.ContentTypes = []string{"application/json", "application/x-www-form-urlencoded", "text/"}
+	}
+}
+
+func (c *AccessLogConfig) InitDefaults() error {
+	if c.SampleRate == nil {
+		rate := 1.0
+		c.SampleRate = &rate
+	}
+
+	if *c.SampleRate < 0 || *c.SampleRate > 1 {
+		return errors.Errorf("access_log sample_rate should be in the [0, 1] range, provided: %v", *c.SampleRate)
+	}
+
+	if c.BodySnippet != nil {
+		c.BodySnippet.InitDefaults()
+	}
+
+	if c.RequestID != nil {
+		err := c.RequestID.InitDefaults()
+		if err != nil {
+			return err
+		}
+	}
+
+	// the credentials are hidden even without the redact config
+	if c.Redact == nil {
+		c.Redact = &RedactConfig{}
+	}
+
+	err := c.Redact.InitDefaults()
+	if err != nil {
+		return err
+	}
+
+	names := make(map[string]struct{}, len(c.Sinks))
+	for i := 0; i < len(c.Sinks); i++ {
+		err := c.Sinks[i].InitDefaults()
+		if err != nil {
+			return err
+		}
+
+		if _, ok := names[c.Sinks[i].Name]; ok {
+			return errors.Errorf("access_log sinks names should be unique, %q is repeated", c.Sinks[i].Name)
+		}
+		names[c.Sinks[i].Name] = struct{}{}
+	}
+
+	return nil
+}
@@ -0,0 +1,238 @@
+// MIT License
+//
+// Copyright (c) 2023 Spiral Scout
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/roadrunner-server/errors"
+)
+
+const (
+	redacted = "[REDACTED]"
+
+	IPMask = "mask"
+	IPHash = "hash"
+)
+
+type RedactConfig struct {
+	// Headers values hidden in the log output, defaults to Authorization, Proxy-Authorization and Cookie.
+	Headers []string `mapstructure:"headers" json:"headers,omitempty" bson:"headers,omitempty"`
+
+	// Query parameters values hidden in the log output, also the form and JSON body keys of the captures.
+	Query []string `mapstructure:"query" json:"query,omitempty" bson:"query,omitempty"`
+
+	// IP anonymization, mask zeroes the host part (/24 IPv4, /48 IPv6), hash replaces the address.
+	IP string `mapstructure:"ip" json:"ip,omitempty" bson:"ip,omitempty"`
+
+	// Hash replaces the values with a salted hash instead of [REDACTED], so requests could be correlated.
+	Hash bool `mapstructure:"hash" json:"hash,omitempty" bson:"hash,omitempty"`
+
+	// Salt of the hash.
+	Salt string `mapstructure:"salt" json:"salt,omitempty" bson:"salt,omitempty"`
+}
+
+func (c *RedactConfig) InitDefaults() error {
+	if len(c.Headers) == 0 {
+		c.Headers = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+	}
+
+	switch c.IP {
+	case "", IPMask, IPHash:
+	default:
+		return errors.Errorf("unknown access_log redact ip mode '%s', should be mask or hash", c.IP)
+	}
+
+	return nil
+}
+
+// Redactor hides the configured headers, query parameters and client IP, shared by the access log and the capture
+type Redactor struct {
+	headers map[string]struct{}
+	query   map[string]struct{}
+	ip      string
+	hash    bool
+	salt    []byte
+}
+
+// NewRedactor of the config, the default one hides the credential headers
+func NewRedactor(cfg *RedactConfig) *Redactor {
+	if cfg == nil {
+		cfg = &RedactConfig{}
+		_ = cfg.InitDefaults()
+	}
+
+	rd := &Redactor{
+		headers: make(map[string]struct{}, len(cfg.Headers)),
+		query:   make(map[string]struct{}, len(cfg.Query)),
+		ip:      cfg.IP,
+		hash:    cfg.Hash,
+		salt:    []byte(cfg.Salt),
+	}
+
+	for i := 0; i < len(cfg.Headers); i++ {
+		rd.headers[http.CanonicalHeaderKey(cfg.Headers[i])] = struct{}{}
+	}
+
+	for i := 0; i < len(cfg.Query); i++ {
+		rd.query[cfg.Query[i]] = struct{}{}
+	}
+
+	return rd
+}
+
+func (rd *Redactor) value(v string) string {
+	if !rd.hash {
+		return redacted
+	}
+
+	return rd.sum(v)
+}
+
+func (rd *Redactor) sum(v string) string {
+	mac := hmac.New(sha256.New, rd.salt)
+	_, _ = mac.Write([]byte(v))
+
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// Header returns the value of the header, hidden when the header is redacted
+func (rd *Redactor) Header(name, value string) string {
+	if rd == nil {
+		return value
+	}
+
+	if _, ok := rd.headers[http.CanonicalHeaderKey(name)]; ok {
+		return rd.value(value)
+	}
+
+	return value
+}
+
+// RawQuery returns the query with the values of the redacted parameters hidden
+func (rd *Redactor) RawQuery(raw string) string {
+	if rd == nil || len(rd.query) == 0 {
+		return raw
+	}
+
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		return rd.value(raw)
+	}
+
+	for name, vals := range values {
+		if _, ok := rd.query[name]; !ok {
+			continue
+		}
+
+		for i := 0; i < len(vals); i++ {
+			vals[i] = rd.value(vals[i])
+		}
+	}
+
+	// the placeholder should stay readable
+	return strings.ReplaceAll(values.Encode(), url.QueryEscape(redacted), redacted)
+}
+
+// Body returns the form or JSON body with the values of the redacted query parameters hidden, the body which
+// could not be parsed is hidden entirely
+func (rd *Redactor) Body(contentType string, body []byte) string {
+	if rd == nil || len(rd.query) == 0 || len(body) == 0 {
+		return string(body)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		return rd.RawQuery(string(body))
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var v any
+		if json.Unmarshal(body, &v) != nil {
+			return rd.value(string(body))
+		}
+
+		out, err := json.Marshal(rd.json(v))
+		if err != nil {
+			return rd.value(string(body))
+		}
+
+		return string(out)
+	default:
+		return string(body)
+	}
+}
+
+// json hides the values of the redacted keys at any depth
+func (rd *Redactor) json(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, val := range t {
+			if _, ok := rd.query[k]; ok {
+				t[k] = rd.value(fmt.Sprint(val))
+				continue
+			}
+
+			t[k] = rd.json(val)
+		}
+	case []any:
+		for i := 0; i < len(t); i++ {
+			t[i] = rd.json(t[i])
+		}
+	}
+
+	return v
+}
+
+// Addr returns the client IP anonymized by the ip mode
+func (rd *Redactor) Addr(ip string) string {
+	if rd == nil {
+		return ip
+	}
+
+	switch rd.ip {
+	case IPMask:
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return rd.sum(ip)
+		}
+
+		if v4 := parsed.To4(); v4 != nil {
+			return v4.Mask(net.CIDRMask(24, 32)).String()
+		}
+
+		return parsed.Mask(net.CIDRMask(48, 128)).String()
+	case IPHash:
+		return rd.sum(ip)
+	default:
+		return ip
+	}
+}
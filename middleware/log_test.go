@@ -0,0 +1,108 @@
+// the race detector drops the pooled values at random, the allocations are not stable with it
+
+//go:build !race
+
+package middleware
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rumorshub/http/metrics"
+	"github.com/rumorshub/http/stats"
+)
+
+// discardWriter is the response writer without the recorder allocations
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w *discardWriter) WriteHeader(int) {}
+
+var okBody = []byte("ok")
+
+func accessLogHandler(level slog.Level, cfg *AccessLogConfig) http.Handler {
+	log := slog.New(slog.NewJSONHandler(io.Discard, &slog.HandlerOptions{Level: level}))
+	latency := metrics.NewRegistry().Histogram("latency", "", metrics.DefBuckets, "method", "code")
+
+	return NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(okBody)
+	}), log, cfg, stats.New(), latency)
+}
+
+func accessLogRequest() *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/api/users?id=1", nil)
+	r.RemoteAddr = "10.0.0.1:12345"
+	r.Header.Set("User-Agent", "bench")
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	return r
+}
+
+func BenchmarkAccessLog(b *testing.B) {
+	h := accessLogHandler(slog.LevelInfo, &AccessLogConfig{})
+	r := accessLogRequest()
+	w := &discardWriter{header: make(http.Header)}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		h.ServeHTTP(w, r)
+	}
+}
+
+func BenchmarkAccessLogDisabled(b *testing.B) {
+	h := accessLogHandler(slog.LevelError, &AccessLogConfig{})
+	r := accessLogRequest()
+	w := &discardWriter{header: make(http.Header)}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		h.ServeHTTP(w, r)
+	}
+}
+
+func TestAccessLogAllocs(t *testing.T) {
+	tests := []struct {
+		name  string
+		level slog.Level
+		max   float64
+	}{
+		// the request ID header value, the request context (with the boxed request ID and trace context), the
+		// request copy and the peer IP of the trusted request ID check
+		{name: "disabled", level: slog.LevelError, max: 6},
+		// the attributes above the inline ones of the slog record
+		{name: "logged", level: slog.LevelInfo, max: 7},
+	}
+
+	// the request ID of the trusted proxy is kept, the generated one costs the uuid allocations on top
+	cfg := &AccessLogConfig{RequestID: &RequestIDConfig{Header: "X-Request-ID", TrustedSubnets: []string{"10.0.0.0/8"}}}
+
+	for i := 0; i < len(tests); i++ {
+		h := accessLogHandler(tests[i].level, cfg)
+		r := accessLogRequest()
+		r.Header.Set("X-Request-ID", "4bf92f3577b34da6")
+		w := &discardWriter{header: make(http.Header)}
+
+		// warm up the pools and the histogram series
+		h.ServeHTTP(w, r)
+
+		if allocs := testing.AllocsPerRun(100, func() { h.ServeHTTP(w, r) }); allocs > tests[i].max {
+			t.Fatalf("%s: the access log allocates %v times per request, should be at most %v", tests[i].name, allocs, tests[i].max)
+		}
+	}
+}
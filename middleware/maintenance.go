@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const MaintenanceName = "maintenance"
+
+type MaintenanceConfig struct {
+	// Enabled turns the maintenance mode on at the start, it could be toggled over RPC.
+	Enabled bool `mapstructure:"enabled" json:"enabled,omitempty" bson:"enabled,omitempty"`
+
+	// RetryAfter sent with the 503 responses, defaults to 30s.
+	RetryAfter time.Duration `mapstructure:"retry_after" json:"retry_after,omitempty" bson:"retry_after,omitempty"`
+
+	// ExcludePaths prefixes served during the maintenance (health checks, status pages), matched on the path segments.
+	ExcludePaths []string `mapstructure:"exclude_paths" json:"exclude_paths,omitempty" bson:"exclude_paths,omitempty"`
+}
+
+func (c *MaintenanceConfig) InitDefaults() {
+	if c.RetryAfter <= 0 {
+		c.RetryAfter = 30 * time.Second
+	}
+}
+
+// Maintenance answers 503 to every request except the trusted clients and the excluded paths while enabled.
+type Maintenance struct {
+	enabled    atomic.Bool
+	retryAfter string
+	exclude    []string
+}
+
+func NewMaintenance(cfg *MaintenanceConfig) *Maintenance {
+	m := &Maintenance{
+		retryAfter: strconv.Itoa(int(cfg.RetryAfter.Seconds())),
+		exclude:    cfg.ExcludePaths,
+	}
+	m.enabled.Store(cfg.Enabled)
+
+	return m
+}
+
+func (m *Maintenance) Name() string {
+	return MaintenanceName
+}
+
+// Enable toggles the maintenance mode, returns the previous value
+func (m *Maintenance) Enable(enabled bool) bool {
+	return m.enabled.Swap(enabled)
+}
+
+func (m *Maintenance) Enabled() bool {
+	return m.enabled.Load()
+}
+
+func (m *Maintenance) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.enabled.Load() || IsTrusted(r) || m.excluded(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		Annotate(r, "maintenance: rejected")
+		w.Header().Set("Retry-After", m.retryAfter)
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	})
+}
+
+// excluded matches the cleaned path segments, /status does not exclude /statusx or /status/../api
+func (m *Maintenance) excluded(path string) bool {
+	return matchPath(path, m.exclude)
+}
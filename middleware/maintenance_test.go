@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMaintenanceExclude(t *testing.T) {
+	cfg := &MaintenanceConfig{Enabled: true, ExcludePaths: []string{"/status"}}
+	cfg.InitDefaults()
+
+	h := NewMaintenance(cfg).Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{path: "/status", status: http.StatusOK},
+		{path: "/status/db", status: http.StatusOK},
+		{path: "/statusx", status: http.StatusServiceUnavailable},
+		{path: "/status/../api", status: http.StatusServiceUnavailable},
+		{path: "/api", status: http.StatusServiceUnavailable},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.URL.Path = tests[i].path
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, r)
+
+		if w.Code != tests[i].status {
+			t.Fatalf("%s: status %d, should be %d", tests[i].path, w.Code, tests[i].status)
+		}
+	}
+}
@@ -22,16 +22,173 @@
 
 package middleware
 
-import "net/http"
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"sync/atomic"
 
-func MaxRequestSize(next http.Handler, maxReqSize uint64) http.Handler {
+	"github.com/rumorshub/http/metrics"
+)
+
+const MaxRequestSizeName = "max_request_size"
+
+// RequestSizeLimit overrides the max request size for the path prefix and methods.
+type RequestSizeLimit struct {
+	// Path prefix matched on the segment boundary of the cleaned path, the longest matching one is used.
+	Path string `mapstructure:"path" json:"path,omitempty" bson:"path,omitempty"`
+
+	// Methods the limit applies to, all methods when empty.
+	Methods []string `mapstructure:"methods" json:"methods,omitempty" bson:"methods,omitempty"`
+
+	// Size of the request body in megabytes.
+	Size uint64 `mapstructure:"size" json:"size,omitempty" bson:"size,omitempty"`
+}
+
+// match the cleaned path on the segment boundary, /upload does not match /uploadx or /upload/../api
+func (l *RequestSizeLimit) match(cleaned, method string) bool {
+	if !SegmentPrefix(cleaned, l.Path) {
+		return false
+	}
+
+	return len(l.Methods) == 0 || slices.Contains(l.Methods, method)
+}
+
+// MaxRequestSize limits the request body, maxReqSize and the overrides sizes are in bytes.
+// Responses of the requests which hit the limit are replaced with the structured 413.
+func MaxRequestSize(next http.Handler, maxReqSize uint64, overrides []RequestSizeLimit, log *slog.Logger, tooLarge *metrics.Counter) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if IsUpgrade(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		// validating request size
+		limit := maxReqSize
+		if len(overrides) > 0 {
+			matched := -1
+			cleaned := CleanPath(r.URL.Path)
+			for i := 0; i < len(overrides); i++ {
+				if overrides[i].match(cleaned, r.Method) && len(overrides[i].Path) > matched {
+					matched = len(overrides[i].Path)
+					limit = overrides[i].Size
+				}
+			}
+		}
+
+		body := &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, int64(limit)), limit: int64(limit)}
+		body.onTrip = func(limit int64) {
+			tooLarge.Inc(metricMethod(r.Method))
+			log.Warn("request body is too large", "request-id", GetRequestID(r), "method", r.Method, "path", r.URL.Path, "limit", limit)
+			Audit(r, AuditTooLarge, slog.Int64("limit", limit))
+		}
 
 		r2 := r.Clone(r.Context())
-		r2.Body = http.MaxBytesReader(w, r2.Body, int64(maxReqSize))
+		r2.Body = body
 
 		// use max_request_size limit in megabytes
-		next.ServeHTTP(w, r2)
+		next.ServeHTTP(&tooLargeWriter{ResponseWriter: w, r: r, body: body}, r2)
+	})
+}
+
+// limitedBody detects the exceeded limit, handlers see it as a generic read error
+type limitedBody struct {
+	io.ReadCloser
+	onTrip  func(limit int64)
+	tripped atomic.Bool
+	limit   int64 // set before the body is handed out, never written after, the writer reads it once tripped
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) && b.tripped.CompareAndSwap(false, true) {
+			b.onTrip(b.limit)
+		}
+	}
+
+	return n, err
+}
+
+type tooLargeResponse struct {
+	Error     string `json:"error"`
+	Message   string `json:"message"`
+	Limit     int64  `json:"limit"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// tooLargeWriter replaces the handler response with 413 once the body limit is exceeded
+type tooLargeWriter struct {
+	http.ResponseWriter
+	r        *http.Request
+	body     *limitedBody
+	wrote    bool
+	replaced bool
+}
+
+func (w *tooLargeWriter) WriteHeader(code int) {
+	if w.wrote {
+		return
+	}
+	w.wrote = true
+
+	if !w.body.tripped.Load() {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	w.replaced = true
+	h := w.ResponseWriter.Header()
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+	h.Set("Content-Type", "application/json")
+	h.Set("Connection", "close")
+	w.ResponseWriter.WriteHeader(http.StatusRequestEntityTooLarge)
+
+	_ = json.NewEncoder(w.ResponseWriter).Encode(&tooLargeResponse{
+		Error:     "request_entity_too_large",
+		Message:   "request body exceeds the size limit",
+		Limit:     w.body.limit,
+		RequestID: GetRequestID(w.r),
 	})
 }
+
+func (w *tooLargeWriter) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.replaced {
+		return len(b), nil
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *tooLargeWriter) Flush() {
+	if w.replaced {
+		return
+	}
+
+	if fl, ok := w.ResponseWriter.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+func (w *tooLargeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+
+	return nil, nil, ErrHijackerNotSupported
+}
+
+func (w *tooLargeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
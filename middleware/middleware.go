@@ -7,6 +7,18 @@ type Middleware interface {
 	Middleware(next http.Handler) http.Handler
 }
 
+// Prioritized middleware is applied even when it is not listed in the config. The unlisted ones wrap the listed
+// ones, the higher priority wraps the lower one and the equal priorities are ordered by the name.
+type Prioritized interface {
+	Priority() int
+}
+
+// Outermost middleware wraps the server level middleware as well (the URI limit, the drain state, the connection
+// limits), so it sees the rejections of every other middleware, e.g. the access log.
+type Outermost interface {
+	Outermost() bool
+}
+
 type Middlewares interface {
 	HTTPMiddlewares() []interface{}
 }
@@ -0,0 +1,201 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+)
+
+const MirrorName = "mirror"
+
+type MirrorConfig struct {
+	// Upstream URL receiving the mirrored requests, the request path and query are appended to it.
+	Upstream string `mapstructure:"upstream" json:"upstream,omitempty" bson:"upstream,omitempty"`
+
+	// Percentage of the mirrored requests, 0-100. Default: 100.
+	Percentage float64 `mapstructure:"percentage" json:"percentage,omitempty" bson:"percentage,omitempty"`
+
+	// Paths prefixes to mirror matched on the segment boundary of the cleaned path, all requests when empty.
+	Paths []string `mapstructure:"paths" json:"paths,omitempty" bson:"paths,omitempty"`
+
+	// Timeout of the mirrored request. Default: 10s.
+	Timeout time.Duration `mapstructure:"timeout" json:"timeout,omitempty" bson:"timeout,omitempty"`
+
+	// MaxBodySize of the mirrored requests in bytes, larger requests are not mirrored. Default: 1Mb.
+	MaxBodySize int64 `mapstructure:"max_body_size" json:"max_body_size,omitempty" bson:"max_body_size,omitempty"`
+
+	// MaxConcurrent mirrored requests, the rest are dropped. Default: 100.
+	MaxConcurrent int `mapstructure:"max_concurrent" json:"max_concurrent,omitempty" bson:"max_concurrent,omitempty"`
+}
+
+func (c *MirrorConfig) InitDefaults() error {
+	const op = errors.Op("mirror_config")
+
+	if c.Percentage == 0 {
+		c.Percentage = 100
+	}
+
+	if c.Timeout == 0 {
+		c.Timeout = 10 * time.Second
+	}
+
+	if c.MaxBodySize == 0 {
+		c.MaxBodySize = 1024 * 1024
+	}
+
+	if c.MaxConcurrent == 0 {
+		c.MaxConcurrent = 100
+	}
+
+	if c.Percentage < 0 || c.Percentage > 100 {
+		return errors.E(op, errors.Errorf("percentage should be in [0,100], got %v", c.Percentage))
+	}
+
+	u, err := url.Parse(c.Upstream)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.E(op, errors.Errorf("upstream should be the http(s) URL, got %q", c.Upstream))
+	}
+
+	return nil
+}
+
+// Mirror sends the copies of the requests to the secondary upstream, the mirror responses are discarded.
+type Mirror struct {
+	cfg      *MirrorConfig
+	log      *slog.Logger
+	upstream *url.URL
+	client   *http.Client
+	slots    chan struct{}
+}
+
+func NewMirror(cfg *MirrorConfig, log *slog.Logger) (*Mirror, error) {
+	upstream, err := url.Parse(cfg.Upstream)
+	if err != nil {
+		return nil, errors.E(errors.Op("mirror"), err)
+	}
+
+	return &Mirror{
+		cfg:      cfg,
+		log:      log,
+		upstream: upstream,
+		client: &http.Client{
+			Timeout: cfg.Timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		slots: make(chan struct{}, cfg.MaxConcurrent),
+	}, nil
+}
+
+func (m *Mirror) Name() string {
+	return MirrorName
+}
+
+func (m *Mirror) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.sampled(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var body []byte
+		if r.Body != nil && r.Body != http.NoBody {
+			if r.ContentLength > m.cfg.MaxBodySize {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var err error
+			body, err = io.ReadAll(io.LimitReader(r.Body, m.cfg.MaxBodySize+1))
+			// the primary still gets the whole body
+			r.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(body), r.Body), Closer: r.Body}
+			if err != nil || int64(len(body)) > m.cfg.MaxBodySize {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		select {
+		case m.slots <- struct{}{}:
+			req, err := m.request(r, body)
+			if err != nil {
+				<-m.slots
+				m.log.Debug("mirror request", "error", err)
+				break
+			}
+
+			go m.send(req)
+		default:
+			m.log.Debug("mirror is busy, request was not mirrored", "path", r.URL.Path)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *Mirror) sampled(r *http.Request) bool {
+	if IsUpgrade(r) || IsGRPC(r) {
+		return false
+	}
+
+	if len(m.cfg.Paths) > 0 && !matchPath(r.URL.Path, m.cfg.Paths) {
+		return false
+	}
+
+	return m.cfg.Percentage >= 100 || rand.Float64()*100 < m.cfg.Percentage //nolint:gosec
+}
+
+// request is built before the handler, it may modify the original one
+func (m *Mirror) request(r *http.Request, body []byte) (*http.Request, error) {
+	u := *m.upstream
+	u.Path = strings.TrimSuffix(u.Path, "/") + r.URL.Path
+	u.RawPath = ""
+	u.RawQuery = r.URL.RawQuery
+
+	// the mirror outlives the original request
+	req, err := http.NewRequestWithContext(context.Background(), r.Method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header = r.Header.Clone()
+	req.Header.Del("Connection")
+	req.Header.Set("X-Mirrored-From", r.Host)
+	req.Host = r.Host
+	req.ContentLength = int64(len(body))
+
+	return req, nil
+}
+
+func (m *Mirror) send(req *http.Request) {
+	defer func() {
+		<-m.slots
+	}()
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		m.log.Debug("mirror request", "error", err, "upstream", m.upstream.Host)
+		return
+	}
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
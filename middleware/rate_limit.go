@@ -0,0 +1,364 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+
+	"github.com/rumorshub/http/redis"
+)
+
+const RateLimitName = "rate_limit"
+
+// Keys of the rate limit
+const (
+	RateLimitByIP     = "ip"
+	RateLimitByHost   = "host"
+	RateLimitByHeader = "header"
+)
+
+type RateLimitQuota struct {
+	// Requests within the Period, 0 disables the limit.
+	Requests int `mapstructure:"requests" json:"requests,omitempty" bson:"requests,omitempty"`
+
+	// Period of the window, defaults to 1m.
+	Period time.Duration `mapstructure:"period" json:"period,omitempty" bson:"period,omitempty"`
+}
+
+type RateLimitConfig struct {
+	// Key the requests are counted by: ip, host or header (the tenant header). Defaults to ip.
+	Key string `mapstructure:"key" json:"key,omitempty" bson:"key,omitempty"`
+
+	// Header with the tenant id for the header key, e.g. X-Tenant-ID.
+	Header string `mapstructure:"header" json:"header,omitempty" bson:"header,omitempty"`
+
+	// Default quota of the keys without the own one. The requests without the tenant header are counted by the
+	// client IP with the default quota.
+	Default RateLimitQuota `mapstructure:"default" json:"default,omitempty" bson:"default,omitempty"`
+
+	// Tenants quotas by the host (without the port) or the header value, checked before the quota providers.
+	Tenants map[string]RateLimitQuota `mapstructure:"tenants" json:"tenants,omitempty" bson:"tenants,omitempty"`
+
+	// Redis shares the counters of the instances behind the load balancer, the counters are kept in memory
+	// of every instance when not set.
+	Redis *redis.Config `mapstructure:"redis" json:"redis,omitempty" bson:"redis,omitempty"`
+
+	// Headers adds the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers to the responses.
+	Headers bool `mapstructure:"headers" json:"headers,omitempty" bson:"headers,omitempty"`
+
+	// MaxKeys counted at once by the memory store, the random windows are evicted above it. Defaults to 100000.
+	MaxKeys int `mapstructure:"max_keys" json:"max_keys,omitempty" bson:"max_keys,omitempty"`
+}
+
+func (c *RateLimitConfig) InitDefaults() error {
+	const op = errors.Op("rate_limit_config")
+
+	if c.Key == "" {
+		c.Key = RateLimitByIP
+	}
+
+	switch c.Key {
+	case RateLimitByIP, RateLimitByHost:
+	case RateLimitByHeader:
+		if c.Header == "" {
+			return errors.E(op, errors.Str("header should be set for the header key"))
+		}
+	default:
+		return errors.E(op, errors.Errorf("key should be ip, host or header, got %q", c.Key))
+	}
+
+	if c.MaxKeys == 0 {
+		c.MaxKeys = 100000
+	}
+
+	if c.MaxKeys < 0 {
+		return errors.E(op, errors.Str("max_keys should not be negative"))
+	}
+
+	if c.Redis != nil {
+		err := c.Redis.InitDefaults()
+		if err != nil {
+			return errors.E(op, err)
+		}
+	}
+
+	err := c.Default.initDefaults()
+	if err != nil {
+		return errors.E(op, errors.Errorf("default: %v", err))
+	}
+
+	// the hosts are matched lowercase
+	tenants := make(map[string]RateLimitQuota, len(c.Tenants))
+	for tenant, quota := range c.Tenants {
+		err = quota.initDefaults()
+		if err != nil {
+			return errors.E(op, errors.Errorf("tenant %s: %v", tenant, err))
+		}
+
+		if c.Key == RateLimitByHost {
+			tenant = strings.ToLower(tenant)
+		}
+
+		tenants[tenant] = quota
+	}
+	c.Tenants = tenants
+
+	return nil
+}
+
+func (q *RateLimitQuota) initDefaults() error {
+	if q.Period == 0 {
+		q.Period = time.Minute
+	}
+
+	if q.Requests < 0 || q.Period < 0 {
+		return errors.Str("requests and period should not be negative")
+	}
+
+	return nil
+}
+
+// QuotaProvider supplies the quotas of the tenants unknown to the config, e.g. from the billing database.
+// Provider is called on every request of such tenants, so it should cache the quotas itself.
+type QuotaProvider interface {
+	// Quota returns the quota of the tenant, ok is false when the provider does not know the tenant
+	Quota(ctx context.Context, tenant string) (quota RateLimitQuota, ok bool)
+}
+
+// RateLimitStore counts the requests of the keys in the windows
+type RateLimitStore interface {
+	// Take counts the request of the key and returns the number of the requests within the current window
+	// and the time left until the window resets
+	Take(ctx context.Context, key string, period time.Duration) (count int, reset time.Duration, err error)
+}
+
+// RateLimit limits the requests per client IP, host or tenant header in the fixed windows of the memory store or
+// the sliding windows of the Redis one. Trusted clients are not limited.
+type RateLimit struct {
+	cfg       *RateLimitConfig
+	store     RateLimitStore
+	log       *slog.Logger
+	providers atomic.Pointer[[]QuotaProvider]
+}
+
+func NewRateLimit(cfg *RateLimitConfig, store RateLimitStore, log *slog.Logger) *RateLimit {
+	return &RateLimit{
+		cfg:   cfg,
+		store: store,
+		log:   log,
+	}
+}
+
+func (l *RateLimit) Name() string {
+	return RateLimitName
+}
+
+// Stop stops the store, e.g. the sweeping of the memory one
+func (l *RateLimit) Stop() {
+	if st, ok := l.store.(interface{ Stop() }); ok {
+		st.Stop()
+	}
+}
+
+// AddQuotaProvider adds the provider of the tenant quotas, the providers are asked in the order of addition
+func (l *RateLimit) AddQuotaProvider(provider QuotaProvider) {
+	var providers []QuotaProvider
+	if prev := l.providers.Load(); prev != nil {
+		providers = append(providers, *prev...)
+	}
+
+	providers = append(providers, provider)
+	l.providers.Store(&providers)
+}
+
+func (l *RateLimit) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if IsTrusted(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, quota := l.quota(r)
+		if quota.Requests == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		count, reset, err := l.store.Take(r.Context(), key, quota.Period)
+		if err != nil {
+			// the store outage should not take the service down
+			l.log.Warn("rate limit store", "key", key, "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		retry := strconv.Itoa(int(reset.Seconds()) + 1)
+		if l.cfg.Headers {
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(quota.Requests))
+			h.Set("RateLimit-Remaining", strconv.Itoa(max(quota.Requests-count, 0)))
+			h.Set("RateLimit-Reset", retry)
+		}
+
+		if count > quota.Requests {
+			Annotate(r, "rate_limit: rejected, "+key)
+			Audit(r, AuditRateLimited, slog.String("limiter", RateLimitName), slog.String("key", key))
+			w.Header().Set("Retry-After", retry)
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// quota returns the store key and the quota of the request
+func (l *RateLimit) quota(r *http.Request) (string, RateLimitQuota) {
+	var tenant string
+	switch l.cfg.Key {
+	case RateLimitByHost:
+		tenant = r.Host
+		if host, _, err := net.SplitHostPort(tenant); err == nil {
+			tenant = host
+		}
+		tenant = strings.ToLower(tenant)
+	case RateLimitByHeader:
+		tenant = r.Header.Get(l.cfg.Header)
+	}
+
+	if tenant == "" {
+		ip := remoteIP(r)
+		if ip == nil {
+			return "ip:" + r.RemoteAddr, l.cfg.Default
+		}
+
+		// the IPv6 clients are counted by the /64 network, the single host usually owns all of it
+		return "ip:" + banKey(ip), l.cfg.Default
+	}
+
+	key := l.cfg.Key + ":" + tenant
+	if quota, ok := l.cfg.Tenants[tenant]; ok {
+		return key, quota
+	}
+
+	if providers := l.providers.Load(); providers != nil {
+		for i := 0; i < len(*providers); i++ {
+			if quota, ok := (*providers)[i].Quota(r.Context(), tenant); ok {
+				if quota.Period <= 0 {
+					quota.Period = time.Minute
+				}
+
+				return key, quota
+			}
+		}
+	}
+
+	return key, l.cfg.Default
+}
+
+// MemoryRateLimitStore counts the requests of the single instance. The expired windows are swept off the request
+// path, the number of the keys is capped.
+type MemoryRateLimitStore struct {
+	maxKeys int
+
+	mu      sync.Mutex
+	windows map[string]*rateWindow
+
+	stopCh chan struct{}
+	stop   sync.Once
+}
+
+type rateWindow struct {
+	start  time.Time
+	period time.Duration
+	count  int
+}
+
+// NewMemoryRateLimitStore creates the store of up to maxKeys windows, Stop should be called to stop the sweeping
+func NewMemoryRateLimitStore(maxKeys int) *MemoryRateLimitStore {
+	s := &MemoryRateLimitStore{
+		maxKeys: maxKeys,
+		windows: make(map[string]*rateWindow),
+		stopCh:  make(chan struct{}),
+	}
+
+	go s.sweepLoop()
+
+	return s
+}
+
+// Stop stops the sweeping of the expired windows
+func (s *MemoryRateLimitStore) Stop() {
+	s.stop.Do(func() {
+		close(s.stopCh)
+	})
+}
+
+func (s *MemoryRateLimitStore) Take(_ context.Context, key string, period time.Duration) (int, time.Duration, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	window, ok := s.windows[key]
+	if !ok || now.Sub(window.start) >= window.period {
+		if !ok && len(s.windows) >= s.maxKeys {
+			s.evict(now)
+		}
+
+		window = &rateWindow{start: now, period: period}
+		s.windows[key] = window
+	}
+
+	window.count++
+
+	return window.count, window.start.Add(window.period).Sub(now), nil
+}
+
+// evict forgets one of the windows to make room for the new one, the expired one when the few probes find it.
+// The map iteration order is random, the window of the random key is evicted otherwise. Should be called with
+// the lock held.
+func (s *MemoryRateLimitStore) evict(now time.Time) {
+	probes := 0
+	for key, w := range s.windows {
+		probes++
+		if now.Sub(w.start) >= w.period || probes >= 16 {
+			delete(s.windows, key)
+			return
+		}
+	}
+}
+
+// sweepLoop forgets the expired windows, off the request path
+func (s *MemoryRateLimitStore) sweepLoop() {
+	ticker := time.NewTicker(time.Second * 10)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case now := <-ticker.C:
+			s.sweep(now)
+		}
+	}
+}
+
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, w := range s.windows {
+		if now.Sub(w.start) >= w.period {
+			delete(s.windows, key)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+
+	"github.com/rumorshub/http/redis"
+)
+
+// slidingWindow counts the request in the current fixed window and weights the previous window by its overlap
+// with the sliding one. The time of the server is used, so the instances with the skewed clocks agree. The keys
+// share the hash tag of KEYS[1] for the cluster.
+var slidingWindow = redis.NewScript(`
+local period = tonumber(ARGV[1])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local window = math.floor(now / period)
+local current = KEYS[1] .. ':' .. window
+local count = redis.call('INCR', current)
+if count == 1 then
+	redis.call('PEXPIRE', current, period * 2)
+end
+local elapsed = now - window * period
+local previous = tonumber(redis.call('GET', KEYS[1] .. ':' .. (window - 1)) or '0')
+return {count + math.floor(previous * (period - elapsed) / period), period - elapsed}
+`)
+
+// RedisRateLimitStore counts the requests in the sliding windows shared by all the instances using the server.
+// Requires Redis 5 or newer (the script effects replication).
+type RedisRateLimitStore struct {
+	client *redis.Client
+}
+
+func NewRedisRateLimitStore(client *redis.Client) *RedisRateLimitStore {
+	return &RedisRateLimitStore{client: client}
+}
+
+func (s *RedisRateLimitStore) Take(ctx context.Context, key string, period time.Duration) (int, time.Duration, error) {
+	reply, err := s.client.Run(ctx, slidingWindow, []string{s.client.Key("rl", "{"+key+"}")}, strconv.FormatInt(period.Milliseconds(), 10))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	values, ok := reply.([]any)
+	if !ok || len(values) != 2 {
+		return 0, 0, errors.Errorf("unexpected rate limit script reply: %v", reply)
+	}
+
+	count, ok1 := values[0].(int64)
+	reset, ok2 := values[1].(int64)
+	if !ok1 || !ok2 {
+		return 0, 0, errors.Errorf("unexpected rate limit script reply: %v", reply)
+	}
+
+	return int(count), time.Duration(reset) * time.Millisecond, nil
+}
@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimitStoreMaxKeys(t *testing.T) {
+	s := NewMemoryRateLimitStore(100)
+	defer s.Stop()
+
+	// the rotated source addresses do not grow the store above the cap
+	for i := 0; i < 10000; i++ {
+		count, _, err := s.Take(context.Background(), "ip:"+strconv.Itoa(i), time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if count != 1 {
+			t.Fatalf("key %d: count %d, should be 1", i, count)
+		}
+	}
+
+	s.mu.Lock()
+	n := len(s.windows)
+	s.mu.Unlock()
+
+	if n > 100 {
+		t.Fatalf("the store keeps %d windows, should be at most 100", n)
+	}
+}
+
+func TestMemoryRateLimitStoreSweep(t *testing.T) {
+	s := NewMemoryRateLimitStore(100)
+	defer s.Stop()
+
+	for i := 0; i < 10; i++ {
+		_, _, _ = s.Take(context.Background(), "short:"+strconv.Itoa(i), time.Millisecond)
+	}
+	_, _, _ = s.Take(context.Background(), "long", time.Hour)
+
+	s.sweep(time.Now().Add(time.Second))
+
+	s.mu.Lock()
+	_, kept := s.windows["long"]
+	n := len(s.windows)
+	s.mu.Unlock()
+
+	if n != 1 || !kept {
+		t.Fatalf("the expired windows should be swept, %d left", n)
+	}
+
+	// the counting starts over once the window has expired
+	count, _, _ := s.Take(context.Background(), "short:0", time.Millisecond)
+	if count != 1 {
+		t.Fatalf("count %d after the sweep, should be 1", count)
+	}
+}
+
+func TestRateLimitIPv6Network(t *testing.T) {
+	cfg := &RateLimitConfig{Default: RateLimitQuota{Requests: 2}}
+	if err := cfg.InitDefaults(); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewMemoryRateLimitStore(cfg.MaxKeys)
+	defer store.Stop()
+
+	h := NewRateLimit(cfg, store, slog.New(slog.NewTextHandler(io.Discard, nil))).Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	tests := []struct {
+		addr   string
+		status int
+	}{
+		{addr: "[2001:db8::1]:1000", status: http.StatusOK},
+		// the same /64 network shares the window
+		{addr: "[2001:db8::2]:1000", status: http.StatusOK},
+		{addr: "[2001:db8::ffff:1]:1000", status: http.StatusTooManyRequests},
+		{addr: "[2001:db8:0:1::1]:1000", status: http.StatusOK},
+		{addr: "192.0.2.1:1000", status: http.StatusOK},
+		{addr: "192.0.2.2:1000", status: http.StatusOK},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tests[i].addr
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, r)
+
+		if w.Code != tests[i].status {
+			t.Fatalf("%s: status %d, should be %d", tests[i].addr, w.Code, tests[i].status)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const RealIPName = "real_ip"
+
+const peerAddrCtx contextKey = "peer_addr"
+
+// Headers of the client address
+const (
+	HeaderForwarded     = "Forwarded"
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderXRealIP       = "X-Real-Ip"
+)
+
+// RealIP resolves the client address from the configured header (Forwarded, X-Forwarded-For or X-Real-IP) when
+// the peer is a trusted proxy and rewrites the RemoteAddr, so the downstream middleware and access logs see the
+// client. The other headers are ignored, the client could send them through the proxy which does not touch them.
+type RealIP struct {
+	subnets []*net.IPNet
+	header  string
+}
+
+func NewRealIP(trustedSubnets []string, header string) (*RealIP, error) {
+	subnets, err := parseSubnets(trustedSubnets)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RealIP{subnets: subnets, header: http.CanonicalHeaderKey(header)}, nil
+}
+
+func (ri *RealIP) Name() string {
+	return RealIPName
+}
+
+func (ri *RealIP) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !containsIP(ri.subnets, remoteIP(r)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ri.clientIP(r)
+		if ip == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		_, port, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
+		if err != nil {
+			port = "0"
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), peerAddrCtx, r.RemoteAddr))
+		r.RemoteAddr = net.JoinHostPort(ip.String(), port)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP is the rightmost untrusted address of the proxies chain
+func (ri *RealIP) clientIP(r *http.Request) net.IP {
+	var chain []string
+	switch ri.header {
+	case HeaderForwarded:
+		chain = forwardedFor(r.Header.Values(HeaderForwarded))
+	case HeaderXForwardedFor:
+		for _, value := range r.Header.Values(HeaderXForwardedFor) {
+			chain = append(chain, strings.Split(value, ",")...)
+		}
+	case HeaderXRealIP:
+		if value := r.Header.Get(HeaderXRealIP); value != "" {
+			chain = []string{value}
+		}
+	}
+
+	var client net.IP
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := parseForwardedIP(chain[i])
+		if ip == nil {
+			// obfuscated or malformed entry, nothing to the left of it could be trusted
+			return client
+		}
+
+		client = ip
+		if !containsIP(ri.subnets, ip) {
+			return client
+		}
+	}
+
+	return client
+}
+
+// forwardedFor extracts the for= parameters of the RFC 7239 Forwarded header
+func forwardedFor(values []string) []string {
+	var chain []string
+	for _, value := range values {
+		elements := strings.Split(value, ",")
+		for i := 0; i < len(elements); i++ {
+			pairs := strings.Split(elements[i], ";")
+			for j := 0; j < len(pairs); j++ {
+				key, val, ok := strings.Cut(strings.TrimSpace(pairs[j]), "=")
+				if ok && strings.EqualFold(key, "for") {
+					chain = append(chain, val)
+				}
+			}
+		}
+	}
+
+	return chain
+}
+
+// parseForwardedIP accepts 192.0.2.1, 192.0.2.1:80, "[2001:db8::1]:80" and 2001:db8::1
+func parseForwardedIP(value string) net.IP {
+	value = strings.Trim(strings.TrimSpace(value), `"`)
+
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+
+	return net.ParseIP(strings.Trim(value, "[]"))
+}
+
+// peerIP is the address of the direct peer, before the RealIP rewrite
+func peerIP(r *http.Request) net.IP {
+	if addr, ok := r.Context().Value(peerAddrCtx).(string); ok {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+
+		return net.ParseIP(host)
+	}
+
+	return remoteIP(r)
+}
@@ -23,36 +23,81 @@
 package middleware
 
 import (
-	"fmt"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 )
 
 const scheme string = "https"
 
-func Redirect(_ http.Handler, port int) http.Handler {
+type RedirectOptions struct {
+	// Port of the https server
+	Port int
+	// HSTS is the Strict-Transport-Security value, empty disables it
+	HSTS string
+	// Status of the redirect, 301, 302, 307 or 308
+	Status int
+	// RejectUnsafe responds 403 to the non-idempotent methods instead of redirecting them
+	RejectUnsafe bool
+	// Exclude path prefixes served over http by the next handler, matched on the segment boundary of the cleaned path
+	Exclude []string
+}
+
+// Redirect sends the clients to the https port
+func Redirect(next http.Handler, opts RedirectOptions) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Add("Strict-Transport-Security", "max-age=31536000; includeSubDomains; preload")
+		if matchPath(r.URL.Path, opts.Exclude) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if opts.RejectUnsafe && !idempotent(r.Method) {
+			http.Error(w, "https is required", http.StatusForbidden)
+			return
+		}
+
+		if opts.HSTS != "" {
+			w.Header().Add("Strict-Transport-Security", opts.HSTS)
+		}
 		target := &url.URL{
 			Scheme: scheme,
 			// host or host:port
-			Host:     TLSAddr(r.Host, false, port),
+			Host:     TLSAddr(r.Host, false, opts.Port),
 			Path:     r.URL.Path,
 			RawQuery: r.URL.RawQuery,
 		}
 
-		http.Redirect(w, r, target.String(), http.StatusPermanentRedirect)
+		http.Redirect(w, r, target.String(), opts.Status)
 	})
 }
 
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
 // TLSAddr replaces listen or host port with port configured by SSLConfig config.
+// IPv6 hosts are bracketed: [::1]:8443.
 func TLSAddr(host string, forcePort bool, sslPort int) string {
-	// remove current forcePort first
-	host = strings.Split(host, ":")[0]
+	// remove current port first
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	} else {
+		host = strings.Trim(host, "[]")
+	}
 
 	if forcePort || sslPort != 443 {
-		host = fmt.Sprintf("%s:%v", host, sslPort)
+		return net.JoinHostPort(host, strconv.Itoa(sslPort))
+	}
+
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
 	}
 
 	return host
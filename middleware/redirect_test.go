@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectExclude(t *testing.T) {
+	h := Redirect(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), RedirectOptions{
+		Port:    443,
+		Status:  http.StatusPermanentRedirect,
+		Exclude: []string{"/healthz", "/.well-known/"},
+	})
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{path: "/healthz", status: http.StatusOK},
+		{path: "/healthz/live", status: http.StatusOK},
+		{path: "/.well-known/acme-challenge/token", status: http.StatusOK},
+		{path: "/healthzx", status: http.StatusPermanentRedirect},
+		{path: "/healthz/../admin", status: http.StatusPermanentRedirect},
+		{path: "/admin", status: http.StatusPermanentRedirect},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		r.URL.Path = tests[i].path
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, r)
+
+		if w.Code != tests[i].status {
+			t.Fatalf("%s: status %d, should be %d", tests[i].path, w.Code, tests[i].status)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"net/http"
+)
+
+const SecurityHeadersName = "security_headers"
+
+type SecurityHeadersConfig struct {
+	// ContentSecurityPolicy header value, not set when empty.
+	ContentSecurityPolicy string `mapstructure:"content_security_policy" json:"content_security_policy,omitempty" bson:"content_security_policy,omitempty"`
+
+	// ContentTypeOptions defaults to nosniff.
+	ContentTypeOptions string `mapstructure:"content_type_options" json:"content_type_options,omitempty" bson:"content_type_options,omitempty"`
+
+	// FrameOptions defaults to DENY.
+	FrameOptions string `mapstructure:"frame_options" json:"frame_options,omitempty" bson:"frame_options,omitempty"`
+
+	// ReferrerPolicy defaults to strict-origin-when-cross-origin.
+	ReferrerPolicy string `mapstructure:"referrer_policy" json:"referrer_policy,omitempty" bson:"referrer_policy,omitempty"`
+
+	// PermissionsPolicy header value, not set when empty.
+	PermissionsPolicy string `mapstructure:"permissions_policy" json:"permissions_policy,omitempty" bson:"permissions_policy,omitempty"`
+
+	// Overrides of the headers for the path prefixes, the first matching one is used.
+	Overrides []SecurityHeadersOverride `mapstructure:"overrides" json:"overrides,omitempty" bson:"overrides,omitempty"`
+}
+
+type SecurityHeadersOverride struct {
+	// Paths prefixes of the override.
+	Paths []string `mapstructure:"paths" json:"paths,omitempty" bson:"paths,omitempty"`
+
+	// Headers replacing the defaults, the empty value removes the header.
+	Headers map[string]string `mapstructure:"headers" json:"headers,omitempty" bson:"headers,omitempty"`
+}
+
+func (c *SecurityHeadersConfig) InitDefaults() {
+	if c.ContentTypeOptions == "" {
+		c.ContentTypeOptions = "nosniff"
+	}
+
+	if c.FrameOptions == "" {
+		c.FrameOptions = "DENY"
+	}
+
+	if c.ReferrerPolicy == "" {
+		c.ReferrerPolicy = "strict-origin-when-cross-origin"
+	}
+}
+
+type securityOverride struct {
+	paths   []string
+	headers map[string]string
+}
+
+// SecurityHeaders sets the hardening headers, handlers could replace them.
+type SecurityHeaders struct {
+	headers   map[string]string
+	overrides []securityOverride
+}
+
+func NewSecurityHeaders(cfg *SecurityHeadersConfig) *SecurityHeaders {
+	sh := &SecurityHeaders{
+		headers: make(map[string]string, 5),
+	}
+
+	base := map[string]string{
+		"Content-Security-Policy": cfg.ContentSecurityPolicy,
+		"X-Content-Type-Options":  cfg.ContentTypeOptions,
+		"X-Frame-Options":         cfg.FrameOptions,
+		"Referrer-Policy":         cfg.ReferrerPolicy,
+		"Permissions-Policy":      cfg.PermissionsPolicy,
+	}
+
+	for name, value := range base {
+		if value != "" {
+			sh.headers[name] = value
+		}
+	}
+
+	for i := 0; i < len(cfg.Overrides); i++ {
+		headers := make(map[string]string, len(sh.headers))
+		for name, value := range sh.headers {
+			headers[name] = value
+		}
+
+		for name, value := range cfg.Overrides[i].Headers {
+			name = http.CanonicalHeaderKey(name)
+			if value == "" {
+				delete(headers, name)
+				continue
+			}
+
+			headers[name] = value
+		}
+
+		sh.overrides = append(sh.overrides, securityOverride{
+			paths:   cfg.Overrides[i].Paths,
+			headers: headers,
+		})
+	}
+
+	return sh
+}
+
+func (sh *SecurityHeaders) Name() string {
+	return SecurityHeadersName
+}
+
+func (sh *SecurityHeaders) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers := sh.headers
+		for i := 0; i < len(sh.overrides); i++ {
+			if hasPrefix(r.URL.Path, sh.overrides[i].paths) {
+				headers = sh.overrides[i].headers
+				break
+			}
+		}
+
+		h := w.Header()
+		for name, value := range headers {
+			h.Set(name, value)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSignedURL(t *testing.T, cfg *SignedURLConfig) *SignedURL {
+	t.Helper()
+
+	err := cfg.InitDefaults()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewSignedURL(cfg)
+}
+
+func signURL(t *testing.T, s *SignedURL, path string, expires time.Time, ip string) string {
+	t.Helper()
+
+	signed, err := s.Sign(path, expires, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed
+}
+
+func TestSignedURLReject(t *testing.T) {
+	s := newTestSignedURL(t, &SignedURLConfig{Secrets: []string{"new", "old"}, Paths: []string{"/downloads"}})
+	// the links issued before the rotation
+	old := newTestSignedURL(t, &SignedURLConfig{Secrets: []string{"old"}, Paths: []string{"/downloads"}})
+	other := newTestSignedURL(t, &SignedURLConfig{Secrets: []string{"other"}, Paths: []string{"/downloads"}})
+
+	h := s.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	valid := signURL(t, s, "/downloads/file.zip", time.Now().Add(time.Minute), "")
+	expired := signURL(t, s, "/downloads/file.zip", time.Now().Add(-time.Second), "")
+
+	// the expiry of the valid link is extended, the signature stays the same
+	extended, _ := url.Parse(valid)
+	query := extended.Query()
+	query.Set("expires", "9999999999")
+	extended.RawQuery = query.Encode()
+
+	tests := []struct {
+		name   string
+		url    string
+		status int
+	}{
+		{name: "valid", url: valid, status: http.StatusOK},
+		{name: "rotated secret", url: signURL(t, old, "/downloads/file.zip", time.Now().Add(time.Minute), ""), status: http.StatusOK},
+		{name: "expired", url: expired, status: http.StatusForbidden},
+		{name: "extended expiry", url: extended.String(), status: http.StatusForbidden},
+		{name: "other path", url: strings.Replace(valid, "file.zip", "other.zip", 1), status: http.StatusForbidden},
+		{name: "unknown secret", url: signURL(t, other, "/downloads/file.zip", time.Now().Add(time.Minute), ""), status: http.StatusForbidden},
+		{name: "missing signature", url: "/downloads/file.zip?expires=9999999999", status: http.StatusForbidden},
+		{name: "missing expiry", url: "/downloads/file.zip", status: http.StatusForbidden},
+		{name: "malformed expiry", url: "/downloads/file.zip?expires=soon&signature=AAAA", status: http.StatusForbidden},
+		{name: "malformed signature", url: "/downloads/file.zip?expires=9999999999&signature=%21%21", status: http.StatusForbidden},
+		{name: "not protected", url: "/public/file.zip", status: http.StatusOK},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		r := httptest.NewRequest(http.MethodGet, tests[i].url, nil)
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, r)
+
+		if w.Code != tests[i].status {
+			t.Fatalf("%s: status %d, should be %d", tests[i].name, w.Code, tests[i].status)
+		}
+	}
+}
+
+func TestSignedURLBindIP(t *testing.T) {
+	s := newTestSignedURL(t, &SignedURLConfig{Secrets: []string{"secret"}, Paths: []string{"/downloads"}, BindIP: true})
+	h := s.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	if _, err := s.Sign("/downloads/file.zip", time.Now().Add(time.Minute), "not an ip"); err == nil {
+		t.Fatal("the link bound to the invalid ip should not be signed")
+	}
+
+	link := signURL(t, s, "/downloads/file.zip", time.Now().Add(time.Minute), "2001:db8::1")
+
+	tests := []struct {
+		addr   string
+		status int
+	}{
+		{addr: "[2001:db8::1]:1000", status: http.StatusOK},
+		{addr: "[2001:db8:0::1]:2000", status: http.StatusOK},
+		{addr: "[2001:db8::2]:1000", status: http.StatusForbidden},
+		{addr: "192.0.2.1:1000", status: http.StatusForbidden},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		r := httptest.NewRequest(http.MethodGet, link, nil)
+		r.RemoteAddr = tests[i].addr
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, r)
+
+		if w.Code != tests[i].status {
+			t.Fatalf("%s: status %d, should be %d", tests[i].addr, w.Code, tests[i].status)
+		}
+	}
+}
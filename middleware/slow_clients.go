@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	rrErrors "github.com/roadrunner-server/errors"
+
+	"github.com/rumorshub/http/metrics"
+)
+
+const SlowClientsName = "slow_clients"
+
+// errSlowClient is returned by the request body read when the client sends it too slowly
+var errSlowClient = errors.New("request body is sent below the minimal rate")
+
+type SlowClientsConfig struct {
+	// HeaderTimeout of the request headers read, the ReadHeaderTimeout of the servers. Default: 1m.
+	HeaderTimeout time.Duration `mapstructure:"header_timeout" json:"header_timeout,omitempty" bson:"header_timeout,omitempty"`
+
+	// BodyReadTimeout of every request body read, the deadline is reset after each read. Default: 10s.
+	BodyReadTimeout time.Duration `mapstructure:"body_read_timeout" json:"body_read_timeout,omitempty" bson:"body_read_timeout,omitempty"`
+
+	// MinBodyRate of the request body upload in bytes per second, checked after the grace period. Default: off.
+	MinBodyRate int64 `mapstructure:"min_body_rate" json:"min_body_rate,omitempty" bson:"min_body_rate,omitempty"`
+
+	// Grace period of the upload before the min rate is enforced. Default: 5s.
+	Grace time.Duration `mapstructure:"grace" json:"grace,omitempty" bson:"grace,omitempty"`
+}
+
+func (c *SlowClientsConfig) InitDefaults() error {
+	const op = rrErrors.Op("slow_clients_config")
+
+	if c.HeaderTimeout == 0 {
+		c.HeaderTimeout = time.Minute
+	}
+
+	if c.BodyReadTimeout == 0 {
+		c.BodyReadTimeout = 10 * time.Second
+	}
+
+	if c.Grace == 0 {
+		c.Grace = 5 * time.Second
+	}
+
+	if c.HeaderTimeout < 0 || c.BodyReadTimeout < 0 || c.Grace < 0 || c.MinBodyRate < 0 {
+		return rrErrors.E(op, rrErrors.Str("header_timeout, body_read_timeout, grace and min_body_rate should not be negative"))
+	}
+
+	return nil
+}
+
+// SlowClients drops the clients sending the request body too slowly (slow POST), the headers are limited
+// by the ReadHeaderTimeout of the servers.
+type SlowClients struct {
+	cfg     *SlowClientsConfig
+	log     *slog.Logger
+	dropped *metrics.Counter
+}
+
+func NewSlowClients(cfg *SlowClientsConfig, registry *metrics.Registry, log *slog.Logger) *SlowClients {
+	return &SlowClients{
+		cfg:     cfg,
+		log:     log,
+		dropped: registry.Counter("http_slow_clients_dropped_total", "Clients dropped for the slow request body upload.", "reason"),
+	}
+}
+
+func (s *SlowClients) Name() string {
+	return SlowClientsName
+}
+
+func (s *SlowClients) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		r.Body = &slowBody{
+			ReadCloser: r.Body,
+			rc:         http.NewResponseController(w),
+			cfg:        s.cfg,
+			drop: func(reason string) {
+				s.dropped.Inc(reason)
+				Audit(r, AuditSlowClient, slog.String("reason", reason))
+				s.log.Warn("slow client dropped", "reason", reason, "ip", r.RemoteAddr, "path", r.URL.Path)
+			},
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// slowBody resets the read deadline of the connection before every read and checks the upload rate
+type slowBody struct {
+	io.ReadCloser
+	rc      *http.ResponseController
+	cfg     *SlowClientsConfig
+	started time.Time // of the first read, the handler may queue or do other work before reading the body
+	read    int64
+	dropped bool
+	drop    func(reason string)
+}
+
+func (b *slowBody) Read(p []byte) (int, error) {
+	if b.dropped {
+		return 0, errSlowClient
+	}
+
+	if b.started.IsZero() {
+		b.started = time.Now()
+	}
+
+	// not supported by every writer, e.g. the wrappers without Unwrap
+	_ = b.rc.SetReadDeadline(time.Now().Add(b.cfg.BodyReadTimeout))
+
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+
+	if err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			b.dropped = true
+			b.drop("timeout")
+		} else if errors.Is(err, io.EOF) {
+			_ = b.rc.SetReadDeadline(time.Time{})
+		}
+
+		return n, err
+	}
+
+	if b.cfg.MinBodyRate > 0 {
+		elapsed := time.Since(b.started)
+		if elapsed > b.cfg.Grace && float64(b.read)/elapsed.Seconds() < float64(b.cfg.MinBodyRate) {
+			b.dropped = true
+			b.drop("rate")
+			// the connection is closed, the rest of the body could not be read
+			_ = b.rc.SetReadDeadline(time.Now())
+
+			return n, errSlowClient
+		}
+	}
+
+	return n, nil
+}
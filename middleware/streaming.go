@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/roadrunner-server/errors"
+)
+
+const streamingCtx contextKey = "streaming"
+
+// StreamingConfig marks the endpoints as streaming (SSE, long-poll, NDJSON), their responses are flushed
+// after every write and not cached, the access log records the stream duration.
+type StreamingConfig struct {
+	// Paths prefixes of the streaming endpoints.
+	Paths []string `mapstructure:"paths" json:"paths,omitempty" bson:"paths,omitempty"`
+
+	// ContentTypes of the streaming responses. Default: text/event-stream.
+	ContentTypes []string `mapstructure:"content_types" json:"content_types,omitempty" bson:"content_types,omitempty"`
+}
+
+func (c *StreamingConfig) InitDefaults() error {
+	const op = errors.Op("streaming_config")
+
+	if len(c.ContentTypes) == 0 {
+		c.ContentTypes = []string{"text/event-stream"}
+	}
+
+	for i := 0; i < len(c.Paths); i++ {
+		if !strings.HasPrefix(c.Paths[i], "/") {
+			return errors.E(op, errors.Errorf("path should start with /, got %q", c.Paths[i]))
+		}
+	}
+
+	for i := 0; i < len(c.ContentTypes); i++ {
+		c.ContentTypes[i] = strings.ToLower(c.ContentTypes[i])
+	}
+
+	return nil
+}
+
+// streamMark is shared by the request copies of the middleware chain, so the handler could mark the request
+// for the middleware wrapping it
+type streamMark struct {
+	on atomic.Bool
+}
+
+// Streaming marks the requests of the streaming paths and the responses with the streaming content types,
+// the handlers mark the rest with MarkStreaming. Nil config uses the defaults.
+func Streaming(next http.Handler, cfg *StreamingConfig) http.Handler {
+	if cfg == nil {
+		cfg = &StreamingConfig{}
+		_ = cfg.InitDefaults()
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mark := &streamMark{}
+		if hasPrefix(r.URL.Path, cfg.Paths) {
+			mark.on.Store(true)
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), streamingCtx, mark))
+		next.ServeHTTP(&streamWriter{ResponseWriter: w, mark: mark, types: cfg.ContentTypes}, r)
+	})
+}
+
+// MarkStreaming marks the request as streaming, should be called by the handler before the response is written
+func MarkStreaming(r *http.Request) {
+	if mark, ok := r.Context().Value(streamingCtx).(*streamMark); ok {
+		mark.on.Store(true)
+	}
+}
+
+// IsStreaming reports whether the request has been marked as streaming by the path, the handler or the response
+// content type, the latter is known once the response headers are written
+func IsStreaming(r *http.Request) bool {
+	mark, ok := r.Context().Value(streamingCtx).(*streamMark)
+	return ok && mark.on.Load()
+}
+
+// streamWriter flushes every write of the streaming response
+type streamWriter struct {
+	http.ResponseWriter
+	mark    *streamMark
+	types   []string
+	checked bool
+}
+
+func (w *streamWriter) WriteHeader(code int) {
+	w.check()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *streamWriter) Write(b []byte) (int, error) {
+	w.check()
+
+	n, err := w.ResponseWriter.Write(b)
+	if err == nil && w.mark.on.Load() {
+		w.Flush()
+	}
+
+	return n, err
+}
+
+func (w *streamWriter) check() {
+	if w.checked {
+		return
+	}
+	w.checked = true
+
+	if matchContentType(w.Header().Get("Content-Type"), w.types) {
+		w.mark.on.Store(true)
+	}
+}
+
+func (w *streamWriter) Flush() {
+	if fl, ok := w.ResponseWriter.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+func (w *streamWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+
+	return nil, nil, ErrHijackerNotSupported
+}
+
+func (w *streamWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
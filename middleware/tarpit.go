@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+
+	"github.com/rumorshub/http/metrics"
+)
+
+type TarpitConfig struct {
+	// MaxClients held at once, the clients above it are rejected as usual. Defaults to 64.
+	MaxClients int `mapstructure:"max_clients" json:"max_clients,omitempty" bson:"max_clients,omitempty"`
+
+	// Duration the client is held for, defaults to 30s.
+	Duration time.Duration `mapstructure:"duration" json:"duration,omitempty" bson:"duration,omitempty"`
+
+	// Interval between the dripped bytes, defaults to 1s.
+	Interval time.Duration `mapstructure:"interval" json:"interval,omitempty" bson:"interval,omitempty"`
+}
+
+func (c *TarpitConfig) InitDefaults() error {
+	if c.MaxClients == 0 {
+		c.MaxClients = 64
+	}
+
+	if c.Duration == 0 {
+		c.Duration = time.Second * 30
+	}
+
+	if c.Interval == 0 {
+		c.Interval = time.Second
+	}
+
+	if c.MaxClients < 0 || c.Duration < 0 || c.Interval <= 0 {
+		return errors.Str("tarpit max_clients, duration and interval should be positive")
+	}
+
+	return nil
+}
+
+// Tarpit drip-feeds the response to the abusive clients (banned IPs, blocked user agents) to tie up their
+// resources. The number of the held clients and the hold duration are bounded, so the tarpit could not exhaust
+// the server connections.
+type Tarpit struct {
+	cfg   *TarpitConfig
+	slots chan struct{}
+	held  *metrics.Gauge
+	total *metrics.Counter
+}
+
+func NewTarpit(cfg *TarpitConfig, registry *metrics.Registry) *Tarpit {
+	return &Tarpit{
+		cfg:   cfg,
+		slots: make(chan struct{}, cfg.MaxClients),
+		held:  registry.Gauge("http_tarpit_clients", "Clients currently held in the tarpit."),
+		total: registry.Counter("http_tarpit_total", "Requests held in the tarpit, by the outcome (held, full).", "outcome"),
+	}
+}
+
+// Hold drip-feeds the response until the duration elapses or the client gives up. It reports false right away
+// when the tarpit is full, the caller should reject the request itself then.
+func (t *Tarpit) Hold(w http.ResponseWriter, r *http.Request) bool {
+	select {
+	case t.slots <- struct{}{}:
+	default:
+		t.total.Inc("full")
+		return false
+	}
+
+	t.total.Inc("held")
+	t.held.Inc()
+	defer func() {
+		t.held.Dec()
+		<-t.slots
+	}()
+
+	Annotate(r, "tarpit: held for "+t.cfg.Duration.String())
+
+	h := w.Header()
+	h.Set("Content-Type", "text/html")
+	h.Set("Cache-Control", "no-store")
+	h.Set("Connection", "close")
+	w.WriteHeader(http.StatusOK)
+
+	// the write timeout of the server would cut the hold short
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Now().Add(t.cfg.Duration + t.cfg.Interval))
+
+	timer := time.NewTimer(t.cfg.Duration)
+	defer timer.Stop()
+
+	ticker := time.NewTicker(t.cfg.Interval)
+	defer ticker.Stop()
+
+	drip := []byte{' '}
+	for {
+		select {
+		case <-r.Context().Done():
+			return true
+		case <-timer.C:
+			return true
+		case <-ticker.C:
+			_, err := w.Write(drip)
+			if err == nil {
+				err = rc.Flush()
+			}
+
+			if err != nil {
+				return true
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// MIT License
+//
+// Copyright (c) 2023 Spiral Scout
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package middleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+// TraceContextKey is the request context key of the W3C trace context
+const TraceContextKey contextKey = "trace_context"
+
+// TraceContext of the incoming traceparent header (W3C Trace Context)
+type TraceContext struct {
+	TraceID string
+	SpanID  string
+	Sampled bool
+}
+
+// ParseTraceparent parses the version-traceid-parentid-flags header value
+func ParseTraceparent(value string) (TraceContext, bool) {
+	// cut instead of split, it is parsed for every request
+	version, rest, _ := strings.Cut(strings.TrimSpace(value), "-")
+	traceID, rest, _ := strings.Cut(rest, "-")
+	spanID, rest, _ := strings.Cut(rest, "-")
+	flags, _, more := strings.Cut(rest, "-")
+
+	// future versions may append fields, version 00 has exactly 4
+	if !isHex(version, 2) || version == "ff" || (version == "00" && more) {
+		return TraceContext{}, false
+	}
+
+	if !isHex(traceID, 32) || !isHex(spanID, 16) || !isHex(flags, 2) {
+		return TraceContext{}, false
+	}
+
+	if strings.Trim(traceID, "0") == "" || strings.Trim(spanID, "0") == "" {
+		return TraceContext{}, false
+	}
+
+	// the flags are the hex encoded byte, the sampled flag is the lowest bit of its low nibble
+	return TraceContext{
+		TraceID: traceID,
+		SpanID:  spanID,
+		Sampled: unhex(flags[1])&1 == 1,
+	}, true
+}
+
+// GetTraceContext returns the trace context of the request, if it came with a valid traceparent
+func GetTraceContext(r *http.Request) (TraceContext, bool) {
+	tc, ok := r.Context().Value(TraceContextKey).(TraceContext)
+	return tc, ok
+}
+
+// unhex of the lowercase hex digit, checked by isHex
+func unhex(c byte) byte {
+	if c >= 'a' {
+		return c - 'a' + 10
+	}
+
+	return c - '0'
+}
+
+// isHex checks for the lowercase hex string of the given length
+func isHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		if (s[i] < '0' || s[i] > '9') && (s[i] < 'a' || s[i] > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
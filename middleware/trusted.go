@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"context"
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/roadrunner-server/errors"
+)
+
+const TrustedClientsName = "trusted_clients"
+
+type contextKey string
+
+const trustedCtx contextKey = "trusted_client"
+
+// TrustedClientsConfig is a shared list of clients (health checkers, internal tooling) exempted from
+// rate limiting, maintenance mode, WAF and other protective middleware.
+type TrustedClientsConfig struct {
+	// Subnets in CIDR notation, single IPs are accepted as well.
+	Subnets []string `mapstructure:"subnets" json:"subnets,omitempty" bson:"subnets,omitempty"`
+
+	// APIKeys accepted in the APIKeyHeader.
+	APIKeys []string `mapstructure:"api_keys" json:"api_keys,omitempty" bson:"api_keys,omitempty"`
+
+	// APIKeyHeader defaults to X-API-Key.
+	APIKeyHeader string `mapstructure:"api_key_header" json:"api_key_header,omitempty" bson:"api_key_header,omitempty"`
+}
+
+func (c *TrustedClientsConfig) InitDefaults() error {
+	if c.APIKeyHeader == "" {
+		c.APIKeyHeader = "X-API-Key"
+	}
+
+	_, err := parseSubnets(c.Subnets)
+	return err
+}
+
+// TrustedClients marks requests from the trusted clients, other middleware checks the mark with IsTrusted.
+type TrustedClients struct {
+	subnets []*net.IPNet
+	keys    [][]byte
+	header  string
+}
+
+func NewTrustedClients(cfg *TrustedClientsConfig) (*TrustedClients, error) {
+	subnets, err := parseSubnets(cfg.Subnets)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([][]byte, 0, len(cfg.APIKeys))
+	for i := 0; i < len(cfg.APIKeys); i++ {
+		keys = append(keys, []byte(cfg.APIKeys[i]))
+	}
+
+	return &TrustedClients{
+		subnets: subnets,
+		keys:    keys,
+		header:  cfg.APIKeyHeader,
+	}, nil
+}
+
+func (t *TrustedClients) Name() string {
+	return TrustedClientsName
+}
+
+func (t *TrustedClients) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if t.Contains(r) {
+			Annotate(r, "trusted_clients: trusted client")
+			r = r.WithContext(context.WithValue(r.Context(), trustedCtx, true))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Contains reports whether the request peer address or API key belongs to the trusted clients
+func (t *TrustedClients) Contains(r *http.Request) bool {
+	if t == nil {
+		return false
+	}
+
+	if key := r.Header.Get(t.header); key != "" {
+		for i := 0; i < len(t.keys); i++ {
+			if subtle.ConstantTimeCompare([]byte(key), t.keys[i]) == 1 {
+				return true
+			}
+		}
+	}
+
+	return containsIP(t.subnets, remoteIP(r))
+}
+
+// IsTrusted reports whether the request was marked by the trusted_clients middleware
+func IsTrusted(r *http.Request) bool {
+	trusted, _ := r.Context().Value(trustedCtx).(bool)
+	return trusted
+}
+
+func parseSubnets(subnets []string) ([]*net.IPNet, error) {
+	const op = errors.Op("parse_subnets")
+
+	nets := make([]*net.IPNet, 0, len(subnets))
+	for i := 0; i < len(subnets); i++ {
+		cidr := subnets[i]
+		// single address
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, errors.E(op, errors.Errorf("invalid ip address: %s", cidr))
+			}
+
+			if ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+
+		nets = append(nets, ipNet)
+	}
+
+	return nets, nil
+}
+
+func containsIP(subnets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	for i := 0; i < len(subnets); i++ {
+		if subnets[i].Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return net.ParseIP(host)
+}
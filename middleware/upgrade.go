@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+// IsUpgrade reports whether the request asks for the protocol upgrade (WebSocket), such requests have no body
+// and the connection is hijacked, the response should not be buffered or rewritten.
+func IsUpgrade(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+
+	for _, value := range r.Header.Values("Connection") {
+		tokens := strings.Split(value, ",")
+		for i := 0; i < len(tokens); i++ {
+			if strings.EqualFold(strings.TrimSpace(tokens[i]), "upgrade") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// IsGRPC reports whether the request is the gRPC call, its body is a stream and should not be read ahead.
+func IsGRPC(r *http.Request) bool {
+	return r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
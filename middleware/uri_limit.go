@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/roadrunner-server/errors"
+)
+
+// URILimitConfig of the request URI length, the limit is off when MaxLength is 0.
+type URILimitConfig struct {
+	// MaxLength of the request URI (path and query) in bytes.
+	MaxLength int `mapstructure:"max_length" json:"max_length,omitempty" bson:"max_length,omitempty"`
+
+	// Status of the rejection response, 414 or 400. Default: 414.
+	Status int `mapstructure:"status" json:"status,omitempty" bson:"status,omitempty"`
+
+	// Body of the rejection response. Default: the status text.
+	Body string `mapstructure:"body" json:"body,omitempty" bson:"body,omitempty"`
+
+	// Close the connection after the rejection response (HTTP/1.x only).
+	Close bool `mapstructure:"close" json:"close,omitempty" bson:"close,omitempty"`
+}
+
+func (c *URILimitConfig) InitDefaults() error {
+	const op = errors.Op("uri_limit_config")
+
+	if c.Status == 0 {
+		c.Status = http.StatusRequestURITooLong
+	}
+
+	if c.MaxLength < 0 {
+		return errors.E(op, errors.Errorf("max_length should not be negative, got %d", c.MaxLength))
+	}
+
+	if c.Status != http.StatusRequestURITooLong && c.Status != http.StatusBadRequest {
+		return errors.E(op, errors.Errorf("status should be 414 or 400, got %d", c.Status))
+	}
+
+	if c.Body == "" {
+		c.Body = http.StatusText(c.Status)
+	}
+
+	return nil
+}
+
+// MaxURILength rejects the requests with the URI above the limit before they reach the middleware and handlers.
+func MaxURILength(next http.Handler, cfg *URILimitConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.RequestURI) <= cfg.MaxLength {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		Audit(r, AuditURITooLong, slog.Int("length", len(r.RequestURI)))
+
+		if cfg.Close && r.ProtoMajor == 1 {
+			w.Header().Set("Connection", "close")
+		}
+
+		http.Error(w, cfg.Body, cfg.Status)
+	})
+}
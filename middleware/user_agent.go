@@ -0,0 +1,402 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+)
+
+const UserAgentName = "user_agent"
+
+// maxUserAgentEntries of the verifications and the limit windows each, the expired ones are swept first, then
+// the random ones are evicted
+const maxUserAgentEntries = 4096
+
+// BotKey is the request context key of the user agent tag
+const BotKey contextKey = "bot"
+
+// Actions of the user agent rules, the tarpit one requires the tarpit config
+const (
+	UserAgentAllow  = "allow"
+	UserAgentBlock  = "block"
+	UserAgentLimit  = "limit"
+	UserAgentTag    = "tag"
+	UserAgentTarpit = "tarpit"
+)
+
+type UserAgentRule struct {
+	// Pattern is the regular expression of the User-Agent, e.g. (?i)curl|python-requests.
+	Pattern string `mapstructure:"pattern" json:"pattern,omitempty" bson:"pattern,omitempty"`
+
+	// Action of the matching requests: allow, block, limit, tag or tarpit. Defaults to block.
+	Action string `mapstructure:"action" json:"action,omitempty" bson:"action,omitempty"`
+
+	// Tag of the matching requests in the access log, defaults to the pattern.
+	Tag string `mapstructure:"tag" json:"tag,omitempty" bson:"tag,omitempty"`
+}
+
+type VerifiedBot struct {
+	// Name of the bot, the tag of the verified requests.
+	Name string `mapstructure:"name" json:"name,omitempty" bson:"name,omitempty"`
+
+	// Pattern is the regular expression of the User-Agent the bot claims, e.g. (?i)googlebot.
+	Pattern string `mapstructure:"pattern" json:"pattern,omitempty" bson:"pattern,omitempty"`
+
+	// Domains the reverse DNS name of the client IP should belong to, e.g. googlebot.com.
+	Domains []string `mapstructure:"domains" json:"domains,omitempty" bson:"domains,omitempty"`
+}
+
+type UserAgentLimitConfig struct {
+	// Requests per IP within the Period, defaults to 10.
+	Requests int `mapstructure:"requests" json:"requests,omitempty" bson:"requests,omitempty"`
+
+	// Period of the limit, defaults to 1m.
+	Period time.Duration `mapstructure:"period" json:"period,omitempty" bson:"period,omitempty"`
+}
+
+type UserAgentConfig struct {
+	// Rules matched in order against the User-Agent, the first matching one applies. Unmatched requests pass.
+	Rules []UserAgentRule `mapstructure:"rules" json:"rules,omitempty" bson:"rules,omitempty"`
+
+	// Bots are verified by the reverse and the forward DNS lookup of the client IP, the requests claiming to be
+	// the bot from other addresses are blocked. The verified bots skip the rules.
+	Bots []VerifiedBot `mapstructure:"bots" json:"bots,omitempty" bson:"bots,omitempty"`
+
+	// Limit of the requests matching the limit rules.
+	Limit UserAgentLimitConfig `mapstructure:"limit" json:"limit,omitempty" bson:"limit,omitempty"`
+
+	// CacheTTL of the bot verification results, defaults to 1h.
+	CacheTTL time.Duration `mapstructure:"cache_ttl" json:"cache_ttl,omitempty" bson:"cache_ttl,omitempty"`
+
+	// NegativeCacheTTL of the unverified bots and the failed lookups, so the spoofed bot could not make every
+	// request wait for the DNS. Defaults to 1m.
+	NegativeCacheTTL time.Duration `mapstructure:"negative_cache_ttl" json:"negative_cache_ttl,omitempty" bson:"negative_cache_ttl,omitempty"`
+
+	// LookupTimeout of the DNS lookups of the verification, defaults to 2s.
+	LookupTimeout time.Duration `mapstructure:"lookup_timeout" json:"lookup_timeout,omitempty" bson:"lookup_timeout,omitempty"`
+}
+
+func (c *UserAgentConfig) InitDefaults() error {
+	const op = errors.Op("user_agent_config")
+
+	if c.Limit.Requests == 0 {
+		c.Limit.Requests = 10
+	}
+
+	if c.Limit.Period == 0 {
+		c.Limit.Period = time.Minute
+	}
+
+	if c.CacheTTL == 0 {
+		c.CacheTTL = time.Hour
+	}
+
+	if c.NegativeCacheTTL == 0 {
+		c.NegativeCacheTTL = time.Minute
+	}
+
+	if c.LookupTimeout == 0 {
+		c.LookupTimeout = time.Second * 2
+	}
+
+	if len(c.Rules) == 0 && len(c.Bots) == 0 {
+		return errors.E(op, errors.Str("rules or bots should be set"))
+	}
+
+	for i := 0; i < len(c.Rules); i++ {
+		rule := &c.Rules[i]
+		if rule.Action == "" {
+			rule.Action = UserAgentBlock
+		}
+
+		switch rule.Action {
+		case UserAgentAllow, UserAgentBlock, UserAgentLimit, UserAgentTag, UserAgentTarpit:
+		default:
+			return errors.E(op, errors.Errorf("rule %d: unknown action %q", i, rule.Action))
+		}
+
+		if rule.Tag == "" {
+			rule.Tag = rule.Pattern
+		}
+
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return errors.E(op, errors.Errorf("rule %d: %v", i, err))
+		}
+	}
+
+	for i := 0; i < len(c.Bots); i++ {
+		bot := &c.Bots[i]
+		if bot.Name == "" || bot.Pattern == "" || len(bot.Domains) == 0 {
+			return errors.E(op, errors.Errorf("bot %d: name, pattern and domains should be set", i))
+		}
+
+		if _, err := regexp.Compile(bot.Pattern); err != nil {
+			return errors.E(op, errors.Errorf("bot %s: %v", bot.Name, err))
+		}
+
+		for j := 0; j < len(bot.Domains); j++ {
+			bot.Domains[j] = strings.ToLower(strings.Trim(bot.Domains[j], "."))
+		}
+	}
+
+	return nil
+}
+
+// UserAgent filters the requests by the User-Agent rules and verifies the clients claiming to be the known bots.
+// The matched rule or the verified bot tags the request for the access log. Trusted clients are not filtered.
+type UserAgent struct {
+	cfg      *UserAgentConfig
+	tarpit   *Tarpit
+	rules    []*regexp.Regexp
+	bots     []*regexp.Regexp
+	log      *slog.Logger
+	resolver *net.Resolver
+
+	mu       sync.Mutex
+	verified map[string]verification
+	windows  map[string]*uaWindow
+}
+
+// verification of the bot claimed from the IP
+type verification struct {
+	ok      bool
+	expires time.Time
+}
+
+// uaWindow counts the limited requests of the IP
+type uaWindow struct {
+	start time.Time
+	count int
+}
+
+// NewUserAgent creates the filter, the tarpit could be nil, then the tarpit rules block the requests
+func NewUserAgent(cfg *UserAgentConfig, tarpit *Tarpit, log *slog.Logger) *UserAgent {
+	u := &UserAgent{
+		cfg:      cfg,
+		tarpit:   tarpit,
+		log:      log,
+		resolver: net.DefaultResolver,
+		verified: make(map[string]verification),
+		windows:  make(map[string]*uaWindow),
+	}
+
+	// the patterns are validated by the config
+	for i := 0; i < len(cfg.Rules); i++ {
+		u.rules = append(u.rules, regexp.MustCompile(cfg.Rules[i].Pattern))
+	}
+
+	for i := 0; i < len(cfg.Bots); i++ {
+		u.bots = append(u.bots, regexp.MustCompile(cfg.Bots[i].Pattern))
+	}
+
+	return u
+}
+
+func (u *UserAgent) Name() string {
+	return UserAgentName
+}
+
+func (u *UserAgent) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := remoteIP(r)
+		if ip == nil || IsTrusted(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		agent := r.UserAgent()
+
+		for i := 0; i < len(u.bots); i++ {
+			if !u.bots[i].MatchString(agent) {
+				continue
+			}
+
+			bot := &u.cfg.Bots[i]
+			if !u.verify(r.Context(), ip, bot) {
+				Annotate(r, "user_agent: rejected, unverified "+bot.Name)
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), BotKey, bot.Name)))
+			return
+		}
+
+		for i := 0; i < len(u.rules); i++ {
+			if !u.rules[i].MatchString(agent) {
+				continue
+			}
+
+			rule := &u.cfg.Rules[i]
+			r = r.WithContext(context.WithValue(r.Context(), BotKey, rule.Tag))
+
+			switch rule.Action {
+			case UserAgentBlock:
+				Annotate(r, "user_agent: rejected, "+rule.Tag)
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			case UserAgentTarpit:
+				if u.tarpit != nil && u.tarpit.Hold(w, r) {
+					return
+				}
+
+				Annotate(r, "user_agent: rejected, "+rule.Tag)
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			case UserAgentLimit:
+				if retry, ok := u.allow(ip.String()); !ok {
+					Annotate(r, "user_agent: rate limited, "+rule.Tag)
+					Audit(r, AuditRateLimited, slog.String("limiter", UserAgentName), slog.String("tag", rule.Tag))
+					w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+					http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+					return
+				}
+			}
+
+			break
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GetBot returns the tag of the user agent rule or the name of the verified bot
+func GetBot(r *http.Request) string {
+	bot, _ := r.Context().Value(BotKey).(string)
+	return bot
+}
+
+// verify checks the reverse DNS name of the IP belongs to the bot domains and resolves back to the IP
+func (u *UserAgent) verify(ctx context.Context, ip net.IP, bot *VerifiedBot) bool {
+	key := bot.Name + "|" + ip.String()
+	now := time.Now()
+
+	u.mu.Lock()
+	v, ok := u.verified[key]
+	u.mu.Unlock()
+
+	if ok && now.Before(v.expires) {
+		return v.ok
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, u.cfg.LookupTimeout)
+	defer cancel()
+
+	verified, err := u.lookup(ctx, ip, bot.Domains)
+	if err != nil {
+		// the client is rejected until the DNS answers
+		u.log.Debug("bot verification lookup", "bot", bot.Name, "ip", ip.String(), "error", err)
+	}
+
+	ttl := u.cfg.CacheTTL
+	if !verified {
+		ttl = u.cfg.NegativeCacheTTL
+	}
+
+	u.mu.Lock()
+	if _, ok := u.verified[key]; !ok && len(u.verified) >= maxUserAgentEntries {
+		for k, v := range u.verified {
+			if now.After(v.expires) {
+				delete(u.verified, k)
+			}
+		}
+
+		for k := range u.verified {
+			if len(u.verified) < maxUserAgentEntries {
+				break
+			}
+			delete(u.verified, k)
+		}
+	}
+	u.verified[key] = verification{ok: verified, expires: now.Add(ttl)}
+	u.mu.Unlock()
+
+	return verified
+}
+
+func (u *UserAgent) lookup(ctx context.Context, ip net.IP, domains []string) (bool, error) {
+	names, err := u.resolver.LookupAddr(ctx, ip.String())
+	if err != nil {
+		// no PTR record is the unverified bot, not the lookup failure
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	for i := 0; i < len(names); i++ {
+		name := strings.ToLower(strings.TrimSuffix(names[i], "."))
+		if !inDomains(name, domains) {
+			continue
+		}
+
+		addrs, err := u.resolver.LookupIPAddr(ctx, name)
+		if err != nil {
+			return false, err
+		}
+
+		for j := 0; j < len(addrs); j++ {
+			if addrs[j].IP.Equal(ip) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// allow counts the request of the IP in the fixed window, returns the time to the next window when exceeded
+func (u *UserAgent) allow(ip string) (time.Duration, bool) {
+	now := time.Now()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	window, ok := u.windows[ip]
+	if !ok || now.Sub(window.start) >= u.cfg.Limit.Period {
+		if !ok && len(u.windows) >= maxUserAgentEntries {
+			for k, w := range u.windows {
+				if now.Sub(w.start) >= u.cfg.Limit.Period {
+					delete(u.windows, k)
+				}
+			}
+
+			for k := range u.windows {
+				if len(u.windows) < maxUserAgentEntries {
+					break
+				}
+				delete(u.windows, k)
+			}
+		}
+
+		window = &uaWindow{start: now}
+		u.windows[ip] = window
+	}
+
+	if window.count >= u.cfg.Limit.Requests {
+		return window.start.Add(u.cfg.Limit.Period).Sub(now), false
+	}
+
+	window.count++
+
+	return 0, true
+}
+
+func inDomains(name string, domains []string) bool {
+	for i := 0; i < len(domains); i++ {
+		if name == domains[i] || strings.HasSuffix(name, "."+domains[i]) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,57 @@
+package http
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/roadrunner-server/errors"
+)
+
+// mux serves the mountable handlers by the longest matching path prefix
+type mux struct {
+	mounts   []Mountable
+	fallback http.Handler
+}
+
+func newMux(mounts []Mountable, fallback http.Handler) (http.Handler, error) {
+	const op = errors.Op("http_plugin_mux")
+
+	sorted := make([]Mountable, len(mounts))
+	copy(sorted, mounts)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].Pattern()) > len(sorted[j].Pattern())
+	})
+
+	for i := 0; i < len(sorted); i++ {
+		if !strings.HasPrefix(sorted[i].Pattern(), "/") {
+			return nil, errors.E(op, errors.Errorf("mount pattern should start with /, got %q", sorted[i].Pattern()))
+		}
+
+		if i > 0 && sorted[i].Pattern() == sorted[i-1].Pattern() {
+			return nil, errors.E(op, errors.Errorf("pattern %q is mounted more than once", sorted[i].Pattern()))
+		}
+	}
+
+	return &mux{mounts: sorted, fallback: fallback}, nil
+}
+
+func (m *mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for i := 0; i < len(m.mounts); i++ {
+		if matchPrefix(r.URL.Path, m.mounts[i].Pattern()) {
+			m.mounts[i].ServeHTTP(w, r)
+			return
+		}
+	}
+
+	m.fallback.ServeHTTP(w, r)
+}
+
+// matchPrefix matches the whole path segments, /api matches /api and /api/users but not /apis
+func matchPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
@@ -1,20 +1,41 @@
 package http
 
 import (
+	"cmp"
 	"context"
 	"log"
 	"log/slog"
+	"maps"
+	"net"
 	"net/http"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 
+	"github.com/caddyserver/certmagic"
 	"github.com/roadrunner-server/endure/v2/dep"
 	"github.com/roadrunner-server/errors"
 	"go.uber.org/zap"
 
+	"github.com/rumorshub/http/audit"
+	"github.com/rumorshub/http/cache"
+	"github.com/rumorshub/http/capture"
 	"github.com/rumorshub/http/config"
+	"github.com/rumorshub/http/events"
+	"github.com/rumorshub/http/inspector"
+	"github.com/rumorshub/http/logsink"
+	"github.com/rumorshub/http/metrics"
 	"github.com/rumorshub/http/middleware"
+	"github.com/rumorshub/http/proxy"
+	"github.com/rumorshub/http/redis"
 	httpServer "github.com/rumorshub/http/servers/http"
 	httpsServer "github.com/rumorshub/http/servers/https"
+	"github.com/rumorshub/http/servers/listener"
+	muxServer "github.com/rumorshub/http/servers/mux"
+	"github.com/rumorshub/http/servers/state"
+	"github.com/rumorshub/http/stats"
+	"github.com/rumorshub/http/supervisor"
 )
 
 const (
@@ -26,20 +47,99 @@ type internalServer interface {
 	Start(map[string]middleware.Middleware, []string) error
 	GetServer() *http.Server
 	Stop()
+
+	Name() string
+	Address() string
+	State() state.State
+	Stats() *stats.Stats
+	Drain(bool) bool
+	OnShutdown(func())
 }
 
 type Plugin struct {
 	mu sync.RWMutex
+	// resetMu serializes the Reset calls (RPC, config reload)
+	resetMu sync.Mutex
 
-	log    *slog.Logger
-	stdLog *log.Logger
-	zapLog *zap.Logger
+	log        *slog.Logger
+	stdLog     *log.Logger
+	stdAdapter *StdLogAdapter
+	zapLog     *zap.Logger
 
 	cfg *config.Config
+	// raw section of the config the middleware was built of, Reset rejects the changes outside the server settings
+	raw map[string]any
+	// configurer is kept for the Reset, it re-reads the config
+	configurer Configurer
+	// reload result of the last Reset, nil before the first one
+	reload atomic.Pointer[ReloadStatus]
+	// errCh of the Serve, the servers re-created by the Reset report to it
+	errCh chan error
+	// stopCh stops the config watching
+	stopCh   chan struct{}
+	stopOnce sync.Once
 
 	mdwr    map[string]middleware.Middleware
-	handler http.Handler
+	handler atomic.Pointer[http.Handler]
+	root    http.Handler
+	mounts  []Mountable
+	named   map[string]http.Handler
+	signer  httpsServer.SignerProvider
+	tlsCfg  []httpsServer.TLSConfigurer
+	storage map[string]httpsServer.StorageProvider
+	dns     map[string]httpsServer.DNSProvider
+	events  []httpsServer.CertificateEventListener
 	servers []internalServer
+
+	// collected is false when the default handler serves the requests
+	collected atomic.Bool
+	// listeners provided by other plugins by the name
+	listeners map[string]listener.Provider
+	// shared listeners by the address, addresses used by the current servers
+	shared    map[string]*listener.Shared
+	addresses map[string]struct{}
+
+	// listeners of the server lifecycle events
+	serverEvents []events.Listener
+	// protocols multiplexed on the plain http listener
+	protocols []muxServer.Protocol
+
+	// level of the plugin logger, adjusted over RPC
+	level *logLevel
+
+	metrics    *metrics.Registry
+	reloads    *metrics.Counter
+	reloadOK   *metrics.Gauge
+	tooLarge   *metrics.Counter
+	latency    *metrics.Histogram
+	conns      *connMetrics
+	exporter   *metrics.Server
+	geoip      *middleware.GeoIP
+	jwt        *middleware.JWT
+	signedURL  *middleware.SignedURL
+	ban        *middleware.Ban
+	tarpit     *middleware.Tarpit
+	rateLimit  *middleware.RateLimit
+	redis      []*redis.Client
+	cache      *cache.Cache
+	capture    *capture.Capture
+	proxy      *proxy.Proxy
+	inspector  *inspector.Inspector
+	supervisor *supervisor.Supervisor
+
+	// sinks of the access log, accessLog writes to them and the logger, both are re-built by the Reset
+	sinks       *logsink.Sinks
+	accessLog   *slog.Logger
+	sinkDropped *metrics.Counter
+	audit       *audit.Log
+
+	// maintenance mode is toggled over RPC
+	maintenance *middleware.Maintenance
+
+	// hooks called when the graceful shutdown begins, registered on every server
+	onShutdown []*shutdownHook
+	// stopping is set by the Stop, the servers stopped by the Reset do not call the hooks
+	stopping atomic.Bool
 }
 
 func (p *Plugin) Init(cfg Configurer, logger Logger) error {
@@ -48,11 +148,16 @@ func (p *Plugin) Init(cfg Configurer, logger Logger) error {
 		return errors.E(op, errors.Disabled)
 	}
 
-	if err := cfg.UnmarshalKey(PluginName, &p.cfg); err != nil {
+	c, raw, err := unmarshalConfig(cfg)
+	if err != nil {
 		return errors.E(op, err)
 	}
+	p.cfg = c
+	p.raw = raw
+	p.configurer = cfg
 
-	if err := p.cfg.InitDefaults(); err != nil {
+	err = p.cfg.InitDefaults()
+	if err != nil {
 		return errors.E(op, err)
 	}
 
@@ -60,45 +165,367 @@ func (p *Plugin) Init(cfg Configurer, logger Logger) error {
 		return errors.E(op, errors.Disabled)
 	}
 
-	p.log = logger.NamedLogger(PluginName)
-	p.zapLog = logger.NamedZapLogger(PluginName)
-	p.stdLog = log.New(NewStdAdapter(p.log), "http_plugin: ", log.Ldate|log.Ltime|log.LUTC)
+	return p.init(logger.NamedLogger(PluginName), logger.NamedZapLogger(PluginName))
+}
+
+// unmarshalConfig decodes the raw plugin section, the durations are accepted as strings and the errors name the key
+// regardless of the decode hooks of the configurer.
+func unmarshalConfig(cfg Configurer) (*config.Config, map[string]any, error) {
+	var raw map[string]any
+	err := cfg.UnmarshalKey(PluginName, &raw)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c := &config.Config{}
+	err = config.Decode(raw, c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return c, raw, nil
+}
+
+// init builds the plugin from the config with the defaults applied
+func (p *Plugin) init(sLog *slog.Logger, zapLog *zap.Logger) error {
+	const op = errors.Op("http_plugin_init")
+
+	p.level = &logLevel{}
+	p.log = slog.New(newLevelHandler(sLog.Handler(), p.level))
+	p.zapLog = zapLog
+	p.stdAdapter = NewStdAdapter(p.log)
+	p.stdLog = log.New(p.stdAdapter, "http_plugin: ", log.Ldate|log.Ltime|log.LUTC)
 	p.mdwr = make(map[string]middleware.Middleware)
+	p.named = make(map[string]http.Handler)
+	p.listeners = make(map[string]listener.Provider)
+	p.stopCh = make(chan struct{})
+	p.shared = make(map[string]*listener.Shared)
+	p.storage = make(map[string]httpsServer.StorageProvider)
+	p.dns = make(map[string]httpsServer.DNSProvider)
 	p.servers = make([]internalServer, 0, 2)
-	p.handler = http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {})
+	p.metrics = metrics.NewRegistry()
+	p.reloads = p.metrics.Counter("http_config_reloads_total", "Config reloads by the result (success, failure).", "result")
+	p.reloadOK = p.metrics.Gauge("http_config_last_reload_successful", "Whether the last config reload succeeded, 1 before the first one.")
+	p.reloadOK.Set(1)
+	p.tooLarge = p.metrics.Counter("http_request_too_large_total", "Requests rejected because of the body size limit.", "method")
+	p.latency = p.metrics.Histogram("http_request_duration_seconds", "Request latency by the method and the status code, the OpenMetrics exemplars carry the request_id and the trace_id.", metrics.DefBuckets, "method", "code")
+	p.conns = newConnMetrics(p.metrics, p.log)
+
+	if p.cfg.Metrics != nil {
+		p.exporter = metrics.NewServer(p.cfg.Metrics, p.metrics, p.log)
+	}
+
+	if len(p.cfg.TrustedSubnets) > 0 {
+		realIP, err := middleware.NewRealIP(p.cfg.TrustedSubnets, p.cfg.RealIPHeader)
+		if err != nil {
+			return errors.E(op, err)
+		}
+
+		p.mdwr[realIP.Name()] = realIP
+	}
+
+	p.maintenance = middleware.NewMaintenance(p.cfg.Maintenance)
+	p.mdwr[p.maintenance.Name()] = p.maintenance
+
+	if p.cfg.TrustedClients != nil {
+		trusted, err := middleware.NewTrustedClients(p.cfg.TrustedClients)
+		if err != nil {
+			return errors.E(op, err)
+		}
+
+		p.mdwr[trusted.Name()] = trusted
+	}
+
+	// shared by the ban and the user agent filter, so the held clients are bounded once
+	if p.cfg.Tarpit != nil {
+		p.tarpit = middleware.NewTarpit(p.cfg.Tarpit, p.metrics)
+	}
+
+	if p.cfg.GeoIP != nil {
+		geo, err := middleware.NewGeoIP(p.cfg.GeoIP, p.log)
+		if err != nil {
+			return errors.E(op, err)
+		}
+
+		p.geoip = geo
+		p.mdwr[geo.Name()] = geo
+	}
+
+	if p.cfg.UserAgent != nil {
+		p.mdwr[middleware.UserAgentName] = middleware.NewUserAgent(p.cfg.UserAgent, p.tarpit, p.log)
+	}
+
+	if p.cfg.BasicAuth != nil {
+		auth, err := middleware.NewBasicAuth(p.cfg.BasicAuth)
+		if err != nil {
+			return errors.E(op, err)
+		}
+
+		p.mdwr[auth.Name()] = auth
+	}
+
+	if p.cfg.JWT != nil {
+		jwt, err := middleware.NewJWT(p.cfg.JWT, p.log)
+		if err != nil {
+			return errors.E(op, err)
+		}
+
+		p.jwt = jwt
+		p.mdwr[jwt.Name()] = jwt
+	}
+
+	if p.cfg.HMAC != nil {
+		p.mdwr[middleware.HMACName] = middleware.NewHMAC(p.cfg.HMAC)
+	}
+
+	if p.cfg.SignedURL != nil {
+		p.signedURL = middleware.NewSignedURL(p.cfg.SignedURL)
+		p.mdwr[p.signedURL.Name()] = p.signedURL
+	}
+
+	if p.cfg.SecurityHeaders != nil {
+		p.mdwr[middleware.SecurityHeadersName] = middleware.NewSecurityHeaders(p.cfg.SecurityHeaders)
+	}
+
+	if p.cfg.ErrorPages != nil {
+		pages, err := middleware.NewErrorPages(p.cfg.ErrorPages)
+		if err != nil {
+			return errors.E(op, err)
+		}
+
+		p.mdwr[pages.Name()] = pages
+	}
+
+	if p.cfg.InFlight != nil {
+		p.mdwr[middleware.InFlightName] = middleware.NewInFlight(p.cfg.InFlight, p.metrics)
+	}
+
+	if p.cfg.RateLimit != nil {
+		var store middleware.RateLimitStore
+		if p.cfg.RateLimit.Redis != nil {
+			client := redis.New(p.cfg.RateLimit.Redis)
+			p.redis = append(p.redis, client)
+			store = middleware.NewRedisRateLimitStore(client)
+		} else {
+			store = middleware.NewMemoryRateLimitStore(p.cfg.RateLimit.MaxKeys)
+		}
+
+		p.rateLimit = middleware.NewRateLimit(p.cfg.RateLimit, store, p.log)
+		p.mdwr[p.rateLimit.Name()] = p.rateLimit
+	}
+
+	if p.cfg.Ban != nil {
+		p.ban = middleware.NewBan(p.cfg.Ban, p.tarpit, p.metrics)
+		p.mdwr[p.ban.Name()] = p.ban
+	}
+
+	if p.cfg.Honeypot != nil {
+		p.mdwr[middleware.HoneypotName] = middleware.NewHoneypot(p.cfg.Honeypot, p.ban)
+	}
+
+	if p.cfg.SlowClients != nil {
+		p.mdwr[middleware.SlowClientsName] = middleware.NewSlowClients(p.cfg.SlowClients, p.metrics, p.log)
+	}
+
+	if p.cfg.AuditLog != nil {
+		auditLog, err := audit.New(p.cfg.AuditLog, p.metrics, p.log)
+		if err != nil {
+			return errors.E(op, err)
+		}
+
+		p.audit = auditLog
+		p.stdAdapter.audit = auditLog
+	}
+
+	p.accessLog, p.sinks = p.newAccessLog(p.cfg.AccessLog)
+
+	if p.cfg.Proxy != nil {
+		px, err := proxy.New(p.cfg.Proxy, p.log)
+		if err != nil {
+			return errors.E(op, err)
+		}
+
+		p.proxy = px
+		p.mdwr[px.Name()] = px
+	}
+
+	if p.cfg.Mirror != nil {
+		mirror, err := middleware.NewMirror(p.cfg.Mirror, p.log)
+		if err != nil {
+			return errors.E(op, err)
+		}
+
+		p.mdwr[mirror.Name()] = mirror
+	}
+
+	if p.cfg.Cache != nil {
+		var store cache.Store
+		if p.cfg.Cache.Redis != nil {
+			client := redis.New(p.cfg.Cache.Redis)
+			p.redis = append(p.redis, client)
+			store = cache.NewRedisStore(client, p.log)
+		}
+
+		p.cache = cache.New(p.cfg.Cache, store, p.log)
+		p.mdwr[p.cache.Name()] = p.cache
+	}
+
+	if p.cfg.Capture != nil {
+		cp, err := capture.New(p.cfg.Capture, p.log)
+		if err != nil {
+			return errors.E(op, err)
+		}
+
+		p.capture = cp
+		p.mdwr[cp.Name()] = cp
+	}
+
+	if p.cfg.Inspector != nil {
+		p.inspector = inspector.New(p.cfg.Inspector, p.log)
+		p.mdwr[p.inspector.Name()] = p.inspector
+	}
+
+	if p.cfg.Workers != nil && !supervisor.IsWorker() {
+		p.supervisor = supervisor.New(p.cfg.Workers, p.log)
+	}
 
 	return nil
 }
 
 func (p *Plugin) Serve() chan error {
-	errCh := make(chan error, 2)
+	errCh := make(chan error, 3)
+	p.errCh = errCh
 	var err error
 
+	go p.watchConfig()
+
+	// the supervisor process only manages the workers, they serve the traffic
+	if p.supervisor != nil {
+		err = p.supervisor.Start()
+		if err != nil {
+			errCh <- err
+		}
+
+		return errCh
+	}
+
+	p.mu.Lock()
+	p.collected.Store(p.handler.Load() != nil || len(p.mounts) > 0)
+	if !p.collected.Load() {
+		p.log.Warn("no http handler has been collected, every request is answered with the default status", "status", p.cfg.DefaultStatus)
+	}
+
+	if p.handler.Load() == nil {
+		handler := defaultHandler(p.cfg.DefaultStatus)
+		p.handler.Store(&handler)
+	}
+
+	// mounts fall back to the current handler, so it could be swapped
+	p.root = http.HandlerFunc(p.serveHandler)
+	if len(p.mounts) > 0 {
+		p.root, err = newMux(p.mounts, p.root)
+		if err != nil {
+			p.mu.Unlock()
+			errCh <- err
+			return errCh
+		}
+	}
+	p.mu.Unlock()
+
+	p.mu.Lock()
 	err = p.initServers()
+	p.mu.Unlock()
 	if err != nil {
 		errCh <- err
 		return errCh
 	}
 
-	p.applyBundledMiddleware()
-
-	for i := 0; i < len(p.servers); i++ {
-		go func(i int) {
-			errSt := p.servers[i].Start(p.mdwr, p.cfg.Middleware)
+	if p.inspector != nil {
+		go func() {
+			errSt := p.inspector.Start()
 			if errSt != nil {
 				errCh <- errSt
-				return
 			}
-		}(i)
+		}()
 	}
 
+	p.startServers(p.servers)
+
 	return errCh
 }
 
+// startServers serves every server in its own goroutine, the errors go to the Serve channel
+func (p *Plugin) startServers(servers []internalServer) {
+	for i := 0; i < len(servers); i++ {
+		mdwr, order := p.applyBundledMiddleware(servers[i], p.middlewareOrder(p.serverMiddleware(servers[i].Name())))
+		p.conns.observe(servers[i].Name(), servers[i].Stats())
+
+		go func(srv internalServer, mdwr map[string]middleware.Middleware, order []string) {
+			errSt := srv.Start(mdwr, order)
+			if errSt != nil {
+				p.errCh <- &ServerError{Server: srv.Name(), Address: srv.Address(), Err: errSt}
+				return
+			}
+		}(servers[i], mdwr, order)
+	}
+}
+
+// shutdownHook is called once, by the first server which graceful shutdown begins after the plugin Stop
+type shutdownHook struct {
+	f    func()
+	once sync.Once
+}
+
+// OnShutdown registers the function called in its own goroutine when the graceful shutdown of the plugin begins,
+// e.g. to deregister from the service discovery. It is registered on every server, the servers re-created by the
+// Reset included, and called once.
+func (p *Plugin) OnShutdown(f func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	hook := &shutdownHook{f: f}
+	p.onShutdown = append(p.onShutdown, hook)
+
+	for i := 0; i < len(p.servers); i++ {
+		p.servers[i].OnShutdown(p.shutdownFunc(hook))
+	}
+}
+
+// shutdownFunc of the server, the shutdown of the server replaced by the Reset is not the shutdown of the plugin
+func (p *Plugin) shutdownFunc(hook *shutdownHook) func() {
+	return func() {
+		if p.stopping.Load() {
+			hook.once.Do(hook.f)
+		}
+	}
+}
+
+// registerShutdown registers the hooks on the servers created by the initServers
+func (p *Plugin) registerShutdown() {
+	for i := 0; i < len(p.servers); i++ {
+		for j := 0; j < len(p.onShutdown); j++ {
+			p.servers[i].OnShutdown(p.shutdownFunc(p.onShutdown[j]))
+		}
+	}
+}
+
 func (p *Plugin) Stop(ctx context.Context) error {
+	p.stopOnce.Do(func() {
+		close(p.stopCh)
+	})
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	p.stopping.Store(true)
+
+	// workers serve the traffic, no server calls the hooks
+	if len(p.servers) == 0 {
+		for i := 0; i < len(p.onShutdown); i++ {
+			go p.onShutdown[i].once.Do(p.onShutdown[i].f)
+		}
+	}
+
 	doneCh := make(chan struct{}, 1)
 
 	go func() {
@@ -107,6 +534,42 @@ func (p *Plugin) Stop(ctx context.Context) error {
 				p.servers[i].Stop()
 			}
 		}
+		p.closeListeners(nil)
+		if p.ban != nil {
+			p.ban.Stop()
+		}
+		if p.rateLimit != nil {
+			p.rateLimit.Stop()
+		}
+
+		if p.geoip != nil {
+			p.geoip.Stop()
+		}
+		if p.jwt != nil {
+			p.jwt.Stop()
+		}
+		if p.proxy != nil {
+			p.proxy.Stop()
+		}
+		if p.inspector != nil {
+			p.inspector.Stop()
+		}
+		if p.exporter != nil {
+			p.exporter.Stop()
+		}
+		if p.supervisor != nil {
+			p.supervisor.Stop()
+		}
+		// the servers are stopped, the last entries are shipped
+		if p.sinks != nil {
+			p.sinks.Stop()
+		}
+		if p.audit != nil {
+			p.audit.Stop()
+		}
+		for i := 0; i < len(p.redis); i++ {
+			p.redis[i].Close()
+		}
 		doneCh <- struct{}{}
 	}()
 
@@ -119,17 +582,82 @@ func (p *Plugin) Stop(ctx context.Context) error {
 }
 
 func (p *Plugin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	p.mu.RLock()
-	p.handler.ServeHTTP(w, r)
-	p.mu.RUnlock()
+	p.root.ServeHTTP(w, r)
 
 	_ = r.Body.Close()
 }
 
+// SetHandler replaces the default handler at runtime, the in-flight requests are served by the previous one.
+// Nil handler restores the default status responses.
+func (p *Plugin) SetHandler(handler http.Handler) {
+	p.collected.Store(handler != nil || len(p.mounts) > 0)
+	if handler == nil {
+		handler = defaultHandler(p.cfg.DefaultStatus)
+	}
+
+	p.handler.Store(&handler)
+}
+
+func (p *Plugin) serveHandler(w http.ResponseWriter, r *http.Request) {
+	(*p.handler.Load()).ServeHTTP(w, r)
+}
+
 func (p *Plugin) Name() string {
 	return PluginName
 }
 
+// PurgeCache invalidates the cached responses with the path prefix and returns the number of purged entries.
+func (p *Plugin) PurgeCache(prefix string) int {
+	if p.cache == nil {
+		return 0
+	}
+
+	return p.cache.Purge(prefix)
+}
+
+// PurgeCacheTags invalidates the cached responses tagged (Surrogate-Key) with any of the tags.
+func (p *Plugin) PurgeCacheTags(tags ...string) int {
+	if p.cache == nil {
+		return 0
+	}
+
+	return p.cache.PurgeTags(tags...)
+}
+
+// PurgeCacheAll invalidates every cached response.
+func (p *Plugin) PurgeCacheAll() int {
+	if p.cache == nil {
+		return 0
+	}
+
+	return p.cache.PurgeAll()
+}
+
+// Stats returns the runtime statistics of every server
+func (p *Plugin) Stats() []ServerStats {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	out := make([]ServerStats, 0, len(p.servers))
+	for i := 0; i < len(p.servers); i++ {
+		out = append(out, ServerStats{
+			ServerInfo: ServerInfo{
+				Name:    p.servers[i].Name(),
+				Address: p.servers[i].Address(),
+				State:   string(p.servers[i].State()),
+			},
+			Snapshot: p.servers[i].Stats().Snapshot(),
+		})
+	}
+
+	return out
+}
+
+// RPC returns the management service of the servers
+func (p *Plugin) RPC() any {
+	return &rpc{p: p}
+}
+
 func (p *Plugin) Collects() []*dep.In {
 	return []*dep.In{
 		dep.Fits(func(pp interface{}) {
@@ -149,36 +677,664 @@ func (p *Plugin) Collects() []*dep.In {
 			p.mu.Unlock()
 		}, (*middleware.Middlewares)(nil)),
 		dep.Fits(func(pp interface{}) {
-			handler := pp.(http.Handler)
+			handler := pp.(Mountable)
 
 			p.mu.Lock()
-			p.handler = handler
+			p.mounts = append(p.mounts, handler)
 			p.mu.Unlock()
+		}, (*Mountable)(nil)),
+		dep.Fits(func(pp interface{}) {
+			handler := pp.(NamedHandler)
+
+			p.mu.Lock()
+			p.named[handler.HandlerName()] = handler
+			p.mu.Unlock()
+		}, (*NamedHandler)(nil)),
+		dep.Fits(func(pp interface{}) {
+			// mountable and named handlers are collected above
+			switch pp.(type) {
+			case Mountable, NamedHandler:
+				return
+			}
+
+			handler := pp.(http.Handler)
+
+			p.handler.Store(&handler)
 		}, (*http.Handler)(nil)),
+		dep.Fits(func(pp interface{}) {
+			protocol := pp.(muxServer.Protocol)
+
+			p.mu.Lock()
+			p.protocols = append(p.protocols, protocol)
+			p.mu.Unlock()
+		}, (*muxServer.Protocol)(nil)),
+		dep.Fits(func(pp interface{}) {
+			signer := pp.(httpsServer.SignerProvider)
+
+			p.mu.Lock()
+			p.signer = signer
+			p.mu.Unlock()
+		}, (*httpsServer.SignerProvider)(nil)),
+		dep.Fits(func(pp interface{}) {
+			configurer := pp.(httpsServer.TLSConfigurer)
+
+			p.mu.Lock()
+			p.tlsCfg = append(p.tlsCfg, configurer)
+			p.mu.Unlock()
+		}, (*httpsServer.TLSConfigurer)(nil)),
+		dep.Fits(func(pp interface{}) {
+			provider := pp.(listener.Provider)
+
+			p.mu.Lock()
+			p.listeners[provider.Name()] = provider
+			p.mu.Unlock()
+		}, (*listener.Provider)(nil)),
+		dep.Fits(func(pp interface{}) {
+			storage := pp.(httpsServer.StorageProvider)
+
+			p.mu.Lock()
+			p.storage[storage.Name()] = storage
+			p.mu.Unlock()
+		}, (*httpsServer.StorageProvider)(nil)),
+		dep.Fits(func(pp interface{}) {
+			dns := pp.(httpsServer.DNSProvider)
+
+			p.mu.Lock()
+			p.dns[dns.Name()] = dns
+			p.mu.Unlock()
+		}, (*httpsServer.DNSProvider)(nil)),
+		dep.Fits(func(pp interface{}) {
+			listener := pp.(httpsServer.CertificateEventListener)
+
+			p.mu.Lock()
+			p.events = append(p.events, listener)
+			p.mu.Unlock()
+		}, (*httpsServer.CertificateEventListener)(nil)),
+		dep.Fits(func(pp interface{}) {
+			provider := pp.(middleware.QuotaProvider)
+
+			// collected after the Init, the quotas are not used without the rate_limit config
+			p.mu.Lock()
+			if p.rateLimit != nil {
+				p.rateLimit.AddQuotaProvider(provider)
+			}
+			p.mu.Unlock()
+		}, (*middleware.QuotaProvider)(nil)),
+		dep.Fits(func(pp interface{}) {
+			listener := pp.(events.Listener)
+
+			p.mu.Lock()
+			p.serverEvents = append(p.serverEvents, listener)
+			p.mu.Unlock()
+		}, (*events.Listener)(nil)),
+	}
+}
+
+// onCertificateEvent sends the ACME lifecycle event to the collected listeners
+func (p *Plugin) onCertificateEvent(event httpsServer.CertificateEvent) {
+	p.log.Debug("certificate event", "type", event.Type, "domain", event.Domain, "error", event.Error)
+
+	for i := 0; i < len(p.events); i++ {
+		p.events[i].OnCertificateEvent(event)
+	}
+}
+
+// onServerEvent sends the server lifecycle event to the collected listeners
+func (p *Plugin) onServerEvent(event events.Event) {
+	p.log.Debug("server event", "type", event.Type, "server", event.Server, "address", event.Address, "error", event.Error)
+
+	for i := 0; i < len(p.serverEvents); i++ {
+		p.serverEvents[i].OnServerEvent(event)
 	}
 }
 
 func (p *Plugin) initServers() error {
+	p.addresses = make(map[string]struct{})
+
+	var plain *httpServer.Server
 	if p.cfg.EnableHTTP() {
-		p.servers = append(p.servers, httpServer.NewHTTPServer(p, p.cfg, p.stdLog, p.log))
+		l, err := p.serverListener(p.cfg.Address)
+		if err != nil {
+			return &ServerError{Server: "http", Address: p.cfg.Address, Err: err}
+		}
+
+		plain = httpServer.NewHTTPServer(p, p.cfg, p.stdLog, p.log, p.httpOptions(l)...)
+		plain.OnEvent(events.ListenerFunc(p.onServerEvent))
+
+		if p.cfg.Multiplex != nil {
+			plain.Multiplex(p.cfg.Multiplex, p.protocols)
+		}
+		p.servers = append(p.servers, plain)
 	}
 
 	if p.cfg.EnableTLS() {
-		https, err := httpsServer.NewHTTPSServer(p, p.cfg.SSL, p.cfg.HTTP2, p.stdLog, p.log, p.zapLog)
+		storage, err := p.acmeStorage()
 		if err != nil {
 			return err
 		}
 
+		dns, err := p.acmeDNSProvider()
+		if err != nil {
+			return err
+		}
+
+		opts := append(p.httpsOptions(), httpsServer.WithACME(storage, dns), httpsServer.WithListenerConfig(p.cfg.Listener))
+
+		// tls-alpn-01 challenges are solved on the port before the server binds it
+		var l net.Listener
+		if !p.cfg.SSL.EnableACME() || strings.HasPrefix(p.cfg.SSL.Address, listener.ProvidedScheme) {
+			l, err = p.serverListener(p.cfg.SSL.Address)
+			if err != nil {
+				return &ServerError{Server: "https", Address: p.cfg.SSL.Address, Err: err}
+			}
+
+			opts = append(opts, httpsServer.WithListener(l))
+		}
+
+		https, err := httpsServer.NewHTTPSServer(p, p.cfg.SSL, p.cfg.HTTP2, p.stdLog, p.log, p.zapLog, opts...)
+		if err != nil {
+			if l != nil {
+				_ = l.Close()
+			}
+
+			return err
+		}
+
+		https.OnEvent(events.ListenerFunc(p.onServerEvent))
+
+		// HTTP-01 challenges are solved on the HTTP listener, no alt_http_port needed
+		if plain != nil && p.cfg.SSL.EnableACME() {
+			plain.ServeACMEChallenges(https.HTTPChallengeHandler)
+			https.ManageAfter(plain.Listening())
+		}
+
 		p.servers = append(p.servers, https)
 	}
 
+	err := p.initNamedServers()
+	if err != nil {
+		return err
+	}
+
+	p.registerShutdown()
+
 	return nil
 }
 
-func (p *Plugin) applyBundledMiddleware() {
-	for i := 0; i < len(p.servers); i++ {
-		serv := p.servers[i].GetServer()
-		serv.Handler = middleware.MaxRequestSize(serv.Handler, p.cfg.MaxRequestSize*MB)
-		serv.Handler = middleware.NewLogMiddleware(serv.Handler, p.log)
+// httpOptions of every plain HTTP server serving the listener
+func (p *Plugin) httpOptions(l net.Listener) []httpServer.Option {
+	return []httpServer.Option{
+		httpServer.WithListener(l),
+		httpServer.WithKeepAlive(p.keepAlive()),
+		httpServer.WithTimeouts(p.timeouts()),
+		httpServer.WithURILimit(p.cfg.URILimit),
+		httpServer.WithStreaming(p.cfg.Streaming),
+		httpServer.WithAuditor(p.auditor()),
+	}
+}
+
+// httpsOptions of every HTTPS server, the listener and the ACME sources are added by the caller
+func (p *Plugin) httpsOptions() []httpsServer.Option {
+	return []httpsServer.Option{
+		httpsServer.WithSigner(p.signer),
+		httpsServer.WithCertificateListener(httpsServer.CertificateEventListenerFunc(p.onCertificateEvent)),
+		httpsServer.WithTLSConfigurers(p.tlsCfg...),
+		httpsServer.WithKeepAlive(httpsServer.KeepAlive(p.keepAlive())),
+		httpsServer.WithTimeouts(httpsServer.Timeouts(p.timeouts())),
+		httpsServer.WithURILimit(p.cfg.URILimit),
+		httpsServer.WithStreaming(p.cfg.Streaming),
+		httpsServer.WithAuditor(p.auditor()),
+	}
+}
+
+// keepAlive settings of every server, the defaults when not configured
+func (p *Plugin) keepAlive() httpServer.KeepAlive {
+	if p.cfg.KeepAlive == nil {
+		return httpServer.KeepAlive{}
+	}
+
+	return httpServer.KeepAlive{
+		Disabled:    p.cfg.KeepAlive.Disable,
+		IdleTimeout: p.cfg.KeepAlive.IdleTimeout,
+		MaxRequests: p.cfg.KeepAlive.MaxRequests,
+	}
+}
+
+// auditor of every server, the interface is nil when the audit log is disabled
+func (p *Plugin) auditor() middleware.Auditor {
+	if p.audit == nil {
+		return nil
+	}
+
+	return p.audit
+}
+
+// timeouts of every server, zero values keep the defaults of the servers
+func (p *Plugin) timeouts() httpServer.Timeouts {
+	if p.cfg.SlowClients == nil {
+		return httpServer.Timeouts{}
+	}
+
+	return httpServer.Timeouts{ReadHeader: p.cfg.SlowClients.HeaderTimeout}
+}
+
+// initNamedServers creates the additional servers, they are sorted by the name
+func (p *Plugin) initNamedServers() error {
+	const op = errors.Op("http_plugin_init_named_servers")
+
+	names := make([]string, 0, len(p.cfg.Servers))
+	for name := range p.cfg.Servers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for i := 0; i < len(names); i++ {
+		cfg := p.cfg.Servers[names[i]]
+
+		var handler http.Handler = p
+		if cfg.Handler != "" {
+			named, ok := p.named[cfg.Handler]
+			if !ok {
+				return errors.E(op, errors.Errorf("handler '%s' of the server '%s' has not been collected", cfg.Handler, names[i]))
+			}
+
+			handler = named
+		}
+
+		if cfg.SSL != nil {
+			l, err := p.serverListener(cfg.SSL.Address)
+			if err != nil {
+				return errors.E(op, &ServerError{Server: names[i], Address: cfg.SSL.Address, Err: err})
+			}
+
+			https, err := httpsServer.NewHTTPSServer(handler, cfg.SSL, p.cfg.HTTP2, p.stdLog, p.log, p.zapLog, append(p.httpsOptions(), httpsServer.WithListener(l))...)
+			if err != nil {
+				_ = l.Close()
+				return errors.E(op, err)
+			}
+
+			https.SetName(names[i])
+			https.OnEvent(events.ListenerFunc(p.onServerEvent))
+
+			p.servers = append(p.servers, https)
+			continue
+		}
+
+		l, err := p.serverListener(cfg.Address)
+		if err != nil {
+			return errors.E(op, &ServerError{Server: names[i], Address: cfg.Address, Err: err})
+		}
+
+		plain := httpServer.NewHTTPServer(handler, &config.Config{Address: cfg.Address, HTTP2: p.cfg.HTTP2}, p.stdLog, p.log, p.httpOptions(l)...)
+		plain.SetName(names[i])
+		plain.OnEvent(events.ListenerFunc(p.onServerEvent))
+
+		p.servers = append(p.servers, plain)
+	}
+
+	return nil
+}
+
+// serverListener returns the handle of the shared listener of the address, the listener is bound once and kept
+// open while the address is used by the servers, so they could be re-created without rebinding it
+func (p *Plugin) serverListener(address string) (net.Listener, error) {
+	const op = errors.Op("http_plugin_server_listener")
+
+	p.addresses[address] = struct{}{}
+	if shared, ok := p.shared[address]; ok {
+		return shared.Handle(), nil
+	}
+
+	l, err := p.providedListener(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if l == nil {
+		l, err = listener.CreateListener(address, p.cfg.Listener)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+
+	// the banned connections never take the slots of the limits
+	if p.ban != nil && p.cfg.Ban.Listener {
+		l = listener.Filter(l, p.ban.BannedIP, p.conns.rejected(address))
+	}
+
+	l = listener.Limit(l, p.cfg.Listener, p.conns.rejected(address))
+
+	shared := listener.NewShared(l)
+	p.shared[address] = shared
+
+	return shared.Handle(), nil
+}
+
+// closeListeners closes the shared listeners of the addresses the servers do not use anymore
+func (p *Plugin) closeListeners(addresses map[string]struct{}) {
+	for address, shared := range p.shared {
+		if _, ok := addresses[address]; ok {
+			continue
+		}
+
+		_ = shared.Close()
+		delete(p.shared, address)
+	}
+}
+
+// providedListener returns the listener of the provided:// address, nil for the other addresses
+func (p *Plugin) providedListener(address string) (net.Listener, error) {
+	const op = errors.Op("http_plugin_provided_listener")
+
+	name, ok := listener.ProvidedName(address)
+	if !ok {
+		return nil, nil
+	}
+
+	provider, ok := p.listeners[name]
+	if !ok {
+		return nil, errors.E(op, errors.Errorf("listener provider '%s' is not registered", name))
+	}
+
+	l, err := provider.Listener()
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	return l, nil
+}
+
+// acmeStorage returns the certificates storage selected by the acme.storage, nil for the file storage
+func (p *Plugin) acmeStorage() (certmagic.Storage, error) {
+	const op = errors.Op("http_plugin_acme_storage")
+
+	if !p.cfg.SSL.EnableACME() || p.cfg.SSL.Acme.Storage == httpsServer.FileStorage {
+		return nil, nil
+	}
+
+	provider, ok := p.storage[p.cfg.SSL.Acme.Storage]
+	if !ok {
+		return nil, errors.E(op, errors.Errorf("acme storage provider '%s' is not registered", p.cfg.SSL.Acme.Storage))
+	}
+
+	storage, err := provider.ACMEStorage()
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	return storage, nil
+}
+
+// acmeDNSProvider returns the dns provider for the dns-01 challenges, nil when it is not configured
+func (p *Plugin) acmeDNSProvider() (certmagic.ACMEDNSProvider, error) {
+	const op = errors.Op("http_plugin_acme_dns_provider")
+
+	if !p.cfg.SSL.EnableACME() || p.cfg.SSL.Acme.DNSProvider == "" {
+		return nil, nil
+	}
+
+	provider, ok := p.dns[p.cfg.SSL.Acme.DNSProvider]
+	if !ok {
+		return nil, errors.E(op, errors.Errorf("acme dns provider '%s' is not registered", p.cfg.SSL.Acme.DNSProvider))
+	}
+
+	return provider.ACMEDNSProvider(), nil
+}
+
+// serverMiddleware returns the user-defined middleware order of the server
+func (p *Plugin) serverMiddleware(server string) []string {
+	if srv, ok := p.cfg.Servers[server]; ok {
+		return srv.Middleware
+	}
+
+	if server == "https" && p.cfg.SSL != nil && len(p.cfg.SSL.Middleware) > 0 {
+		return p.cfg.SSL.Middleware
+	}
+
+	return p.cfg.Middleware
+}
+
+// middlewareOrder returns the user-defined middleware order with the built-in middleware which should wrap
+// the whole chain appended (the last middleware is the outermost one)
+func (p *Plugin) middlewareOrder(listed []string) []string {
+	order := slices.Clone(listed)
+	order = append(order, p.prioritized(order)...)
+
+	// every appended middleware wraps the previous ones, so the last one runs first
+	// proxied routes are served in place of the handler
+	if p.cfg.Proxy != nil && !slices.Contains(order, proxy.MiddlewareName) {
+		order = append(order, proxy.MiddlewareName)
+	}
+
+	// only the requests reaching the handler are mirrored
+	if p.cfg.Mirror != nil && !slices.Contains(order, middleware.MirrorName) {
+		order = append(order, middleware.MirrorName)
+	}
+
+	// in-flight slots are held only by the requests reaching the handler
+	if p.cfg.InFlight != nil && !slices.Contains(order, middleware.InFlightName) {
+		order = append(order, middleware.InFlightName)
+	}
+
+	// cache hits are not counted by the rate limit
+	if p.cfg.RateLimit != nil && !slices.Contains(order, middleware.RateLimitName) {
+		order = append(order, middleware.RateLimitName)
+	}
+
+	// cache stays behind the authentication, the rejected requests never reach it
+	if p.cfg.Cache != nil && !slices.Contains(order, cache.MiddlewareName) {
+		order = append(order, cache.MiddlewareName)
+	}
+
+	if p.cfg.HMAC != nil && !slices.Contains(order, middleware.HMACName) {
+		order = append(order, middleware.HMACName)
+	}
+
+	if p.cfg.SignedURL != nil && !slices.Contains(order, middleware.SignedURLName) {
+		order = append(order, middleware.SignedURLName)
+	}
+
+	if p.cfg.JWT != nil && !slices.Contains(order, middleware.JWTName) {
+		order = append(order, middleware.JWTName)
+	}
+
+	if p.cfg.BasicAuth != nil && !slices.Contains(order, middleware.BasicAuthName) {
+		order = append(order, middleware.BasicAuthName)
+	}
+
+	// geoip goes after the trusted mark and the real client address resolution
+	if p.cfg.GeoIP != nil && !slices.Contains(order, middleware.GeoIPName) {
+		order = append(order, middleware.GeoIPName)
+	}
+
+	// the user agent filter goes after the trusted mark and the real client address resolution as well
+	if p.cfg.UserAgent != nil && !slices.Contains(order, middleware.UserAgentName) {
+		order = append(order, middleware.UserAgentName)
+	}
+
+	// rejected responses get the security headers as well
+	if p.cfg.SecurityHeaders != nil && !slices.Contains(order, middleware.SecurityHeadersName) {
+		order = append(order, middleware.SecurityHeadersName)
+	}
+
+	// maintenance mode goes after the trusted mark, the trusted clients pass through
+	if !slices.Contains(order, middleware.MaintenanceName) {
+		order = append(order, middleware.MaintenanceName)
+	}
+
+	// the honeypot answers the traps before the authentication and the app, the banned clients never reach it
+	if p.cfg.Honeypot != nil && !slices.Contains(order, middleware.HoneypotName) {
+		order = append(order, middleware.HoneypotName)
+	}
+
+	// the ban counts the audit events of every authentication middleware, the trusted clients are never banned
+	if p.cfg.Ban != nil && !slices.Contains(order, middleware.BanName) {
+		order = append(order, middleware.BanName)
+	}
+
+	// trusted mark should be visible to every middleware, unless positioned explicitly
+	if p.cfg.TrustedClients != nil && !slices.Contains(order, middleware.TrustedClientsName) {
+		order = append(order, middleware.TrustedClientsName)
 	}
+
+	// inspector captures the request as it came from the client
+	if p.cfg.Inspector != nil && !slices.Contains(order, inspector.MiddlewareName) {
+		order = append(order, inspector.MiddlewareName)
+	}
+
+	// the body reads of every middleware (inspector, hmac, mirror, proxy) are protected
+	if p.cfg.SlowClients != nil && !slices.Contains(order, middleware.SlowClientsName) {
+		order = append(order, middleware.SlowClientsName)
+	}
+
+	// every middleware should see the client address, not the load balancer one
+	if len(p.cfg.TrustedSubnets) > 0 && !slices.Contains(order, middleware.RealIPName) {
+		order = append(order, middleware.RealIPName)
+	}
+
+	// error pages replace the rejections of every middleware
+	if p.cfg.ErrorPages != nil && !slices.Contains(order, middleware.ErrorPagesName) {
+		order = append(order, middleware.ErrorPagesName)
+	}
+
+	// the capture records the responses as the client gets them
+	if p.cfg.Capture != nil && !slices.Contains(order, capture.MiddlewareName) {
+		order = append(order, capture.MiddlewareName)
+	}
+
+	return order
+}
+
+// prioritized returns the collected middleware with the priority which is not in the order, the lowest priority first
+func (p *Plugin) prioritized(order []string) []string {
+	type entry struct {
+		name     string
+		priority int
+	}
+
+	entries := make([]entry, 0)
+	for name, m := range p.mdwr {
+		pr, ok := m.(middleware.Prioritized)
+		if !ok || slices.Contains(order, name) {
+			continue
+		}
+
+		entries = append(entries, entry{name: name, priority: pr.Priority()})
+	}
+
+	slices.SortFunc(entries, func(a, b entry) int {
+		if a.priority != b.priority {
+			return cmp.Compare(a.priority, b.priority)
+		}
+
+		return strings.Compare(a.name, b.name)
+	})
+
+	names := make([]string, 0, len(entries))
+	for i := 0; i < len(entries); i++ {
+		names = append(names, entries[i].name)
+	}
+
+	return names
+}
+
+// applyBundledMiddleware wraps the server handler with the bundled middleware which is not in the order, the
+// returned middleware set contains the listed ones. The unlisted access log is returned in the order as the
+// outermost middleware, it should see the rejections of the server level middleware as well.
+func (p *Plugin) applyBundledMiddleware(srv internalServer, order []string) (map[string]middleware.Middleware, []string) {
+	serv := srv.GetServer()
+	if p.cfg.BodySpool != nil {
+		serv.Handler = middleware.SpoolRequestBody(serv.Handler, int64(p.cfg.BodySpool.Threshold*MB), p.cfg.BodySpool.Dir)
+	}
+
+	bundled := make([]*bundledMiddleware, 0, 2)
+
+	if p.cfg.Bundled == nil || !p.cfg.Bundled.DisableMaxRequestSize {
+		overrides := make([]middleware.RequestSizeLimit, len(p.cfg.MaxRequestSizeOverrides))
+		for i := 0; i < len(overrides); i++ {
+			overrides[i] = p.cfg.MaxRequestSizeOverrides[i]
+			overrides[i].Size *= MB
+		}
+
+		// the middleware is built in the server goroutine, the config is captured before the next Reset changes it
+		size := p.cfg.MaxRequestSize * MB
+		bundled = append(bundled, &bundledMiddleware{name: middleware.MaxRequestSizeName, wrap: func(next http.Handler) http.Handler {
+			return middleware.MaxRequestSize(next, size, overrides, p.log, p.tooLarge)
+		}})
+	}
+
+	if p.cfg.Bundled == nil || !p.cfg.Bundled.DisableAccessLog {
+		// the access logger is swapped by the Reset
+		p.mu.RLock()
+		accessLog := p.accessLog
+		p.mu.RUnlock()
+		logCfg := p.cfg.AccessLog
+
+		bundled = append(bundled, &bundledMiddleware{
+			name:      middleware.AccessLogName,
+			outermost: !slices.Contains(order, middleware.AccessLogName),
+			wrap: func(next http.Handler) http.Handler {
+				return middleware.NewLogMiddleware(next, accessLog, logCfg, srv.Stats(), p.latency)
+			},
+		})
+	}
+
+	mdwr := maps.Clone(p.mdwr)
+	for i := 0; i < len(bundled); i++ {
+		if bundled[i].outermost {
+			mdwr[bundled[i].Name()] = bundled[i]
+			order = append(order, bundled[i].Name())
+			continue
+		}
+
+		if slices.Contains(order, bundled[i].Name()) {
+			mdwr[bundled[i].Name()] = bundled[i]
+			continue
+		}
+
+		serv.Handler = bundled[i].Middleware(serv.Handler)
+	}
+
+	return mdwr, order
+}
+
+// newAccessLog returns the access logger writing to the plugin logger and the configured sinks
+func (p *Plugin) newAccessLog(cfg *middleware.AccessLogConfig) (*slog.Logger, *logsink.Sinks) {
+	if cfg == nil || len(cfg.Sinks) == 0 {
+		return p.log, nil
+	}
+
+	if p.sinkDropped == nil {
+		p.sinkDropped = p.metrics.Counter("http_access_log_dropped_total", "Access log entries dropped by the sinks (full buffer, delivery error).", "sink", "reason")
+	}
+
+	sinks := logsink.New(cfg.Sinks, p.sinkDropped, p.log)
+
+	return slog.New(sinks.Handler(p.log.Handler())), sinks
+}
+
+// bundledMiddleware is the per server instance of the bundled middleware
+type bundledMiddleware struct {
+	name      string
+	outermost bool
+	wrap      func(next http.Handler) http.Handler
+}
+
+func (b *bundledMiddleware) Name() string {
+	return b.name
+}
+
+func (b *bundledMiddleware) Middleware(next http.Handler) http.Handler {
+	return b.wrap(next)
+}
+
+func (b *bundledMiddleware) Outermost() bool {
+	return b.outermost
+}
+
+// defaultHandler answers every request when no handler has been collected
+func defaultHandler(status int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, http.StatusText(status), status)
+	})
 }
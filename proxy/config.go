@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+)
+
+const (
+	RoundRobin      = "round_robin"
+	LeastConnection = "least_conn"
+
+	ProtocolHTTP = "http"
+	ProtocolH2C  = "h2c"
+	// ProtocolFastCGI targets are unix:///path/to.sock or tcp://host:port
+	ProtocolFastCGI = "fastcgi"
+)
+
+type Config struct {
+	// Upstreams are the named pools of the backend addresses.
+	Upstreams map[string]*UpstreamConfig `mapstructure:"upstreams" json:"upstreams,omitempty" bson:"upstreams,omitempty"`
+
+	// Routes by the path prefix matched on the segment boundary of the cleaned path, /api does not match /apix. The
+	// longest matching one is used, unmatched requests go to the handler.
+	Routes []RouteConfig `mapstructure:"routes" json:"routes,omitempty" bson:"routes,omitempty"`
+}
+
+type RouteConfig struct {
+	Path string `mapstructure:"path" json:"path,omitempty" bson:"path,omitempty"`
+
+	// Upstream name.
+	Upstream string `mapstructure:"upstream" json:"upstream,omitempty" bson:"upstream,omitempty"`
+
+	// StripPrefix removes the route path before forwarding.
+	StripPrefix bool `mapstructure:"strip_prefix" json:"strip_prefix,omitempty" bson:"strip_prefix,omitempty"`
+}
+
+type UpstreamConfig struct {
+	// Targets are the backend URLs, e.g. http://10.0.0.1:8080.
+	Targets []string `mapstructure:"targets" json:"targets,omitempty" bson:"targets,omitempty"`
+
+	// Protocol is http (HTTP/1.1, HTTP/2 over TLS when negotiated), h2c for the plaintext HTTP/2 (gRPC) upstreams
+	// or fastcgi (PHP-FPM). Default: http.
+	Protocol string `mapstructure:"protocol" json:"protocol,omitempty" bson:"protocol,omitempty"`
+
+	// FastCGI options of the fastcgi protocol.
+	FastCGI *FastCGIConfig `mapstructure:"fastcgi" json:"fastcgi,omitempty" bson:"fastcgi,omitempty"`
+
+	// Balancing is round_robin or least_conn. Default: round_robin.
+	Balancing string `mapstructure:"balancing" json:"balancing,omitempty" bson:"balancing,omitempty"`
+
+	// MaxFails in a row marks the target as down for FailTimeout. Default: 3.
+	MaxFails int `mapstructure:"max_fails" json:"max_fails,omitempty" bson:"max_fails,omitempty"`
+
+	// FailTimeout of the target marked as down by the failed requests. Default: 30s.
+	FailTimeout time.Duration `mapstructure:"fail_timeout" json:"fail_timeout,omitempty" bson:"fail_timeout,omitempty"`
+
+	// HealthCheck enables the active health checks.
+	HealthCheck *HealthCheckConfig `mapstructure:"health_check" json:"health_check,omitempty" bson:"health_check,omitempty"`
+}
+
+type HealthCheckConfig struct {
+	// Path requested on every target. Default: /health.
+	Path string `mapstructure:"path" json:"path,omitempty" bson:"path,omitempty"`
+
+	// Interval between the checks. Default: 10s.
+	Interval time.Duration `mapstructure:"interval" json:"interval,omitempty" bson:"interval,omitempty"`
+
+	// Timeout of the check. Default: 2s.
+	Timeout time.Duration `mapstructure:"timeout" json:"timeout,omitempty" bson:"timeout,omitempty"`
+
+	// Status expected, any 2xx when 0.
+	Status int `mapstructure:"status" json:"status,omitempty" bson:"status,omitempty"`
+}
+
+func (c *Config) InitDefaults() error {
+	const op = errors.Op("proxy_config")
+
+	for name, upstream := range c.Upstreams {
+		if upstream == nil {
+			return errors.E(op, errors.Errorf("upstream %s should have targets", name))
+		}
+
+		err := upstream.InitDefaults()
+		if err != nil {
+			return errors.E(op, errors.Errorf("upstream %s: %v", name, err))
+		}
+	}
+
+	for i := 0; i < len(c.Routes); i++ {
+		if !strings.HasPrefix(c.Routes[i].Path, "/") {
+			return errors.E(op, errors.Errorf("route path should start with /, got %q", c.Routes[i].Path))
+		}
+
+		if _, ok := c.Upstreams[c.Routes[i].Upstream]; !ok {
+			return errors.E(op, errors.Errorf("route %s: unknown upstream %q", c.Routes[i].Path, c.Routes[i].Upstream))
+		}
+	}
+
+	return nil
+}
+
+func (c *UpstreamConfig) InitDefaults() error {
+	if c.Protocol == "" {
+		c.Protocol = ProtocolHTTP
+	}
+
+	if c.Balancing == "" {
+		c.Balancing = RoundRobin
+	}
+
+	if c.MaxFails == 0 {
+		c.MaxFails = 3
+	}
+
+	if c.FailTimeout == 0 {
+		c.FailTimeout = 30 * time.Second
+	}
+
+	if c.HealthCheck != nil {
+		if c.HealthCheck.Path == "" {
+			c.HealthCheck.Path = "/health"
+		}
+
+		if c.HealthCheck.Interval == 0 {
+			c.HealthCheck.Interval = 10 * time.Second
+		}
+
+		if c.HealthCheck.Timeout == 0 {
+			c.HealthCheck.Timeout = 2 * time.Second
+		}
+	}
+
+	switch c.Protocol {
+	case ProtocolHTTP, ProtocolH2C:
+	case ProtocolFastCGI:
+		if c.FastCGI == nil {
+			c.FastCGI = &FastCGIConfig{}
+		}
+
+		err := c.FastCGI.InitDefaults()
+		if err != nil {
+			return err
+		}
+	default:
+		return errors.Errorf("unknown protocol %q, should be http, h2c or fastcgi", c.Protocol)
+	}
+
+	switch c.Balancing {
+	case RoundRobin, LeastConnection:
+	default:
+		return errors.Errorf("unknown balancing %q, should be round_robin or least_conn", c.Balancing)
+	}
+
+	if len(c.Targets) == 0 {
+		return errors.Str("at least one target should be set")
+	}
+
+	for i := 0; i < len(c.Targets); i++ {
+		u, err := url.Parse(c.Targets[i])
+		if err != nil {
+			return err
+		}
+
+		if c.Protocol == ProtocolFastCGI {
+			if (u.Scheme != "unix" || u.Path == "") && (u.Scheme != "tcp" || u.Host == "") {
+				return errors.Errorf("fastcgi target should be unix:///path or tcp://host:port, got %q", c.Targets[i])
+			}
+			continue
+		}
+
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.Errorf("target should be the http(s) URL, got %q", c.Targets[i])
+		}
+
+		if c.Protocol == ProtocolH2C && u.Scheme != "http" {
+			return errors.Errorf("h2c target should be the plaintext http URL, got %q", c.Targets[i])
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,382 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/textproto"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+)
+
+// FastCGI record types
+const (
+	fcgiBeginRequest uint8 = 1
+	fcgiEndRequest   uint8 = 3
+	fcgiParams       uint8 = 4
+	fcgiStdin        uint8 = 5
+	fcgiStdout       uint8 = 6
+	fcgiStderr       uint8 = 7
+
+	fcgiVersion   uint8  = 1
+	fcgiResponder uint16 = 1
+	fcgiRequestID uint16 = 1
+
+	fcgiMaxContent = 65535
+)
+
+type FastCGIConfig struct {
+	// Root is the document root, SCRIPT_FILENAME is resolved against it.
+	Root string `mapstructure:"root" json:"root,omitempty" bson:"root,omitempty"`
+
+	// Index script of the directory requests. Default: index.php.
+	Index string `mapstructure:"index" json:"index,omitempty" bson:"index,omitempty"`
+
+	// SplitPath separates the script name and PATH_INFO. Default: .php.
+	SplitPath string `mapstructure:"split_path" json:"split_path,omitempty" bson:"split_path,omitempty"`
+
+	// Params override or extend the CGI parameters.
+	Params map[string]string `mapstructure:"params" json:"params,omitempty" bson:"params,omitempty"`
+
+	// DialTimeout of the FastCGI connection. Default: 5s.
+	DialTimeout time.Duration `mapstructure:"dial_timeout" json:"dial_timeout,omitempty" bson:"dial_timeout,omitempty"`
+
+	// ReadTimeout of the response. Default: 60s.
+	ReadTimeout time.Duration `mapstructure:"read_timeout" json:"read_timeout,omitempty" bson:"read_timeout,omitempty"`
+
+	// WriteTimeout of the request. Default: 60s.
+	WriteTimeout time.Duration `mapstructure:"write_timeout" json:"write_timeout,omitempty" bson:"write_timeout,omitempty"`
+}
+
+func (c *FastCGIConfig) InitDefaults() error {
+	if c.Index == "" {
+		c.Index = "index.php"
+	}
+
+	if c.SplitPath == "" {
+		c.SplitPath = ".php"
+	}
+
+	if c.DialTimeout == 0 {
+		c.DialTimeout = 5 * time.Second
+	}
+
+	if c.ReadTimeout == 0 {
+		c.ReadTimeout = 60 * time.Second
+	}
+
+	if c.WriteTimeout == 0 {
+		c.WriteTimeout = 60 * time.Second
+	}
+
+	if c.Root == "" {
+		return errors.Str("fastcgi root should be set")
+	}
+
+	return nil
+}
+
+// fastCGI serves the route by the FastCGI responders (PHP-FPM), one connection per request
+type fastCGI struct {
+	rt  *route
+	cfg *FastCGIConfig
+	log *slog.Logger
+}
+
+func (f *fastCGI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t := r.Context().Value(targetCtx).(*target)
+
+	err := f.serve(w, r, t)
+	if err == nil {
+		f.rt.pool.succeeded(t)
+		return
+	}
+
+	// the client went away, not the upstream failure
+	if r.Context().Err() == nil {
+		f.rt.pool.failed(t, err)
+	}
+
+	f.log.Error("fastcgi request", "upstream", f.rt.pool.name, "path", r.URL.Path, "error", err)
+	http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+}
+
+func (f *fastCGI) serve(w http.ResponseWriter, r *http.Request, t *target) error {
+	// CONTENT_LENGTH is required, the chunked bodies are read ahead
+	body := io.Reader(r.Body)
+	length := r.ContentLength
+	if r.Body == nil || r.Body == http.NoBody {
+		body, length = http.NoBody, 0
+	} else if length < 0 {
+		buf, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		body, length = bytes.NewReader(buf), int64(len(buf))
+	}
+
+	network, address := "tcp", t.url.Host
+	if t.url.Scheme == "unix" {
+		network, address = "unix", t.url.Path
+	}
+
+	dialer := &net.Dialer{Timeout: f.cfg.DialTimeout}
+	conn, err := dialer.DialContext(r.Context(), network, address)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	// the request is canceled with the client
+	stop := context.AfterFunc(r.Context(), func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	_ = conn.SetWriteDeadline(time.Now().Add(f.cfg.WriteTimeout))
+	fw := &fcgiWriter{w: bufio.NewWriter(conn)}
+
+	begin := make([]byte, 8)
+	binary.BigEndian.PutUint16(begin, fcgiResponder)
+	fw.record(fcgiBeginRequest, begin)
+	fw.stream(fcgiParams, bytes.NewReader(encodeParams(f.params(r, length))))
+	fw.stream(fcgiStdin, body)
+	if fw.err == nil {
+		fw.err = fw.w.Flush()
+	}
+
+	if fw.err != nil {
+		return fw.err
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(f.cfg.ReadTimeout))
+	pr, pw := io.Pipe()
+	go f.read(conn, pw, r.URL.Path)
+
+	err = writeCGIResponse(w, pr)
+	_ = pr.Close()
+	return err
+}
+
+// read demultiplexes the records, stdout goes to the pipe, stderr to the log
+func (f *fastCGI) read(conn net.Conn, pw *io.PipeWriter, path string) {
+	br := bufio.NewReader(conn)
+	header := make([]byte, 8)
+	for {
+		_, err := io.ReadFull(br, header)
+		if err != nil {
+			_ = pw.CloseWithError(err)
+			return
+		}
+
+		typ := header[1]
+		content := make([]byte, binary.BigEndian.Uint16(header[4:6]))
+		padding := int(header[6])
+
+		_, err = io.ReadFull(br, content)
+		if err == nil {
+			_, err = br.Discard(padding)
+		}
+		if err != nil {
+			_ = pw.CloseWithError(err)
+			return
+		}
+
+		switch typ {
+		case fcgiStdout:
+			if len(content) > 0 {
+				_, err = pw.Write(content)
+				if err != nil {
+					return
+				}
+			}
+		case fcgiStderr:
+			if len(content) > 0 {
+				f.log.Warn("fastcgi stderr", "upstream", f.rt.pool.name, "path", path, "message", strings.TrimSpace(string(content)))
+			}
+		case fcgiEndRequest:
+			_ = pw.Close()
+			return
+		}
+	}
+}
+
+func (f *fastCGI) params(r *http.Request, length int64) map[string]string {
+	urlPath := r.URL.Path
+	if f.rt.strip {
+		urlPath = "/" + strings.TrimPrefix(strings.TrimPrefix(urlPath, f.rt.prefix), "/")
+	}
+
+	if strings.HasSuffix(urlPath, "/") {
+		urlPath += f.cfg.Index
+	}
+
+	scriptName, pathInfo := urlPath, ""
+	if i := strings.Index(strings.ToLower(urlPath), f.cfg.SplitPath); i != -1 {
+		scriptName, pathInfo = urlPath[:i+len(f.cfg.SplitPath)], urlPath[i+len(f.cfg.SplitPath):]
+	}
+
+	remoteAddr, remotePort, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteAddr = r.RemoteAddr
+	}
+
+	serverName, serverPort, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		serverName = r.Host
+		serverPort = "80"
+		if r.TLS != nil {
+			serverPort = "443"
+		}
+	}
+
+	params := map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"SERVER_SOFTWARE":   "rumorshub/http",
+		"SERVER_PROTOCOL":   r.Proto,
+		"SERVER_NAME":       serverName,
+		"SERVER_PORT":       serverPort,
+		"REQUEST_METHOD":    r.Method,
+		"REQUEST_URI":       r.URL.RequestURI(),
+		"QUERY_STRING":      r.URL.RawQuery,
+		"DOCUMENT_ROOT":     f.cfg.Root,
+		"DOCUMENT_URI":      urlPath,
+		"SCRIPT_NAME":       scriptName,
+		"SCRIPT_FILENAME":   path.Join(f.cfg.Root, scriptName),
+		"PATH_INFO":         pathInfo,
+		"REMOTE_ADDR":       remoteAddr,
+		"REMOTE_PORT":       remotePort,
+		"CONTENT_TYPE":      r.Header.Get("Content-Type"),
+		"CONTENT_LENGTH":    strconv.FormatInt(length, 10),
+	}
+
+	if r.TLS != nil {
+		params["HTTPS"] = "on"
+	}
+
+	for name, values := range r.Header {
+		// httpoxy, the Proxy header should never become HTTP_PROXY
+		if name == "Proxy" || name == "Content-Type" || name == "Content-Length" {
+			continue
+		}
+
+		params["HTTP_"+strings.ToUpper(strings.ReplaceAll(name, "-", "_"))] = strings.Join(values, ", ")
+	}
+
+	for name, value := range f.cfg.Params {
+		params[name] = value
+	}
+
+	return params
+}
+
+// writeCGIResponse translates the CGI response (headers with the Status one, then the body)
+func writeCGIResponse(w http.ResponseWriter, body io.Reader) error {
+	br := bufio.NewReader(body)
+	header, err := textproto.NewReader(br).ReadMIMEHeader()
+	if err != nil {
+		return errors.Errorf("invalid CGI response headers: %v", err)
+	}
+
+	status := http.StatusOK
+	if value := header.Get("Status"); value != "" {
+		status, err = strconv.Atoi(strings.SplitN(value, " ", 2)[0])
+		if err != nil || status < 100 || status > 999 {
+			return errors.Errorf("invalid CGI response status: %q", value)
+		}
+	} else if header.Get("Location") != "" {
+		status = http.StatusFound
+	}
+	header.Del("Status")
+
+	h := w.Header()
+	for name, values := range header {
+		h[name] = values
+	}
+
+	w.WriteHeader(status)
+	// the headers are sent, the body errors are not reported to the client
+	_, _ = io.Copy(w, br)
+	return nil
+}
+
+type fcgiWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (fw *fcgiWriter) record(typ uint8, content []byte) {
+	if fw.err != nil {
+		return
+	}
+
+	padding := uint8(-len(content) & 7)
+	header := [8]byte{fcgiVersion, typ}
+	binary.BigEndian.PutUint16(header[2:4], fcgiRequestID)
+	binary.BigEndian.PutUint16(header[4:6], uint16(len(content)))
+	header[6] = padding
+
+	_, fw.err = fw.w.Write(header[:])
+	if fw.err == nil {
+		_, fw.err = fw.w.Write(content)
+	}
+	if fw.err == nil {
+		_, fw.err = fw.w.Write(make([]byte, padding))
+	}
+}
+
+// stream writes the reader in records terminated with the empty one
+func (fw *fcgiWriter) stream(typ uint8, r io.Reader) {
+	buf := make([]byte, fcgiMaxContent)
+	for fw.err == nil {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			fw.record(typ, buf[:n])
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+
+		if err != nil {
+			fw.err = err
+			return
+		}
+	}
+
+	fw.record(typ, nil)
+}
+
+func encodeParams(params map[string]string) []byte {
+	var buf bytes.Buffer
+	for name, value := range params {
+		writeParamLength(&buf, len(name))
+		writeParamLength(&buf, len(value))
+		buf.WriteString(name)
+		buf.WriteString(value)
+	}
+
+	return buf.Bytes()
+}
+
+func writeParamLength(buf *bytes.Buffer, n int) {
+	if n < 128 {
+		buf.WriteByte(byte(n))
+		return
+	}
+
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(n)|1<<31)
+	buf.Write(b[:])
+}
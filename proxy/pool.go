@@ -0,0 +1,213 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+type target struct {
+	url *url.URL
+
+	active    atomic.Int64
+	fails     atomic.Int64
+	downUntil atomic.Int64
+	// unhealthy by the active checks
+	unhealthy atomic.Bool
+}
+
+func (t *target) available(now int64) bool {
+	return !t.unhealthy.Load() && t.downUntil.Load() <= now
+}
+
+// pool balances the requests over the upstream targets
+type pool struct {
+	name    string
+	cfg     *UpstreamConfig
+	log     *slog.Logger
+	targets []*target
+	next    atomic.Uint64
+
+	// transport is nil for the fastcgi pools
+	transport http.RoundTripper
+	client    *http.Client
+	stopCh    chan struct{}
+	wg        sync.WaitGroup
+}
+
+func newPool(name string, cfg *UpstreamConfig, log *slog.Logger) (*pool, error) {
+	p := &pool{
+		name:      name,
+		cfg:       cfg,
+		log:       log,
+		transport: newTransport(cfg.Protocol),
+		stopCh:    make(chan struct{}),
+	}
+
+	for i := 0; i < len(cfg.Targets); i++ {
+		u, err := url.Parse(cfg.Targets[i])
+		if err != nil {
+			return nil, err
+		}
+
+		p.targets = append(p.targets, &target{url: u})
+	}
+
+	if cfg.HealthCheck != nil {
+		p.client = &http.Client{
+			Transport: p.transport,
+			Timeout:   cfg.HealthCheck.Timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+
+		p.wg.Add(1)
+		go p.check()
+	}
+
+	return p, nil
+}
+
+func newTransport(protocol string) http.RoundTripper {
+	switch protocol {
+	case ProtocolFastCGI:
+		return nil
+	case ProtocolH2C:
+		// plaintext HTTP/2 with the prior knowledge, gRPC does not upgrade
+		return &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		}
+	default:
+		return http.DefaultTransport.(*http.Transport).Clone()
+	}
+}
+
+// pick returns the available target, nil when every target is down
+func (p *pool) pick() *target {
+	now := time.Now().UnixNano()
+
+	if p.cfg.Balancing == LeastConnection {
+		var best *target
+		for i := 0; i < len(p.targets); i++ {
+			t := p.targets[i]
+			if t.available(now) && (best == nil || t.active.Load() < best.active.Load()) {
+				best = t
+			}
+		}
+
+		return best
+	}
+
+	n := uint64(len(p.targets))
+	start := p.next.Add(1) - 1
+	for i := uint64(0); i < n; i++ {
+		t := p.targets[(start+i)%n]
+		if t.available(now) {
+			return t
+		}
+	}
+
+	return nil
+}
+
+// failed is the passive failure detection, MaxFails in a row take the target down for FailTimeout
+func (p *pool) failed(t *target, err error) {
+	if t.fails.Add(1) < int64(p.cfg.MaxFails) {
+		return
+	}
+
+	t.fails.Store(0)
+	t.downUntil.Store(time.Now().Add(p.cfg.FailTimeout).UnixNano())
+	p.log.Warn("upstream target is down", "upstream", p.name, "target", t.url.Host, "error", err, "for", p.cfg.FailTimeout)
+}
+
+func (p *pool) succeeded(t *target) {
+	t.fails.Store(0)
+}
+
+func (p *pool) check() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.cfg.HealthCheck.Interval)
+	defer ticker.Stop()
+
+	for {
+		for i := 0; i < len(p.targets); i++ {
+			p.probe(p.targets[i])
+		}
+
+		select {
+		case <-p.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *pool) probe(t *target) {
+	if p.cfg.Protocol == ProtocolFastCGI {
+		p.dialProbe(t)
+		return
+	}
+
+	u := *t.url
+	u.Path = p.cfg.HealthCheck.Path
+	u.RawQuery = ""
+
+	healthy := false
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, u.String(), nil)
+	if err == nil {
+		var resp *http.Response
+		resp, err = p.client.Do(req)
+		if err == nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+
+			if p.cfg.HealthCheck.Status != 0 {
+				healthy = resp.StatusCode == p.cfg.HealthCheck.Status
+			} else {
+				healthy = resp.StatusCode >= 200 && resp.StatusCode < 300
+			}
+		}
+	}
+
+	if t.unhealthy.Swap(!healthy) == healthy {
+		p.log.Info("upstream target health changed", "upstream", p.name, "target", t.url.Host, "healthy", healthy, "error", err)
+	}
+}
+
+// dialProbe checks the FastCGI targets, they do not speak HTTP
+func (p *pool) dialProbe(t *target) {
+	network, address := "tcp", t.url.Host
+	if t.url.Scheme == "unix" {
+		network, address = "unix", t.url.Path
+	}
+
+	conn, err := net.DialTimeout(network, address, p.cfg.HealthCheck.Timeout)
+	if err == nil {
+		_ = conn.Close()
+	}
+
+	healthy := err == nil
+	if t.unhealthy.Swap(!healthy) == healthy {
+		p.log.Info("upstream target health changed", "upstream", p.name, "target", t.url.String(), "healthy", healthy, "error", err)
+	}
+}
+
+func (p *pool) stop() {
+	close(p.stopCh)
+	p.wg.Wait()
+}
@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"sort"
+	"strings"
+
+	"github.com/roadrunner-server/errors"
+
+	"github.com/rumorshub/http/middleware"
+)
+
+const MiddlewareName = "proxy"
+
+type contextKey string
+
+const targetCtx contextKey = "proxy_target"
+
+type route struct {
+	prefix string
+	strip  bool
+	pool   *pool
+	proxy  http.Handler
+}
+
+// Proxy forwards the requests matching the routes to the upstream pools, the rest go to the next handler.
+type Proxy struct {
+	log    *slog.Logger
+	routes []*route
+	pools  map[string]*pool
+}
+
+func New(cfg *Config, log *slog.Logger) (*Proxy, error) {
+	const op = errors.Op("proxy_new")
+
+	p := &Proxy{
+		log:   log,
+		pools: make(map[string]*pool, len(cfg.Upstreams)),
+	}
+
+	for name, upstream := range cfg.Upstreams {
+		pl, err := newPool(name, upstream, log)
+		if err != nil {
+			p.Stop()
+			return nil, errors.E(op, err)
+		}
+
+		p.pools[name] = pl
+	}
+
+	for i := 0; i < len(cfg.Routes); i++ {
+		r := &route{
+			prefix: cfg.Routes[i].Path,
+			strip:  cfg.Routes[i].StripPrefix,
+			pool:   p.pools[cfg.Routes[i].Upstream],
+		}
+		if r.pool.cfg.Protocol == ProtocolFastCGI {
+			r.proxy = &fastCGI{rt: r, cfg: r.pool.cfg.FastCGI, log: log}
+		} else {
+			r.proxy = p.reverseProxy(r)
+		}
+		p.routes = append(p.routes, r)
+	}
+
+	sort.SliceStable(p.routes, func(i, j int) bool {
+		return len(p.routes[i].prefix) > len(p.routes[j].prefix)
+	})
+
+	return p, nil
+}
+
+func (p *Proxy) Name() string {
+	return MiddlewareName
+}
+
+func (p *Proxy) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the routes are matched on the cleaned path segments, the upstream gets the path which has been matched
+		cleaned := middleware.CleanPath(r.URL.Path)
+		rt := p.match(cleaned)
+		if rt == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		t := rt.pool.pick()
+		if t == nil {
+			middleware.Annotate(r, "proxy: no available upstream target")
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			return
+		}
+
+		t.active.Add(1)
+		defer t.active.Add(-1)
+
+		r = r.WithContext(context.WithValue(r.Context(), targetCtx, t))
+		if cleaned != r.URL.Path {
+			u := *r.URL
+			u.Path, u.RawPath = cleaned, ""
+			r.URL = &u
+		}
+
+		rt.proxy.ServeHTTP(w, r)
+	})
+}
+
+func (p *Proxy) Stop() {
+	for _, pl := range p.pools {
+		pl.stop()
+	}
+}
+
+func (p *Proxy) match(path string) *route {
+	for i := 0; i < len(p.routes); i++ {
+		if middleware.SegmentPrefix(path, p.routes[i].prefix) {
+			return p.routes[i]
+		}
+	}
+
+	return nil
+}
+
+func (p *Proxy) reverseProxy(rt *route) *httputil.ReverseProxy {
+	rp := &httputil.ReverseProxy{
+		Transport: rt.pool.transport,
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			t := pr.In.Context().Value(targetCtx).(*target)
+			if rt.strip {
+				pr.Out.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(pr.Out.URL.Path, rt.prefix), "/")
+				pr.Out.URL.RawPath = ""
+			}
+
+			pr.SetURL(t.url)
+			pr.SetXForwarded()
+			pr.Out.Host = pr.In.Host
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			rt.pool.succeeded(resp.Request.Context().Value(targetCtx).(*target))
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			// the client went away, not the upstream failure
+			if r.Context().Err() == nil {
+				rt.pool.failed(r.Context().Value(targetCtx).(*target), err)
+			}
+
+			p.log.Error("proxy request", "upstream", rt.pool.name, "path", r.URL.Path, "error", err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+		ErrorLog: slog.NewLogLogger(p.log.Handler(), slog.LevelDebug),
+	}
+
+	// gRPC streams are flushed on every message, trailers are propagated by the reverse proxy
+	if rt.pool.cfg.Protocol == ProtocolH2C {
+		rp.FlushInterval = -1
+	}
+
+	return rp
+}
@@ -0,0 +1,342 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1" //nolint:gosec
+	"crypto/tls"
+	"encoding/hex"
+	stderrors "errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+)
+
+// Nil is the reply of the missing key
+var Nil = errors.Str("redis: nil")
+
+// Error is the error reply of the server, the connection stays usable
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Client is the minimal RESP2 client with the pool of the connections, enough for the scripts and the plain
+// commands of the rate limit and cache stores. Replies are string, int64, []any or Nil error.
+type Client struct {
+	cfg    *Config
+	dialer *net.Dialer
+	idle   chan *conn
+}
+
+type conn struct {
+	net.Conn
+	rd *bufio.Reader
+	wr *bufio.Writer
+}
+
+// New creates the client, the connections are dialed on demand
+func New(cfg *Config) *Client {
+	return &Client{
+		cfg:    cfg,
+		dialer: &net.Dialer{Timeout: cfg.Timeout, KeepAlive: 30 * time.Second},
+		idle:   make(chan *conn, cfg.PoolSize),
+	}
+}
+
+// Key returns the key with the prefix of the config
+func (c *Client) Key(parts ...string) string {
+	return c.cfg.Prefix + strings.Join(parts, ":")
+}
+
+// Do sends the command and returns the reply
+func (c *Client) Do(ctx context.Context, args ...string) (any, error) {
+	replies, err := c.exec(ctx, [][]string{args})
+	if err != nil {
+		return nil, err
+	}
+
+	if e, ok := replies[0].(error); ok {
+		return nil, e
+	}
+
+	return replies[0], nil
+}
+
+// Pipeline sends the commands at once and returns the replies in the order of the commands. The Error and Nil
+// replies are the values of the result, the error is returned for the connection failures only.
+func (c *Client) Pipeline(ctx context.Context, cmds ...[]string) ([]any, error) {
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+
+	return c.exec(ctx, cmds)
+}
+
+// Script is the Lua script run by EVALSHA, loaded with EVAL once the server does not know it
+type Script struct {
+	src string
+	sha string
+}
+
+func NewScript(src string) *Script {
+	sum := sha1.Sum([]byte(src)) //nolint:gosec
+	return &Script{src: src, sha: hex.EncodeToString(sum[:])}
+}
+
+// Run runs the script with the keys and the arguments
+func (c *Client) Run(ctx context.Context, s *Script, keys []string, args ...string) (any, error) {
+	cmd := make([]string, 0, 3+len(keys)+len(args))
+	cmd = append(cmd, "EVALSHA", s.sha, strconv.Itoa(len(keys)))
+	cmd = append(cmd, keys...)
+	cmd = append(cmd, args...)
+
+	reply, err := c.Do(ctx, cmd...)
+	if e, ok := err.(Error); ok && strings.HasPrefix(string(e), "NOSCRIPT") { //nolint:errorlint
+		cmd[0], cmd[1] = "EVAL", s.src
+		return c.Do(ctx, cmd...)
+	}
+
+	return reply, err
+}
+
+// Close closes the idle connections
+func (c *Client) Close() {
+	for {
+		select {
+		case cn := <-c.idle:
+			_ = cn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// exec sends the commands over the pooled connection. The one closed by the server (idle timeout, restart) fails
+// before the first reply, the commands are sent once again over the new connection.
+func (c *Client) exec(ctx context.Context, cmds [][]string) ([]any, error) {
+	cn, pooled, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	replies, err := cn.exec(ctx, c.cfg.Timeout, cmds)
+	if err != nil && pooled && len(replies) == 0 && closed(err) {
+		_ = cn.Close()
+
+		cn, err = c.dial(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		replies, err = cn.exec(ctx, c.cfg.Timeout, cmds)
+	}
+
+	if err != nil {
+		// the rest of the replies would be read by the next command
+		_ = cn.Close()
+		return nil, err
+	}
+
+	c.put(cn)
+
+	return replies, nil
+}
+
+// closed reports the connection closed by the server
+func closed(err error) bool {
+	return stderrors.Is(err, io.EOF) || stderrors.Is(err, syscall.ECONNRESET) || stderrors.Is(err, syscall.EPIPE)
+}
+
+// get returns the idle connection or dials the new one, pooled reports the idle one
+func (c *Client) get(ctx context.Context) (*conn, bool, error) {
+	select {
+	case cn := <-c.idle:
+		return cn, true, nil
+	default:
+	}
+
+	cn, err := c.dial(ctx)
+
+	return cn, false, err
+}
+
+func (c *Client) dial(ctx context.Context) (*conn, error) {
+	var nc net.Conn
+	var err error
+	if c.cfg.TLS {
+		host, _, _ := net.SplitHostPort(c.cfg.Address)
+		td := &tls.Dialer{NetDialer: c.dialer, Config: &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}}
+		nc, err = td.DialContext(ctx, "tcp", c.cfg.Address)
+	} else {
+		nc, err = c.dialer.DialContext(ctx, "tcp", c.cfg.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cn := &conn{Conn: nc, rd: bufio.NewReader(nc), wr: bufio.NewWriter(nc)}
+
+	if c.cfg.Password != "" {
+		auth := []string{"AUTH", c.cfg.Password}
+		if c.cfg.Username != "" {
+			auth = []string{"AUTH", c.cfg.Username, c.cfg.Password}
+		}
+
+		if err = cn.command(ctx, c.cfg.Timeout, auth); err != nil {
+			_ = nc.Close()
+			return nil, err
+		}
+	}
+
+	if c.cfg.DB != 0 {
+		if err = cn.command(ctx, c.cfg.Timeout, []string{"SELECT", strconv.Itoa(c.cfg.DB)}); err != nil {
+			_ = nc.Close()
+			return nil, err
+		}
+	}
+
+	return cn, nil
+}
+
+// put returns the connection to the pool
+func (c *Client) put(cn *conn) {
+	select {
+	case c.idle <- cn:
+	default:
+		_ = cn.Close()
+	}
+}
+
+// command sends the command of the connection setup, the error reply fails it
+func (cn *conn) command(ctx context.Context, timeout time.Duration, args []string) error {
+	replies, err := cn.exec(ctx, timeout, [][]string{args})
+	if err != nil {
+		return err
+	}
+
+	if e, ok := replies[0].(error); ok {
+		return e
+	}
+
+	return nil
+}
+
+// exec writes the commands with a single flush and reads their replies, the Error and Nil replies are the values.
+// The replies read before the connection failure are returned with the error.
+func (cn *conn) exec(ctx context.Context, timeout time.Duration, cmds [][]string) ([]any, error) {
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	err := cn.SetDeadline(deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < len(cmds); i++ {
+		cn.wr.WriteString("*" + strconv.Itoa(len(cmds[i])) + "\r\n")
+		for j := 0; j < len(cmds[i]); j++ {
+			cn.wr.WriteString("$" + strconv.Itoa(len(cmds[i][j])) + "\r\n")
+			cn.wr.WriteString(cmds[i][j])
+			cn.wr.WriteString("\r\n")
+		}
+	}
+
+	err = cn.wr.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	replies := make([]any, 0, len(cmds))
+	for i := 0; i < len(cmds); i++ {
+		reply, err := cn.read()
+		switch e := err.(type) { //nolint:errorlint
+		case nil:
+			replies = append(replies, reply)
+		case Error:
+			replies = append(replies, e)
+		default:
+			if err == Nil { //nolint:errorlint
+				replies = append(replies, Nil)
+				continue
+			}
+
+			return replies, err
+		}
+	}
+
+	return replies, nil
+}
+
+func (cn *conn) read() (any, error) {
+	line, err := cn.rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.Errorf("redis: malformed reply %q", line)
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		if n < 0 {
+			return nil, Nil
+		}
+
+		buf := make([]byte, n+2)
+		_, err = io.ReadFull(cn.rd, buf)
+		if err != nil {
+			return nil, err
+		}
+
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		if n < 0 {
+			return nil, Nil
+		}
+
+		// the nil elements of the array are kept as nil and the error ones as Error, the rest should be read anyway
+		out := make([]any, n)
+		for i := 0; i < n; i++ {
+			out[i], err = cn.read()
+			if e, ok := err.(Error); ok { //nolint:errorlint
+				out[i] = e
+				continue
+			}
+
+			if err != nil && err != Nil { //nolint:errorlint
+				return nil, err
+			}
+		}
+
+		return out, nil
+	default:
+		return nil, errors.Errorf("redis: unknown reply type %q", line[0])
+	}
+}
@@ -0,0 +1,323 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeRedis answers the commands with the raw RESP replies of the handler
+type fakeRedis struct {
+	ln    net.Listener
+	conns atomic.Int32
+	// batch of the commands read before the replies are written, the pipelined client does not wait for them
+	batch int
+	// reply returns the raw reply of the command, drop closes the connection once the reply is written
+	reply func(cmd []string) (raw string, drop bool)
+
+	mu       sync.Mutex
+	commands [][]string
+}
+
+func newFakeRedis(t *testing.T, batch int, reply func(cmd []string) (string, bool)) *fakeRedis {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeRedis{ln: ln, batch: batch, reply: reply}
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			f.conns.Add(1)
+			go f.serve(c)
+		}
+	}()
+
+	return f
+}
+
+func (f *fakeRedis) serve(c net.Conn) {
+	defer func() {
+		_ = c.Close()
+	}()
+
+	rd := bufio.NewReader(c)
+	var out strings.Builder
+	pending := 0
+	for {
+		cmd, err := readCommand(rd)
+		if err != nil {
+			return
+		}
+
+		f.mu.Lock()
+		f.commands = append(f.commands, cmd)
+		f.mu.Unlock()
+
+		raw, drop := f.reply(cmd)
+		out.WriteString(raw)
+		pending++
+
+		if pending < f.batch && !drop {
+			continue
+		}
+
+		_, err = io.WriteString(c, out.String())
+		if err != nil || drop {
+			return
+		}
+
+		out.Reset()
+		pending = 0
+	}
+}
+
+func (f *fakeRedis) received() [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([][]string(nil), f.commands...)
+}
+
+func readCommand(rd *bufio.Reader) ([]string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := strconv.Atoi(strings.TrimSuffix(line[1:], "\r\n"))
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := make([]string, n)
+	for i := 0; i < n; i++ {
+		line, err = rd.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := strconv.Atoi(strings.TrimSuffix(line[1:], "\r\n"))
+		if err != nil {
+			return nil, err
+		}
+
+		buf := make([]byte, size+2)
+		_, err = io.ReadFull(rd, buf)
+		if err != nil {
+			return nil, err
+		}
+
+		cmd[i] = string(buf[:size])
+	}
+
+	return cmd, nil
+}
+
+func newTestClient(t *testing.T, f *fakeRedis, cfg *Config) *Client {
+	t.Helper()
+
+	cfg.Address = f.ln.Addr().String()
+	err := cfg.InitDefaults()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := New(cfg)
+	t.Cleanup(c.Close)
+
+	return c
+}
+
+func TestClientReplies(t *testing.T) {
+	replies := map[string]string{
+		"status":  "+OK\r\n",
+		"int":     ":42\r\n",
+		"bulk":    "$5\r\nhello\r\n",
+		"empty":   "$0\r\n\r\n",
+		"nil":     "$-1\r\n",
+		"nilarr":  "*-1\r\n",
+		"array":   "*4\r\n$1\r\na\r\n$-1\r\n-ERR element\r\n*1\r\n:1\r\n",
+		"error":   "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n",
+		"invalid": "?\r\n",
+	}
+
+	f := newFakeRedis(t, 1, func(cmd []string) (string, bool) {
+		return replies[cmd[0]], false
+	})
+	c := newTestClient(t, f, &Config{})
+
+	tests := []struct {
+		cmd   string
+		reply any
+		err   error
+	}{
+		{cmd: "status", reply: "OK"},
+		{cmd: "int", reply: int64(42)},
+		{cmd: "bulk", reply: "hello"},
+		{cmd: "empty", reply: ""},
+		{cmd: "nil", err: Nil},
+		{cmd: "nilarr", err: Nil},
+		{cmd: "array", reply: []any{"a", nil, Error("ERR element"), []any{int64(1)}}},
+		{cmd: "error", err: Error("WRONGTYPE Operation against a key holding the wrong kind of value")},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		reply, err := c.Do(context.Background(), tests[i].cmd)
+		if err != tests[i].err { //nolint:errorlint
+			t.Fatalf("%s: error %v, should be %v", tests[i].cmd, err, tests[i].err)
+		}
+
+		if !reflect.DeepEqual(reply, tests[i].reply) {
+			t.Fatalf("%s: reply %#v, should be %#v", tests[i].cmd, reply, tests[i].reply)
+		}
+	}
+
+	// the error and nil replies keep the connection
+	if n := f.conns.Load(); n != 1 {
+		t.Fatalf("%d connections, should be 1", n)
+	}
+
+	// the malformed reply closes the connection, the next command dials the new one
+	if _, err := c.Do(context.Background(), "invalid"); err == nil {
+		t.Fatal("the malformed reply should fail")
+	}
+
+	if reply, err := c.Do(context.Background(), "status"); err != nil || reply != "OK" {
+		t.Fatalf("reply %v, error %v after the malformed reply", reply, err)
+	}
+
+	if n := f.conns.Load(); n != 2 {
+		t.Fatalf("%d connections after the malformed reply, should be 2", n)
+	}
+}
+
+func TestClientPipeline(t *testing.T) {
+	// the replies are written once the whole batch is read, the client waiting for every reply times out
+	f := newFakeRedis(t, 4, func(cmd []string) (string, bool) {
+		switch cmd[1] {
+		case "a":
+			return "$1\r\n1\r\n", false
+		case "missing":
+			return "$-1\r\n", false
+		case "list":
+			return "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n", false
+		default:
+			return ":1\r\n", false
+		}
+	})
+	c := newTestClient(t, f, &Config{Timeout: time.Millisecond * 500})
+
+	replies, err := c.Pipeline(context.Background(),
+		[]string{"GET", "a"},
+		[]string{"GET", "missing"},
+		[]string{"GET", "list"},
+		[]string{"INCR", "counter"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []any{"1", Nil, Error("WRONGTYPE Operation against a key holding the wrong kind of value"), int64(1)}
+	if !reflect.DeepEqual(replies, expected) {
+		t.Fatalf("replies %#v, should be %#v", replies, expected)
+	}
+
+	// the single commands are not answered until the batch is full
+	if _, err = c.Do(context.Background(), "GET", "a"); err == nil {
+		t.Fatal("the fake server should not answer the single command")
+	}
+}
+
+func TestClientReconnect(t *testing.T) {
+	// the server closes every connection once the command is answered, as on the idle timeout or the restart
+	f := newFakeRedis(t, 1, func([]string) (string, bool) {
+		return "+PONG\r\n", true
+	})
+	c := newTestClient(t, f, &Config{})
+
+	for i := 0; i < 3; i++ {
+		reply, err := c.Do(context.Background(), "PING")
+		if err != nil {
+			t.Fatalf("command %d over the closed pooled connection: %v", i, err)
+		}
+
+		if reply != "PONG" {
+			t.Fatalf("reply %v, should be PONG", reply)
+		}
+	}
+
+	if n := f.conns.Load(); n != 3 {
+		t.Fatalf("%d connections, should be 3", n)
+	}
+
+	if n := len(f.received()); n != 3 {
+		t.Fatalf("%d commands received, the command should be sent once per connection", n)
+	}
+}
+
+func TestClientSetup(t *testing.T) {
+	f := newFakeRedis(t, 1, func(cmd []string) (string, bool) {
+		if cmd[0] == "AUTH" && cmd[2] != "secret" {
+			return "-WRONGPASS invalid username-password pair\r\n", false
+		}
+
+		return "+OK\r\n", false
+	})
+
+	c := newTestClient(t, f, &Config{Username: "user", Password: "secret", DB: 2})
+	if _, err := c.Do(context.Background(), "PING"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]string{{"AUTH", "user", "secret"}, {"SELECT", "2"}, {"PING"}}
+	if received := f.received(); !reflect.DeepEqual(received, expected) {
+		t.Fatalf("commands %v, should be %v", received, expected)
+	}
+
+	wrong := newTestClient(t, f, &Config{Username: "user", Password: "wrong"})
+	if _, err := wrong.Do(context.Background(), "PING"); err == nil {
+		t.Fatal("the command should fail with the wrong password")
+	}
+}
+
+func TestClientScript(t *testing.T) {
+	f := newFakeRedis(t, 1, func(cmd []string) (string, bool) {
+		if cmd[0] == "EVALSHA" {
+			return "-NOSCRIPT No matching script. Please use EVAL.\r\n", false
+		}
+
+		return ":1\r\n", false
+	})
+	c := newTestClient(t, f, &Config{})
+
+	s := NewScript("return 1")
+	reply, err := c.Run(context.Background(), s, []string{"key"}, "arg")
+	if err != nil || reply != int64(1) {
+		t.Fatalf("reply %v, error %v", reply, err)
+	}
+
+	expected := [][]string{{"EVALSHA", s.sha, "1", "key", "arg"}, {"EVAL", "return 1", "1", "key", "arg"}}
+	if received := f.received(); !reflect.DeepEqual(received, expected) {
+		t.Fatalf("commands %v, should be %v", received, expected)
+	}
+}
@@ -0,0 +1,60 @@
+package redis
+
+import (
+	"strings"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+)
+
+type Config struct {
+	// Address (host:port) of the Redis server.
+	Address string `mapstructure:"address" json:"address,omitempty" bson:"address,omitempty"`
+
+	// Username of the ACL user, the default user when empty.
+	Username string `mapstructure:"username" json:"username,omitempty" bson:"username,omitempty"`
+
+	// Password of the user, no AUTH when empty.
+	Password string `mapstructure:"password" json:"password,omitempty" bson:"password,omitempty"`
+
+	// DB index selected on the connect. Default: 0.
+	DB int `mapstructure:"db" json:"db,omitempty" bson:"db,omitempty"`
+
+	// TLS connects over TLS, the server certificate is verified against the system roots.
+	TLS bool `mapstructure:"tls" json:"tls,omitempty" bson:"tls,omitempty"`
+
+	// Prefix of the keys, so the instances could share the server with other apps. Default: rr:http:.
+	Prefix string `mapstructure:"prefix" json:"prefix,omitempty" bson:"prefix,omitempty"`
+
+	// PoolSize of the idle connections kept open. Default: 10.
+	PoolSize int `mapstructure:"pool_size" json:"pool_size,omitempty" bson:"pool_size,omitempty"`
+
+	// Timeout of the dial and every command. Default: 1s.
+	Timeout time.Duration `mapstructure:"timeout" json:"timeout,omitempty" bson:"timeout,omitempty"`
+}
+
+func (c *Config) InitDefaults() error {
+	const op = errors.Op("redis_config")
+
+	if c.Prefix == "" {
+		c.Prefix = "rr:http:"
+	}
+
+	if c.PoolSize == 0 {
+		c.PoolSize = 10
+	}
+
+	if c.Timeout == 0 {
+		c.Timeout = time.Second
+	}
+
+	if c.Address == "" || !strings.Contains(c.Address, ":") {
+		return errors.E(op, errors.Errorf("address should be host:port, got %q", c.Address))
+	}
+
+	if c.DB < 0 || c.PoolSize < 0 || c.Timeout < 0 {
+		return errors.E(op, errors.Str("db, pool_size and timeout should not be negative"))
+	}
+
+	return nil
+}
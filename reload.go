@@ -0,0 +1,161 @@
+package http
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ReloadStatus of the last config reload, Error is empty when it succeeded. Pending is the diff of the config
+// which failed to apply, the servers keep the current one.
+type ReloadStatus struct {
+	Time    time.Time      `json:"time"`
+	Error   string         `json:"error,omitempty"`
+	Pending []ConfigChange `json:"pending,omitempty"`
+}
+
+// ConfigChange of the top-level key of the config section, the nil value is the absent key
+type ConfigChange struct {
+	Key        string `json:"key"`
+	Current    any    `json:"current,omitempty"`
+	Pending    any    `json:"pending,omitempty"`
+	Reloadable bool   `json:"reloadable"`
+}
+
+// reloadableKeys of the config section are applied by Reset to the new servers, the rest configures the
+// middleware built once by Init
+var reloadableKeys = map[string]struct{}{
+	"address":                    {},
+	"middleware":                 {},
+	"ssl":                        {},
+	"http2":                      {},
+	"multiplex":                  {},
+	"servers":                    {},
+	"max_request_size":           {},
+	"max_request_size_overrides": {},
+	"body_spool":                 {},
+	"access_log":                 {},
+	"bundled":                    {},
+	"keep_alive":                 {},
+	"listener":                   {},
+	"uri_limit":                  {},
+	"streaming":                  {},
+	"workers":                    {},
+}
+
+// ConfigWatcher is the optional Configurer API, the channel receives when the config section changes.
+type ConfigWatcher interface {
+	Watch(name string) <-chan struct{}
+}
+
+// watchConfig resets the servers on the config changes and SIGHUP (when enabled) until the plugin stops
+func (p *Plugin) watchConfig() {
+	var changes <-chan struct{}
+	if watcher, ok := p.configurer.(ConfigWatcher); ok {
+		changes = watcher.Watch(PluginName)
+	}
+
+	var signals chan os.Signal
+	if p.cfg.ReloadSignal {
+		signals = make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGHUP)
+		defer signal.Stop(signals)
+	}
+
+	if changes == nil && signals == nil {
+		return
+	}
+
+	for {
+		select {
+		case _, ok := <-changes:
+			if !ok {
+				changes = nil
+				continue
+			}
+		case <-signals:
+		case <-p.stopCh:
+			return
+		}
+
+		p.log.Info("reloading the config")
+		err := p.Reset()
+		if err != nil {
+			p.log.Error("config reload failed, the servers keep the previous config", "error", err)
+		}
+	}
+}
+
+// LastReload returns the result of the last config reload, nil before the first one
+func (p *Plugin) LastReload() *ReloadStatus {
+	return p.reload.Load()
+}
+
+// PendingConfig returns the diff of the config which failed to apply on the last reload, nil when it succeeded
+func (p *Plugin) PendingConfig() []ConfigChange {
+	if st := p.reload.Load(); st != nil {
+		return st.Pending
+	}
+
+	return nil
+}
+
+func (p *Plugin) recordReload(pending []ConfigChange, err error) {
+	st := &ReloadStatus{Time: time.Now()}
+	if err != nil {
+		st.Error = err.Error()
+		st.Pending = pending
+	}
+	p.reload.Store(st)
+
+	if err != nil {
+		p.reloads.Inc("failure")
+		p.reloadOK.Set(0)
+		return
+	}
+
+	p.reloads.Inc("success")
+	p.reloadOK.Set(1)
+}
+
+// configDiff of the raw config sections, sorted by the key
+func configDiff(prev, next map[string]any) []ConfigChange {
+	var diff []ConfigChange
+	for key, value := range next {
+		if !reflect.DeepEqual(prev[key], value) {
+			diff = append(diff, ConfigChange{Key: key, Current: prev[key], Pending: value})
+		}
+	}
+
+	for key, value := range prev {
+		if _, ok := next[key]; !ok {
+			diff = append(diff, ConfigChange{Key: key, Current: value})
+		}
+	}
+
+	for i := 0; i < len(diff); i++ {
+		_, diff[i].Reloadable = reloadableKeys[diff[i].Key]
+	}
+
+	slices.SortFunc(diff, func(a, b ConfigChange) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+
+	return diff
+}
+
+// changedKeys of the diff outside the reloadable ones
+func changedKeys(diff []ConfigChange) []string {
+	var changed []string
+	for i := 0; i < len(diff); i++ {
+		if !diff[i].Reloadable {
+			changed = append(changed, diff[i].Key)
+		}
+	}
+
+	return changed
+}
@@ -0,0 +1,152 @@
+package http
+
+import (
+	"strings"
+
+	"github.com/roadrunner-server/errors"
+
+	"github.com/rumorshub/http/config"
+	"github.com/rumorshub/http/servers/state"
+	"github.com/rumorshub/http/supervisor"
+)
+
+// Reset re-creates the servers without restarting the process. The config is re-read, the server settings
+// (addresses, middleware order, TLS, HTTP/2, request size limits, access log and its sinks, keep-alive) and the
+// certificates are applied to the new servers. The middleware is built once by Init, the config with the changed
+// middleware sections is rejected and the servers keep the previous one. The listeners of the kept addresses stay
+// open, only the new addresses are bound. The drained servers stay drained. The result is reported by LastReload,
+// the diff of the config which failed to apply by PendingConfig.
+func (p *Plugin) Reset() error {
+	p.resetMu.Lock()
+	defer p.resetMu.Unlock()
+
+	pending, err := p.reset()
+	p.recordReload(pending, err)
+
+	return err
+}
+
+// reset returns the diff of the re-read config, it is pending when the reset fails
+func (p *Plugin) reset() ([]ConfigChange, error) {
+	const op = errors.Op("http_plugin_reset")
+
+	// the standalone plugin has no configurer, the servers are re-created with the same config
+	var cfg *config.Config
+	var raw map[string]any
+	var diff []ConfigChange
+	if p.configurer == nil {
+		cfg = p.cfg
+	} else {
+		var err error
+		cfg, raw, err = unmarshalConfig(p.configurer)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+
+		diff = configDiff(p.raw, raw)
+
+		err = cfg.InitDefaults()
+		if err != nil {
+			return diff, errors.E(op, err)
+		}
+	}
+
+	if !cfg.EnableHTTP() && !cfg.EnableTLS() {
+		return diff, errors.E(op, errors.Str("both http and https servers are disabled in the new config"))
+	}
+
+	// workers serve the traffic, they are re-spawned and read the new config themselves
+	if p.supervisor != nil {
+		p.supervisor.Stop()
+		if cfg.Workers != nil {
+			p.cfg.Workers = cfg.Workers
+		}
+		p.supervisor = supervisor.New(p.cfg.Workers, p.log)
+
+		err := p.supervisor.Start()
+		if err != nil {
+			return diff, errors.E(op, err)
+		}
+
+		return diff, nil
+	}
+
+	if raw != nil {
+		if changed := changedKeys(diff); len(changed) > 0 {
+			return diff, errors.E(op, errors.Errorf("the middleware config can not be reloaded, restart to apply the changed keys: %s", strings.Join(changed, ", ")))
+		}
+	}
+
+	p.mu.Lock()
+	prev := *p.cfg
+	old, addresses := p.servers, p.addresses
+
+	p.servers = make([]internalServer, 0, 2)
+	p.cfg.Address = cfg.Address
+	p.cfg.Middleware = cfg.Middleware
+	p.cfg.SSL = cfg.SSL
+	p.cfg.HTTP2 = cfg.HTTP2
+	p.cfg.Multiplex = cfg.Multiplex
+	p.cfg.Servers = cfg.Servers
+	p.cfg.MaxRequestSize = cfg.MaxRequestSize
+	p.cfg.MaxRequestSizeOverrides = cfg.MaxRequestSizeOverrides
+	p.cfg.BodySpool = cfg.BodySpool
+	p.cfg.AccessLog = cfg.AccessLog
+	p.cfg.Bundled = cfg.Bundled
+	p.cfg.KeepAlive = cfg.KeepAlive
+	p.cfg.Listener = cfg.Listener
+	p.cfg.URILimit = cfg.URILimit
+	p.cfg.Streaming = cfg.Streaming
+
+	err := p.initServers()
+	if err != nil {
+		// the old servers keep serving
+		*p.cfg = prev
+		p.servers, p.addresses = old, addresses
+		p.closeListeners(addresses)
+		p.mu.Unlock()
+		return diff, errors.E(op, err)
+	}
+
+	// the drained servers stay drained until the Undrain RPC
+	for i := 0; i < len(old); i++ {
+		if old[i].State() != state.Draining {
+			continue
+		}
+
+		for j := 0; j < len(p.servers); j++ {
+			if p.servers[j].Name() == old[i].Name() {
+				p.servers[j].Drain(true)
+			}
+		}
+	}
+
+	// the new servers log to the new sinks, the old ones are stopped with the old servers
+	oldSinks := p.sinks
+	p.accessLog, p.sinks = p.newAccessLog(p.cfg.AccessLog)
+
+	servers := p.servers
+	p.mu.Unlock()
+
+	// the new servers accept the connections of the kept listeners while the old ones finish the in-flight requests
+	p.startServers(servers)
+	for i := 0; i < len(old); i++ {
+		old[i].Stop()
+	}
+
+	// the old servers are stopped, their last entries are shipped
+	if oldSinks != nil {
+		oldSinks.Stop()
+	}
+
+	p.mu.Lock()
+	p.closeListeners(p.addresses)
+	if raw != nil {
+		p.raw = raw
+	}
+	p.mu.Unlock()
+
+	p.log.Info("servers were reset", "count", len(servers))
+
+	return diff, nil
+}
@@ -0,0 +1,310 @@
+package http
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+
+	"github.com/rumorshub/http/capture"
+	"github.com/rumorshub/http/middleware"
+	"github.com/rumorshub/http/stats"
+)
+
+type ServerInfo struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	State   string `json:"state"`
+}
+
+type ServerStats struct {
+	ServerInfo
+	stats.Snapshot
+}
+
+// SignURLRequest of the signed link, the ip is required when the links are bound to the client IP
+type SignURLRequest struct {
+	Path    string        `json:"path"`
+	Expires time.Duration `json:"expires"`
+	IP      string        `json:"ip,omitempty"`
+}
+
+type certificateReloader interface {
+	ReloadableCertificates() bool
+	ReloadCertificates() error
+}
+
+// rpc is the management service of the http plugin, methods follow the net/rpc conventions
+type rpc struct {
+	p *Plugin
+}
+
+// Servers lists the servers with their addresses and states
+func (r *rpc) Servers(_ bool, out *[]ServerInfo) error {
+	r.p.mu.RLock()
+	defer r.p.mu.RUnlock()
+
+	*out = make([]ServerInfo, 0, len(r.p.servers))
+	for i := 0; i < len(r.p.servers); i++ {
+		*out = append(*out, ServerInfo{
+			Name:    r.p.servers[i].Name(),
+			Address: r.p.servers[i].Address(),
+			State:   string(r.p.servers[i].State()),
+		})
+	}
+
+	return nil
+}
+
+// LastReload returns the result of the last config reload, the zero status before the first one
+func (r *rpc) LastReload(_ bool, out *ReloadStatus) error {
+	if st := r.p.LastReload(); st != nil {
+		*out = *st
+	}
+
+	return nil
+}
+
+// PendingConfig returns the diff of the config which failed to apply on the last reload, empty when it succeeded
+func (r *rpc) PendingConfig(_ bool, out *[]ConfigChange) error {
+	*out = r.p.PendingConfig()
+	return nil
+}
+
+// Stats returns the runtime statistics of the servers
+func (r *rpc) Stats(_ bool, out *[]ServerStats) error {
+	*out = r.p.Stats()
+	return nil
+}
+
+// Drain makes the server (http, https or empty for all) answer 503 and close the connections
+func (r *rpc) Drain(name string, ok *bool) error {
+	const op = errors.Op("http_rpc_drain")
+
+	err := r.drain(name, true)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	*ok = true
+	return nil
+}
+
+// Undrain returns the server (http, https or empty for all) to the normal operation
+func (r *rpc) Undrain(name string, ok *bool) error {
+	const op = errors.Op("http_rpc_undrain")
+
+	err := r.drain(name, false)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	*ok = true
+	return nil
+}
+
+// ReloadCertificates re-reads the certificate files of every https server using them
+func (r *rpc) ReloadCertificates(_ bool, ok *bool) error {
+	const op = errors.Op("http_rpc_reload_certificates")
+
+	r.p.mu.RLock()
+	defer r.p.mu.RUnlock()
+
+	for i := 0; i < len(r.p.servers); i++ {
+		reloader, is := r.p.servers[i].(certificateReloader)
+		if !is || !reloader.ReloadableCertificates() {
+			continue
+		}
+
+		err := reloader.ReloadCertificates()
+		if err != nil {
+			return errors.E(op, err)
+		}
+
+		r.p.log.Info("certificates reloaded", "server", r.p.servers[i].Name())
+		*ok = true
+	}
+
+	if !*ok {
+		return errors.E(op, errors.Str("no https server uses the certificate files (ACME, signer or SPIFFE manage them)"))
+	}
+
+	return nil
+}
+
+// Maintenance toggles the maintenance mode
+func (r *rpc) Maintenance(enabled bool, ok *bool) error {
+	prev := r.p.maintenance.Enable(enabled)
+	if prev != enabled {
+		r.p.log.Info("maintenance mode changed", "enabled", enabled)
+	}
+
+	*ok = true
+	return nil
+}
+
+// SignURL returns the signed link of the path expiring after the duration
+func (r *rpc) SignURL(in SignURLRequest, out *string) error {
+	const op = errors.Op("http_rpc_sign_url")
+
+	if r.p.signedURL == nil {
+		return errors.E(op, errors.Str("signed_url is not enabled"))
+	}
+
+	if in.Expires <= 0 {
+		return errors.E(op, errors.Str("expires should be positive"))
+	}
+
+	signed, err := r.p.signedURL.Sign(in.Path, time.Now().Add(in.Expires), in.IP)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	*out = signed
+	return nil
+}
+
+// Bans lists the banned IPs, the soonest to expire first
+func (r *rpc) Bans(_ bool, out *[]middleware.BanEntry) error {
+	const op = errors.Op("http_rpc_bans")
+
+	if r.p.ban == nil {
+		return errors.E(op, errors.Str("ban is not enabled"))
+	}
+
+	*out = r.p.ban.List()
+	return nil
+}
+
+// Unban lifts the ban of the IP, ok reports whether the IP was banned
+func (r *rpc) Unban(ip string, ok *bool) error {
+	const op = errors.Op("http_rpc_unban")
+
+	if r.p.ban == nil {
+		return errors.E(op, errors.Str("ban is not enabled"))
+	}
+
+	unbanned, err := r.p.ban.Unban(ip)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	if unbanned {
+		r.p.log.Info("ip unbanned", "ip", ip)
+	}
+
+	*ok = unbanned
+	return nil
+}
+
+// PurgeCache invalidates the cached responses with the path prefix
+func (r *rpc) PurgeCache(prefix string, purged *int) error {
+	const op = errors.Op("http_rpc_purge_cache")
+
+	if r.p.cache == nil {
+		return errors.E(op, errors.Str("cache is not enabled"))
+	}
+
+	if !strings.HasPrefix(prefix, "/") {
+		return errors.E(op, errors.Errorf("prefix should start with /, got %q", prefix))
+	}
+
+	*purged = r.p.cache.Purge(prefix)
+	r.p.log.Info("cache purged", "prefix", prefix, "entries", *purged)
+	return nil
+}
+
+// PurgeCacheTags invalidates the cached responses tagged (Surrogate-Key) with any of the tags
+func (r *rpc) PurgeCacheTags(tags []string, purged *int) error {
+	const op = errors.Op("http_rpc_purge_cache_tags")
+
+	if r.p.cache == nil {
+		return errors.E(op, errors.Str("cache is not enabled"))
+	}
+
+	*purged = r.p.cache.PurgeTags(tags...)
+	r.p.log.Info("cache purged", "tags", tags, "entries", *purged)
+	return nil
+}
+
+// PurgeCacheAll invalidates every cached response
+func (r *rpc) PurgeCacheAll(_ bool, purged *int) error {
+	const op = errors.Op("http_rpc_purge_cache_all")
+
+	if r.p.cache == nil {
+		return errors.E(op, errors.Str("cache is not enabled"))
+	}
+
+	*purged = r.p.cache.PurgeAll()
+	r.p.log.Info("cache purged", "entries", *purged)
+	return nil
+}
+
+// Captures returns the captured requests of the ring buffer as the HAR, empty when the capture is disabled
+func (r *rpc) Captures(_ bool, out *capture.HAR) error {
+	const op = errors.Op("http_rpc_captures")
+
+	if r.p.capture == nil {
+		return errors.E(op, errors.Str("capture is not enabled"))
+	}
+
+	*out = *r.p.capture.HAR()
+	return nil
+}
+
+// ClearCaptures empties the ring buffer of the captured requests
+func (r *rpc) ClearCaptures(_ bool, cleared *int) error {
+	const op = errors.Op("http_rpc_clear_captures")
+
+	if r.p.capture == nil {
+		return errors.E(op, errors.Str("capture is not enabled"))
+	}
+
+	*cleared = r.p.capture.Clear()
+	return nil
+}
+
+// SetLogLevel adjusts the plugin log level (debug, info, warn, error), empty level restores the configured one
+func (r *rpc) SetLogLevel(level string, ok *bool) error {
+	const op = errors.Op("http_rpc_set_log_level")
+
+	if level == "" {
+		r.p.level.Reset()
+		*ok = true
+		return nil
+	}
+
+	var l slog.Level
+	err := l.UnmarshalText([]byte(strings.TrimSpace(level)))
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	r.p.level.Set(l)
+	*ok = true
+	return nil
+}
+
+func (r *rpc) drain(name string, drain bool) error {
+	r.p.mu.RLock()
+	defer r.p.mu.RUnlock()
+
+	found := false
+	for i := 0; i < len(r.p.servers); i++ {
+		if name != "" && r.p.servers[i].Name() != name {
+			continue
+		}
+
+		found = true
+		if r.p.servers[i].Drain(drain) != drain {
+			r.p.log.Info("server drain changed", "server", r.p.servers[i].Name(), "draining", drain)
+		}
+	}
+
+	if !found {
+		return errors.Errorf("server '%s' is not found", name)
+	}
+
+	return nil
+}
@@ -0,0 +1,19 @@
+package http
+
+import "fmt"
+
+// ServerError is sent to the Serve channel when a server fails, it names the server and its address.
+type ServerError struct {
+	// Server name, http, https or the name of the additional server
+	Server  string
+	Address string
+	Err     error
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("%s server (%s): %v", e.Server, e.Address, e.Err)
+}
+
+func (e *ServerError) Unwrap() error {
+	return e.Err
+}
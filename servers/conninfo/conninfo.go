@@ -0,0 +1,67 @@
+package conninfo
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+type contextKey string
+
+const infoCtx contextKey = "conn_info"
+
+// Info about the connection the request arrived on, the servers stash it into the request context.
+type Info struct {
+	// Server name, http, https or the name of the additional server
+	Server string
+	// Listener address the server is bound to, the servers could share the port in the multiplex mode
+	Listener string
+	// Accepted is the time the connection was accepted at
+	Accepted time.Time
+	// LocalAddr and RemoteAddr of the connection
+	LocalAddr  net.Addr
+	RemoteAddr net.Addr
+
+	conn     net.Conn
+	requests atomic.Uint64
+}
+
+// Request counts the request served on the connection and returns its number starting from 1.
+func (i *Info) Request() uint64 {
+	return i.requests.Add(1)
+}
+
+// TLS returns the state of the TLS connection, nil for the plain connections. The handshake is completed before
+// the request is served, so the state is complete in the handlers.
+func (i *Info) TLS() *tls.ConnectionState {
+	c, ok := i.conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+
+	state := c.ConnectionState()
+
+	return &state
+}
+
+// ConnContext returns the http.Server ConnContext callback stashing the Info of every accepted connection.
+func ConnContext(server, listener string) func(ctx context.Context, c net.Conn) context.Context {
+	return func(ctx context.Context, c net.Conn) context.Context {
+		return context.WithValue(ctx, infoCtx, &Info{
+			Server:     server,
+			Listener:   listener,
+			Accepted:   time.Now(),
+			LocalAddr:  c.LocalAddr(),
+			RemoteAddr: c.RemoteAddr(),
+			conn:       c,
+		})
+	}
+}
+
+// FromContext returns the Info of the connection the request arrived on.
+func FromContext(ctx context.Context) (*Info, bool) {
+	info, ok := ctx.Value(infoCtx).(*Info)
+	return info, ok
+}
@@ -27,6 +27,7 @@ import (
 	"errors"
 	"log"
 	"log/slog"
+	"net"
 	"net/http"
 	"time"
 
@@ -35,33 +36,80 @@ import (
 	"golang.org/x/net/http2/h2c"
 
 	"github.com/rumorshub/http/config"
+	"github.com/rumorshub/http/events"
 	"github.com/rumorshub/http/middleware"
+	"github.com/rumorshub/http/servers/conninfo"
 	"github.com/rumorshub/http/servers/listener"
+	"github.com/rumorshub/http/servers/mux"
+	"github.com/rumorshub/http/servers/state"
+	"github.com/rumorshub/http/stats"
 )
 
 type Server struct {
-	log          *slog.Logger
-	http         *http.Server
-	address      string
-	redirect     bool
-	redirectPort int
+	name     string
+	log      *slog.Logger
+	http     *http.Server
+	address  string
+	redirect bool
+	opts     middleware.RedirectOptions
+
+	// challenge handler serving the ACME HTTP-01 challenges, goes before the redirect
+	challenge func(http.Handler) http.Handler
+	listening chan struct{}
+
+	events events.Listener
+	state  *state.Tracker
+	stats  *stats.Stats
+
+	// ln is the listener provided by the WithListener option
+	ln net.Listener
+	// lnCfg of the listener bound by the server itself, the defaults when nil
+	lnCfg *listener.Config
+
+	// keep-alive settings of the WithKeepAlive option
+	keepAlivesDisabled bool
+	maxConnRequests    uint64
+
+	// uriLimit of the WithURILimit option, nil when disabled
+	uriLimit *middleware.URILimitConfig
+	// streaming endpoints of the WithStreaming option, the defaults when nil
+	streaming *middleware.StreamingConfig
+	// auditor of the WithAuditor option, nil when the audit log is disabled
+	auditor middleware.Auditor
+
+	// protocols multiplexed on the listener, nil when disabled
+	mux       *mux.Config
+	protocols []mux.Protocol
 }
 
-func NewHTTPServer(handler http.Handler, cfg *config.Config, errLog *log.Logger, log *slog.Logger) *Server {
+// NewHTTPServer creates the server of the config, the rest of the settings are passed as the options.
+func NewHTTPServer(handler http.Handler, cfg *config.Config, errLog *log.Logger, log *slog.Logger, opts ...Option) *Server {
 	var redirect bool
-	var redirectPort int
+	var redirectOpts middleware.RedirectOptions
 
 	if cfg.SSL != nil {
 		redirect = cfg.SSL.Redirect
-		redirectPort = cfg.SSL.Port
+		redirectOpts = middleware.RedirectOptions{
+			Port:         cfg.SSL.Port,
+			HSTS:         cfg.SSL.HSTS.Value(),
+			Status:       cfg.SSL.RedirectStatus,
+			RejectUnsafe: cfg.SSL.RedirectRejectUnsafe,
+			Exclude:      cfg.SSL.RedirectExclude,
+		}
 	}
 
+	st := stats.New()
+
+	var s *Server
 	if cfg.HTTP2 != nil && cfg.HTTP2.H2C {
-		return &Server{
-			log:          log,
-			redirect:     redirect,
-			redirectPort: redirectPort,
-			address:      cfg.Address,
+		s = &Server{
+			log:       log,
+			redirect:  redirect,
+			opts:      redirectOpts,
+			address:   cfg.Address,
+			listening: make(chan struct{}),
+			state:     state.NewTracker(),
+			stats:     st,
 			http: &http.Server{
 				Handler: h2c.NewHandler(handler, &http2.Server{
 					MaxConcurrentStreams:         cfg.HTTP2.MaxConcurrentStreams,
@@ -71,52 +119,236 @@ func NewHTTPServer(handler http.Handler, cfg *config.Config, errLog *log.Logger,
 				ReadHeaderTimeout: time.Minute,
 				WriteTimeout:      time.Minute,
 				ErrorLog:          errLog,
+				ConnState:         st.ConnState,
+			},
+		}
+	} else {
+		s = &Server{
+			log:       log,
+			redirect:  redirect,
+			opts:      redirectOpts,
+			address:   cfg.Address,
+			listening: make(chan struct{}),
+			state:     state.NewTracker(),
+			stats:     st,
+			http: &http.Server{
+				ReadHeaderTimeout: time.Minute * 5,
+				Handler:           handler,
+				ErrorLog:          errLog,
+				ConnState:         st.ConnState,
 			},
 		}
 	}
-	return &Server{
-		log:          log,
-		redirect:     redirect,
-		redirectPort: redirectPort,
-		address:      cfg.Address,
-		http: &http.Server{
-			ReadHeaderTimeout: time.Minute * 5,
-			Handler:           handler,
-			ErrorLog:          errLog,
-		},
+
+	for i := 0; i < len(opts); i++ {
+		opts[i](s)
 	}
+
+	return s
 }
 
 func (s *Server) Start(mdwr map[string]middleware.Middleware, order []string) error {
 	const op = rrErrors.Op("serveHTTP")
 
+	outermost := make([]middleware.Middleware, 0, 1)
+	for i := 0; i < len(order); i++ {
+		if o, ok := mdwr[order[i]].(middleware.Outermost); ok && o.Outermost() {
+			outermost = append(outermost, mdwr[order[i]])
+		}
+	}
+
+	// the outermost access log reads the values the middleware add to their request copies
+	if len(outermost) > 0 {
+		s.http.Handler = middleware.TrackRequest(s.http.Handler)
+	}
+
 	for i := 0; i < len(order); i++ {
 		if m, ok := mdwr[order[i]]; ok {
+			if o, ok := m.(middleware.Outermost); ok && o.Outermost() {
+				continue
+			}
+
 			s.http.Handler = m.Middleware(s.http.Handler)
+			if len(outermost) > 0 {
+				s.http.Handler = middleware.TrackRequest(s.http.Handler)
+			}
 		} else {
 			s.log.Warn("requested middleware does not exist", "requested", order[i])
+			s.emit(events.Event{Type: events.MiddlewareMissing, Middleware: order[i]})
 		}
 	}
 
 	// apply redirect middleware first (if redirect specified)
 	if s.redirect {
-		s.http.Handler = middleware.Redirect(s.http.Handler, s.redirectPort)
+		s.http.Handler = middleware.Redirect(s.http.Handler, s.opts)
 	}
 
-	l, err := listener.CreateListener(s.address)
+	// ACME challenges should not be redirected
+	if s.challenge != nil {
+		s.http.Handler = s.challenge(s.http.Handler)
+	}
+
+	// no client certificates here, only the identity headers sent by the client are removed
+	s.http.Handler = middleware.ClientCertIdentity(s.http.Handler, false)
+
+	if s.maxConnRequests > 0 {
+		s.http.Handler = middleware.MaxConnRequests(s.http.Handler, s.maxConnRequests)
+	}
+
+	// the long URIs are rejected before any other middleware
+	if s.uriLimit != nil && s.uriLimit.MaxLength > 0 {
+		s.http.Handler = middleware.MaxURILength(s.http.Handler, s.uriLimit)
+	}
+
+	// every middleware records the security events, the URI limit included
+	if s.auditor != nil {
+		s.http.Handler = middleware.Auditing(s.http.Handler, s.auditor)
+	}
+
+	s.http.Handler = s.state.Middleware(s.http.Handler)
+
+	for i := 0; i < len(outermost); i++ {
+		s.http.Handler = outermost[i].Middleware(s.http.Handler)
+	}
+
+	// every middleware should know the request is streaming
+	s.http.Handler = middleware.Streaming(s.http.Handler, s.streaming)
+
+	l, err := s.listener()
 	if err != nil {
+		s.emit(events.Event{Type: events.ServeError, Error: err})
 		return rrErrors.E(op, err)
 	}
 
+	s.http.ConnContext = conninfo.ConnContext(s.Name(), s.address)
+	s.state.Set(state.Running)
+	s.stats.Start()
+	s.emit(events.Event{Type: events.ListenerBound})
+
+	if s.mux != nil {
+		l = s.multiplex(l)
+	}
+
+	close(s.listening)
+
 	s.log.Debug("http server was started", "address", s.address)
+	s.emit(events.Event{Type: events.ServerStarted})
 	err = s.http.Serve(l)
 	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		s.emit(events.Event{Type: events.ServeError, Error: err})
 		return rrErrors.E(op, err)
 	}
 
 	return nil
 }
 
+// Name of the server, "http" unless it is a named server
+func (s *Server) Name() string {
+	if s.name == "" {
+		return "http"
+	}
+
+	return s.name
+}
+
+// SetName names the additional server
+func (s *Server) SetName(name string) {
+	s.name = name
+}
+
+func (s *Server) Address() string {
+	return s.address
+}
+
+func (s *Server) State() state.State {
+	return s.state.State()
+}
+
+func (s *Server) Stats() *stats.Stats {
+	return s.stats
+}
+
+// Drain makes the server answer 503 with the closed connections, returns the previous value.
+func (s *Server) Drain(drain bool) bool {
+	s.http.SetKeepAlivesEnabled(!drain && !s.keepAlivesDisabled)
+	return s.state.Drain(drain)
+}
+
+// OnShutdown registers the function called in its own goroutine when the graceful shutdown begins,
+// see http.Server.RegisterOnShutdown.
+func (s *Server) OnShutdown(f func()) {
+	s.http.RegisterOnShutdown(f)
+}
+
+func (s *Server) listener() (net.Listener, error) {
+	if s.ln != nil {
+		return s.ln, nil
+	}
+
+	l, err := listener.CreateListener(s.address, s.lnCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return listener.Limit(l, s.lnCfg, nil), nil
+}
+
+// OnEvent sets the listener of the server lifecycle events.
+func (s *Server) OnEvent(listener events.Listener) {
+	s.events = listener
+}
+
+func (s *Server) emit(event events.Event) {
+	if s.events == nil {
+		return
+	}
+
+	event.Server = s.Name()
+	event.Address = s.address
+	event.Time = time.Now()
+	s.events.OnServerEvent(event)
+}
+
+// Multiplex serves the protocols of other plugins on the same listener, HTTP/1.1, h2c and gRPC go to the server.
+func (s *Server) Multiplex(cfg *mux.Config, protocols []mux.Protocol) {
+	s.mux = cfg
+	s.protocols = protocols
+}
+
+func (s *Server) multiplex(l net.Listener) net.Listener {
+	m := mux.New(l, s.mux.SniffTimeout, s.log)
+	for i := 0; i < len(s.protocols); i++ {
+		protocol := s.protocols[i]
+		pl := m.Match(protocol.Match)
+
+		go func() {
+			err := protocol.Serve(pl)
+			if err != nil && !errors.Is(err, net.ErrClosed) {
+				s.log.Error("multiplexed protocol", "protocol", protocol.Name(), "error", err)
+			}
+		}()
+	}
+
+	go func() {
+		err := m.Serve()
+		if err != nil {
+			s.log.Error("listener multiplexer", "error", err)
+		}
+	}()
+
+	return m.Default()
+}
+
+// ServeACMEChallenges routes the ACME HTTP-01 challenges to the provided handler wrapper.
+func (s *Server) ServeACMEChallenges(challenge func(http.Handler) http.Handler) {
+	s.challenge = challenge
+}
+
+// Listening is closed when the server listener is bound.
+func (s *Server) Listening() <-chan struct{} {
+	return s.listening
+}
+
 func (s *Server) GetServer() *http.Server {
 	return s.http
 }
@@ -126,4 +358,7 @@ func (s *Server) Stop() {
 	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		s.log.Error("http shutdown", "error", err)
 	}
+
+	s.state.Set(state.Stopped)
+	s.emit(events.Event{Type: events.ServerStopped, Error: err})
 }
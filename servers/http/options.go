@@ -0,0 +1,147 @@
+package http
+
+import (
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/rumorshub/http/middleware"
+	"github.com/rumorshub/http/servers/listener"
+	"github.com/rumorshub/http/servers/state"
+	"github.com/rumorshub/http/stats"
+)
+
+// Option customizes the server created by New.
+type Option func(*Server)
+
+// Timeouts of the server, zero values keep the defaults.
+type Timeouts struct {
+	Read       time.Duration
+	ReadHeader time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+}
+
+// KeepAlive of the persistent connections, zero values keep the defaults.
+type KeepAlive struct {
+	// Disabled closes every connection after the response
+	Disabled bool
+	// IdleTimeout of the connection between the requests
+	IdleTimeout time.Duration
+	// MaxRequests served on the HTTP/1.x connection before it is closed
+	MaxRequests uint64
+}
+
+// New creates the plain HTTP server without the config structs, it listens on 127.0.0.1:8080 by default.
+func New(handler http.Handler, opts ...Option) *Server {
+	st := stats.New()
+	s := &Server{
+		log:       slog.Default(),
+		address:   "127.0.0.1:8080",
+		listening: make(chan struct{}),
+		state:     state.NewTracker(),
+		stats:     st,
+		http: &http.Server{
+			ReadHeaderTimeout: time.Minute * 5,
+			Handler:           handler,
+			ConnState:         st.ConnState,
+		},
+	}
+
+	for i := 0; i < len(opts); i++ {
+		opts[i](s)
+	}
+
+	return s
+}
+
+func WithAddress(address string) Option {
+	return func(s *Server) {
+		s.address = address
+	}
+}
+
+func WithTimeouts(timeouts Timeouts) Option {
+	return func(s *Server) {
+		if timeouts.Read > 0 {
+			s.http.ReadTimeout = timeouts.Read
+		}
+
+		if timeouts.ReadHeader > 0 {
+			s.http.ReadHeaderTimeout = timeouts.ReadHeader
+		}
+
+		if timeouts.Write > 0 {
+			s.http.WriteTimeout = timeouts.Write
+		}
+
+		if timeouts.Idle > 0 {
+			s.http.IdleTimeout = timeouts.Idle
+		}
+	}
+}
+
+// WithErrorLog sets the logger of the net/http errors (handshakes, panics)
+func WithErrorLog(errLog *log.Logger) Option {
+	return func(s *Server) {
+		s.http.ErrorLog = errLog
+	}
+}
+
+func WithLogger(log *slog.Logger) Option {
+	return func(s *Server) {
+		s.log = log
+	}
+}
+
+// WithListener serves the provided listener instead of binding the address
+func WithListener(l net.Listener) Option {
+	return func(s *Server) {
+		s.ln = l
+		s.address = l.Addr().String()
+	}
+}
+
+// WithListenerConfig sets the options of the listener bound by the server, see WithListener
+func WithListenerConfig(cfg *listener.Config) Option {
+	return func(s *Server) {
+		s.lnCfg = cfg
+	}
+}
+
+// WithKeepAlive configures the persistent connections
+func WithKeepAlive(keepAlive KeepAlive) Option {
+	return func(s *Server) {
+		s.keepAlivesDisabled = keepAlive.Disabled
+		s.http.SetKeepAlivesEnabled(!keepAlive.Disabled)
+
+		if keepAlive.IdleTimeout > 0 {
+			s.http.IdleTimeout = keepAlive.IdleTimeout
+		}
+
+		s.maxConnRequests = keepAlive.MaxRequests
+	}
+}
+
+// WithURILimit rejects the requests with the URI above the limit, nil disables it
+func WithURILimit(cfg *middleware.URILimitConfig) Option {
+	return func(s *Server) {
+		s.uriLimit = cfg
+	}
+}
+
+// WithStreaming marks the streaming endpoints, nil uses the defaults
+func WithStreaming(cfg *middleware.StreamingConfig) Option {
+	return func(s *Server) {
+		s.streaming = cfg
+	}
+}
+
+// WithAuditor records the security events of the requests, nil disables it
+func WithAuditor(a middleware.Auditor) Option {
+	return func(s *Server) {
+		s.auditor = a
+	}
+}
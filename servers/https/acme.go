@@ -25,9 +25,12 @@ package https
 import (
 	"context"
 	"crypto/tls"
-	"time"
+	"crypto/x509"
+	"net/http"
+	"os"
 
 	"github.com/caddyserver/certmagic"
+	"github.com/roadrunner-server/errors"
 	"go.uber.org/zap"
 )
 
@@ -36,74 +39,230 @@ type challenge string
 const (
 	HTTP01    challenge = "http-01"
 	TLSAlpn01 challenge = "tlsalpn-01"
+	DNS01     challenge = "dns-01"
 )
 
-func IssueCertificates(cacheDir, email, challengeType string, domains []string, useProduction bool, altHTTPPort, altTLSAlpnPort int, log *zap.Logger) (*tls.Config, error) {
+// StorageProvider is implemented by the plugins providing a shared certificates storage (redis, consul, s3, etcd),
+// selected by the acme.storage option equal to the provider Name. Storage should implement the certmagic locking,
+// so only one of the instances behind a load balancer obtains a certificate.
+type StorageProvider interface {
+	Name() string
+	ACMEStorage() (certmagic.Storage, error)
+}
+
+// DNSProvider is implemented by the plugins managing DNS records (libdns providers), selected by the
+// acme.dns_provider option equal to the provider Name, used to solve the dns-01 challenges.
+type DNSProvider interface {
+	Name() string
+	ACMEDNSProvider() certmagic.ACMEDNSProvider
+}
+
+// IssueCertificates obtains and manages the certificates, certmagic.FileStorage in the cache_dir is used
+// when the storage is nil. Lifecycle events are sent to the listener when it is not nil. The dns provider
+// is required only for the dns-01 challenges.
+func IssueCertificates(acme *AcmeConfig, storage certmagic.Storage, dns certmagic.ACMEDNSProvider, listener CertificateEventListener, log *zap.Logger) (*tls.Config, error) {
+	manager, err := newACMEManager(acme, storage, dns, listener, log)
+	if err != nil {
+		return nil, err
+	}
+
+	err = manager.manage(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return manager.primary.TLSConfig(), nil
+}
+
+type acmeManager struct {
+	acme    *AcmeConfig
+	primary *certmagic.Config
+	// domain -> config managing it, the certificates cache is shared, so any of the configs serves the handshakes
+	configs map[string]*certmagic.Config
+	issuers []*certmagic.ACMEIssuer
+}
+
+func newACMEManager(acme *AcmeConfig, storage certmagic.Storage, dns certmagic.ACMEDNSProvider, listener CertificateEventListener, log *zap.Logger) (*acmeManager, error) {
+	if storage == nil {
+		storage = &certmagic.FileStorage{Path: acme.CacheDir}
+	}
+
+	var trustedRoots *x509.CertPool
+	if acme.CARoot != "" {
+		pem, err := os.ReadFile(acme.CARoot)
+		if err != nil {
+			return nil, err
+		}
+
+		trustedRoots = x509.NewCertPool()
+		if !trustedRoots.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates found in the ca_root '%s'", acme.CARoot)
+		}
+	}
+
+	m := &acmeManager{
+		acme:    acme,
+		configs: make(map[string]*certmagic.Config),
+	}
+
 	cache := certmagic.NewCache(certmagic.CacheOptions{
 		GetConfigForCert: func(c certmagic.Certificate) (*certmagic.Config, error) {
-			return &certmagic.Config{
-				RenewalWindowRatio: 0,
-				MustStaple:         false,
-				OCSP:               certmagic.OCSPConfig{},
-				Storage:            &certmagic.FileStorage{Path: cacheDir},
-				Logger:             log,
-			}, nil
+			for i := 0; i < len(c.Names); i++ {
+				if cfg, ok := m.configs[c.Names[i]]; ok {
+					return cfg, nil
+				}
+			}
+
+			return m.primary, nil
 		},
-		OCSPCheckInterval:  0,
-		RenewCheckInterval: 0,
+		OCSPCheckInterval:  acme.OCSPCheckInterval,
+		RenewCheckInterval: acme.RenewCheckInterval,
 		Capacity:           0,
 	})
 
-	cfg := certmagic.New(cache, certmagic.Config{
-		RenewalWindowRatio: 0,
-		MustStaple:         false,
-		OCSP:               certmagic.OCSPConfig{},
-		Storage:            &certmagic.FileStorage{Path: cacheDir},
-		Logger:             log,
-	})
+	newConfig := func(chType challenge) *certmagic.Config {
+		cfg := certmagic.New(cache, certmagic.Config{
+			RenewalWindowRatio: acme.RenewalWindowRatio,
+			MustStaple:         acme.MustStaple,
+			OCSP:               certmagic.OCSPConfig{},
+			Storage:            storage,
+			Logger:             log,
+		})
 
-	myAcme := certmagic.NewACMEIssuer(cfg, certmagic.ACMEIssuer{
-		CA:                      certmagic.LetsEncryptProductionCA,
-		TestCA:                  certmagic.LetsEncryptStagingCA,
-		Email:                   email,
-		Agreed:                  true,
-		DisableHTTPChallenge:    false,
-		DisableTLSALPNChallenge: false,
-		ListenHost:              "0.0.0.0",
-		AltHTTPPort:             altHTTPPort,
-		AltTLSALPNPort:          altTLSAlpnPort,
-		CertObtainTimeout:       time.Second * 240,
-		PreferredChains:         certmagic.ChainPreference{},
-		Logger:                  log,
-	})
+		myAcme := certmagic.NewACMEIssuer(cfg, certmagic.ACMEIssuer{
+			CA:                      certmagic.LetsEncryptProductionCA,
+			TestCA:                  certmagic.LetsEncryptStagingCA,
+			Email:                   acme.Email,
+			Agreed:                  true,
+			DisableHTTPChallenge:    false,
+			DisableTLSALPNChallenge: false,
+			ListenHost:              "0.0.0.0",
+			AltHTTPPort:             acme.AltHTTPPort,
+			AltTLSALPNPort:          acme.AltTLSALPNPort,
+			CertObtainTimeout:       acme.CertObtainTimeout,
+			PreferredChains:         preferredChains(acme.PreferredChains),
+			TrustedRoots:            trustedRoots,
+			Logger:                  log,
+		})
+
+		if !acme.UseProductionEndpoint {
+			myAcme.CA = certmagic.LetsEncryptStagingCA
+		}
 
-	if !useProduction {
-		myAcme.CA = certmagic.LetsEncryptStagingCA
+		// custom ACME server (private CA, pebble in tests)
+		if acme.CA != "" {
+			myAcme.CA = acme.CA
+			myAcme.TestCA = acme.CA
+		}
+
+		switch chType {
+		case HTTP01:
+			myAcme.DisableTLSALPNChallenge = true
+		case TLSAlpn01:
+			myAcme.DisableHTTPChallenge = true
+		case DNS01:
+			myAcme.DisableHTTPChallenge = true
+			myAcme.DisableTLSALPNChallenge = true
+			myAcme.DNS01Solver = &certmagic.DNS01Solver{
+				DNSProvider: dns,
+			}
+		default:
+			// default - http
+			myAcme.DisableTLSALPNChallenge = true
+		}
+
+		cfg.Issuers = append(cfg.Issuers, myAcme)
+		m.issuers = append(m.issuers, myAcme)
+
+		if listener != nil {
+			cfg.OnEvent = onEvent(listener)
+		}
+
+		return cfg
+	}
+
+	m.primary = newConfig(challenge(acme.ChallengeType))
+	if acme.OnDemand != nil {
+		m.primary.OnDemand = &certmagic.OnDemandConfig{
+			DecisionFunc: acme.OnDemand.decision(&http.Client{Timeout: acme.OnDemand.AskTimeout}),
+		}
+	}
+
+	// one config per challenge type
+	byType := map[challenge]*certmagic.Config{challenge(acme.ChallengeType): m.primary}
+	for i := 0; i < len(acme.Domains); i++ {
+		chType := challenge(acme.DomainChallenge(acme.Domains[i]))
+		cfg, ok := byType[chType]
+		if !ok {
+			cfg = newConfig(chType)
+			byType[chType] = cfg
+		}
+
+		m.configs[acme.Domains[i]] = cfg
 	}
 
-	switch challenge(challengeType) {
-	case HTTP01:
-		myAcme.DisableTLSALPNChallenge = true
-	case TLSAlpn01:
-		myAcme.DisableHTTPChallenge = true
-	default:
-		// default - http
-		myAcme.DisableTLSALPNChallenge = true
+	return m, nil
+}
+
+// manage obtains the certificates for the configured domains and keeps them renewed
+func (m *acmeManager) manage(ctx context.Context) error {
+	// group the domains by the config managing them
+	groups := make(map[*certmagic.Config][]string)
+	order := make([]*certmagic.Config, 0, 1)
+	for i := 0; i < len(m.acme.Domains); i++ {
+		cfg := m.configs[m.acme.Domains[i]]
+		if _, ok := groups[cfg]; !ok {
+			order = append(order, cfg)
+		}
+		groups[cfg] = append(groups[cfg], m.acme.Domains[i])
 	}
 
-	cfg.Issuers = append(cfg.Issuers, myAcme)
+	for _, cfg := range order {
+		domains := groups[cfg]
+		for i := 0; i < len(domains); i++ {
+			err := cfg.ObtainCertAsync(ctx, domains[i])
+			if err != nil {
+				return err
+			}
+		}
 
-	for i := 0; i < len(domains); i++ {
-		err := cfg.ObtainCertAsync(context.Background(), domains[i])
+		err := cfg.ManageSync(ctx, domains)
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
 
-	err := cfg.ManageSync(context.Background(), domains)
-	if err != nil {
-		return nil, err
+	return nil
+}
+
+// httpChallengeHandler answers the HTTP-01 challenges on the /.well-known/acme-challenge/ path and passes
+// every other request to the next handler
+func (m *acmeManager) httpChallengeHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < len(m.issuers); i++ {
+			if m.issuers[i].HandleHTTPChallenge(w, r) {
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func preferredChains(cfg *PreferredChainsConfig) certmagic.ChainPreference {
+	if cfg == nil {
+		return certmagic.ChainPreference{}
+	}
+
+	pref := certmagic.ChainPreference{
+		AnyCommonName:  cfg.AnyCommonName,
+		RootCommonName: cfg.RootCommonName,
+	}
+
+	if cfg.Smallest {
+		smallest := true
+		pref.Smallest = &smallest
 	}
 
-	return cfg.TLSConfig(), nil
+	return pref
 }
@@ -22,19 +22,37 @@
 
 package https
 
-import "github.com/roadrunner-server/errors"
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+)
+
+// FileStorage keeps the certificates in the cache_dir
+const FileStorage string = "file"
 
 type AcmeConfig struct {
 	// directory to save the certificates, le_certs default
 	CacheDir string `mapstructure:"cache_dir" json:"cache_dir,omitempty" bson:"cache_dir,omitempty"`
 
+	// Storage for the certificates and locks: file (default, cache_dir) or the name of a storage provider plugin
+	Storage string `mapstructure:"storage" json:"storage,omitempty" bson:"storage,omitempty"`
+
 	// User email, mandatory
 	Email string `mapstructure:"email" json:"email,omitempty" bson:"email,omitempty"`
 
-	// supported values: http-01, tlsalpn-01
+	// supported values: http-01, tlsalpn-01, dns-01
 	ChallengeType string `mapstructure:"challenge_type" json:"challenge_type,omitempty" bson:"challenge_type,omitempty"`
 
-	// The alternate port to use for the ACME HTTP challenge
+	// Challenges overrides the challenge type for the listed domains, e.g. dns-01 for the wildcards
+	Challenges []DomainChallengeConfig `mapstructure:"challenges" json:"challenges,omitempty" bson:"challenges,omitempty"`
+
+	// DNSProvider is the name of the dns provider plugin solving dns-01 challenges
+	DNSProvider string `mapstructure:"dns_provider" json:"dns_provider,omitempty" bson:"dns_provider,omitempty"`
+
+	// The alternate port to use for the ACME HTTP challenge, when the HTTP server is enabled it serves the challenges as well
 	AltHTTPPort int `mapstructure:"alt_http_port" json:"alt_http_port,omitempty" bson:"alt_http_port,omitempty"`
 
 	// The alternate port to use for the ACME TLS-ALPN
@@ -43,8 +61,67 @@ type AcmeConfig struct {
 	// Use LE production endpoint or staging
 	UseProductionEndpoint bool `mapstructure:"use_production_endpoint" json:"use_production_endpoint,omitempty" bson:"use_production_endpoint,omitempty"`
 
+	// CA directory URL of a custom ACME server, overrides the LE endpoints (private CA, pebble in tests)
+	CA string `mapstructure:"ca" json:"ca,omitempty" bson:"ca,omitempty"`
+
+	// CARoot PEM file with the roots trusted for the ACME server connection
+	CARoot string `mapstructure:"ca_root" json:"ca_root,omitempty" bson:"ca_root,omitempty"`
+
 	// Domains to obtain certificates
 	Domains []string `mapstructure:"domains" json:"domains,omitempty" bson:"domains,omitempty"`
+
+	// RenewalWindowRatio is the portion of the certificate lifetime left when the renewal starts, 0 keeps the certmagic default (1/3)
+	RenewalWindowRatio float64 `mapstructure:"renewal_window_ratio" json:"renewal_window_ratio,omitempty" bson:"renewal_window_ratio,omitempty"`
+
+	// MustStaple adds the OCSP Must-Staple extension to the obtained certificates
+	MustStaple bool `mapstructure:"must_staple" json:"must_staple,omitempty" bson:"must_staple,omitempty"`
+
+	// CertObtainTimeout for a single certificate obtain, default 240s
+	CertObtainTimeout time.Duration `mapstructure:"cert_obtain_timeout" json:"cert_obtain_timeout,omitempty" bson:"cert_obtain_timeout,omitempty"`
+
+	// OCSPCheckInterval how often to refresh the OCSP staples, certmagic default 1h
+	OCSPCheckInterval time.Duration `mapstructure:"ocsp_check_interval" json:"ocsp_check_interval,omitempty" bson:"ocsp_check_interval,omitempty"`
+
+	// RenewCheckInterval how often to check the certificates for renewal, certmagic default 10m
+	RenewCheckInterval time.Duration `mapstructure:"renew_check_interval" json:"renew_check_interval,omitempty" bson:"renew_check_interval,omitempty"`
+
+	// PreferredChains selects one of the alternate chains offered by the CA
+	PreferredChains *PreferredChainsConfig `mapstructure:"preferred_chains" json:"preferred_chains,omitempty" bson:"preferred_chains,omitempty"`
+
+	// OnDemand issues certificates at the handshake time for the server names not known at startup
+	OnDemand *OnDemandConfig `mapstructure:"on_demand" json:"on_demand,omitempty" bson:"on_demand,omitempty"`
+}
+
+type PreferredChainsConfig struct {
+	// Smallest chain (fewest bytes) is preferred
+	Smallest bool `mapstructure:"smallest" json:"smallest,omitempty" bson:"smallest,omitempty"`
+
+	// AnyCommonName chain with any of the issuer common names is preferred, in order
+	AnyCommonName []string `mapstructure:"any_common_name" json:"any_common_name,omitempty" bson:"any_common_name,omitempty"`
+
+	// RootCommonName chain with the root issuer common name is preferred, in order
+	RootCommonName []string `mapstructure:"root_common_name" json:"root_common_name,omitempty" bson:"root_common_name,omitempty"`
+}
+
+type DomainChallengeConfig struct {
+	// Domains from the acme domains list
+	Domains []string `mapstructure:"domains" json:"domains,omitempty" bson:"domains,omitempty"`
+
+	// Type of the challenge: http-01, tlsalpn-01, dns-01
+	Type string `mapstructure:"type" json:"type,omitempty" bson:"type,omitempty"`
+}
+
+// DomainChallenge returns the challenge type used for the domain
+func (ac *AcmeConfig) DomainChallenge(domain string) string {
+	for i := 0; i < len(ac.Challenges); i++ {
+		for j := 0; j < len(ac.Challenges[i].Domains); j++ {
+			if ac.Challenges[i].Domains[j] == domain {
+				return ac.Challenges[i].Type
+			}
+		}
+	}
+
+	return ac.ChallengeType
 }
 
 func (ac *AcmeConfig) InitDefaults() error {
@@ -52,14 +129,33 @@ func (ac *AcmeConfig) InitDefaults() error {
 		ac.CacheDir = "cache_dir"
 	}
 
+	if ac.Storage == "" {
+		ac.Storage = FileStorage
+	}
+
+	if ac.CertObtainTimeout == 0 {
+		ac.CertObtainTimeout = time.Second * 240
+	}
+
+	if ac.RenewalWindowRatio < 0 || ac.RenewalWindowRatio >= 1 {
+		return errors.Errorf("renewal_window_ratio should be in the [0, 1) range, provided: %v", ac.RenewalWindowRatio)
+	}
+
 	if ac.Email == "" {
 		return errors.Str("email could not be empty")
 	}
 
-	if len(ac.Domains) == 0 {
+	if len(ac.Domains) == 0 && ac.OnDemand == nil {
 		return errors.Str("should be at least 1 domain")
 	}
 
+	if ac.OnDemand != nil {
+		err := ac.OnDemand.InitDefaults()
+		if err != nil {
+			return err
+		}
+	}
+
 	if ac.ChallengeType == "" {
 		ac.ChallengeType = "http-01"
 		if ac.AltHTTPPort == 0 {
@@ -67,5 +163,56 @@ func (ac *AcmeConfig) InitDefaults() error {
 		}
 	}
 
+	return ac.validChallenges()
+}
+
+func (ac *AcmeConfig) validChallenges() error {
+	const op = errors.Op("acme_challenges_valid")
+
+	valid := func(chType string) bool {
+		switch challenge(chType) {
+		case HTTP01, TLSAlpn01, DNS01:
+			return true
+		default:
+			return false
+		}
+	}
+
+	if !valid(ac.ChallengeType) {
+		return errors.E(op, errors.Errorf("unknown challenge type: %s", ac.ChallengeType))
+	}
+
+	seen := make(map[string]struct{})
+	for i := 0; i < len(ac.Challenges); i++ {
+		if !valid(ac.Challenges[i].Type) {
+			return errors.E(op, errors.Errorf("unknown challenge type: %s", ac.Challenges[i].Type))
+		}
+
+		for j := 0; j < len(ac.Challenges[i].Domains); j++ {
+			domain := ac.Challenges[i].Domains[j]
+			if !slices.Contains(ac.Domains, domain) {
+				return errors.E(op, errors.Errorf("challenge domain '%s' is not in the domains list", domain))
+			}
+
+			if _, ok := seen[domain]; ok {
+				return errors.E(op, errors.Errorf("domain '%s' has more than one challenge type", domain))
+			}
+			seen[domain] = struct{}{}
+		}
+	}
+
+	for i := 0; i < len(ac.Domains); i++ {
+		chType := challenge(ac.DomainChallenge(ac.Domains[i]))
+
+		// CAs issue wildcard certificates only with the dns-01 challenge
+		if strings.HasPrefix(ac.Domains[i], "*.") && chType != DNS01 {
+			return errors.E(op, errors.Errorf("wildcard domain '%s' requires dns-01 challenge", ac.Domains[i]))
+		}
+
+		if chType == DNS01 && ac.DNSProvider == "" {
+			return errors.E(op, errors.Errorf("domain '%s' uses dns-01 challenge, but dns_provider is not set", ac.Domains[i]))
+		}
+	}
+
 	return nil
 }
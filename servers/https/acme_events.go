@@ -0,0 +1,83 @@
+package https
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type CertificateEventType string
+
+const (
+	CertificateObtained      CertificateEventType = "obtained"
+	CertificateObtainFailed  CertificateEventType = "obtain_failed"
+	CertificateRenewed       CertificateEventType = "renewed"
+	CertificateRenewalFailed CertificateEventType = "renewal_failed"
+	CertificateRevoked       CertificateEventType = "revoked"
+)
+
+// CertificateEvent is an ACME certificate lifecycle change
+type CertificateEvent struct {
+	Type   CertificateEventType
+	Domain string
+	Time   time.Time
+	// Error of the failed obtain or renewal
+	Error error
+}
+
+// CertificateEventListener is implemented by the plugins reacting to the certificate lifecycle (alerting, webhooks).
+// OnCertificateEvent is called synchronously from the certmagic goroutines, so it should not block.
+type CertificateEventListener interface {
+	OnCertificateEvent(event CertificateEvent)
+}
+
+// CertificateEventListenerFunc is an adapter to use ordinary functions as CertificateEventListener
+type CertificateEventListenerFunc func(event CertificateEvent)
+
+func (f CertificateEventListenerFunc) OnCertificateEvent(event CertificateEvent) {
+	f(event)
+}
+
+// onEvent translates certmagic events into the CertificateEvent
+func onEvent(listener CertificateEventListener) func(ctx context.Context, event string, data map[string]any) error {
+	return func(_ context.Context, event string, data map[string]any) error {
+		renewal, _ := data["renewal"].(bool)
+		ev := CertificateEvent{
+			Time: time.Now(),
+		}
+
+		if id, ok := data["identifier"].(string); ok {
+			ev.Domain = id
+		}
+
+		switch event {
+		case "cert_obtained":
+			ev.Type = CertificateObtained
+			if renewal {
+				ev.Type = CertificateRenewed
+			}
+		case "cert_failed":
+			ev.Type = CertificateObtainFailed
+			if renewal {
+				ev.Type = CertificateRenewalFailed
+			}
+
+			switch e := data["error"].(type) {
+			case error:
+				ev.Error = e
+			case nil:
+			default:
+				ev.Error = fmt.Errorf("%v", e)
+			}
+		case "cert_ocsp_revoked":
+			ev.Type = CertificateRevoked
+		default:
+			return nil
+		}
+
+		listener.OnCertificateEvent(ev)
+
+		// a non-nil error would abort the certmagic operation
+		return nil
+	}
+}
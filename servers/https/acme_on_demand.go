@@ -0,0 +1,104 @@
+package https
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+)
+
+type OnDemandConfig struct {
+	// Allowed server names, "*.domain.com" matches a single label subdomain.
+	Allowed []string `mapstructure:"allowed" json:"allowed,omitempty" bson:"allowed,omitempty"`
+
+	// Ask endpoint is called as GET <ask>?domain=<name> before the issuance, 200 allows it.
+	Ask string `mapstructure:"ask" json:"ask,omitempty" bson:"ask,omitempty"`
+
+	// AskTimeout default 5s.
+	AskTimeout time.Duration `mapstructure:"ask_timeout" json:"ask_timeout,omitempty" bson:"ask_timeout,omitempty"`
+}
+
+func (od *OnDemandConfig) InitDefaults() error {
+	if od.AskTimeout == 0 {
+		od.AskTimeout = time.Second * 5
+	}
+
+	// anyone could make us issue certificates for any name otherwise
+	if len(od.Allowed) == 0 && od.Ask == "" {
+		return errors.Str("on_demand requires allowed names or ask endpoint")
+	}
+
+	if od.Ask != "" {
+		_, err := url.ParseRequestURI(od.Ask)
+		if err != nil {
+			return errors.Errorf("malformed on_demand ask endpoint: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// decision is the certmagic.OnDemandConfig.DecisionFunc, called at handshake for the names without certificate
+func (od *OnDemandConfig) decision(client *http.Client) func(name string) error {
+	return func(name string) error {
+		name = strings.ToLower(name)
+
+		if len(od.Allowed) > 0 && !matchName(od.Allowed, name) {
+			return errors.Errorf("on-demand certificate for '%s' is not allowed", name)
+		}
+
+		if od.Ask == "" {
+			return nil
+		}
+
+		ask, err := url.Parse(od.Ask)
+		if err != nil {
+			return err
+		}
+
+		q := ask.Query()
+		q.Set("domain", name)
+		ask.RawQuery = q.Encode()
+
+		ctx, cancel := context.WithTimeout(context.Background(), od.AskTimeout)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, ask.String(), nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return errors.Errorf("on-demand certificate for '%s' was declined by the ask endpoint: %d", name, resp.StatusCode)
+		}
+
+		return nil
+	}
+}
+
+func matchName(patterns []string, name string) bool {
+	for i := 0; i < len(patterns); i++ {
+		pattern := strings.ToLower(patterns[i])
+		if pattern == name {
+			return true
+		}
+
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+			// single label only: a.domain.com matches *.domain.com, a.b.domain.com does not
+			if label, found := strings.CutSuffix(name, suffix); found && label != "" && !strings.Contains(label, ".") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
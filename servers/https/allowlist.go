@@ -0,0 +1,43 @@
+package https
+
+import (
+	"crypto/tls"
+
+	"github.com/roadrunner-server/errors"
+
+	"github.com/rumorshub/http/middleware"
+)
+
+// clientAllowlist rejects verified client certificates whose CN and SANs are not allowed, at the TLS layer
+type clientAllowlist map[string]struct{}
+
+func newClientAllowlist(names []string) clientAllowlist {
+	al := make(clientAllowlist, len(names))
+	for i := 0; i < len(names); i++ {
+		al[names[i]] = struct{}{}
+	}
+
+	return al
+}
+
+// verifyConnection is the tls.Config.VerifyConnection, it runs for the resumed sessions as well
+func (al clientAllowlist) verifyConnection(cs tls.ConnectionState) error {
+	// no client certificate, the auth type decides
+	if len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	info := middleware.NewClientCertInfo(cs.VerifiedChains[0][0])
+	if _, ok := al[info.CommonName]; ok && info.CommonName != "" {
+		return nil
+	}
+
+	sans := info.SANs()
+	for i := 0; i < len(sans); i++ {
+		if _, ok := al[sans[i]]; ok {
+			return nil
+		}
+	}
+
+	return errors.Errorf("client certificate '%s' is not in the allowlist", info.Subject)
+}
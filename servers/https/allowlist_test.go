@@ -0,0 +1,75 @@
+package https
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestClientAllowlist(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := func(cn string, dns []string, uri string) *x509.Certificate {
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: cn},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			DNSNames:     dns,
+		}
+
+		if uri != "" {
+			u, _ := url.Parse(uri)
+			tmpl.URIs = []*url.URL{u}
+		}
+
+		der, errC := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+		if errC != nil {
+			t.Fatal(errC)
+		}
+
+		cert, errC := x509.ParseCertificate(der)
+		if errC != nil {
+			t.Fatal(errC)
+		}
+
+		return cert
+	}
+
+	al := newClientAllowlist([]string{"client.domain.com", "spiffe://domain.com/service"})
+
+	tests := []struct {
+		name string
+		cert *x509.Certificate
+		ok   bool
+	}{
+		{name: "common name", cert: client("client.domain.com", nil, ""), ok: true},
+		{name: "dns san", cert: client("other", []string{"client.domain.com"}, ""), ok: true},
+		{name: "spiffe id", cert: client("", nil, "spiffe://domain.com/service"), ok: true},
+		{name: "not allowed", cert: client("other.domain.com", []string{"other.domain.com"}, "")},
+		{name: "other spiffe id", cert: client("", nil, "spiffe://domain.com/other")},
+		{name: "empty", cert: client("", nil, "")},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		err = al.verifyConnection(tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{tests[i].cert}}})
+		if (err == nil) != tests[i].ok {
+			t.Fatalf("%s: error %v, allowed should be %v", tests[i].name, err, tests[i].ok)
+		}
+	}
+
+	// no verified certificate, the client auth type decides
+	if err = al.verifyConnection(tls.ConnectionState{}); err != nil {
+		t.Fatalf("the connection without the client certificate: %v", err)
+	}
+}
@@ -23,11 +23,16 @@
 package https
 
 import (
+	"net"
+	"net/http"
 	"os"
 	"strconv"
 	"strings"
 
 	"github.com/roadrunner-server/errors"
+
+	"github.com/rumorshub/http/middleware"
+	"github.com/rumorshub/http/servers/listener"
 )
 
 type ClientAuthType string
@@ -55,13 +60,26 @@ type HTTP2Config struct {
 
 	// MaxConcurrentStreams defaults to 128.
 	MaxConcurrentStreams uint32 `mapstructure:"max_concurrent_streams" json:"max_concurrent_streams,omitempty" bson:"max_concurrent_streams,omitempty"`
+
+	// Enabled HTTP/2 over TLS (h2 ALPN protocol) on the HTTPS server, defaults to true.
+	Enabled *bool `mapstructure:"enabled" json:"enabled,omitempty" bson:"enabled,omitempty"`
 }
 
 func (h2 *HTTP2Config) EnableHTTP2() bool {
 	return h2 != nil && h2.H2C
 }
 
+// DisableH2 reports whether the HTTPS server should stick to HTTP/1.1.
+func (h2 *HTTP2Config) DisableH2() bool {
+	return h2 != nil && h2.Enabled != nil && !*h2.Enabled
+}
+
 func (h2 *HTTP2Config) InitDefaults() error {
+	if h2.Enabled == nil {
+		enabled := true
+		h2.Enabled = &enabled
+	}
+
 	if h2.MaxConcurrentStreams == 0 {
 		h2.MaxConcurrentStreams = 128
 	}
@@ -73,13 +91,29 @@ type SSLConfig struct {
 	// Address to listen as HTTPS server, defaults to 0.0.0.0:443.
 	Address string `mapstructure:"address" json:"address,omitempty" bson:"address,omitempty"`
 
+	// Middleware of the HTTPS server (order will be preserved), the http middleware list is used when empty.
+	Middleware []string `mapstructure:"middleware" json:"middleware,omitempty" bson:"middleware,omitempty"`
+
 	// Acme configuration
 	Acme *AcmeConfig `mapstructure:"acme" json:"acme,omitempty" bson:"acme,omitempty"`
 
 	// Redirect when enabled forces all http connections to switch to https.
 	Redirect bool `mapstructure:"redirect" json:"redirect,omitempty" bson:"redirect,omitempty"`
 
-	// Key defined private server key.
+	// RedirectStatus of the http to https redirects: 301, 302, 307 or 308, defaults to 308.
+	RedirectStatus int `mapstructure:"redirect_status" json:"redirect_status,omitempty" bson:"redirect_status,omitempty"`
+
+	// RedirectRejectUnsafe responds 403 to the non-idempotent methods (POST, PATCH) instead of redirecting them,
+	// some clients drop the body on redirects.
+	RedirectRejectUnsafe bool `mapstructure:"redirect_reject_unsafe" json:"redirect_reject_unsafe,omitempty" bson:"redirect_reject_unsafe,omitempty"`
+
+	// RedirectExclude path prefixes served over http (health checks, ACME challenges).
+	RedirectExclude []string `mapstructure:"redirect_exclude" json:"redirect_exclude,omitempty" bson:"redirect_exclude,omitempty"`
+
+	// HSTS policy of the redirects and the HTTPS responses, defaults are used when the redirect is enabled.
+	HSTS *middleware.HSTSConfig `mapstructure:"hsts" json:"hsts,omitempty" bson:"hsts,omitempty"`
+
+	// Key defined private server key, file path or PKCS#11 URI (pkcs11:token=...;object=...) resolved by a SignerProvider.
 	Key string `mapstructure:"key" json:"key,omitempty" bson:"key,omitempty"`
 
 	// Cert is https certificate.
@@ -91,6 +125,25 @@ type SSLConfig struct {
 	// AuthType mTLS auth
 	AuthType ClientAuthType `mapstructure:"client_auth_type" json:"auth_type,omitempty" bson:"auth_type,omitempty"`
 
+	// ClientAuthPaths path prefixes which require a verified client certificate, other paths are served without it.
+	// The prefixes match the whole segments of the cleaned path, /admin does not cover /administrator.
+	// Requires root_ca and verify_client_cert_if_given auth type (used by default).
+	ClientAuthPaths []string `mapstructure:"client_auth_paths" json:"client_auth_paths,omitempty" bson:"client_auth_paths,omitempty"`
+
+	// ClientCertHeaders adds X-Client-Cert-CN, X-Client-Cert-Subject, X-Client-Cert-SAN and X-Client-Cert-Fingerprint
+	// request headers with the verified client certificate identity, it is always available in the request context.
+	ClientCertHeaders bool `mapstructure:"client_cert_headers" json:"client_cert_headers,omitempty" bson:"client_cert_headers,omitempty"`
+
+	// AllowedClientNames client certificate CNs or SANs (DNS, email, IP, URI incl. SPIFFE IDs) allowed to connect,
+	// other verified certificates are rejected during the handshake. Requires root_ca.
+	AllowedClientNames []string `mapstructure:"allowed_client_names" json:"allowed_client_names,omitempty" bson:"allowed_client_names,omitempty"`
+
+	// Spiffe obtains the server certificate and the client trust bundle from the SPIFFE workload API
+	Spiffe *SpiffeConfig `mapstructure:"spiffe" json:"spiffe,omitempty" bson:"spiffe,omitempty"`
+
+	// Revocation checking (CRL/OCSP) of the verified client certificates.
+	Revocation *RevocationConfig `mapstructure:"revocation" json:"revocation,omitempty" bson:"revocation,omitempty"`
+
 	// internal
 	host string
 	// internal
@@ -109,6 +162,39 @@ func (s *SSLConfig) InitDefaults() error {
 		s.Address = "127.0.0.1:443"
 	}
 
+	if s.RedirectStatus == 0 {
+		s.RedirectStatus = http.StatusPermanentRedirect
+	}
+
+	if s.HSTS == nil && s.Redirect {
+		s.HSTS = &middleware.HSTSConfig{}
+	}
+
+	if s.HSTS != nil {
+		err := s.HSTS.InitDefaults()
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(s.ClientAuthPaths) > 0 && s.AuthType == "" {
+		s.AuthType = VerifyClientCertIfGiven
+	}
+
+	if s.Spiffe != nil {
+		err := s.Spiffe.InitDefaults()
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.Revocation != nil {
+		err := s.Revocation.InitDefaults()
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -119,38 +205,55 @@ func (s *SSLConfig) EnableACME() bool {
 	return s.Acme != nil
 }
 
+func (s *SSLConfig) EnableSpiffe() bool {
+	if s == nil {
+		return false
+	}
+	return s.Spiffe != nil
+}
+
+// EnableMTLS reports whether client certificates could be verified: root_ca or SPIFFE trust bundle
+func (s *SSLConfig) EnableMTLS() bool {
+	return s.RootCA != "" || s.EnableSpiffe()
+}
+
 func (s *SSLConfig) Valid() error {
 	const op = errors.Op("ssl_valid")
 
-	parts := strings.Split(s.Address, ":")
-	switch len(parts) {
-	// :443 form
-	// 127.0.0.1:443 form
-	// use 0.0.0.0 as host and 443 as port
-	case 2:
-		if parts[0] == "" {
+	// the listener provided by a plugin has no port, the redirects go to the default one
+	if strings.HasPrefix(s.Address, listener.ProvidedScheme) {
+		s.host = "127.0.0.1"
+		s.Port = 443
+	} else {
+		// :443, 127.0.0.1:443 and [::1]:443 forms, 127.0.0.1 is used when the host is empty
+		host, port, err := net.SplitHostPort(s.Address)
+		if err != nil {
+			return errors.E(op, errors.Errorf("unknown format, accepted format is [:<port> or <host>:<port>], provided: %s", s.Address))
+		}
+
+		if host == "" {
 			s.host = "127.0.0.1"
 		} else {
-			s.host = parts[0]
+			s.host = host
 		}
 
-		port, err := strconv.Atoi(parts[1])
+		s.Port, err = strconv.Atoi(port)
 		if err != nil {
 			return errors.E(op, err)
 		}
-		s.Port = port
-	default:
-		return errors.E(op, errors.Errorf("unknown format, accepted format is [:<port> or <host>:<port>], provided: %s", s.Address))
 	}
 
 	// the user use they own certificates
-	if s.Acme == nil {
-		if _, err := os.Stat(s.Key); err != nil {
-			if os.IsNotExist(err) {
-				return errors.E(op, errors.Errorf("key file '%s' does not exists", s.Key))
+	if s.Acme == nil && s.Spiffe == nil {
+		// PKCS#11 key is not a file
+		if !s.EnableSigner() {
+			if _, err := os.Stat(s.Key); err != nil {
+				if os.IsNotExist(err) {
+					return errors.E(op, errors.Errorf("key file '%s' does not exists", s.Key))
+				}
+
+				return err
 			}
-
-			return err
 		}
 
 		if _, err := os.Stat(s.Cert); err != nil {
@@ -162,6 +265,34 @@ func (s *SSLConfig) Valid() error {
 		}
 	}
 
+	switch s.RedirectStatus {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return errors.E(op, errors.Errorf("redirect_status should be 301, 302, 307 or 308, provided: %d", s.RedirectStatus))
+	}
+
+	if len(s.ClientAuthPaths) > 0 {
+		if !s.EnableMTLS() {
+			return errors.E(op, errors.Str("client_auth_paths requires root_ca or spiffe to verify client certificates"))
+		}
+
+		// the handshake should not fail for the clients without certificate
+		if s.AuthType != VerifyClientCertIfGiven {
+			return errors.E(op, errors.Errorf("client_auth_paths requires %s client auth type, provided: %s", VerifyClientCertIfGiven, s.AuthType))
+		}
+	}
+
+	if len(s.AllowedClientNames) > 0 && !s.EnableMTLS() {
+		return errors.E(op, errors.Str("allowed_client_names requires root_ca or spiffe to verify client certificates"))
+	}
+
+	if s.Revocation != nil {
+		// only verified chains are passed to the VerifyPeerCertificate
+		if !s.EnableMTLS() || (s.AuthType != VerifyClientCertIfGiven && s.AuthType != RequireAndVerifyClientCert) {
+			return errors.E(op, errors.Errorf("revocation checking requires root_ca or spiffe and %s or %s client auth type", VerifyClientCertIfGiven, RequireAndVerifyClientCert))
+		}
+	}
+
 	// RootCA is optional, but if provided - check it
 	if s.RootCA != "" {
 		if _, err := os.Stat(s.RootCA); err != nil {
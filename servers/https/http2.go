@@ -23,6 +23,7 @@
 package https
 
 import (
+	"crypto/tls"
 	"net/http"
 
 	"golang.org/x/net/http2"
@@ -34,3 +35,17 @@ func initHTTP2(server *http.Server, streams uint32) error {
 		MaxConcurrentStreams: streams,
 	})
 }
+
+// disable http/2 over TLS, the empty TLSNextProto prevents net/http from adding h2 on ServeTLS
+func disableHTTP2(server *http.Server) {
+	server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+
+	protos := server.TLSConfig.NextProtos[:0]
+	for i := 0; i < len(server.TLSConfig.NextProtos); i++ {
+		if server.TLSConfig.NextProtos[i] != http2.NextProtoTLS {
+			protos = append(protos, server.TLSConfig.NextProtos[i])
+		}
+	}
+
+	server.TLSConfig.NextProtos = protos
+}
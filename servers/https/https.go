@@ -27,31 +27,99 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
-	"fmt"
 	"log"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
-	"strings"
 	"time"
 
+	"github.com/caddyserver/certmagic"
 	"github.com/mholt/acmez"
 	rrErrors "github.com/roadrunner-server/errors"
 	"go.uber.org/zap"
 	"golang.org/x/sys/cpu"
 
+	"github.com/rumorshub/http/events"
 	"github.com/rumorshub/http/middleware"
+	"github.com/rumorshub/http/servers/conninfo"
 	"github.com/rumorshub/http/servers/listener"
+	"github.com/rumorshub/http/servers/state"
+	"github.com/rumorshub/http/stats"
 )
 
 type Server struct {
-	cfg   *SSLConfig
-	log   *slog.Logger
-	https *http.Server
+	name       string
+	cfg        *SSLConfig
+	log        *slog.Logger
+	https      *http.Server
+	revocation *revocationChecker
+	spiffe     *spiffeSource
+	acme       *acmeManager
+
+	// acmeReady delays the certificates management until the HTTP listener serving the challenges is bound
+	acmeReady <-chan struct{}
+	stopCh    chan struct{}
+	events    events.Listener
+
+	// certs is set for the file based certificates, they can be reloaded at runtime
+	certs *certReloader
+	state *state.Tracker
+	stats *stats.Stats
+
+	// ln is the listener provided by the WithListener option
+	ln net.Listener
+	// lnCfg of the listener bound by the server itself, the defaults when nil
+	lnCfg *listener.Config
+
+	// keep-alive settings of the WithKeepAlive option
+	keepAlivesDisabled bool
+	maxConnRequests    uint64
+
+	// uriLimit of the WithURILimit option, nil when disabled
+	uriLimit *middleware.URILimitConfig
+	// streaming endpoints of the WithStreaming option, the defaults when nil
+	streaming *middleware.StreamingConfig
+	// auditor of the WithAuditor option, nil when the audit log is disabled
+	auditor middleware.Auditor
+
+	// certificate sources and TLS hooks of the options, used by NewHTTPSServer only
+	signer       SignerProvider
+	acmeStorage  certmagic.Storage
+	acmeDNS      certmagic.ACMEDNSProvider
+	certListener CertificateEventListener
+	configurers  []TLSConfigurer
 }
 
-func NewHTTPSServer(handler http.Handler, cfg *SSLConfig, cfgHTTP2 *HTTP2Config, errLog *log.Logger, sLog *slog.Logger, zapLog *zap.Logger) (*Server, error) {
-	httpsServer := initTLS(handler, errLog, cfg.Address, cfg.Port)
+// NewHTTPSServer creates the server of the SSL config, the certificate sources other than the files (signer,
+// ACME storage and DNS provider) and the rest of the settings are passed as the options.
+func NewHTTPSServer(handler http.Handler, cfg *SSLConfig, cfgHTTP2 *HTTP2Config, errLog *log.Logger, sLog *slog.Logger, zapLog *zap.Logger, opts ...Option) (*Server, error) {
+	st := stats.New()
+	s := &Server{
+		cfg:    cfg,
+		log:    sLog,
+		https:  initTLS(handler, errLog, cfg.Address, cfg.Port),
+		state:  state.NewTracker(),
+		stats:  st,
+		stopCh: make(chan struct{}),
+	}
+	s.https.ConnState = st.ConnState
+
+	// before the TLS setup, it uses the certificate sources of the options
+	for i := 0; i < len(opts); i++ {
+		opts[i](s)
+	}
+
+	httpsServer := s.https
+
+	if cfg.EnableSigner() {
+		cert, err := loadSignerCertificate(cfg.Cert, cfg.Key, s.signer)
+		if err != nil {
+			return nil, err
+		}
+
+		httpsServer.TLSConfig.Certificates = append(httpsServer.TLSConfig.Certificates, *cert)
+	}
 
 	if cfg.RootCA != "" {
 		pool, err := createCertPool(cfg.RootCA)
@@ -62,95 +130,225 @@ func NewHTTPSServer(handler http.Handler, cfg *SSLConfig, cfgHTTP2 *HTTP2Config,
 		if pool != nil {
 			httpsServer.TLSConfig.ClientCAs = pool
 			// auth type used only for the CA
-			switch cfg.AuthType {
-			case NoClientCert:
-				httpsServer.TLSConfig.ClientAuth = tls.NoClientCert
-			case RequestClientCert:
-				httpsServer.TLSConfig.ClientAuth = tls.RequestClientCert
-			case RequireAnyClientCert:
-				httpsServer.TLSConfig.ClientAuth = tls.RequireAnyClientCert
-			case VerifyClientCertIfGiven:
-				httpsServer.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
-			case RequireAndVerifyClientCert:
-				httpsServer.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
-			default:
-				httpsServer.TLSConfig.ClientAuth = tls.NoClientCert
-			}
+			httpsServer.TLSConfig.ClientAuth = clientAuth(cfg.AuthType)
 		}
 	}
 
-	if cfg.EnableACME() {
-		tlsCfg, err := IssueCertificates(
-			cfg.Acme.CacheDir,
-			cfg.Acme.Email,
-			cfg.Acme.ChallengeType,
-			cfg.Acme.Domains,
-			cfg.Acme.UseProductionEndpoint,
-			cfg.Acme.AltHTTPPort,
-			cfg.Acme.AltTLSALPNPort,
-			zapLog,
-		)
+	if cfg.EnableSpiffe() {
+		var err error
+		s.spiffe, err = newSpiffeSource(cfg.Spiffe, sLog)
+		if err != nil {
+			return nil, err
+		}
+
+		httpsServer.TLSConfig.GetCertificate = s.spiffe.getCertificate
+		// the trust bundle rotates, so the ClientCAs are set per handshake
+		httpsServer.TLSConfig.ClientAuth = clientAuth(cfg.AuthType)
+		httpsServer.TLSConfig.GetConfigForClient = s.spiffe.getConfigForClient(httpsServer.TLSConfig)
+	}
+
+	if len(cfg.AllowedClientNames) > 0 {
+		httpsServer.TLSConfig.VerifyConnection = newClientAllowlist(cfg.AllowedClientNames).verifyConnection
+	}
 
+	if cfg.Revocation != nil {
+		var err error
+		s.revocation, err = newRevocationChecker(cfg.Revocation, sLog)
 		if err != nil {
 			return nil, err
 		}
 
-		httpsServer.TLSConfig.GetCertificate = tlsCfg.GetCertificate
+		httpsServer.TLSConfig.VerifyPeerCertificate = s.revocation.verify
+	}
+
+	if cfg.EnableACME() {
+		var err error
+		s.acme, err = newACMEManager(cfg.Acme, s.acmeStorage, s.acmeDNS, s.certListener, zapLog)
+		if err != nil {
+			return nil, err
+		}
+
+		httpsServer.TLSConfig.GetCertificate = s.acme.primary.GetCertificate
 		httpsServer.TLSConfig.NextProtos = append(httpsServer.TLSConfig.NextProtos, acmez.ACMETLS1Protocol)
 	}
 
-	if cfgHTTP2 != nil && cfgHTTP2.EnableHTTP2() {
+	if !cfg.EnableSigner() && !cfg.EnableACME() && !cfg.EnableSpiffe() && cfg.Cert != "" {
+		var err error
+		s.certs, err = newCertReloader(cfg.Cert, cfg.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		httpsServer.TLSConfig.GetCertificate = s.certs.getCertificate
+	}
+
+	for i := 0; i < len(s.configurers); i++ {
+		err := s.configurers[i].ConfigureTLS(httpsServer.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case cfgHTTP2.DisableH2():
+		disableHTTP2(httpsServer)
+	case cfgHTTP2.EnableHTTP2():
 		err := initHTTP2(httpsServer, cfgHTTP2.MaxConcurrentStreams)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	return &Server{
-		cfg:   cfg,
-		log:   sLog,
-		https: httpsServer,
-	}, nil
+	return s, nil
+}
+
+// HTTPChallengeHandler serves the ACME HTTP-01 challenges in front of the next handler, so the plain HTTP
+// server could solve them instead of the listener on the alt_http_port.
+func (s *Server) HTTPChallengeHandler(next http.Handler) http.Handler {
+	if s.acme == nil {
+		return next
+	}
+
+	return s.acme.httpChallengeHandler(next)
+}
+
+// ManageAfter delays obtaining the ACME certificates until the ready channel is closed.
+func (s *Server) ManageAfter(ready <-chan struct{}) {
+	s.acmeReady = ready
 }
 
 func (s *Server) Start(mdwr map[string]middleware.Middleware, order []string) error {
 	const op = rrErrors.Op("serveHTTPS")
 
+	// the CRL files are re-read by the started server only, the discarded one (e.g. the failed reset) leaks nothing
+	if s.revocation != nil {
+		s.revocation.start()
+	}
+
+	outermost := make([]middleware.Middleware, 0, 1)
+	for i := 0; i < len(order); i++ {
+		if o, ok := mdwr[order[i]].(middleware.Outermost); ok && o.Outermost() {
+			outermost = append(outermost, mdwr[order[i]])
+		}
+	}
+
+	// the outermost access log reads the values the middleware add to their request copies
+	if len(outermost) > 0 {
+		s.https.Handler = middleware.TrackRequest(s.https.Handler)
+	}
+
 	for i := 0; i < len(order); i++ {
 		if m, ok := mdwr[order[i]]; ok {
+			if o, ok := m.(middleware.Outermost); ok && o.Outermost() {
+				continue
+			}
+
 			s.https.Handler = m.Middleware(s.https.Handler)
+			if len(outermost) > 0 {
+				s.https.Handler = middleware.TrackRequest(s.https.Handler)
+			}
 		} else {
 			s.log.Warn("requested middleware does not exist", "requested", order[i])
+			s.emit(events.Event{Type: events.MiddlewareMissing, Middleware: order[i]})
+		}
+	}
+
+	if hsts := s.cfg.HSTS.Value(); hsts != "" {
+		s.https.Handler = middleware.HSTS(s.https.Handler, hsts)
+	}
+
+	// identity of the verified client certificate for the middleware and handlers, the identity headers sent by
+	// the client are removed even without mTLS
+	s.https.Handler = middleware.ClientCertIdentity(s.https.Handler, s.cfg.EnableMTLS() && s.cfg.ClientCertHeaders)
+
+	// client certificate check should go before any other middleware
+	if len(s.cfg.ClientAuthPaths) > 0 {
+		s.https.Handler = middleware.ClientCertRequired(s.https.Handler, s.cfg.ClientAuthPaths)
+	}
+
+	if s.maxConnRequests > 0 {
+		s.https.Handler = middleware.MaxConnRequests(s.https.Handler, s.maxConnRequests)
+	}
+
+	// the long URIs are rejected before any other middleware
+	if s.uriLimit != nil && s.uriLimit.MaxLength > 0 {
+		s.https.Handler = middleware.MaxURILength(s.https.Handler, s.uriLimit)
+	}
+
+	// every middleware records the security events, the URI limit included
+	if s.auditor != nil {
+		s.https.Handler = middleware.Auditing(s.https.Handler, s.auditor)
+	}
+
+	s.https.Handler = s.state.Middleware(s.https.Handler)
+
+	for i := 0; i < len(outermost); i++ {
+		s.https.Handler = outermost[i].Middleware(s.https.Handler)
+	}
+
+	// every middleware should know the request is streaming
+	s.https.Handler = middleware.Streaming(s.https.Handler, s.streaming)
+
+	// certificates are obtained before the listener is bound, so the tls-alpn-01 solver could use the port
+	if s.cfg.EnableACME() {
+		if s.acmeReady != nil {
+			select {
+			case <-s.acmeReady:
+			case <-s.stopCh:
+				return nil
+			}
+		}
+
+		err := s.acme.manage(context.Background())
+		if err != nil {
+			s.emit(events.Event{Type: events.ServeError, Error: err})
+			return rrErrors.E(op, err)
 		}
 	}
 
-	l, err := listener.CreateListener(s.cfg.Address)
+	l, err := s.listener()
 	if err != nil {
+		s.emit(events.Event{Type: events.ServeError, Error: err})
 		return rrErrors.E(op, err)
 	}
 
+	s.https.ConnContext = conninfo.ConnContext(s.Name(), s.Address())
+	s.state.Set(state.Running)
+	s.stats.Start()
+	s.emit(events.Event{Type: events.ListenerBound})
+
 	if s.cfg.EnableACME() {
-		s.log.Debug("https(acme) server was started", "address", s.cfg.Address)
+		s.log.Debug("https(acme) server was started", "address", s.Address())
+		s.emit(events.Event{Type: events.ServerStarted})
 		err = s.https.ServeTLS(
 			l,
 			"",
 			"",
 		)
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.emit(events.Event{Type: events.ServeError, Error: err})
 			return rrErrors.E(op, err)
 		}
 
 		return nil
 	}
 
-	s.log.Debug("https server was started", "address", s.cfg.Address)
+	certFile, keyFile := s.cfg.Cert, s.cfg.Key
+	// the signer backed, reloadable and SPIFFE certificates are already in the TLS config
+	if s.cfg.EnableSigner() || s.cfg.EnableSpiffe() || s.certs != nil {
+		certFile, keyFile = "", ""
+	}
+
+	s.log.Debug("https server was started", "address", s.Address())
+	s.emit(events.Event{Type: events.ServerStarted})
 	err = s.https.ServeTLS(
 		l,
-		s.cfg.Cert,
-		s.cfg.Key,
+		certFile,
+		keyFile,
 	)
 
 	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		s.emit(events.Event{Type: events.ServeError, Error: err})
 		return rrErrors.E(op, err)
 	}
 
@@ -161,11 +359,126 @@ func (s *Server) GetServer() *http.Server {
 	return s.https
 }
 
+// Name of the server, "https" unless it is a named server
+func (s *Server) Name() string {
+	if s.name == "" {
+		return "https"
+	}
+
+	return s.name
+}
+
+// SetName names the additional server
+func (s *Server) SetName(name string) {
+	s.name = name
+}
+
+func (s *Server) Address() string {
+	if s.ln != nil {
+		return s.ln.Addr().String()
+	}
+
+	return s.cfg.Address
+}
+
+func (s *Server) State() state.State {
+	return s.state.State()
+}
+
+func (s *Server) Stats() *stats.Stats {
+	return s.stats
+}
+
+// Drain makes the server answer 503 with the closed connections, returns the previous value.
+func (s *Server) Drain(drain bool) bool {
+	s.https.SetKeepAlivesEnabled(!drain && !s.keepAlivesDisabled)
+	return s.state.Drain(drain)
+}
+
+// OnShutdown registers the function called in its own goroutine when the graceful shutdown begins,
+// see http.Server.RegisterOnShutdown.
+func (s *Server) OnShutdown(f func()) {
+	s.https.RegisterOnShutdown(f)
+}
+
+// ReloadableCertificates reports whether the certificates are loaded from the files
+func (s *Server) ReloadableCertificates() bool {
+	return s.certs != nil
+}
+
+// ReloadCertificates re-reads the certificate and key files, the new handshakes use them.
+func (s *Server) ReloadCertificates() error {
+	if s.certs == nil {
+		return rrErrors.Str("certificates are not loaded from the files (ACME, signer or SPIFFE manage them)")
+	}
+
+	return s.certs.reload()
+}
+
+func (s *Server) listener() (net.Listener, error) {
+	if s.ln != nil {
+		return s.ln, nil
+	}
+
+	l, err := listener.CreateListener(s.cfg.Address, s.lnCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return listener.Limit(l, s.lnCfg, nil), nil
+}
+
+// OnEvent sets the listener of the server lifecycle events.
+func (s *Server) OnEvent(listener events.Listener) {
+	s.events = listener
+}
+
+func (s *Server) emit(event events.Event) {
+	if s.events == nil {
+		return
+	}
+
+	event.Server = s.Name()
+	event.Address = s.Address()
+	event.Time = time.Now()
+	s.events.OnServerEvent(event)
+}
+
 func (s *Server) Stop() {
+	close(s.stopCh)
+
+	if s.revocation != nil {
+		s.revocation.stop()
+	}
+
+	if s.spiffe != nil {
+		s.spiffe.stop()
+	}
+
 	err := s.https.Shutdown(context.Background())
 	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		s.log.Error("https shutdown", "error", err)
 	}
+
+	s.state.Set(state.Stopped)
+	s.emit(events.Event{Type: events.ServerStopped, Error: err})
+}
+
+func clientAuth(authType ClientAuthType) tls.ClientAuthType {
+	switch authType {
+	case NoClientCert:
+		return tls.NoClientCert
+	case RequestClientCert:
+		return tls.RequestClientCert
+	case RequireAnyClientCert:
+		return tls.RequireAnyClientCert
+	case VerifyClientCertIfGiven:
+		return tls.VerifyClientCertIfGiven
+	case RequireAndVerifyClientCert:
+		return tls.RequireAndVerifyClientCert
+	default:
+		return tls.NoClientCert
+	}
 }
 
 // append RootCA to the https server TLS config
@@ -244,7 +557,7 @@ func initTLS(handler http.Handler, errLog *log.Logger, addr string, port int) *h
 	DefaultCipherSuites = append(DefaultCipherSuites, defaultCipherSuitesTLS13...)
 
 	sslServer := &http.Server{
-		Addr:              tlsAddr(addr, true, port),
+		Addr:              middleware.TLSAddr(addr, true, port),
 		Handler:           handler,
 		ErrorLog:          errLog,
 		ReadHeaderTimeout: time.Minute * 5,
@@ -262,15 +575,3 @@ func initTLS(handler http.Handler, errLog *log.Logger, addr string, port int) *h
 
 	return sslServer
 }
-
-// tlsAddr replaces listen or host port with port configured by SSLConfig config.
-func tlsAddr(host string, forcePort bool, sslPort int) string {
-	// remove current forcePort first
-	host = strings.Split(host, ":")[0]
-
-	if forcePort || sslPort != 443 {
-		host = fmt.Sprintf("%s:%v", host, sslPort)
-	}
-
-	return host
-}
@@ -0,0 +1,184 @@
+package https
+
+import (
+	"crypto/tls"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+
+	"github.com/rumorshub/http/middleware"
+	"github.com/rumorshub/http/servers/listener"
+	"github.com/rumorshub/http/servers/state"
+	"github.com/rumorshub/http/stats"
+)
+
+// Option customizes the server created by New.
+type Option func(*Server)
+
+// Timeouts of the server, zero values keep the defaults.
+type Timeouts struct {
+	Read       time.Duration
+	ReadHeader time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+}
+
+// KeepAlive of the persistent connections, zero values keep the defaults.
+type KeepAlive struct {
+	// Disabled closes every connection after the response
+	Disabled bool
+	// IdleTimeout of the connection between the requests
+	IdleTimeout time.Duration
+	// MaxRequests served on the HTTP/1.x connection before it is closed
+	MaxRequests uint64
+}
+
+// New creates the HTTPS server without the config structs, it listens on 127.0.0.1:443 by default.
+// The certificates should be provided with WithTLSConfig (Certificates or GetCertificate).
+func New(handler http.Handler, opts ...Option) *Server {
+	st := stats.New()
+	s := &Server{
+		cfg:    &SSLConfig{Address: "127.0.0.1:443"},
+		log:    slog.Default(),
+		https:  initTLS(handler, nil, "127.0.0.1:443", 443),
+		state:  state.NewTracker(),
+		stats:  st,
+		stopCh: make(chan struct{}),
+	}
+	s.https.ConnState = st.ConnState
+
+	for i := 0; i < len(opts); i++ {
+		opts[i](s)
+	}
+
+	return s
+}
+
+func WithAddress(address string) Option {
+	return func(s *Server) {
+		s.cfg.Address = address
+	}
+}
+
+func WithTimeouts(timeouts Timeouts) Option {
+	return func(s *Server) {
+		if timeouts.Read > 0 {
+			s.https.ReadTimeout = timeouts.Read
+		}
+
+		if timeouts.ReadHeader > 0 {
+			s.https.ReadHeaderTimeout = timeouts.ReadHeader
+		}
+
+		if timeouts.Write > 0 {
+			s.https.WriteTimeout = timeouts.Write
+		}
+
+		if timeouts.Idle > 0 {
+			s.https.IdleTimeout = timeouts.Idle
+		}
+	}
+}
+
+// WithTLSConfig replaces the default TLS config (cipher suites, curves, TLS 1.2+)
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(s *Server) {
+		s.https.TLSConfig = cfg
+	}
+}
+
+// WithErrorLog sets the logger of the net/http errors (handshakes, panics)
+func WithErrorLog(errLog *log.Logger) Option {
+	return func(s *Server) {
+		s.https.ErrorLog = errLog
+	}
+}
+
+func WithLogger(log *slog.Logger) Option {
+	return func(s *Server) {
+		s.log = log
+	}
+}
+
+// WithListener serves the provided listener instead of binding the address
+func WithListener(l net.Listener) Option {
+	return func(s *Server) {
+		s.ln = l
+	}
+}
+
+// WithListenerConfig sets the options of the listener bound by the server, see WithListener
+func WithListenerConfig(cfg *listener.Config) Option {
+	return func(s *Server) {
+		s.lnCfg = cfg
+	}
+}
+
+// WithKeepAlive configures the persistent connections
+func WithKeepAlive(keepAlive KeepAlive) Option {
+	return func(s *Server) {
+		s.keepAlivesDisabled = keepAlive.Disabled
+		s.https.SetKeepAlivesEnabled(!keepAlive.Disabled)
+
+		if keepAlive.IdleTimeout > 0 {
+			s.https.IdleTimeout = keepAlive.IdleTimeout
+		}
+
+		s.maxConnRequests = keepAlive.MaxRequests
+	}
+}
+
+// WithURILimit rejects the requests with the URI above the limit, nil disables it
+func WithURILimit(cfg *middleware.URILimitConfig) Option {
+	return func(s *Server) {
+		s.uriLimit = cfg
+	}
+}
+
+// WithStreaming marks the streaming endpoints, nil uses the defaults
+func WithStreaming(cfg *middleware.StreamingConfig) Option {
+	return func(s *Server) {
+		s.streaming = cfg
+	}
+}
+
+// WithAuditor records the security events of the requests, nil disables it
+func WithAuditor(a middleware.Auditor) Option {
+	return func(s *Server) {
+		s.auditor = a
+	}
+}
+
+// WithSigner provides the private key of the signer config, see SSLConfig.Signer
+func WithSigner(signer SignerProvider) Option {
+	return func(s *Server) {
+		s.signer = signer
+	}
+}
+
+// WithACME sets the certificates storage and the DNS-01 solver of the ACME config, nil keeps the defaults
+// (the file storage and the HTTP-01/TLS-ALPN-01 challenges)
+func WithACME(storage certmagic.Storage, dns certmagic.ACMEDNSProvider) Option {
+	return func(s *Server) {
+		s.acmeStorage = storage
+		s.acmeDNS = dns
+	}
+}
+
+// WithCertificateListener receives the certificate events of the ACME manager
+func WithCertificateListener(listener CertificateEventListener) Option {
+	return func(s *Server) {
+		s.certListener = listener
+	}
+}
+
+// WithTLSConfigurers customize the TLS config after the certificates are set up
+func WithTLSConfigurers(configurers ...TLSConfigurer) Option {
+	return func(s *Server) {
+		s.configurers = append(s.configurers, configurers...)
+	}
+}
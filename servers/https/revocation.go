@@ -0,0 +1,435 @@
+package https
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+	"golang.org/x/crypto/ocsp"
+)
+
+type RevocationConfig struct {
+	// CRLFiles PEM or DER encoded certificate revocation lists, re-read every refresh interval and once the list
+	// of the client certificate issuer is past its next update.
+	CRLFiles []string `mapstructure:"crl_files" json:"crl_files,omitempty" bson:"crl_files,omitempty"`
+
+	// CRLFailOpen accepts the certificates of the issuer which CRL is past its next update, they are rejected
+	// until the fresh list is loaded otherwise. The revoked certificates of the stale list are rejected either way.
+	CRLFailOpen bool `mapstructure:"crl_fail_open" json:"crl_fail_open,omitempty" bson:"crl_fail_open,omitempty"`
+
+	// OCSP enables checking of the client certificates against the OCSP responder from the certificate.
+	OCSP bool `mapstructure:"ocsp" json:"ocsp,omitempty" bson:"ocsp,omitempty"`
+
+	// OCSPFailOpen accepts the certificate when the responder is unreachable or the status is unknown. The failures
+	// are cached for a minute either way.
+	OCSPFailOpen bool `mapstructure:"ocsp_fail_open" json:"ocsp_fail_open,omitempty" bson:"ocsp_fail_open,omitempty"`
+
+	// OCSPTimeout for a single responder request, default 5s.
+	OCSPTimeout time.Duration `mapstructure:"ocsp_timeout" json:"ocsp_timeout,omitempty" bson:"ocsp_timeout,omitempty"`
+
+	// RefreshInterval to re-read CRL files and the maximum OCSP response cache time, default 1h.
+	RefreshInterval time.Duration `mapstructure:"refresh_interval" json:"refresh_interval,omitempty" bson:"refresh_interval,omitempty"`
+}
+
+func (rc *RevocationConfig) InitDefaults() error {
+	if rc.OCSPTimeout == 0 {
+		rc.OCSPTimeout = time.Second * 5
+	}
+
+	if rc.RefreshInterval == 0 {
+		rc.RefreshInterval = time.Hour
+	}
+
+	if len(rc.CRLFiles) == 0 && !rc.OCSP {
+		return errors.Str("revocation checking requires crl_files or ocsp")
+	}
+
+	return nil
+}
+
+// ocspFailureTTL of the failed responder answers, the handshakes do not wait for the unreachable responder
+const ocspFailureTTL = time.Minute
+
+// crlReloadInterval between the reloads of the CRL files triggered by the stale lists
+const crlReloadInterval = time.Minute
+
+type ocspStatus struct {
+	revoked bool
+	// failed lookup, the certificate is accepted or rejected by the ocsp_fail_open
+	failed bool
+	err    error
+	until  time.Time
+}
+
+// crlList of the issuer, it applies to the certificates of the issuer it is signed by
+type crlList struct {
+	list    *x509.RevocationList
+	revoked map[string]struct{}
+
+	mu sync.Mutex
+	// signature check results by the raw issuer certificate
+	signedBy map[string]bool
+}
+
+// signed checks the list is signed by the issuer, the result is cached
+func (l *crlList) signed(issuer *x509.Certificate) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if ok, checked := l.signedBy[string(issuer.Raw)]; checked {
+		return ok, nil
+	}
+
+	err := l.list.CheckSignatureFrom(issuer)
+	l.signedBy[string(issuer.Raw)] = err == nil
+
+	return err == nil, err
+}
+
+// revocationChecker rejects revoked client certificates in the tls.Config.VerifyPeerCertificate
+type revocationChecker struct {
+	cfg    *RevocationConfig
+	log    *slog.Logger
+	client *http.Client
+	stopCh chan struct{}
+	once   sync.Once
+	// started by the server Start
+	started sync.Once
+
+	mu sync.RWMutex
+	// lists of the CRL files by the raw issuer name
+	crls map[string][]*crlList
+	// last reload of the CRL files triggered by the stale list
+	staleReload time.Time
+	// issuer + serial -> cached responder answer
+	ocsp map[string]ocspStatus
+	// issuer + serial -> closed once the responder query in flight is done
+	ocspQueries map[string]chan struct{}
+}
+
+func newRevocationChecker(cfg *RevocationConfig, log *slog.Logger) (*revocationChecker, error) {
+	rc := &revocationChecker{
+		cfg:    cfg,
+		log:    log,
+		client: &http.Client{Timeout: cfg.OCSPTimeout},
+		stopCh: make(chan struct{}),
+		crls:   make(map[string][]*crlList),
+		ocsp:   make(map[string]ocspStatus),
+
+		ocspQueries: make(map[string]chan struct{}),
+	}
+
+	// the first load should succeed, later the last good lists are kept
+	err := rc.loadCRLs()
+	if err != nil {
+		return nil, err
+	}
+
+	return rc, nil
+}
+
+func (rc *revocationChecker) start() {
+	rc.started.Do(func() {
+		go rc.refresh()
+	})
+}
+
+func (rc *revocationChecker) stop() {
+	rc.once.Do(func() {
+		close(rc.stopCh)
+	})
+}
+
+func (rc *revocationChecker) refresh() {
+	ticker := time.NewTicker(rc.cfg.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rc.stopCh:
+			return
+		case <-ticker.C:
+			err := rc.loadCRLs()
+			if err != nil {
+				rc.log.Error("failed to reload CRL files, previous lists are kept", "error", err)
+			}
+
+			// drop expired OCSP answers
+			now := time.Now()
+			rc.mu.Lock()
+			for k, v := range rc.ocsp {
+				if now.After(v.until) {
+					delete(rc.ocsp, k)
+				}
+			}
+			rc.mu.Unlock()
+		}
+	}
+}
+
+func (rc *revocationChecker) loadCRLs() error {
+	const op = errors.Op("https_load_crl")
+
+	if len(rc.cfg.CRLFiles) == 0 {
+		return nil
+	}
+
+	crls := make(map[string][]*crlList)
+	for i := 0; i < len(rc.cfg.CRLFiles); i++ {
+		data, err := os.ReadFile(rc.cfg.CRLFiles[i])
+		if err != nil {
+			return errors.E(op, err)
+		}
+
+		if block, _ := pem.Decode(data); block != nil {
+			data = block.Bytes
+		}
+
+		crl, err := x509.ParseRevocationList(data)
+		if err != nil {
+			return errors.E(op, errors.Errorf("crl file '%s': %v", rc.cfg.CRLFiles[i], err))
+		}
+
+		list := &crlList{
+			list:     crl,
+			revoked:  make(map[string]struct{}, len(crl.RevokedCertificateEntries)),
+			signedBy: make(map[string]bool),
+		}
+
+		for j := 0; j < len(crl.RevokedCertificateEntries); j++ {
+			list.revoked[string(crl.RevokedCertificateEntries[j].SerialNumber.Bytes())] = struct{}{}
+		}
+
+		crls[string(crl.RawIssuer)] = append(crls[string(crl.RawIssuer)], list)
+	}
+
+	rc.mu.Lock()
+	rc.crls = crls
+	rc.mu.Unlock()
+
+	return nil
+}
+
+// verify is the tls.Config.VerifyPeerCertificate, called after the chains are verified against the ClientCAs
+func (rc *revocationChecker) verify(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+	for i := 0; i < len(verifiedChains); i++ {
+		chain := verifiedChains[i]
+		if len(chain) == 0 {
+			continue
+		}
+
+		leaf := chain[0]
+		key := serialKey(leaf.RawIssuer, leaf.SerialNumber.Bytes())
+
+		// the self-signed leaf has no issuer in the chain, no list could be verified for it
+		if len(chain) > 1 {
+			err := rc.checkCRL(leaf, chain[1])
+			if err != nil {
+				return err
+			}
+		}
+
+		if rc.cfg.OCSP && len(chain) > 1 && len(leaf.OCSPServer) > 0 {
+			err := rc.checkOCSP(key, leaf, chain[1])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkCRL checks the lists of the leaf issuer name, the ones not signed by the issuer of the chain are ignored. The
+// lists past the next update trigger the reload of the files and reject the certificate unless crl_fail_open.
+func (rc *revocationChecker) checkCRL(leaf, issuer *x509.Certificate) error {
+	rc.mu.RLock()
+	lists := rc.crls[string(leaf.RawIssuer)]
+	rc.mu.RUnlock()
+
+	now := time.Now()
+	stale := false
+	for i := 0; i < len(lists); i++ {
+		signed, err := lists[i].signed(issuer)
+		if !signed {
+			rc.log.Warn("crl is not signed by the issuer of the client certificate, ignored", "issuer", issuer.Subject.String(), "error", err)
+			continue
+		}
+
+		if _, ok := lists[i].revoked[string(leaf.SerialNumber.Bytes())]; ok {
+			return errors.Errorf("client certificate '%s' is revoked (CRL)", leaf.Subject.String())
+		}
+
+		if next := lists[i].list.NextUpdate; !next.IsZero() && now.After(next) {
+			stale = true
+		}
+	}
+
+	if !stale {
+		return nil
+	}
+
+	rc.reloadStale(now)
+
+	if rc.cfg.CRLFailOpen {
+		rc.log.Warn("crl of the client certificate issuer is past its next update, accepting the certificate", "issuer", issuer.Subject.String())
+		return nil
+	}
+
+	return errors.Errorf("client certificate '%s' issuer crl is past its next update", leaf.Subject.String())
+}
+
+// reloadStale re-reads the CRL files in the background, at most once per crlReloadInterval
+func (rc *revocationChecker) reloadStale(now time.Time) {
+	rc.mu.Lock()
+	if now.Sub(rc.staleReload) < crlReloadInterval {
+		rc.mu.Unlock()
+		return
+	}
+	rc.staleReload = now
+	rc.mu.Unlock()
+
+	go func() {
+		err := rc.loadCRLs()
+		if err != nil {
+			rc.log.Error("failed to reload the stale CRL files, previous lists are kept", "error", err)
+		}
+	}()
+}
+
+func (rc *revocationChecker) checkOCSP(key string, leaf, issuer *x509.Certificate) error {
+	st, ok := rc.cachedOCSP(key)
+	if !ok {
+		st = rc.lookupOCSP(key, leaf, issuer)
+	}
+
+	if st.failed {
+		if rc.cfg.OCSPFailOpen {
+			rc.log.Warn("ocsp check failed, accepting the certificate", "subject", leaf.Subject.String(), "error", st.err)
+			return nil
+		}
+
+		return errors.Errorf("client certificate '%s' ocsp check failed: %v", leaf.Subject.String(), st.err)
+	}
+
+	if st.revoked {
+		return errors.Errorf("client certificate '%s' is revoked (OCSP)", leaf.Subject.String())
+	}
+
+	return nil
+}
+
+// cachedOCSP returns the responder answer which has not expired yet
+func (rc *revocationChecker) cachedOCSP(key string) (ocspStatus, bool) {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	st, ok := rc.ocsp[key]
+	if !ok || time.Now().After(st.until) {
+		return ocspStatus{}, false
+	}
+
+	return st, true
+}
+
+// lookupOCSP queries the responder once for the concurrent handshakes of the same certificate, the others wait
+// for the answer of the query in flight
+func (rc *revocationChecker) lookupOCSP(key string, leaf, issuer *x509.Certificate) ocspStatus {
+	rc.mu.Lock()
+	if done, ok := rc.ocspQueries[key]; ok {
+		rc.mu.Unlock()
+		<-done
+
+		if st, ok := rc.cachedOCSP(key); ok {
+			return st
+		}
+
+		return ocspStatus{failed: true, err: errors.Str("no answer of the concurrent query")}
+	}
+
+	done := make(chan struct{})
+	rc.ocspQueries[key] = done
+	rc.mu.Unlock()
+
+	var st ocspStatus
+
+	resp, err := rc.queryOCSP(leaf, issuer)
+	switch {
+	case err != nil:
+		st = ocspStatus{failed: true, err: err, until: time.Now().Add(ocspFailureTTL)}
+	case resp.Status == ocsp.Unknown:
+		st = ocspStatus{failed: true, err: errors.Str("unknown status"), until: time.Now().Add(ocspFailureTTL)}
+	default:
+		st = ocspStatus{
+			revoked: resp.Status == ocsp.Revoked,
+			until:   time.Now().Add(rc.cfg.RefreshInterval),
+		}
+
+		if !resp.NextUpdate.IsZero() && resp.NextUpdate.Before(st.until) {
+			st.until = resp.NextUpdate
+		}
+	}
+
+	rc.mu.Lock()
+	rc.ocsp[key] = st
+	delete(rc.ocspQueries, key)
+	rc.mu.Unlock()
+	close(done)
+
+	return st
+}
+
+func (rc *revocationChecker) queryOCSP(leaf, issuer *x509.Certificate) (*ocsp.Response, error) {
+	req, err := ocsp.CreateRequest(leaf, issuer, &ocsp.RequestOptions{Hash: crypto.SHA256})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), rc.cfg.OCSPTimeout)
+	defer cancel()
+
+	var lastErr error
+	for i := 0; i < len(leaf.OCSPServer); i++ {
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, leaf.OCSPServer[i], bytes.NewReader(req))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		httpReq.Header.Set("Content-Type", "application/ocsp-request")
+
+		resp, err := rc.client.Do(httpReq)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+		_ = resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			lastErr = errors.Errorf("responder %s returned %d", leaf.OCSPServer[i], resp.StatusCode)
+			continue
+		}
+
+		return ocsp.ParseResponseForCert(body, leaf, issuer)
+	}
+
+	return nil, lastErr
+}
+
+func serialKey(rawIssuer, serial []byte) string {
+	return string(rawIssuer) + "/" + string(serial)
+}
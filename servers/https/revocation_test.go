@@ -0,0 +1,315 @@
+package https
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) issue(t *testing.T, serial int64, ocspServer string) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	if ocspServer != "" {
+		tmpl.OCSPServer = []string{ocspServer}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+// writeCRL writes the PEM list of the revoked serials signed by the CA
+func (ca *testCA) writeCRL(t *testing.T, path string, nextUpdate time.Time, revoked ...int64) {
+	t.Helper()
+
+	tmpl := &x509.RevocationList{
+		Number:     big.NewInt(time.Now().UnixNano()),
+		ThisUpdate: time.Now().Add(-time.Hour * 2),
+		NextUpdate: nextUpdate,
+	}
+
+	for i := 0; i < len(revoked); i++ {
+		tmpl.RevokedCertificateEntries = append(tmpl.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   big.NewInt(revoked[i]),
+			RevocationTime: time.Now().Add(-time.Hour),
+		})
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, tmpl, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestRevocationChecker(t *testing.T, cfg *RevocationConfig) *revocationChecker {
+	t.Helper()
+
+	err := cfg.InitDefaults()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rc, err := newRevocationChecker(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(rc.stop)
+
+	return rc
+}
+
+func TestRevocationCRL(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	// the list of the same issuer name, signed by the other key
+	other := newTestCA(t, "ca")
+
+	dir := t.TempDir()
+	ca.writeCRL(t, filepath.Join(dir, "ca.crl"), time.Now().Add(time.Hour), 2)
+	other.writeCRL(t, filepath.Join(dir, "other.crl"), time.Now().Add(time.Hour), 3)
+
+	rc := newTestRevocationChecker(t, &RevocationConfig{CRLFiles: []string{filepath.Join(dir, "ca.crl"), filepath.Join(dir, "other.crl")}})
+
+	tests := []struct {
+		name   string
+		serial int64
+		ok     bool
+	}{
+		{name: "valid", serial: 1, ok: true},
+		{name: "revoked", serial: 2},
+		{name: "revoked by the list of the other issuer", serial: 3, ok: true},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		err := rc.verify(nil, [][]*x509.Certificate{{ca.issue(t, tests[i].serial, ""), ca.cert}})
+		if (err == nil) != tests[i].ok {
+			t.Fatalf("%s: error %v, accepted should be %v", tests[i].name, err, tests[i].ok)
+		}
+	}
+}
+
+func TestRevocationStaleCRL(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	path := filepath.Join(t.TempDir(), "ca.crl")
+	ca.writeCRL(t, path, time.Now().Add(-time.Minute), 2)
+
+	closed := newTestRevocationChecker(t, &RevocationConfig{CRLFiles: []string{path}})
+	open := newTestRevocationChecker(t, &RevocationConfig{CRLFiles: []string{path}, CRLFailOpen: true})
+
+	valid := []*x509.Certificate{ca.issue(t, 1, ""), ca.cert}
+	revoked := []*x509.Certificate{ca.issue(t, 2, ""), ca.cert}
+
+	if err := closed.verify(nil, [][]*x509.Certificate{valid}); err == nil {
+		t.Fatal("the certificate should be rejected by the stale list")
+	}
+
+	if err := open.verify(nil, [][]*x509.Certificate{valid}); err != nil {
+		t.Fatalf("the certificate should be accepted with crl_fail_open: %v", err)
+	}
+
+	if err := open.verify(nil, [][]*x509.Certificate{revoked}); err == nil {
+		t.Fatal("the revoked certificate should be rejected by the stale list with crl_fail_open")
+	}
+
+	// the fresh list is picked up by the reload triggered by the stale one
+	ca.writeCRL(t, path, time.Now().Add(time.Hour), 2)
+
+	closed.mu.Lock()
+	closed.staleReload = time.Time{}
+	closed.mu.Unlock()
+
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		err := closed.verify(nil, [][]*x509.Certificate{valid})
+		if err == nil {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("the fresh list is not reloaded: %v", err)
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestRevocationOCSPSingleFlight(t *testing.T) {
+	ca := newTestCA(t, "ca")
+
+	var queries atomic.Int32
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries.Add(1)
+		// the concurrent handshakes should wait for the single query
+		time.Sleep(time.Millisecond * 50)
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		req, err := ocsp.ParseRequest(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		status := ocsp.Good
+		if req.SerialNumber.Int64() == 2 {
+			status = ocsp.Revoked
+		}
+
+		resp, err := ocsp.CreateResponse(ca.cert, ca.cert, ocsp.Response{
+			Status:       status,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+			RevokedAt:    time.Now().Add(-time.Minute),
+		}, ca.key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		_, _ = w.Write(resp)
+	}))
+	defer responder.Close()
+
+	rc := newTestRevocationChecker(t, &RevocationConfig{OCSP: true})
+
+	valid := []*x509.Certificate{ca.issue(t, 1, responder.URL), ca.cert}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			errs <- rc.verify(nil, [][]*x509.Certificate{valid})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("the good certificate: %v", err)
+		}
+	}
+
+	if n := queries.Load(); n != 1 {
+		t.Fatalf("%d responder queries for the concurrent handshakes, should be 1", n)
+	}
+
+	err := rc.verify(nil, [][]*x509.Certificate{{ca.issue(t, 2, responder.URL), ca.cert}})
+	if err == nil {
+		t.Fatal("the revoked certificate should be rejected")
+	}
+
+	// the answers are cached
+	_ = rc.verify(nil, [][]*x509.Certificate{valid})
+	if n := queries.Load(); n != 2 {
+		t.Fatalf("%d responder queries, should be 2", n)
+	}
+}
+
+func TestRevocationOCSPFailure(t *testing.T) {
+	ca := newTestCA(t, "ca")
+
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer responder.Close()
+
+	closed := newTestRevocationChecker(t, &RevocationConfig{OCSP: true})
+	open := newTestRevocationChecker(t, &RevocationConfig{OCSP: true, OCSPFailOpen: true})
+
+	chain := [][]*x509.Certificate{{ca.issue(t, 1, responder.URL), ca.cert}}
+
+	if err := closed.verify(nil, chain); err == nil {
+		t.Fatal("the certificate should be rejected when the responder fails")
+	}
+
+	if err := open.verify(nil, chain); err != nil {
+		t.Fatalf("the certificate should be accepted with ocsp_fail_open: %v", err)
+	}
+}
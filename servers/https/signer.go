@@ -0,0 +1,75 @@
+package https
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"strings"
+
+	"github.com/roadrunner-server/errors"
+)
+
+const pkcs11Scheme string = "pkcs11:"
+
+// SignerProvider resolves a private key reference (PKCS#11 URI, RFC 7512) into a crypto.Signer.
+// Implementations are provided by other plugins (HSM, cloud KMS), so the key material never leaves the device.
+type SignerProvider interface {
+	Signer(uri string) (crypto.Signer, error)
+}
+
+// EnableSigner reports whether the private key is referenced by PKCS#11 URI instead of a key file.
+func (s *SSLConfig) EnableSigner() bool {
+	return strings.HasPrefix(s.Key, pkcs11Scheme)
+}
+
+// loadSignerCertificate pairs the certificate chain from the cert file with the crypto.Signer resolved by the provider
+func loadSignerCertificate(certFile, keyURI string, provider SignerProvider) (*tls.Certificate, error) {
+	const op = errors.Op("https_load_signer_certificate")
+
+	if provider == nil {
+		return nil, errors.E(op, errors.Errorf("private key '%s' is a PKCS#11 URI, but no signer provider is registered", keyURI))
+	}
+
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	cert := &tls.Certificate{}
+	for {
+		var block *pem.Block
+		block, certPEM = pem.Decode(certPEM)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			cert.Certificate = append(cert.Certificate, block.Bytes)
+		}
+	}
+
+	if len(cert.Certificate) == 0 {
+		return nil, errors.E(op, errors.Errorf("no certificates found in the '%s'", certFile))
+	}
+
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	signer, err := provider.Signer(keyURI)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	// every crypto.PublicKey in the standard library implements Equal
+	pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(cert.Leaf.PublicKey) {
+		return nil, errors.E(op, errors.Str("private key does not match the certificate public key"))
+	}
+
+	cert.PrivateKey = signer
+
+	return cert, nil
+}
@@ -0,0 +1,325 @@
+package https
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+	"golang.org/x/net/http2"
+)
+
+// EnvSpiffeSocket is the standard env variable with the workload API address
+const EnvSpiffeSocket string = "SPIFFE_ENDPOINT_SOCKET"
+
+// maxWorkloadMessageSize of the workload API messages, the gRPC default receive limit
+const maxWorkloadMessageSize = 4 * 1024 * 1024
+
+type SpiffeConfig struct {
+	// Socket of the workload API, defaults to SPIFFE_ENDPOINT_SOCKET env or unix:///tmp/spire-agent/public/api.sock
+	Socket string `mapstructure:"socket" json:"socket,omitempty" bson:"socket,omitempty"`
+
+	// Timeout to wait for the first SVID at startup, default 30s
+	Timeout time.Duration `mapstructure:"timeout" json:"timeout,omitempty" bson:"timeout,omitempty"`
+}
+
+func (sc *SpiffeConfig) InitDefaults() error {
+	if sc.Socket == "" {
+		sc.Socket = os.Getenv(EnvSpiffeSocket)
+	}
+
+	if sc.Socket == "" {
+		sc.Socket = "unix:///tmp/spire-agent/public/api.sock"
+	}
+
+	if !strings.HasPrefix(sc.Socket, "unix://") {
+		return errors.Errorf("spiffe workload API socket should be in the unix:///path form, provided: %s", sc.Socket)
+	}
+
+	if sc.Timeout == 0 {
+		sc.Timeout = time.Second * 30
+	}
+
+	return nil
+}
+
+// spiffeSource keeps the current X509-SVID and trust bundle streamed by the workload API, they rotate without restart
+type spiffeSource struct {
+	cfg    *SpiffeConfig
+	log    *slog.Logger
+	client *http.Client
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.RWMutex
+	cert   *tls.Certificate
+	bundle *x509.CertPool
+	ready  chan struct{}
+	once   sync.Once
+}
+
+func newSpiffeSource(cfg *SpiffeConfig, log *slog.Logger) (*spiffeSource, error) {
+	const op = errors.Op("spiffe_source")
+
+	socket := strings.TrimPrefix(cfg.Socket, "unix://")
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &spiffeSource{
+		cfg:    cfg,
+		log:    log,
+		ctx:    ctx,
+		cancel: cancel,
+		ready:  make(chan struct{}),
+		client: &http.Client{
+			// gRPC over the plaintext HTTP/2 on the unix socket
+			Transport: &http2.Transport{
+				AllowHTTP: true,
+				DialTLSContext: func(ctx context.Context, _, _ string, _ *tls.Config) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+
+	go s.watch()
+
+	select {
+	case <-s.ready:
+		return s, nil
+	case <-time.After(cfg.Timeout):
+		s.stop()
+		return nil, errors.E(op, errors.Errorf("no X509-SVID received from the workload API %s in %s", cfg.Socket, cfg.Timeout))
+	}
+}
+
+func (s *spiffeSource) stop() {
+	s.cancel()
+}
+
+// getCertificate is the tls.Config.GetCertificate
+func (s *spiffeSource) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.cert, nil
+}
+
+// getConfigForClient returns the config with the current trust bundle as ClientCAs
+func (s *spiffeSource) getConfigForClient(base *tls.Config) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.ClientCAs = s.bundle
+
+		return cfg, nil
+	}
+}
+
+// watch keeps the FetchX509SVID stream open and reconnects on errors
+func (s *spiffeSource) watch() {
+	backoff := time.Second
+
+	for {
+		err := s.fetch()
+		if s.ctx.Err() != nil {
+			return
+		}
+
+		s.log.Error("spiffe workload API stream failed, reconnecting", "error", err, "backoff", backoff)
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		if backoff < time.Second*30 {
+			backoff *= 2
+		}
+	}
+}
+
+func (s *spiffeSource) fetch() error {
+	// empty X509SVIDRequest in the gRPC frame: not compressed, zero length
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, "http://localhost/SpiffeWorkloadAPI/FetchX509SVID", bytes.NewReader(make([]byte, 5)))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	// required by the workload API as a SSRF protection
+	req.Header.Set("Workload.spiffe.io", "true")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	// trailers-only response carries the error in the headers
+	if st := resp.Header.Get("Grpc-Status"); st != "" && st != "0" {
+		return errors.Errorf("workload API error %s: %s", st, resp.Header.Get("Grpc-Message"))
+	}
+
+	header := make([]byte, 5)
+	for {
+		_, err = io.ReadFull(resp.Body, header)
+		if err != nil {
+			if st := resp.Trailer.Get("Grpc-Status"); st != "" && st != "0" {
+				return errors.Errorf("workload API error %s: %s", st, resp.Trailer.Get("Grpc-Message"))
+			}
+			return err
+		}
+
+		// the messages are not compressed as no grpc-encoding is requested
+		if header[0] != 0 {
+			return errors.Str("compressed workload API message is not supported")
+		}
+
+		size := binary.BigEndian.Uint32(header[1:])
+		if size > maxWorkloadMessageSize {
+			return errors.Errorf("workload API message of %d bytes exceeds the %d bytes limit", size, maxWorkloadMessageSize)
+		}
+
+		msg := make([]byte, size)
+		_, err = io.ReadFull(resp.Body, msg)
+		if err != nil {
+			return err
+		}
+
+		err = s.update(msg)
+		if err != nil {
+			s.log.Error("malformed X509-SVID response", "error", err)
+		}
+	}
+}
+
+// update decodes X509SVIDResponse and stores the first (default) SVID
+func (s *spiffeSource) update(msg []byte) error {
+	var svid []byte
+	err := protoFields(msg, func(num int, v []byte) {
+		// repeated X509SVID svids = 1
+		if num == 1 && svid == nil {
+			svid = v
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if svid == nil {
+		return errors.Str("response has no SVIDs")
+	}
+
+	var id string
+	var chainDER, keyDER, bundleDER []byte
+	err = protoFields(svid, func(num int, v []byte) {
+		switch num {
+		case 1:
+			id = string(v)
+		case 2:
+			chainDER = v
+		case 3:
+			keyDER = v
+		case 4:
+			bundleDER = v
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	chain, err := x509.ParseCertificates(chainDER)
+	if err != nil || len(chain) == 0 {
+		return errors.Errorf("malformed SVID certificates: %v", err)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(keyDER)
+	if err != nil {
+		return err
+	}
+
+	bundle, err := x509.ParseCertificates(bundleDER)
+	if err != nil {
+		return err
+	}
+
+	cert := &tls.Certificate{PrivateKey: key, Leaf: chain[0]}
+	for i := 0; i < len(chain); i++ {
+		cert.Certificate = append(cert.Certificate, chain[i].Raw)
+	}
+
+	pool := x509.NewCertPool()
+	for i := 0; i < len(bundle); i++ {
+		pool.AddCert(bundle[i])
+	}
+
+	s.mu.Lock()
+	s.cert = cert
+	s.bundle = pool
+	s.mu.Unlock()
+
+	s.log.Debug("X509-SVID updated", "spiffe_id", id, "expires", chain[0].NotAfter)
+	s.once.Do(func() {
+		close(s.ready)
+	})
+
+	return nil
+}
+
+// protoFields walks the protobuf message and calls fn for the length-delimited fields, the other types are skipped
+func protoFields(b []byte, fn func(num int, v []byte)) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.Str("malformed protobuf tag")
+		}
+		b = b[n:]
+
+		switch tag & 7 {
+		case 0: // varint
+			_, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errors.Str("malformed protobuf varint")
+			}
+			b = b[n:]
+		case 1: // 64-bit
+			if len(b) < 8 {
+				return errors.Str("malformed protobuf fixed64")
+			}
+			b = b[8:]
+		case 2: // length-delimited
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return errors.Str("malformed protobuf length")
+			}
+			fn(int(tag>>3), b[n:n+int(l)])
+			b = b[n+int(l):]
+		case 5: // 32-bit
+			if len(b) < 4 {
+				return errors.Str("malformed protobuf fixed32")
+			}
+			b = b[4:]
+		default:
+			return errors.Errorf("unsupported protobuf wire type %d", tag&7)
+		}
+	}
+
+	return nil
+}
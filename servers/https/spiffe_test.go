@@ -0,0 +1,273 @@
+package https
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// fakeWorkloadAPI streams the queued X509SVIDResponse messages, the nil message ends the stream
+type fakeWorkloadAPI struct {
+	socket    string
+	responses chan []byte
+	streams   atomic.Int32
+	// status of the trailers-only responses, the stream is served when empty
+	status string
+}
+
+func newFakeWorkloadAPI(t *testing.T, status string) *fakeWorkloadAPI {
+	t.Helper()
+
+	// the unix socket path is limited to ~100 bytes, the test temp dir could be longer
+	dir, err := os.MkdirTemp("", "spiffe")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeWorkloadAPI{
+		socket:    filepath.Join(dir, "api.sock"),
+		responses: make(chan []byte, 10),
+		status:    status,
+	}
+
+	ln, err := net.Listen("unix", f.socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = ln.Close()
+		_ = os.RemoveAll(dir)
+	})
+
+	h2 := &http2.Server{}
+	go func() {
+		for {
+			c, errA := ln.Accept()
+			if errA != nil {
+				return
+			}
+
+			go h2.ServeConn(c, &http2.ServeConnOpts{Handler: http.HandlerFunc(f.fetchX509SVID)})
+		}
+	}()
+
+	return f
+}
+
+func (f *fakeWorkloadAPI) fetchX509SVID(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/SpiffeWorkloadAPI/FetchX509SVID" {
+		w.Header().Set("Grpc-Status", "12")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Header.Get("Workload.spiffe.io") != "true" {
+		w.Header().Set("Grpc-Status", "3")
+		w.Header().Set("Grpc-Message", "security header missing from request")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if f.status != "" {
+		w.Header().Set("Grpc-Status", f.status)
+		w.Header().Set("Grpc-Message", "no identity issued")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	f.streams.Add(1)
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg := <-f.responses:
+			if msg == nil {
+				w.Header().Set("Grpc-Status", "14")
+				return
+			}
+
+			frame := make([]byte, 5, 5+len(msg))
+			binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+			_, _ = w.Write(append(frame, msg...))
+			w.(http.Flusher).Flush()
+		}
+	}
+}
+
+// protoField encodes the length-delimited protobuf field
+func protoField(num int, v []byte) []byte {
+	b := binary.AppendUvarint(nil, uint64(num<<3|2))
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// svidResponse returns the X509SVIDResponse with the SVID of the serial issued by the CA
+func (ca *testCA) svidResponse(t *testing.T, serial int64) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, _ := url.Parse("spiffe://domain.com/service")
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "service"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		URIs:         []*url.URL{id},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var svid []byte
+	svid = append(svid, protoField(1, []byte(id.String()))...)
+	svid = append(svid, protoField(2, der)...)
+	svid = append(svid, protoField(3, keyDER)...)
+	svid = append(svid, protoField(4, ca.cert.Raw)...)
+
+	// the hint of the SVID, the varint fields are skipped
+	svid = append(svid, 0x28, 0x01)
+
+	return protoField(1, svid)
+}
+
+func newTestSpiffeSource(t *testing.T, f *fakeWorkloadAPI, timeout time.Duration) (*spiffeSource, error) {
+	t.Helper()
+
+	cfg := &SpiffeConfig{Socket: "unix://" + f.socket, Timeout: timeout}
+	err := cfg.InitDefaults()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := newSpiffeSource(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err == nil {
+		t.Cleanup(s.stop)
+	}
+
+	return s, err
+}
+
+// waitSerial waits for the SVID of the serial to be served
+func waitSerial(t *testing.T, s *spiffeSource, serial int64) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second * 10)
+	for {
+		cert, _ := s.getCertificate(nil)
+		if cert != nil && cert.Leaf.SerialNumber.Int64() == serial {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("the SVID %d is not served", serial)
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestSpiffeSourceRotation(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	f := newFakeWorkloadAPI(t, "")
+
+	f.responses <- ca.svidResponse(t, 1)
+
+	s, err := newTestSpiffeSource(t, f, time.Second*10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitSerial(t, s, 1)
+
+	cfg, err := s.getConfigForClient(&tls.Config{MinVersion: tls.VersionTLS12})(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bundle := x509.NewCertPool()
+	bundle.AddCert(ca.cert)
+	if !cfg.ClientCAs.Equal(bundle) {
+		t.Fatal("the client CAs should be the trust bundle of the workload API")
+	}
+
+	// the malformed message is skipped, the current SVID is kept
+	f.responses <- []byte{0x0a, 0xff}
+	f.responses <- ca.svidResponse(t, 2)
+	waitSerial(t, s, 2)
+
+	// the stream ends, the source reconnects and receives the SVID of the new stream
+	f.responses <- nil
+	f.responses <- ca.svidResponse(t, 3)
+	waitSerial(t, s, 3)
+
+	if n := f.streams.Load(); n != 2 {
+		t.Fatalf("%d streams, should be 2", n)
+	}
+}
+
+func TestSpiffeSourceError(t *testing.T) {
+	f := newFakeWorkloadAPI(t, "7")
+
+	_, err := newTestSpiffeSource(t, f, time.Millisecond*200)
+	if err == nil {
+		t.Fatal("the source should fail without the SVID")
+	}
+}
+
+func TestProtoFields(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  []byte
+		ok   bool
+	}{
+		{name: "fields", msg: append(append(protoField(1, []byte("a")), 0x10, 0x01), protoField(2, nil)...), ok: true},
+		{name: "fixed", msg: []byte{0x09, 1, 2, 3, 4, 5, 6, 7, 8, 0x15, 1, 2, 3, 4}, ok: true},
+		{name: "truncated length", msg: []byte{0x0a, 0x05, 'a'}},
+		{name: "truncated fixed64", msg: []byte{0x09, 1, 2}},
+		{name: "truncated varint", msg: []byte{0x10, 0x80}},
+		{name: "group", msg: []byte{0x0b}},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		err := protoFields(tests[i].msg, func(int, []byte) {})
+		if (err == nil) != tests[i].ok {
+			t.Fatalf("%s: error %v, valid should be %v", tests[i].name, err, tests[i].ok)
+		}
+	}
+}
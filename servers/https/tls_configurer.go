@@ -0,0 +1,11 @@
+package https
+
+import (
+	"crypto/tls"
+)
+
+// TLSConfigurer customizes the TLS config of the HTTPS servers (GetCertificate, VerifyPeerCertificate, NextProtos).
+// It is called after the built-in setup, so the configured certificates and client verification could be replaced.
+type TLSConfigurer interface {
+	ConfigureTLS(cfg *tls.Config) error
+}
@@ -0,0 +1,101 @@
+package listener
+
+import (
+	"fmt"
+	"net"
+)
+
+// Config of the TCP listeners, DeferAccept and FastOpen interact badly with some load balancers and kernels.
+// The options are not supported on Windows.
+type Config struct {
+	// ReusePort sets SO_REUSEPORT, the workers share the port with it. Default: true.
+	ReusePort *bool `mapstructure:"reuse_port" json:"reuse_port,omitempty" bson:"reuse_port,omitempty"`
+
+	// DeferAccept sets TCP_DEFER_ACCEPT, the connection is accepted when the client sends the data. Default: false.
+	DeferAccept bool `mapstructure:"defer_accept" json:"defer_accept,omitempty" bson:"defer_accept,omitempty"`
+
+	// FastOpen sets TCP_FASTOPEN. Default: true.
+	FastOpen *bool `mapstructure:"fast_open" json:"fast_open,omitempty" bson:"fast_open,omitempty"`
+
+	// Backlog of the pending connections queue, not supported with the socket options. Default: net.core.somaxconn
+	// of the kernel.
+	Backlog int `mapstructure:"backlog" json:"backlog,omitempty" bson:"backlog,omitempty"`
+
+	// ReadBuffer sets SO_RCVBUF of the listening socket in bytes, the accepted connections inherit it.
+	ReadBuffer int `mapstructure:"read_buffer" json:"read_buffer,omitempty" bson:"read_buffer,omitempty"`
+
+	// WriteBuffer sets SO_SNDBUF of the listening socket in bytes, the accepted connections inherit it.
+	WriteBuffer int `mapstructure:"write_buffer" json:"write_buffer,omitempty" bson:"write_buffer,omitempty"`
+
+	// NoDelay sets TCP_NODELAY of the accepted connections. Default: true.
+	NoDelay *bool `mapstructure:"no_delay" json:"no_delay,omitempty" bson:"no_delay,omitempty"`
+
+	// Mark sets SO_MARK for the policy routing, Linux only, requires CAP_NET_ADMIN.
+	Mark int `mapstructure:"mark" json:"mark,omitempty" bson:"mark,omitempty"`
+
+	// BindToDevice sets SO_BINDTODEVICE to accept the connections of the interface only, Linux only.
+	BindToDevice string `mapstructure:"bind_to_device" json:"bind_to_device,omitempty" bson:"bind_to_device,omitempty"`
+
+	// MaxConnsPerIP open at once, the connections beyond it are closed right after the accept. Default: unlimited.
+	MaxConnsPerIP int `mapstructure:"max_conns_per_ip" json:"max_conns_per_ip,omitempty" bson:"max_conns_per_ip,omitempty"`
+
+	// MaxConns served by the listener at once, the newest connections above it wait in the queue. Default: unlimited.
+	MaxConns int `mapstructure:"max_conns" json:"max_conns,omitempty" bson:"max_conns,omitempty"`
+
+	// MaxConnsQueue of the connections waiting for a free slot, the oldest one is closed when it is full. Default: 16.
+	MaxConnsQueue int `mapstructure:"max_conns_queue" json:"max_conns_queue,omitempty" bson:"max_conns_queue,omitempty"`
+}
+
+func (c *Config) InitDefaults() error {
+	enabled := true
+	if c.ReusePort == nil {
+		c.ReusePort = &enabled
+	}
+
+	if c.FastOpen == nil {
+		c.FastOpen = &enabled
+	}
+
+	if c.NoDelay == nil {
+		c.NoDelay = &enabled
+	}
+
+	if c.Backlog < 0 || c.ReadBuffer < 0 || c.WriteBuffer < 0 || c.Mark < 0 || c.MaxConnsPerIP < 0 || c.MaxConns < 0 || c.MaxConnsQueue < 0 {
+		return fmt.Errorf("backlog, read_buffer, write_buffer, mark, max_conns_per_ip, max_conns and max_conns_queue should not be negative")
+	}
+
+	if c.MaxConns > 0 && c.MaxConnsQueue == 0 {
+		c.MaxConnsQueue = 16
+	}
+
+	// the socket options are set by the ListenConfig which uses the backlog of the kernel
+	if c.Backlog > 0 && c.socketOptions() {
+		return fmt.Errorf("backlog is not supported together with the read_buffer, write_buffer, mark and bind_to_device")
+	}
+
+	return nil
+}
+
+// socketOptions are set, the listener is created with the ListenConfig
+func (c *Config) socketOptions() bool {
+	return c.ReadBuffer > 0 || c.WriteBuffer > 0 || c.Mark > 0 || c.BindToDevice != ""
+}
+
+// noDelayListener sets TCP_NODELAY of the accepted connections
+type noDelayListener struct {
+	net.Listener
+	noDelay bool
+}
+
+func (l *noDelayListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		_ = tcp.SetNoDelay(l.noDelay)
+	}
+
+	return conn, nil
+}
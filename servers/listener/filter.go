@@ -0,0 +1,40 @@
+package listener
+
+import (
+	"net"
+)
+
+// Filter closes the accepted connections of the remote IPs the banned func reports, the unix socket connections
+// are not filtered
+func Filter(l net.Listener, banned func(ip net.IP) bool, onReject func(reason RejectReason, addr net.Addr)) net.Listener {
+	return &filterListener{
+		Listener: l,
+		banned:   banned,
+		onReject: onReject,
+	}
+}
+
+type filterListener struct {
+	net.Listener
+	banned   func(ip net.IP) bool
+	onReject func(reason RejectReason, addr net.Addr)
+}
+
+func (l *filterListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+		if !ok || !l.banned(addr.IP) {
+			return conn, nil
+		}
+
+		_ = conn.Close()
+		if l.onReject != nil {
+			l.onReject(RejectBanned, addr)
+		}
+	}
+}
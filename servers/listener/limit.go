@@ -0,0 +1,258 @@
+package listener
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// RejectReason of the connection closed by the listener limits
+type RejectReason string
+
+const (
+	// RejectPerIP the remote IP has max_conns_per_ip connections open
+	RejectPerIP RejectReason = "per_ip"
+	// RejectLimit the listener has max_conns connections open and the wait queue is full
+	RejectLimit RejectReason = "limit"
+	// RejectBanned the remote IP is banned by the filter
+	RejectBanned RejectReason = "banned"
+)
+
+// Limit wraps the listener with the connection limits of the config, onReject is called for every connection closed
+// right after the accept. Nil config or zero limits return the listener as is.
+func Limit(l net.Listener, cfg *Config, onReject func(reason RejectReason, addr net.Addr)) net.Listener {
+	if cfg == nil {
+		return l
+	}
+
+	if cfg.MaxConnsPerIP > 0 {
+		l = &perIPListener{
+			Listener: l,
+			max:      cfg.MaxConnsPerIP,
+			onReject: onReject,
+			conns:    make(map[string]int),
+		}
+	}
+
+	// the per IP limit goes first, so a single client could not fill the wait queue
+	if cfg.MaxConns > 0 {
+		l = &capListener{
+			Listener: l,
+			max:      cfg.MaxConns,
+			queue:    cfg.MaxConnsQueue,
+			onReject: onReject,
+			notify:   make(chan struct{}, 1),
+			closed:   make(chan struct{}),
+		}
+	}
+
+	return l
+}
+
+// perIPListener closes the connections of the remote IP beyond the max of the open ones, the unix socket
+// connections are not limited
+type perIPListener struct {
+	net.Listener
+	max      int
+	onReject func(reason RejectReason, addr net.Addr)
+
+	mu    sync.Mutex
+	conns map[string]int
+}
+
+func (l *perIPListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+		if !ok {
+			return conn, nil
+		}
+
+		ip := addr.IP.String()
+		if l.acquire(ip) {
+			return &releaseConn{Conn: conn, release: func() { l.release(ip) }}, nil
+		}
+
+		_ = conn.Close()
+		if l.onReject != nil {
+			l.onReject(RejectPerIP, addr)
+		}
+	}
+}
+
+func (l *perIPListener) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conns[ip] >= l.max {
+		return false
+	}
+
+	l.conns[ip]++
+
+	return true
+}
+
+func (l *perIPListener) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.conns[ip]--
+	if l.conns[ip] <= 0 {
+		delete(l.conns, ip)
+	}
+}
+
+// releaseConn releases the slot of the limit once it is closed, the hijacked connections are counted until
+// the handler closes them
+type releaseConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *releaseConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+
+	return err
+}
+
+// capListener serves max connections at once, the connections accepted above it wait in the queue. The newest
+// waiting connection is served first once a slot is free and the oldest one is closed when the queue is full,
+// so the server degrades predictably instead of running out of the file descriptors.
+type capListener struct {
+	net.Listener
+	max      int
+	queue    int
+	onReject func(reason RejectReason, addr net.Addr)
+
+	start  sync.Once
+	notify chan struct{}
+	closed chan struct{}
+	stop   sync.Once
+
+	mu      sync.Mutex
+	active  int
+	waiting []net.Conn
+	err     error
+}
+
+func (l *capListener) Accept() (net.Conn, error) {
+	l.start.Do(func() {
+		go l.accept()
+	})
+
+	for {
+		l.mu.Lock()
+		if l.active < l.max && len(l.waiting) > 0 {
+			conn := l.waiting[len(l.waiting)-1]
+			l.waiting = l.waiting[:len(l.waiting)-1]
+			l.active++
+			l.mu.Unlock()
+
+			return &releaseConn{Conn: conn, release: l.release}, nil
+		}
+
+		if l.err != nil {
+			err := l.err
+			// the temporary errors are returned once, the caller backs off
+			if !errors.Is(err, net.ErrClosed) {
+				l.err = nil
+			}
+			l.mu.Unlock()
+
+			return nil, err
+		}
+		l.mu.Unlock()
+
+		select {
+		case <-l.notify:
+		case <-l.closed:
+			return nil, net.ErrClosed
+		}
+	}
+}
+
+// accept moves the connections of the listener to the wait queue until it is closed
+func (l *capListener) accept() {
+	var delay time.Duration
+
+	for {
+		conn, err := l.Listener.Accept()
+
+		l.mu.Lock()
+		if err != nil {
+			l.err = err
+			l.mu.Unlock()
+			l.wake()
+
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			// e.g. too many open files, the same backoff as the net/http server
+			delay = min(max(delay*2, 5*time.Millisecond), time.Second)
+			time.Sleep(delay)
+
+			continue
+		}
+		delay = 0
+
+		l.waiting = append(l.waiting, conn)
+
+		// the free slots are taken by the waiting connections right away
+		var dropped net.Conn
+		if len(l.waiting) > l.queue+max(l.max-l.active, 0) {
+			dropped = l.waiting[0]
+			l.waiting = l.waiting[1:]
+		}
+		l.mu.Unlock()
+
+		if dropped != nil {
+			_ = dropped.Close()
+			if l.onReject != nil {
+				l.onReject(RejectLimit, dropped.RemoteAddr())
+			}
+		}
+
+		l.wake()
+	}
+}
+
+func (l *capListener) release() {
+	l.mu.Lock()
+	l.active--
+	l.mu.Unlock()
+
+	l.wake()
+}
+
+func (l *capListener) wake() {
+	select {
+	case l.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (l *capListener) Close() error {
+	err := l.Listener.Close()
+
+	l.stop.Do(func() {
+		close(l.closed)
+
+		l.mu.Lock()
+		for i := 0; i < len(l.waiting); i++ {
+			_ = l.waiting[i].Close()
+		}
+		l.waiting = nil
+		l.mu.Unlock()
+	})
+
+	return err
+}
@@ -25,6 +25,7 @@
 package listener
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os"
@@ -50,14 +51,16 @@ const (
 //
 //   - TCP_FASTOPEN. See https://lwn.net/Articles/508865/ for details.
 //
-// CreateListener crates socket listener based on DSN definition.
-func CreateListener(address string) (net.Listener, error) {
+// The options and the backlog are set with the Config.
+//
+// CreateListener crates socket listener based on DSN definition, nil cfg applies the defaults.
+func CreateListener(address string, cfg *Config) (net.Listener, error) {
 	dsn := strings.Split(address, "://")
 
 	switch len(dsn) {
 	case 1:
 		// assume, that there is no prefix here [127.0.0.1:8000]
-		return createTCPListener(dsn[0])
+		return createTCPListener(dsn[0], cfg)
 	case 2:
 		// we got two part here, first part is the transport, second - address
 		// [tcp://127.0.0.1:8000] OR [unix:///path/to/unix.socket] OR [error://path]
@@ -73,7 +76,9 @@ func CreateListener(address string) (net.Listener, error) {
 			}
 			return net.Listen(dsn[0], dsn[1])
 		case "tcp":
-			return createTCPListener(dsn[1])
+			return createTCPListener(dsn[1], cfg)
+		case "provided":
+			return nil, fmt.Errorf("listener '%s' should be provided by a plugin, address: %s", dsn[1], address)
 			// not an tcp or unix
 		default:
 			return nil, fmt.Errorf("invalid Protocol ([tcp://]:6001, unix://file.sock), address: %s", address)
@@ -84,11 +89,17 @@ func CreateListener(address string) (net.Listener, error) {
 	}
 }
 
-func createTCPListener(addr string) (net.Listener, error) {
+func createTCPListener(addr string, options *Config) (net.Listener, error) {
+	// nil options are the defaults
+	if options == nil {
+		options = &Config{}
+	}
+
 	cfg := tcplisten.Config{
-		ReusePort:   true,
-		DeferAccept: false,
-		FastOpen:    true,
+		ReusePort:   options.ReusePort == nil || *options.ReusePort,
+		DeferAccept: options.DeferAccept,
+		FastOpen:    options.FastOpen == nil || *options.FastOpen,
+		Backlog:     options.Backlog,
 	}
 
 	/*
@@ -105,11 +116,28 @@ func createTCPListener(addr string) (net.Listener, error) {
 	}
 
 	// consider this is IPv4
-	if host == "" {
-		return cfg.NewListener(IPV4, addr)
+	network := IPV4
+	if host != "" {
+		network = netw(net.ParseIP(host))
+	}
+
+	var l net.Listener
+	if options.socketOptions() {
+		lc := net.ListenConfig{Control: control(options)}
+		l, err = lc.Listen(context.Background(), network, addr)
+	} else {
+		l, err = cfg.NewListener(network, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Go sets TCP_NODELAY of the accepted connections by default
+	if options.NoDelay != nil && !*options.NoDelay {
+		return &noDelayListener{Listener: l, noDelay: false}, nil
 	}
 
-	return cfg.NewListener(netw(net.ParseIP(host)), addr)
+	return l, nil
 }
 
 // check if we are listening on the ipv6 or ipv4 address
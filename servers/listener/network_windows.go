@@ -24,8 +24,8 @@
 
 package listener
 
-// CreateListener crates socket listener based on DSN definition.
-func CreateListener(address string) (net.Listener, error) {
+// CreateListener crates socket listener based on DSN definition, the cfg options are not supported on Windows.
+func CreateListener(address string, _ *Config) (net.Listener, error) {
 	dsn := strings.Split(address, "://")
 
 	switch len(dsn) {
@@ -48,6 +48,8 @@ func CreateListener(address string) (net.Listener, error) {
 			return net.Listen(dsn[0], dsn[1])
 		case "tcp":
 			return createTCPListener(dsn[1])
+		case "provided":
+			return nil, fmt.Errorf("listener '%s' should be provided by a plugin, address: %s", dsn[1], address)
 			// not an tcp or unix
 		default:
 			return nil, fmt.Errorf("invalid Protocol ([tcp://]:6001, unix://file.sock), address: %s", address)
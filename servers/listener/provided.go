@@ -0,0 +1,21 @@
+package listener
+
+import (
+	"net"
+	"strings"
+)
+
+// ProvidedScheme of the addresses referencing the listeners provided by other plugins, e.g. provided://memory
+const ProvidedScheme string = "provided://"
+
+// Provider gives the ready-made listener (memory listener for the tests, tunnel, custom socket) by its name.
+// Listener is called every time the server is created (Reset included), after the previous listener was closed.
+type Provider interface {
+	Name() string
+	Listener() (net.Listener, error)
+}
+
+// ProvidedName returns the provider name of the provided:// address
+func ProvidedName(address string) (string, bool) {
+	return strings.CutPrefix(address, ProvidedScheme)
+}
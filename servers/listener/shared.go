@@ -0,0 +1,119 @@
+package listener
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Shared accepts the connections of the listener once and hands them to the servers serving its handles,
+// so the servers could be re-created without closing the listener and dropping the queued connections.
+type Shared struct {
+	l     net.Listener
+	conns chan net.Conn
+
+	// stopped is closed when the listener fails or is closed, err is the reason
+	stopped chan struct{}
+	err     error
+	once    sync.Once
+}
+
+func NewShared(l net.Listener) *Shared {
+	s := &Shared{
+		l:       l,
+		conns:   make(chan net.Conn),
+		stopped: make(chan struct{}),
+	}
+
+	go s.accept()
+
+	return s
+}
+
+// Handle returns the listener for a server, closing it leaves the shared listener open
+func (s *Shared) Handle() net.Listener {
+	return &handle{shared: s, done: make(chan struct{})}
+}
+
+func (s *Shared) Addr() net.Addr {
+	return s.l.Addr()
+}
+
+func (s *Shared) Close() error {
+	s.stop(net.ErrClosed)
+	return s.l.Close()
+}
+
+func (s *Shared) stop(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.stopped)
+	})
+}
+
+func (s *Shared) accept() {
+	var delay time.Duration
+
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				s.stop(err)
+				return
+			}
+
+			// EMFILE and similar errors, the same backoff as net/http uses
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else {
+				delay = min(delay*2, time.Second)
+			}
+
+			select {
+			case <-time.After(delay):
+				continue
+			case <-s.stopped:
+				return
+			}
+		}
+
+		delay = 0
+
+		select {
+		case s.conns <- conn:
+		case <-s.stopped:
+			_ = conn.Close()
+			return
+		}
+	}
+}
+
+type handle struct {
+	shared *Shared
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (h *handle) Accept() (net.Conn, error) {
+	select {
+	case conn := <-h.shared.conns:
+		return conn, nil
+	case <-h.done:
+		return nil, net.ErrClosed
+	case <-h.shared.stopped:
+		return nil, h.shared.err
+	}
+}
+
+func (h *handle) Close() error {
+	h.once.Do(func() {
+		close(h.done)
+	})
+
+	return nil
+}
+
+func (h *handle) Addr() net.Addr {
+	return h.shared.Addr()
+}
@@ -0,0 +1,53 @@
+//go:build darwin || freebsd
+
+package listener
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// control sets the options of the listening socket before it is bound, TCP_DEFER_ACCEPT and TCP_FASTOPEN
+// are not set on BSD
+func control(options *Config) func(network, address string, c syscall.RawConn) error {
+	return func(_, _ string, c syscall.RawConn) error {
+		if options.Mark > 0 || options.BindToDevice != "" {
+			return fmt.Errorf("mark and bind_to_device are supported on Linux only")
+		}
+
+		var errOpt error
+		err := c.Control(func(fd uintptr) {
+			errOpt = setSockOpts(int(fd), options)
+		})
+		if err != nil {
+			return err
+		}
+
+		return errOpt
+	}
+}
+
+func setSockOpts(fd int, options *Config) error {
+	if options.ReusePort == nil || *options.ReusePort {
+		err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEPORT, 1)
+		if err != nil {
+			return fmt.Errorf("SO_REUSEPORT: %w", err)
+		}
+	}
+
+	if options.ReadBuffer > 0 {
+		err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, options.ReadBuffer)
+		if err != nil {
+			return fmt.Errorf("SO_RCVBUF: %w", err)
+		}
+	}
+
+	if options.WriteBuffer > 0 {
+		err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, options.WriteBuffer)
+		if err != nil {
+			return fmt.Errorf("SO_SNDBUF: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+//go:build linux
+
+package listener
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// missing in the syscall package
+const (
+	soReusePort  = 0x0F
+	tcpFastOpen  = 0x17
+	fastOpenQlen = 16 * 1024
+)
+
+// control sets the options of the listening socket before it is bound
+func control(options *Config) func(network, address string, c syscall.RawConn) error {
+	return func(_, _ string, c syscall.RawConn) error {
+		var errOpt error
+		err := c.Control(func(fd uintptr) {
+			errOpt = setSockOpts(int(fd), options)
+		})
+		if err != nil {
+			return err
+		}
+
+		return errOpt
+	}
+}
+
+func setSockOpts(fd int, options *Config) error {
+	opts := make([]sockOpt, 0, 6)
+
+	if options.ReusePort == nil || *options.ReusePort {
+		opts = append(opts, sockOpt{"SO_REUSEPORT", syscall.SOL_SOCKET, soReusePort, 1})
+	}
+
+	if options.DeferAccept {
+		opts = append(opts, sockOpt{"TCP_DEFER_ACCEPT", syscall.IPPROTO_TCP, syscall.TCP_DEFER_ACCEPT, 1})
+	}
+
+	if options.FastOpen == nil || *options.FastOpen {
+		opts = append(opts, sockOpt{"TCP_FASTOPEN", syscall.IPPROTO_TCP, tcpFastOpen, fastOpenQlen})
+	}
+
+	if options.ReadBuffer > 0 {
+		opts = append(opts, sockOpt{"SO_RCVBUF", syscall.SOL_SOCKET, syscall.SO_RCVBUF, options.ReadBuffer})
+	}
+
+	if options.WriteBuffer > 0 {
+		opts = append(opts, sockOpt{"SO_SNDBUF", syscall.SOL_SOCKET, syscall.SO_SNDBUF, options.WriteBuffer})
+	}
+
+	if options.Mark > 0 {
+		opts = append(opts, sockOpt{"SO_MARK", syscall.SOL_SOCKET, syscall.SO_MARK, options.Mark})
+	}
+
+	for i := 0; i < len(opts); i++ {
+		err := syscall.SetsockoptInt(fd, opts[i].level, opts[i].opt, opts[i].value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", opts[i].name, err)
+		}
+	}
+
+	if options.BindToDevice != "" {
+		err := syscall.BindToDevice(fd, options.BindToDevice)
+		if err != nil {
+			return fmt.Errorf("SO_BINDTODEVICE %s: %w", options.BindToDevice, err)
+		}
+	}
+
+	return nil
+}
+
+type sockOpt struct {
+	name  string
+	level int
+	opt   int
+	value int
+}
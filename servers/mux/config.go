@@ -0,0 +1,14 @@
+package mux
+
+import "time"
+
+type Config struct {
+	// SniffTimeout is the max wait for the first bytes of the connection. Default: 2s.
+	SniffTimeout time.Duration `mapstructure:"sniff_timeout" json:"sniff_timeout,omitempty" bson:"sniff_timeout,omitempty"`
+}
+
+func (c *Config) InitDefaults() {
+	if c.SniffTimeout == 0 {
+		c.SniffTimeout = 2 * time.Second
+	}
+}
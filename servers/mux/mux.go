@@ -0,0 +1,217 @@
+package mux
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// sniffLen is enough for the HTTP/2 connection preface and the protocol greetings
+const sniffLen = 24
+
+var (
+	http2Preface = []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+	sshPrefix    = []byte("SSH-")
+)
+
+// Matcher decides by the first bytes of the connection whether it belongs to the protocol.
+type Matcher func(prefix []byte) bool
+
+// SSH matches the SSH version exchange.
+func SSH(prefix []byte) bool {
+	return bytes.HasPrefix(prefix, sshPrefix)
+}
+
+// TLS matches the TLS handshake record.
+func TLS(prefix []byte) bool {
+	return len(prefix) >= 3 && prefix[0] == 0x16 && prefix[1] == 0x03 && prefix[2] <= 0x04
+}
+
+// HTTP2 matches the HTTP/2 prior knowledge connection preface (h2c, gRPC).
+func HTTP2(prefix []byte) bool {
+	return bytes.HasPrefix(http2Preface, prefix) || bytes.HasPrefix(prefix, http2Preface)
+}
+
+// Protocol is served by other plugins on the plain http listener, the matching connections are handed to Serve.
+type Protocol interface {
+	Name() string
+	Match(prefix []byte) bool
+	Serve(l net.Listener) error
+}
+
+// Mux sniffs the accepted connections and hands them to the listener of the first matching protocol,
+// unmatched connections go to the default listener.
+type Mux struct {
+	root    net.Listener
+	timeout time.Duration
+	log     *slog.Logger
+
+	routes []route
+	def    *listener
+
+	once   sync.Once
+	doneCh chan struct{}
+}
+
+type route struct {
+	match Matcher
+	l     *listener
+}
+
+func New(root net.Listener, timeout time.Duration, log *slog.Logger) *Mux {
+	m := &Mux{
+		root:    root,
+		timeout: timeout,
+		log:     log,
+		doneCh:  make(chan struct{}),
+	}
+	m.def = m.newListener()
+
+	return m
+}
+
+// Match returns the listener of the connections matching the protocol, should be called before Serve.
+func (m *Mux) Match(match Matcher) net.Listener {
+	l := m.newListener()
+	m.routes = append(m.routes, route{match: match, l: l})
+	return l
+}
+
+// Default returns the listener of the unmatched connections, closing it stops the mux.
+func (m *Mux) Default() net.Listener {
+	return m.def
+}
+
+// Serve accepts the connections until the root listener is closed.
+func (m *Mux) Serve() error {
+	defer m.close()
+
+	for {
+		conn, err := m.root.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+
+			select {
+			case <-m.doneCh:
+				return nil
+			default:
+				return err
+			}
+		}
+
+		go m.dispatch(conn)
+	}
+}
+
+func (m *Mux) dispatch(conn net.Conn) {
+	prefix := make([]byte, sniffLen)
+	_ = conn.SetReadDeadline(time.Now().Add(m.timeout))
+	n, err := io.ReadAtLeast(conn, prefix, 1)
+	_ = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		m.log.Debug("connection was closed before the protocol detection", "remote", conn.RemoteAddr().String(), "error", err)
+		_ = conn.Close()
+		return
+	}
+
+	// the first read usually has the whole greeting, wait briefly for the rest of the short ones
+	if n < sniffLen {
+		_ = conn.SetReadDeadline(time.Now().Add(time.Millisecond * 10))
+		var more int
+		more, _ = io.ReadAtLeast(conn, prefix[n:], 1)
+		n += more
+		_ = conn.SetReadDeadline(time.Time{})
+	}
+
+	prefix = prefix[:n]
+	sc := &sniffedConn{Conn: conn, buf: prefix}
+
+	target := m.def
+	for i := 0; i < len(m.routes); i++ {
+		if m.routes[i].match(prefix) {
+			target = m.routes[i].l
+			break
+		}
+	}
+
+	select {
+	case target.connCh <- sc:
+	case <-target.doneCh:
+		_ = conn.Close()
+	case <-m.doneCh:
+		_ = conn.Close()
+	}
+}
+
+func (m *Mux) close() {
+	m.once.Do(func() {
+		close(m.doneCh)
+		_ = m.root.Close()
+	})
+}
+
+func (m *Mux) newListener() *listener {
+	return &listener{
+		mux:    m,
+		connCh: make(chan net.Conn),
+		doneCh: make(chan struct{}),
+	}
+}
+
+type listener struct {
+	mux    *Mux
+	connCh chan net.Conn
+	doneCh chan struct{}
+	once   sync.Once
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.connCh:
+		return conn, nil
+	case <-l.doneCh:
+		return nil, net.ErrClosed
+	case <-l.mux.doneCh:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *listener) Close() error {
+	l.once.Do(func() {
+		close(l.doneCh)
+	})
+
+	// the server of the default listener owns the mux
+	if l == l.mux.def {
+		l.mux.close()
+	}
+
+	return nil
+}
+
+func (l *listener) Addr() net.Addr {
+	return l.mux.root.Addr()
+}
+
+// sniffedConn replays the sniffed prefix before reading from the connection
+type sniffedConn struct {
+	net.Conn
+	buf []byte
+}
+
+func (c *sniffedConn) Read(b []byte) (int, error) {
+	if len(c.buf) > 0 {
+		n := copy(b, c.buf)
+		c.buf = c.buf[n:]
+		return n, nil
+	}
+
+	return c.Conn.Read(b)
+}
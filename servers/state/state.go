@@ -0,0 +1,59 @@
+package state
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+type State string
+
+const (
+	Starting State = "starting"
+	Running  State = "running"
+	Draining State = "draining"
+	Stopped  State = "stopped"
+)
+
+// Tracker keeps the server state, draining servers answer 503 and close the connections
+// so the load balancers move the traffic away.
+type Tracker struct {
+	state    atomic.Value
+	draining atomic.Bool
+}
+
+func NewTracker() *Tracker {
+	t := &Tracker{}
+	t.state.Store(Starting)
+	return t
+}
+
+func (t *Tracker) Set(state State) {
+	t.state.Store(state)
+}
+
+func (t *Tracker) State() State {
+	state := t.state.Load().(State)
+	if state == Running && t.draining.Load() {
+		return Draining
+	}
+
+	return state
+}
+
+// Drain starts or stops draining, returns the previous value
+func (t *Tracker) Drain(drain bool) bool {
+	return t.draining.Swap(drain)
+}
+
+func (t *Tracker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if t.draining.Load() {
+			w.Header().Set("Connection", "close")
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
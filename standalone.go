@@ -0,0 +1,67 @@
+package http
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/roadrunner-server/errors"
+	"go.uber.org/zap"
+
+	"github.com/rumorshub/http/config"
+	"github.com/rumorshub/http/middleware"
+)
+
+// NewPlugin creates the plugin outside the endure container, the defaults are applied to the cfg.
+// Nil handler answers every request with the default status, the log could be nil as well.
+func NewPlugin(cfg *config.Config, log *slog.Logger, handler http.Handler) (*Plugin, error) {
+	const op = errors.Op("http_plugin_new")
+
+	if cfg == nil {
+		return nil, errors.E(op, errors.Str("config should be provided"))
+	}
+
+	err := cfg.InitDefaults()
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	if log == nil {
+		log = slog.Default()
+	}
+
+	p := &Plugin{cfg: cfg}
+	err = p.init(log.With("logger", PluginName), zap.NewNop())
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	if handler != nil {
+		p.handler.Store(&handler)
+	}
+
+	return p, nil
+}
+
+// Use registers the middleware, it is applied when listed in the middleware order or has the priority.
+// It should be called before the Run.
+func (p *Plugin) Use(mdwr ...middleware.Middleware) {
+	p.mu.Lock()
+	for i := 0; i < len(mdwr); i++ {
+		p.mdwr[mdwr[i].Name()] = mdwr[i]
+	}
+	p.mu.Unlock()
+}
+
+// Run serves until the context is canceled or a server fails, the servers are stopped before it returns.
+func (p *Plugin) Run(ctx context.Context) error {
+	errCh := p.Serve()
+
+	select {
+	case <-ctx.Done():
+		return p.Stop(context.WithoutCancel(ctx))
+	case err := <-errCh:
+		_ = p.Stop(context.WithoutCancel(ctx))
+		return err
+	}
+}
@@ -0,0 +1,164 @@
+package stats
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats of a single server, requests are recorded by the bundled log middleware and the connections
+// by the http.Server ConnState callback.
+type Stats struct {
+	started  atomic.Int64
+	active   atomic.Int64
+	conns    connStats
+	observer func(from, to http.ConnState)
+	inFlight atomic.Int64
+	bytesIn  atomic.Uint64
+	bytesOut atomic.Uint64
+	// 1xx to 5xx
+	classes [5]atomic.Uint64
+}
+
+// Snapshot is a point in time copy of the stats.
+type Snapshot struct {
+	ActiveConnections int64             `json:"active_connections"`
+	InFlight          int64             `json:"in_flight"`
+	Requests          map[string]uint64 `json:"requests"`
+	BytesIn           uint64            `json:"bytes_in"`
+	BytesOut          uint64            `json:"bytes_out"`
+	Uptime            time.Duration     `json:"uptime"`
+	Connections       Connections       `json:"connections"`
+}
+
+// Connections by the http.ConnState, new, active and idle are the current numbers, hijacked and closed are
+// the totals.
+type Connections struct {
+	New      int64  `json:"new"`
+	Active   int64  `json:"active"`
+	Idle     int64  `json:"idle"`
+	Hijacked uint64 `json:"hijacked"`
+	Closed   uint64 `json:"closed"`
+}
+
+// connStats tracks the last state of every open connection, the transitions move it between the gauges
+type connStats struct {
+	states   sync.Map // net.Conn -> http.ConnState
+	new      atomic.Int64
+	active   atomic.Int64
+	idle     atomic.Int64
+	hijacked atomic.Uint64
+	closed   atomic.Uint64
+}
+
+func (c *connStats) gauge(state http.ConnState) *atomic.Int64 {
+	switch state {
+	case http.StateNew:
+		return &c.new
+	case http.StateActive:
+		return &c.active
+	case http.StateIdle:
+		return &c.idle
+	default:
+		return nil
+	}
+}
+
+func New() *Stats {
+	return &Stats{}
+}
+
+// Start marks the moment the server started serving, the uptime is counted from it
+func (s *Stats) Start() {
+	s.started.Store(time.Now().UnixNano())
+}
+
+// Observe sets the callback of the connection state transitions, the from state of a new connection is
+// http.StateNew as well. It should be set before the server starts.
+func (s *Stats) Observe(fn func(from, to http.ConnState)) {
+	s.observer = fn
+}
+
+// ConnState should be set as the http.Server ConnState callback
+func (s *Stats) ConnState(conn net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		s.active.Add(1)
+	case http.StateHijacked, http.StateClosed:
+		s.active.Add(-1)
+	}
+
+	from := http.StateNew
+	if prev, ok := s.conns.states.Load(conn); ok {
+		from = prev.(http.ConnState)
+		if g := s.conns.gauge(from); g != nil {
+			g.Add(-1)
+		}
+	}
+
+	switch state {
+	case http.StateHijacked:
+		s.conns.hijacked.Add(1)
+		s.conns.states.Delete(conn)
+	case http.StateClosed:
+		s.conns.closed.Add(1)
+		s.conns.states.Delete(conn)
+	default:
+		s.conns.gauge(state).Add(1)
+		s.conns.states.Store(conn, state)
+	}
+
+	if s.observer != nil {
+		s.observer(from, state)
+	}
+}
+
+// Begin should be called when the request starts, End when it is served
+func (s *Stats) Begin() {
+	s.inFlight.Add(1)
+}
+
+// End records the served request, zero status is the implicit 200
+func (s *Stats) End(status, read, written int) {
+	s.inFlight.Add(-1)
+	s.bytesIn.Add(uint64(read))
+	s.bytesOut.Add(uint64(written))
+
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	if class := status/100 - 1; class >= 0 && class < len(s.classes) {
+		s.classes[class].Add(1)
+	}
+}
+
+func (s *Stats) Snapshot() Snapshot {
+	snapshot := Snapshot{
+		ActiveConnections: s.active.Load(),
+		InFlight:          s.inFlight.Load(),
+		Requests:          make(map[string]uint64, len(s.classes)),
+		BytesIn:           s.bytesIn.Load(),
+		BytesOut:          s.bytesOut.Load(),
+		Connections: Connections{
+			New:      s.conns.new.Load(),
+			Active:   s.conns.active.Load(),
+			Idle:     s.conns.idle.Load(),
+			Hijacked: s.conns.hijacked.Load(),
+			Closed:   s.conns.closed.Load(),
+		},
+	}
+
+	for i := 0; i < len(s.classes); i++ {
+		snapshot.Requests[strconv.Itoa(i+1)+"xx"] = s.classes[i].Load()
+	}
+
+	if started := s.started.Load(); started > 0 {
+		snapshot.Uptime = time.Since(time.Unix(0, started))
+	}
+
+	return snapshot
+}
@@ -0,0 +1,56 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/roadrunner-server/api/v4/plugins/v1/status"
+	"github.com/rumorshub/http/servers/state"
+)
+
+// Status reports the liveness, the servers should serve (draining included). The failed config reload does not
+// affect the code, the servers keep serving the previous config, it is reported by the LastReload RPC.
+func (p *Plugin) Status() (*status.Status, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	// workers report their own status
+	if p.supervisor != nil {
+		return &status.Status{Code: http.StatusOK}, nil
+	}
+
+	if len(p.servers) == 0 {
+		return &status.Status{Code: http.StatusServiceUnavailable}, nil
+	}
+
+	for i := 0; i < len(p.servers); i++ {
+		switch p.servers[i].State() {
+		case state.Running, state.Draining:
+		default:
+			return &status.Status{Code: http.StatusServiceUnavailable}, nil
+		}
+	}
+
+	return &status.Status{Code: http.StatusOK}, nil
+}
+
+// Ready reports the readiness, every listener is bound, no server is draining and a handler has been collected
+func (p *Plugin) Ready() (*status.Status, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.supervisor != nil {
+		return &status.Status{Code: http.StatusOK}, nil
+	}
+
+	if !p.collected.Load() || len(p.servers) == 0 {
+		return &status.Status{Code: http.StatusServiceUnavailable}, nil
+	}
+
+	for i := 0; i < len(p.servers); i++ {
+		if p.servers[i].State() != state.Running {
+			return &status.Status{Code: http.StatusServiceUnavailable}, nil
+		}
+	}
+
+	return &status.Status{Code: http.StatusOK}, nil
+}
@@ -22,20 +22,54 @@
 
 package http
 
-import "log/slog"
+import (
+	"log/slog"
+	"strings"
+
+	"github.com/rumorshub/http/middleware"
+)
+
+// handshakeError is the prefix of the TLS handshake errors logged by the http.Server
+const handshakeError = "TLS handshake error from "
 
 // StdLogAdapter can be passed to the http.Server or any place which required standard logger to redirect output
 // to the logger plugin
 type StdLogAdapter struct {
 	log *slog.Logger
+	// audit records the client certificate failures of the TLS handshakes, optional
+	audit middleware.Auditor
 }
 
 // Write io.Writer interface implementation
 func (s *StdLogAdapter) Write(p []byte) (n int, err error) {
-	s.log.Error("internal server error", "error", string(p))
+	msg := string(p)
+	s.log.Error("internal server error", "error", msg)
+
+	if s.audit != nil && clientCertFailure(msg) {
+		s.audit.Audit(nil, middleware.AuditClientCert, slog.String("ip", handshakePeer(msg)), slog.String("reason", strings.TrimSpace(msg)))
+	}
+
 	return len(p), nil
 }
 
+// clientCertFailure reports whether the TLS handshake failed on the missing or invalid client certificate
+func clientCertFailure(msg string) bool {
+	return strings.Contains(msg, handshakeError) &&
+		(strings.Contains(msg, "client didn't provide a certificate") || strings.Contains(msg, "failed to verify certificate"))
+}
+
+// handshakePeer returns the IP from the "TLS handshake error from ip:port: error" message
+func handshakePeer(msg string) string {
+	_, rest, _ := strings.Cut(msg, handshakeError)
+	addr, _, _ := strings.Cut(rest, ": ")
+
+	if i := strings.LastIndexByte(addr, ':'); i > 0 {
+		return strings.Trim(addr[:i], "[]")
+	}
+
+	return addr
+}
+
 // NewStdAdapter constructs StdLogAdapter
 func NewStdAdapter(log *slog.Logger) *StdLogAdapter {
 	logAdapter := &StdLogAdapter{
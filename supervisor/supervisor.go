@@ -0,0 +1,178 @@
+package supervisor
+
+import (
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+)
+
+// EnvWorker is set for the worker processes, holds the worker number
+const EnvWorker string = "RUMORSHUB_HTTP_WORKER"
+
+type Config struct {
+	// Count of the worker processes, each one binds the same addresses with SO_REUSEPORT.
+	Count int `mapstructure:"count" json:"count,omitempty" bson:"count,omitempty"`
+
+	// RespawnDelay between a worker exit and its restart, default 1s.
+	RespawnDelay time.Duration `mapstructure:"respawn_delay" json:"respawn_delay,omitempty" bson:"respawn_delay,omitempty"`
+
+	// StopTimeout to wait for the workers graceful shutdown before killing them, default 30s.
+	StopTimeout time.Duration `mapstructure:"stop_timeout" json:"stop_timeout,omitempty" bson:"stop_timeout,omitempty"`
+}
+
+func (c *Config) InitDefaults() error {
+	if c.Count <= 0 {
+		return errors.Str("workers count should be positive")
+	}
+
+	if c.RespawnDelay == 0 {
+		c.RespawnDelay = time.Second
+	}
+
+	if c.StopTimeout == 0 {
+		c.StopTimeout = time.Second * 30
+	}
+
+	return nil
+}
+
+// IsWorker reports whether the current process was started by the supervisor
+func IsWorker() bool {
+	return os.Getenv(EnvWorker) != ""
+}
+
+// Supervisor re-executes the current binary as N worker processes and respawns them on exit. The workers
+// serve the traffic, the supervisor process only manages their lifecycle.
+type Supervisor struct {
+	cfg *Config
+	log *slog.Logger
+
+	mu      sync.Mutex
+	procs   []*exec.Cmd
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+func New(cfg *Config, log *slog.Logger) *Supervisor {
+	return &Supervisor{
+		cfg:   cfg,
+		log:   log,
+		procs: make([]*exec.Cmd, cfg.Count),
+	}
+}
+
+// Start spawns the workers, it returns an error only when a worker could not be started at all
+func (s *Supervisor) Start() error {
+	const op = errors.Op("supervisor_start")
+
+	for i := 0; i < s.cfg.Count; i++ {
+		err := s.spawn(i)
+		if err != nil {
+			s.Stop()
+			return errors.E(op, err)
+		}
+	}
+
+	s.log.Debug("workers were started", "count", s.cfg.Count)
+
+	return nil
+}
+
+func (s *Supervisor) spawn(n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return nil
+	}
+
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Env = append(os.Environ(), EnvWorker+"="+strconv.Itoa(n))
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	s.procs[n] = cmd
+	s.wg.Add(1)
+	go s.wait(n, cmd)
+
+	return nil
+}
+
+func (s *Supervisor) wait(n int, cmd *exec.Cmd) {
+	defer s.wg.Done()
+
+	err := cmd.Wait()
+
+	s.mu.Lock()
+	stopped := s.stopped
+	s.mu.Unlock()
+
+	if stopped {
+		return
+	}
+
+	s.log.Error("worker exited, respawning", "worker", n, "pid", cmd.Process.Pid, "error", err, "delay", s.cfg.RespawnDelay)
+
+	for {
+		time.Sleep(s.cfg.RespawnDelay)
+
+		err = s.spawn(n)
+		if err == nil {
+			return
+		}
+
+		s.log.Error("failed to respawn worker", "worker", n, "error", err)
+	}
+}
+
+// Stop sends SIGTERM to the workers and kills the ones still running after the stop timeout
+func (s *Supervisor) Stop() {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+
+	s.stopped = true
+	for i := 0; i < len(s.procs); i++ {
+		if s.procs[i] != nil && s.procs[i].Process != nil {
+			err := s.procs[i].Process.Signal(syscall.SIGTERM)
+			if err != nil {
+				_ = s.procs[i].Process.Kill()
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	doneCh := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(doneCh)
+	}()
+
+	select {
+	case <-doneCh:
+	case <-time.After(s.cfg.StopTimeout):
+		s.log.Warn("workers did not stop in time, killing them", "timeout", s.cfg.StopTimeout)
+		s.mu.Lock()
+		for i := 0; i < len(s.procs); i++ {
+			if s.procs[i] != nil && s.procs[i].Process != nil {
+				_ = s.procs[i].Process.Kill()
+			}
+		}
+		s.mu.Unlock()
+		<-doneCh
+	}
+}
@@ -0,0 +1,153 @@
+// Package testenv is the integration test harness: a generated CA for server and client (mTLS) certificates,
+// a local Pebble ACME server and the plugin started without the endure container.
+package testenv
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// CA is a throwaway certificate authority, the files are written into the test temp dir
+type CA struct {
+	Cert *x509.Certificate
+	Key  *ecdsa.PrivateKey
+	// CertFile is the PEM encoded CA certificate, used as root_ca (mTLS) or ca_root (ACME)
+	CertFile string
+
+	dir string
+}
+
+// NewCA generates the CA
+func NewCA(tb testing.TB) *CA {
+	tb.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial(tb),
+		Subject:               pkix.Name{CommonName: "testenv root CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour * 24),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	ca := &CA{
+		Cert: cert,
+		Key:  key,
+		dir:  tb.TempDir(),
+	}
+
+	ca.CertFile = ca.write(tb, "ca.pem", "CERTIFICATE", der)
+
+	return ca
+}
+
+// Issue generates a certificate signed by the CA. Hosts are the DNS names, IPs or URIs (spiffe://) SANs, the first
+// one is the common name. Client certificates have the client auth extended key usage.
+func (ca *CA) Issue(tb testing.TB, client bool, hosts ...string) (certFile, keyFile string) {
+	tb.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial(tb),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour * 24),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	if client {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+
+	if len(hosts) > 0 {
+		tmpl.Subject.CommonName = hosts[0]
+	}
+
+	for i := 0; i < len(hosts); i++ {
+		if ip := net.ParseIP(hosts[i]); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+			continue
+		}
+
+		if u, errU := parseURI(hosts[i]); errU == nil {
+			tmpl.URIs = append(tmpl.URIs, u)
+			continue
+		}
+
+		tmpl.DNSNames = append(tmpl.DNSNames, hosts[i])
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Cert, key.Public(), ca.Key)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	name := tmpl.SerialNumber.String()
+	certFile = ca.write(tb, name+".crt", "CERTIFICATE", der)
+	keyFile = ca.write(tb, name+".key", "PRIVATE KEY", keyDer)
+
+	return certFile, keyFile
+}
+
+// Pool returns the cert pool with the CA certificate
+func (ca *CA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+	return pool
+}
+
+func (ca *CA) write(tb testing.TB, name, blockType string, der []byte) string {
+	tb.Helper()
+
+	path := filepath.Join(ca.dir, name)
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return path
+}
+
+func serial(tb testing.TB) *big.Int {
+	n, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 126))
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return n
+}
@@ -0,0 +1,177 @@
+package testenv
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// EnvPebbleBin overrides the pebble binary looked up in the PATH
+const EnvPebbleBin string = "PEBBLE_BIN"
+
+// Pebble is a local ACME server (github.com/letsencrypt/pebble) for the issuance and renewal tests
+type Pebble struct {
+	// DirectoryURL is the ACME directory, used as acme.ca
+	DirectoryURL string
+	// RootFile trusts the pebble API TLS certificate, used as acme.ca_root
+	RootFile string
+
+	mgmt   string
+	client *http.Client
+}
+
+// StartPebble runs pebble with the API certificate issued by the CA. Challenges are not validated
+// (PEBBLE_VA_ALWAYS_VALID), so the plugin domains do not have to resolve. The test is skipped when pebble is not
+// installed.
+func StartPebble(tb testing.TB, ca *CA) *Pebble {
+	tb.Helper()
+
+	bin := os.Getenv(EnvPebbleBin)
+	if bin == "" {
+		var err error
+		bin, err = exec.LookPath("pebble")
+		if err != nil {
+			tb.Skip("pebble is not installed, set PEBBLE_BIN or add it to the PATH")
+		}
+	}
+
+	certFile, keyFile := ca.Issue(tb, false, "localhost", "127.0.0.1")
+	listen, mgmt := freeAddr(tb), freeAddr(tb)
+
+	cfg := map[string]any{
+		"pebble": map[string]any{
+			"listenAddress":           listen,
+			"managementListenAddress": mgmt,
+			"certificate":             certFile,
+			"privateKey":              keyFile,
+			"httpPort":                5002,
+			"tlsPort":                 5001,
+		},
+	}
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	cfgFile := filepath.Join(tb.TempDir(), "pebble.json")
+	err = os.WriteFile(cfgFile, data, 0o600)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	cmd := exec.Command(bin, "-config", cfgFile, "-strict=false")
+	cmd.Env = append(os.Environ(), "PEBBLE_VA_ALWAYS_VALID=1", "PEBBLE_VA_NOSLEEP=1", "PEBBLE_WFE_NONCEREJECT=0")
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Start()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	tb.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+
+	p := &Pebble{
+		DirectoryURL: "https://" + listen + "/dir",
+		RootFile:     ca.CertFile,
+		mgmt:         "https://" + mgmt,
+		client: &http.Client{
+			Timeout: time.Second * 5,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: ca.Pool(), MinVersion: tls.VersionTLS12},
+			},
+		},
+	}
+
+	waitFor(tb, func() error {
+		resp, errG := p.client.Get(p.DirectoryURL)
+		if errG != nil {
+			return errG
+		}
+		_ = resp.Body.Close()
+		return nil
+	})
+
+	return p
+}
+
+// IssuerPool returns the pebble root which signs the issued certificates, to verify the plugin certificate
+func (p *Pebble) IssuerPool(tb testing.TB) *x509.CertPool {
+	tb.Helper()
+
+	resp, err := p.client.Get(p.mgmt + "/roots/0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		tb.Fatal("pebble returned no roots")
+	}
+
+	return pool
+}
+
+// freeAddr returns a free loopback address
+func freeAddr(tb testing.TB) string {
+	tb.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	addr := l.Addr().String()
+	_ = l.Close()
+
+	return addr
+}
+
+func waitFor(tb testing.TB, check func() error) {
+	tb.Helper()
+
+	deadline := time.Now().Add(time.Second * 10)
+	for {
+		err := check()
+		if err == nil {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			tb.Fatal(fmt.Errorf("timeout: %w", err))
+		}
+
+		time.Sleep(time.Millisecond * 50)
+	}
+}
+
+func parseURI(s string) (*url.URL, error) {
+	if !strings.Contains(s, "://") {
+		return nil, fmt.Errorf("not an uri: %s", s)
+	}
+
+	return url.Parse(s)
+}
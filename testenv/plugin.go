@@ -0,0 +1,135 @@
+package testenv
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	httpPlugin "github.com/rumorshub/http"
+	"github.com/rumorshub/http/config"
+)
+
+// StartPlugin initializes and serves the plugin with the config and handler the same way the endure container
+// does, waits for the listeners and stops the plugin on the test cleanup.
+func StartPlugin(tb testing.TB, cfg *config.Config, handler http.Handler) *httpPlugin.Plugin {
+	tb.Helper()
+
+	return StartPluginWithLogger(tb, cfg, handler, slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})))
+}
+
+// StartPluginWithLogger is StartPlugin with the plugin logger, e.g. to check the access log entries.
+func StartPluginWithLogger(tb testing.TB, cfg *config.Config, handler http.Handler, log *slog.Logger) *httpPlugin.Plugin {
+	tb.Helper()
+
+	p := &httpPlugin.Plugin{}
+
+	err := p.Init(&configurer{cfg: cfg}, &logger{log: log})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	handlerType := reflect.TypeOf((*http.Handler)(nil)).Elem()
+	collects := p.Collects()
+	for i := 0; i < len(collects); i++ {
+		if collects[i].Type == handlerType {
+			collects[i].Callback(handler)
+		}
+	}
+
+	errCh := p.Serve()
+
+	tb.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		errS := p.Stop(ctx)
+		if errS != nil {
+			tb.Error(errS)
+		}
+	})
+
+	addresses := make([]string, 0, 2)
+	if cfg.EnableHTTP() {
+		addresses = append(addresses, cfg.Address)
+	}
+	if cfg.EnableTLS() {
+		addresses = append(addresses, cfg.SSL.Address)
+	}
+
+	for i := 0; i < len(addresses); i++ {
+		addr := addresses[i]
+		waitFor(tb, func() error {
+			select {
+			case errS := <-errCh:
+				tb.Fatal(errS)
+			default:
+			}
+
+			conn, errD := dial(addr)
+			if errD != nil {
+				return errD
+			}
+
+			return conn.Close()
+		})
+	}
+
+	return p
+}
+
+func dial(address string) (net.Conn, error) {
+	network, addr := "tcp", address
+	if dsn := strings.SplitN(address, "://", 2); len(dsn) == 2 {
+		network, addr = dsn[0], dsn[1]
+	}
+
+	return net.DialTimeout(network, addr, time.Second)
+}
+
+type configurer struct {
+	cfg *config.Config
+}
+
+func (c *configurer) Has(name string) bool {
+	return name == httpPlugin.PluginName
+}
+
+// UnmarshalKey provides the raw section with the typed values of the config fields, they are assigned as is.
+func (c *configurer) UnmarshalKey(name string, out interface{}) error {
+	raw, ok := out.(*map[string]any)
+	if !ok || name != httpPlugin.PluginName {
+		return fmt.Errorf("unexpected key %s or type %T", name, out)
+	}
+
+	value := reflect.ValueOf(c.cfg).Elem()
+	*raw = make(map[string]any, value.NumField())
+	for i := 0; i < value.NumField(); i++ {
+		key, _, _ := strings.Cut(value.Type().Field(i).Tag.Get("mapstructure"), ",")
+		if key != "" && !value.Field(i).IsZero() {
+			(*raw)[key] = value.Field(i).Interface()
+		}
+	}
+
+	return nil
+}
+
+type logger struct {
+	log *slog.Logger
+}
+
+func (l *logger) NamedLogger(name string) *slog.Logger {
+	return l.log.With("logger", name)
+}
+
+func (l *logger) NamedZapLogger(string) *zap.Logger {
+	return zap.NewNop()
+}
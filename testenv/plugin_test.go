@@ -0,0 +1,354 @@
+package testenv
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	httpPlugin "github.com/rumorshub/http"
+	"github.com/rumorshub/http/config"
+	"github.com/rumorshub/http/middleware"
+	"github.com/rumorshub/http/servers/https"
+)
+
+func TestPluginMTLS(t *testing.T) {
+	ca := NewCA(t)
+	certFile, keyFile := ca.Issue(t, false, "localhost", "127.0.0.1")
+	clientCertFile, clientKeyFile := ca.Issue(t, true, "client")
+
+	cfg := &config.Config{
+		Address: freeAddr(t),
+		SSL: &https.SSLConfig{
+			Address:  freeAddr(t),
+			Cert:     certFile,
+			Key:      keyFile,
+			RootCA:   ca.CertFile,
+			AuthType: https.RequireAndVerifyClientCert,
+		},
+	}
+
+	StartPlugin(t, cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			_, _ = io.WriteString(w, "plain")
+			return
+		}
+
+		_, _ = io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+
+	if body := get(t, http.DefaultClient, "http://"+cfg.Address); body != "plain" {
+		t.Fatalf("http response: %q", body)
+	}
+
+	clientCert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{
+		Timeout: time.Second * 5,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      ca.Pool(),
+			Certificates: []tls.Certificate{clientCert},
+			MinVersion:   tls.VersionTLS12,
+		}},
+	}
+
+	if body := get(t, client, "https://"+cfg.SSL.Address); body != "client" {
+		t.Fatalf("https response: %q", body)
+	}
+
+	anonymous := &http.Client{
+		Timeout: time.Second * 5,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:    ca.Pool(),
+			MinVersion: tls.VersionTLS12,
+		}},
+	}
+
+	resp, err := anonymous.Get("https://" + cfg.SSL.Address)
+	if err == nil {
+		_ = resp.Body.Close()
+		t.Fatal("the client without the certificate should be rejected")
+	}
+}
+
+func get(t *testing.T, client *http.Client, url string) string {
+	t.Helper()
+
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s: status %d, %s", url, resp.StatusCode, body)
+	}
+
+	return string(body)
+}
+
+func TestPluginAccessLogRejected(t *testing.T) {
+	var buf syncBuffer
+	log := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	cfg := &config.Config{
+		Address:     freeAddr(t),
+		Maintenance: &middleware.MaintenanceConfig{Enabled: true},
+		AccessLog:   &middleware.AccessLogConfig{RequestID: &middleware.RequestIDConfig{TrustedSubnets: []string{"127.0.0.0/8"}}},
+	}
+
+	p := StartPluginWithLogger(t, cfg, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}), log)
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+cfg.Address+"/api", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Request-Id", "maintenance-1")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("status %d, should be rejected by the maintenance", resp.StatusCode)
+	}
+
+	if resp.Header.Get("X-Request-Id") != "maintenance-1" {
+		t.Fatal("the rejected response has no request ID")
+	}
+
+	stats := p.Stats()
+	if len(stats) != 1 || stats[0].Requests["5xx"] != 1 {
+		t.Fatalf("the rejection is not counted: %+v", stats)
+	}
+
+	if out := buf.String(); !strings.Contains(out, `"msg":"Incoming request"`) || !strings.Contains(out, `"status":503`) {
+		t.Fatalf("the rejection is not logged: %s", out)
+	}
+}
+
+// syncBuffer is written by the server goroutines and read by the test
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+func TestPluginPendingConfig(t *testing.T) {
+	cfg := &config.Config{Address: freeAddr(t)}
+
+	p := StartPlugin(t, cfg, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+
+	pending, ok := p.RPC().(interface {
+		PendingConfig(bool, *[]httpPlugin.ConfigChange) error
+	})
+	if !ok {
+		t.Fatal("the RPC has no PendingConfig method")
+	}
+
+	// the middleware is built once, the change is rejected and stays pending
+	cfg.Maintenance = &middleware.MaintenanceConfig{Enabled: true}
+	if err := p.Reset(); err == nil {
+		t.Fatal("the maintenance change should be rejected")
+	}
+
+	var diff []httpPlugin.ConfigChange
+	if err := pending.PendingConfig(true, &diff); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(diff) != 1 || diff[0].Key != "maintenance" || diff[0].Reloadable || diff[0].Current != nil || diff[0].Pending != cfg.Maintenance {
+		t.Fatalf("pending diff: %+v", diff)
+	}
+
+	if st := p.LastReload(); st == nil || st.Error == "" || len(st.Pending) != 1 {
+		t.Fatalf("last reload: %+v", st)
+	}
+
+	// the reverted config is applied, nothing is pending
+	cfg.Maintenance = nil
+	if err := p.Reset(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pending.PendingConfig(true, &diff); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(diff) != 0 {
+		t.Fatalf("pending diff after the successful reload: %+v", diff)
+	}
+}
+
+func TestPluginOnShutdown(t *testing.T) {
+	cfg := &config.Config{Address: freeAddr(t)}
+
+	p := StartPlugin(t, cfg, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+
+	var calls atomic.Int32
+	done := make(chan struct{})
+	p.OnShutdown(func() {
+		if calls.Add(1) == 1 {
+			close(done)
+		}
+	})
+
+	// the servers replaced by the reset are not the shutdown of the plugin
+	if err := p.Reset(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := calls.Load(); n != 0 {
+		t.Fatalf("the hook is called %d times by the reset", n)
+	}
+
+	// the hook is registered on the re-created servers, the second stop (the test cleanup) does not panic
+	if err := p.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("the hook is not called by the stop")
+	}
+
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("the hook is called %d times, should be 1", n)
+	}
+}
+
+func TestPluginDrainReset(t *testing.T) {
+	cfg := &config.Config{Address: freeAddr(t)}
+
+	p := StartPlugin(t, cfg, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+
+	rpc, ok := p.RPC().(interface {
+		Drain(string, *bool) error
+		Undrain(string, *bool) error
+	})
+	if !ok {
+		t.Fatal("the RPC has no Drain method")
+	}
+
+	status := func() int {
+		t.Helper()
+
+		resp, err := http.Get("http://" + cfg.Address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	var done bool
+	if err := rpc.Drain("http", &done); err != nil {
+		t.Fatal(err)
+	}
+
+	// the re-created server keeps draining
+	if err := p.Reset(); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := status(); code != http.StatusServiceUnavailable {
+		t.Fatalf("status %d after the reset of the drained server, should be 503", code)
+	}
+
+	if err := rpc.Undrain("http", &done); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := status(); code != http.StatusOK {
+		t.Fatalf("status %d after the undrain, should be 200", code)
+	}
+}
+
+func TestPluginACME(t *testing.T) {
+	pebble := StartPebble(t, NewCA(t))
+
+	cfg := &config.Config{
+		Address: freeAddr(t),
+		SSL: &https.SSLConfig{
+			Address: freeAddr(t),
+			Acme: &https.AcmeConfig{
+				CacheDir:          t.TempDir(),
+				Email:             "admin@acme.test",
+				CA:                pebble.DirectoryURL,
+				CARoot:            pebble.RootFile,
+				Domains:           []string{"acme.test"},
+				CertObtainTimeout: time.Second * 30,
+			},
+		},
+	}
+
+	StartPlugin(t, cfg, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+
+	// the certificate is issued by the pebble CA for the configured domain
+	client := &http.Client{
+		Timeout: time.Second * 5,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:    pebble.IssuerPool(t),
+			ServerName: "acme.test",
+			MinVersion: tls.VersionTLS12,
+		}},
+	}
+
+	waitFor(t, func() error {
+		resp, err := client.Get("https://" + cfg.SSL.Address)
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+
+		return nil
+	})
+
+	if body := get(t, client, "https://"+cfg.SSL.Address); body != "ok" {
+		t.Fatalf("https response: %q", body)
+	}
+}